@@ -35,6 +35,12 @@ var (
 		Long:  "Signature related commands",
 	}
 
+	storageCommand = &cobra.Command{
+		Use:   "storage",
+		Short: "Storage",
+		Long:  "Plugin storage related commands",
+	}
+
 	versionCommand = &cobra.Command{
 		Use:   "version",
 		Short: "Version",
@@ -52,6 +58,7 @@ func init() {
 	rootCommand.AddCommand(pluginCommand)
 	rootCommand.AddCommand(bundleCommand)
 	rootCommand.AddCommand(signatureCommand)
+	rootCommand.AddCommand(storageCommand)
 	rootCommand.AddCommand(versionCommand)
 }
 
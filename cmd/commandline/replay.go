@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/cmd/commandline/run"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayPluginPayload run.ReplayPluginPayload
+)
+
+var (
+	replayPluginCommand = &cobra.Command{
+		Use:   "replay [trace_file]",
+		Short: "replay",
+		Long:  "Replay a recorded remote debugging session against a local plugin, Dify responses are served from the trace",
+		Args:  cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			replayPluginPayload.TracePath = args[0]
+			run.ReplayPlugin(replayPluginPayload)
+		},
+	}
+)
+
+func init() {
+	pluginCommand.AddCommand(replayPluginCommand)
+
+	replayPluginCommand.Flags().StringVarP(&replayPluginPayload.PluginPath, "package", "p", "", "plugin package path")
+	replayPluginCommand.Flags().BoolVarP(&replayPluginPayload.EnableLogs, "enable-logs", "l", false, "enable logs")
+	replayPluginCommand.Flags().StringVarP(&replayPluginPayload.ResponseFormat, "response-format", "r", "text", "response format, text or json")
+	replayPluginCommand.Flags().DurationVarP(&replayPluginPayload.SessionTimeout, "timeout", "t", 5*time.Minute, "max time to wait for each replayed session, 0 means no limit")
+	replayPluginCommand.MarkFlagRequired("package")
+}
@@ -3,6 +3,7 @@ package run
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
 )
@@ -25,6 +26,17 @@ type RunPluginPayload struct {
 	ResponseFormat string
 }
 
+type ReplayPluginPayload struct {
+	PluginPath string
+	TracePath  string
+	EnableLogs bool
+
+	// max time a replayed session is waited for, 0 means no limit
+	SessionTimeout time.Duration
+
+	ResponseFormat string
+}
+
 type client struct {
 	reader io.ReadCloser
 	writer io.WriteCloser
@@ -0,0 +1,309 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/local_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/test_utils"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+/*
+ Replay takes a trace recorded by the remote debugging server and sends the recorded requests
+ to a local plugin one by one, backwards invocations are answered with the recorded responses
+ of Dify by replaySession itself, the session has no BackwardsInvocation, so that a bug
+ reported from a debugging session could be reproduced without a running Dify
+
+ Credentials and settings are redacted when the trace is recorded, the plugin receives
+ the placeholder instead, requests relying on them fail to reach third party services
+*/
+
+// recordedMessage is a message written by the daemon to the plugin
+type recordedMessage struct {
+	SessionID      string                                 `json:"session_id"`
+	ConversationID *string                                `json:"conversation_id"`
+	MessageID      *string                                `json:"message_id"`
+	AppID          *string                                `json:"app_id"`
+	EndpointID     *string                                `json:"endpoint_id"`
+	Event          session_manager.PLUGIN_IN_STREAM_EVENT `json:"event"`
+	Data           map[string]any                         `json:"data"`
+}
+
+// recordedSession is a request and the backwards invocation responses of it
+type recordedSession struct {
+	message recordedMessage
+
+	// responses of backwards invocations in the order of the invocations
+	backwardsResponses [][]backwards_invocation.BackwardsInvocationResponseEvent
+}
+
+// parseRecordedSessions extracts the sessions from the records in the order of requests
+func parseRecordedSessions(records []plugin_entities.DebuggingTraceRecord) ([]*recordedSession, error) {
+	sessions := []*recordedSession{}
+	sessionsMap := map[string]*recordedSession{}
+	// index of backwards invocations in the session
+	backwardsRequests := map[string]int{}
+
+	for _, record := range records {
+		if record.Direction != plugin_entities.DEBUGGING_TRACE_DIRECTION_TO_PLUGIN {
+			continue
+		}
+
+		message, err := parser.UnmarshalJsonBytes[recordedMessage](record.Data)
+		if err != nil {
+			return nil, errors.Join(err, fmt.Errorf("invalid recorded message"))
+		}
+
+		switch message.Event {
+		case session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST:
+			session := &recordedSession{message: message}
+			sessions = append(sessions, session)
+			sessionsMap[message.SessionID] = session
+		case session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE:
+			session, ok := sessionsMap[message.SessionID]
+			if !ok {
+				// request of the session was not recorded
+				continue
+			}
+
+			event, err := parser.MapToStruct[backwards_invocation.BackwardsInvocationResponseEvent](message.Data)
+			if err != nil {
+				return nil, errors.Join(err, fmt.Errorf("invalid recorded backwards response"))
+			}
+
+			key := message.SessionID + "/" + event.BackwardsRequestId
+			index, ok := backwardsRequests[key]
+			if !ok {
+				index = len(session.backwardsResponses)
+				backwardsRequests[key] = index
+				session.backwardsResponses = append(
+					session.backwardsResponses,
+					[]backwards_invocation.BackwardsInvocationResponseEvent{},
+				)
+			}
+
+			session.backwardsResponses[index] = append(session.backwardsResponses[index], *event)
+		}
+	}
+
+	return sessions, nil
+}
+
+func ReplayPlugin(payload ReplayPluginPayload) {
+	if err := replayPlugin(payload); err != nil {
+		systemLog(GenericResponse{
+			Type:     GENERIC_RESPONSE_TYPE_ERROR,
+			Response: map[string]any{"error": err.Error()},
+		}, payload.ResponseFormat)
+		os.Exit(1)
+	}
+}
+
+func replayPlugin(payload ReplayPluginPayload) error {
+	// disable logs
+	log.SetLogVisibility(payload.EnableLogs)
+
+	// init routine pool
+	routine.InitPool(10000)
+
+	traceFile, err := os.ReadFile(payload.TracePath)
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("read trace file error"))
+	}
+
+	meta, records, err := plugin_entities.ParseDebuggingTrace(traceFile)
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("parse trace file error"))
+	}
+
+	sessions, err := parseRecordedSessions(records)
+	if err != nil {
+		return err
+	}
+
+	if meta.Truncated {
+		systemLog(GenericResponse{
+			Type:     GENERIC_RESPONSE_TYPE_INFO,
+			Response: map[string]any{"info": "trace is truncated, the last requests may not be replayed correctly"},
+		}, payload.ResponseFormat)
+	}
+
+	// generate a random cwd
+	dir, err := os.MkdirTemp(os.TempDir(), "plugin-replay-*")
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("create temp directory error"))
+	}
+	defer test_utils.ClearTestingPath(dir)
+
+	// remove the temp directory when the program shuts down
+	setupSignalHandler(dir)
+
+	runtime, declaration, err := launchPlugin(payload.PluginPath, dir, payload.ResponseFormat)
+	if err != nil {
+		return err
+	}
+
+	output := client{writer: os.Stdout}
+
+	logResponse(GenericResponse{
+		Type:     GENERIC_RESPONSE_TYPE_PLUGIN_READY,
+		Response: map[string]any{"info": fmt.Sprintf("replaying %d requests of trace %s", len(sessions), meta.TraceID)},
+	}, payload.ResponseFormat, output)
+
+	for _, recorded := range sessions {
+		replaySession(recorded, meta, declaration, runtime, payload.SessionTimeout, payload.ResponseFormat, output)
+	}
+
+	return nil
+}
+
+// replaySession sends the recorded request to the plugin and waits until the session ends or times out,
+// the plugin is told to stop working on a timed out session and the next one is replayed
+func replaySession(
+	recorded *recordedSession,
+	meta *plugin_entities.DebuggingTraceMeta,
+	declaration *plugin_entities.PluginDeclaration,
+	runtime *local_runtime.LocalPluginRuntime,
+	timeout time.Duration,
+	responseFormat string,
+	output client,
+) {
+	invokeID := recorded.message.SessionID
+	logError := func(err string) {
+		logResponse(GenericResponse{
+			InvokeID: invokeID,
+			Type:     GENERIC_RESPONSE_TYPE_ERROR,
+			Response: map[string]any{"error": err},
+		}, responseFormat, output)
+	}
+
+	accessType, _ := recorded.message.Data["type"].(string)
+	action, _ := recorded.message.Data["action"].(string)
+	userID, _ := recorded.message.Data["user_id"].(string)
+	if accessType == "" || action == "" {
+		logError("recorded request has no type or action")
+		return
+	}
+
+	// runtime.Identity() has already been checked in launchPlugin
+	pluginUniqueIdentifier, _ := runtime.Identity()
+
+	session := session_manager.NewSession(
+		session_manager.NewSessionPayload{
+			UserID:                 userID,
+			TenantID:               meta.TenantID,
+			PluginUniqueIdentifier: pluginUniqueIdentifier,
+			ClusterID:              uuid.New().String(),
+			InvokeFrom:             access_types.PluginAccessType(accessType),
+			Action:                 access_types.PluginAccessAction(action),
+			Declaration:            declaration,
+			IgnoreCache:            true,
+			ConversationID:         recorded.message.ConversationID,
+			MessageID:              recorded.message.MessageID,
+			AppID:                  recorded.message.AppID,
+			EndpointID:             recorded.message.EndpointID,
+		},
+	)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+	session.BindRuntime(runtime)
+
+	done := make(chan bool)
+	doneOnce := sync.Once{}
+	finish := func() {
+		doneOnce.Do(func() { close(done) })
+	}
+	backwardsInvocations := 0
+
+	listener := runtime.Listen(session.ID)
+	listener.Listen(func(chunk plugin_entities.SessionMessage) {
+		switch chunk.Type {
+		case plugin_entities.SESSION_MESSAGE_TYPE_STREAM:
+			response, err := parser.UnmarshalJsonBytes2Map(chunk.Data)
+			if err != nil {
+				logError(err.Error())
+				return
+			}
+			logResponse(GenericResponse{
+				InvokeID: invokeID,
+				Type:     GENERIC_RESPONSE_TYPE_PLUGIN_RESPONSE,
+				Response: response,
+			}, responseFormat, output)
+		case plugin_entities.SESSION_MESSAGE_TYPE_INVOKE:
+			request, err := parser.UnmarshalJsonBytes2Map(chunk.Data)
+			if err != nil {
+				logError(err.Error())
+				return
+			}
+			backwardsRequestID, _ := request["backwards_request_id"].(string)
+
+			// answer the invocation with the recorded responses in the same order
+			if backwardsInvocations >= len(recorded.backwardsResponses) {
+				session.Write(
+					session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE,
+					"",
+					backwards_invocation.NewErrorEvent(backwardsRequestID, "no recorded response for this invocation"),
+				)
+				logError("plugin made more backwards invocations than recorded")
+				return
+			}
+
+			for _, event := range recorded.backwardsResponses[backwardsInvocations] {
+				event.BackwardsRequestId = backwardsRequestID
+				session.Write(session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE, "", event)
+			}
+			backwardsInvocations++
+		case plugin_entities.SESSION_MESSAGE_TYPE_END:
+			finish()
+		case plugin_entities.SESSION_MESSAGE_TYPE_ERROR:
+			e, err := parser.UnmarshalJsonBytes[plugin_entities.ErrorResponse](chunk.Data)
+			if err != nil {
+				logError(string(chunk.Data))
+			} else {
+				logError(e.Error())
+			}
+			finish()
+		default:
+			logError("unknown stream message type: " + string(chunk.Type))
+			finish()
+		}
+	})
+	defer listener.Close()
+
+	session.Write(
+		session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST,
+		session.Action,
+		recorded.message.Data,
+	)
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case <-done:
+	case <-timeoutChan:
+		logError(fmt.Sprintf("session timed out after %s", timeout))
+		session.Interrupt()
+		return
+	}
+
+	logResponse(GenericResponse{
+		InvokeID: invokeID,
+		Type:     GENERIC_RESPONSE_TYPE_PLUGIN_INVOKE_END,
+		Response: map[string]any{"info": "plugin invoke end"},
+	}, responseFormat, output)
+}
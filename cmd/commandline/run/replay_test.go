@@ -0,0 +1,111 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+func TestParseRecordedSessions(t *testing.T) {
+	type expectedSession struct {
+		sessionID string
+		action    string
+		// events of each backwards invocation in the order of the invocations
+		backwardsEvents [][]backwards_invocation.RequestEvent
+	}
+
+	cases := []struct {
+		name     string
+		trace    string
+		sessions []expectedSession
+		err      bool
+	}{
+		{
+			name:  "requests and backwards responses",
+			trace: "trace.jsonl",
+			sessions: []expectedSession{
+				{
+					sessionID: "session-1",
+					action:    "invoke_tool",
+					backwardsEvents: [][]backwards_invocation.RequestEvent{
+						{
+							backwards_invocation.REQUEST_EVENT_RESPONSE,
+							backwards_invocation.REQUEST_EVENT_RESPONSE,
+							backwards_invocation.REQUEST_EVENT_END,
+						},
+						{backwards_invocation.REQUEST_EVENT_ERROR},
+					},
+				},
+				{
+					sessionID: "session-2",
+					action:    "invoke_llm",
+				},
+			},
+		},
+		{
+			name:  "invalid recorded message",
+			trace: "invalid_trace.jsonl",
+			err:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", c.trace))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, records, err := plugin_entities.ParseDebuggingTrace(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sessions, err := parseRecordedSessions(records)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sessions) != len(c.sessions) {
+				t.Fatalf("expected %d sessions, got %d", len(c.sessions), len(sessions))
+			}
+
+			for i, expected := range c.sessions {
+				session := sessions[i]
+				if session.message.SessionID != expected.sessionID {
+					t.Fatalf("expected session %s, got %s", expected.sessionID, session.message.SessionID)
+				}
+				if session.message.Data["action"] != expected.action {
+					t.Fatalf("expected action %s, got %v", expected.action, session.message.Data["action"])
+				}
+
+				if len(session.backwardsResponses) != len(expected.backwardsEvents) {
+					t.Fatalf(
+						"expected %d backwards invocations of %s, got %d",
+						len(expected.backwardsEvents), expected.sessionID, len(session.backwardsResponses),
+					)
+				}
+				for j, events := range expected.backwardsEvents {
+					responses := session.backwardsResponses[j]
+					if len(responses) != len(events) {
+						t.Fatalf("expected %d responses of invocation %d, got %d", len(events), j, len(responses))
+					}
+					for k, event := range events {
+						if responses[k].Event != event {
+							t.Fatalf("expected event %s, got %s", event, responses[k].Event)
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -193,53 +193,65 @@ func setupSignalHandler(dir string) {
 	}()
 }
 
-func runPlugin(payload RunPluginPayload) error {
-	// disable logs
-	log.SetLogVisibility(payload.EnableLogs)
-
-	// init routine pool
-	routine.InitPool(10000)
-
-	// generate a random cwd
-	tempDir := os.TempDir()
-	dir, err := os.MkdirTemp(tempDir, "plugin-run-*")
-	if err != nil {
-		return errors.Join(err, fmt.Errorf("create temp directory error"))
-	}
-	defer test_utils.ClearTestingPath(dir)
-
-	// remove the temp directory when the program shuts down
-	setupSignalHandler(dir)
-
+// launchPlugin decodes the plugin package and launches it locally in dir
+func launchPlugin(pluginPath string, dir string, responseFormat string) (
+	*local_runtime.LocalPluginRuntime, *plugin_entities.PluginDeclaration, error,
+) {
 	// try decode the plugin zip file
-	pluginFile, err := os.ReadFile(payload.PluginPath)
+	pluginFile, err := os.ReadFile(pluginPath)
 	if err != nil {
-		return errors.Join(err, fmt.Errorf("read plugin file error"))
+		return nil, nil, errors.Join(err, fmt.Errorf("read plugin file error"))
 	}
 	zipDecoder, err := decoder.NewZipPluginDecoder(pluginFile)
 	if err != nil {
-		return errors.Join(err, fmt.Errorf("decode plugin file error"))
+		return nil, nil, errors.Join(err, fmt.Errorf("decode plugin file error"))
 	}
 
 	// get the declaration of the plugin
 	declaration, err := zipDecoder.Manifest()
 	if err != nil {
-		return errors.Join(err, fmt.Errorf("get declaration error"))
+		return nil, nil, errors.Join(err, fmt.Errorf("get declaration error"))
 	}
 
 	systemLog(GenericResponse{
 		Type:     GENERIC_RESPONSE_TYPE_INFO,
 		Response: map[string]any{"info": "loading plugin"},
-	}, payload.ResponseFormat)
+	}, responseFormat)
 
 	// launch the plugin locally and returns a local runtime
 	runtime, err := test_utils.GetRuntime(pluginFile, dir)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// check the identity of the plugin
 	_, err = runtime.Identity()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return runtime, &declaration, nil
+}
+
+func runPlugin(payload RunPluginPayload) error {
+	// disable logs
+	log.SetLogVisibility(payload.EnableLogs)
+
+	// init routine pool
+	routine.InitPool(10000)
+
+	// generate a random cwd
+	tempDir := os.TempDir()
+	dir, err := os.MkdirTemp(tempDir, "plugin-run-*")
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("create temp directory error"))
+	}
+	defer test_utils.ClearTestingPath(dir)
+
+	// remove the temp directory when the program shuts down
+	setupSignalHandler(dir)
+
+	runtime, declaration, err := launchPlugin(payload.PluginPath, dir, payload.ResponseFormat)
 	if err != nil {
 		return err
 	}
@@ -282,7 +294,7 @@ func runPlugin(payload RunPluginPayload) error {
 		}
 
 		routine.Submit(nil, func() {
-			handleClient(client, &declaration, runtime, payload.ResponseFormat)
+			handleClient(client, declaration, runtime, payload.ResponseFormat)
 		})
 	}
 
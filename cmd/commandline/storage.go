@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+
+	"github.com/langgenius/dify-plugin-daemon/cmd/commandline/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	storageExportPayload storage.ExportPayload
+	storageImportPayload storage.ImportPayload
+)
+
+var (
+	storageExportCommand = &cobra.Command{
+		Use:   "export",
+		Short: "Export plugin storage",
+		Long:  "Export persistent storage of plugins of a tenant from a running daemon into an archive",
+		Args:  cobra.ExactArgs(0),
+		Run: func(c *cobra.Command, args []string) {
+			if err := storage.Export(storageExportPayload); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+
+	storageImportCommand = &cobra.Command{
+		Use:   "import [archive_path]",
+		Short: "Import plugin storage",
+		Long:  "Import an exported storage archive into a running daemon, existing keys are kept unless --overwrite is set",
+		Args:  cobra.ExactArgs(1),
+		Run: func(c *cobra.Command, args []string) {
+			storageImportPayload.ArchivePath = args[0]
+			if err := storage.Import(storageImportPayload); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	storageCommand.AddCommand(storageExportCommand)
+	storageCommand.AddCommand(storageImportCommand)
+
+	storageExportCommand.Flags().StringVarP(&storageExportPayload.DaemonURL, "url", "u", "http://localhost:5002", "url of the plugin daemon")
+	storageExportCommand.Flags().StringVarP(&storageExportPayload.AdminKey, "key", "k", "", "admin api key of the plugin daemon")
+	storageExportCommand.Flags().StringVarP(&storageExportPayload.TenantID, "tenant-id", "t", "", "tenant to export")
+	storageExportCommand.Flags().StringVarP(&storageExportPayload.PluginID, "plugin-id", "p", "", "plugin to export, all plugins of the tenant if empty")
+	storageExportCommand.Flags().StringVarP(&storageExportPayload.Output, "output", "o", "", "archive path")
+	storageExportCommand.MarkFlagRequired("key")
+	storageExportCommand.MarkFlagRequired("tenant-id")
+
+	storageImportCommand.Flags().StringVarP(&storageImportPayload.DaemonURL, "url", "u", "http://localhost:5002", "url of the plugin daemon")
+	storageImportCommand.Flags().StringVarP(&storageImportPayload.AdminKey, "key", "k", "", "admin api key of the plugin daemon")
+	storageImportCommand.Flags().StringVarP(&storageImportPayload.TenantID, "tenant-id", "t", "", "tenant to import into, the tenant of the archive if empty")
+	storageImportCommand.Flags().BoolVar(&storageImportPayload.Overwrite, "overwrite", false, "overwrite existing keys")
+	storageImportCommand.MarkFlagRequired("key")
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+)
+
+const (
+	ADMIN_API_KEY_HEADER = "X-Admin-Api-Key"
+)
+
+// importResult mirrors persistence.ArchiveImportResult
+type importResult struct {
+	TenantID string `json:"tenant_id"`
+	Plugins  int    `json:"plugins"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+}
+
+type ExportPayload struct {
+	DaemonURL string
+	AdminKey  string
+	TenantID  string
+	PluginID  string
+	Output    string
+}
+
+type ImportPayload struct {
+	DaemonURL   string
+	AdminKey    string
+	TenantID    string
+	Overwrite   bool
+	ArchivePath string
+}
+
+// Export downloads the storage archive of the tenant from the daemon
+func Export(payload ExportPayload) error {
+	query := url.Values{}
+	query.Set("tenant_id", payload.TenantID)
+	if payload.PluginID != "" {
+		query.Set("plugin_id", payload.PluginID)
+	}
+
+	request, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/admin/plugin/storage/export?%s", strings.TrimRight(payload.DaemonURL, "/"), query.Encode()),
+		nil,
+	)
+	if err != nil {
+		log.Error("failed to create request: %v", err)
+		return err
+	}
+	request.Header.Set(ADMIN_API_KEY_HEADER, payload.AdminKey)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Error("failed to export storage: %v", err)
+		return err
+	}
+	defer response.Body.Close()
+
+	// failures are responded as json
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/gzip" {
+		err := readError(response)
+		log.Error("failed to export storage: %v", err)
+		return err
+	}
+
+	output := payload.Output
+	if output == "" {
+		output = fmt.Sprintf("storage-%s.tar.gz", payload.TenantID)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		log.Error("failed to create %s: %v", output, err)
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, response.Body); err != nil {
+		log.Error("failed to write %s: %v", output, err)
+		return err
+	}
+
+	log.Info("storage of tenant %s exported to %s", payload.TenantID, output)
+	return nil
+}
+
+// Import uploads the storage archive to the daemon
+func Import(payload ImportPayload) error {
+	archive, err := os.Open(payload.ArchivePath)
+	if err != nil {
+		log.Error("failed to open %s: %v", payload.ArchivePath, err)
+		return err
+	}
+	defer archive.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if payload.TenantID != "" {
+		writer.WriteField("tenant_id", payload.TenantID)
+	}
+	if payload.Overwrite {
+		writer.WriteField("overwrite", "true")
+	}
+	part, err := writer.CreateFormFile("archive", payload.ArchivePath)
+	if err != nil {
+		log.Error("failed to create form: %v", err)
+		return err
+	}
+	if _, err := io.Copy(part, archive); err != nil {
+		log.Error("failed to read %s: %v", payload.ArchivePath, err)
+		return err
+	}
+	writer.Close()
+
+	request, err := http.NewRequest(
+		http.MethodPost,
+		fmt.Sprintf("%s/admin/plugin/storage/import", strings.TrimRight(payload.DaemonURL, "/")),
+		body,
+	)
+	if err != nil {
+		log.Error("failed to create request: %v", err)
+		return err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	request.Header.Set(ADMIN_API_KEY_HEADER, payload.AdminKey)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Error("failed to import storage: %v", err)
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Error("failed to read response: %v", err)
+		return err
+	}
+
+	result, err := parser.UnmarshalJsonBytes[entities.GenericResponse[importResult]](data)
+	if err != nil {
+		log.Error("unexpected response %s: %s", response.Status, string(data))
+		return err
+	}
+	if result.Code != 0 {
+		err := errors.New(result.Message)
+		log.Error("failed to import storage: %v", err)
+		return err
+	}
+
+	log.Info(
+		"imported %d keys of %d plugins into tenant %s, %d skipped",
+		result.Data.Imported, result.Data.Plugins, result.Data.TenantID, result.Data.Skipped,
+	)
+	return nil
+}
+
+func readError(response *http.Response) error {
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	result, err := parser.UnmarshalJsonBytes[entities.Response](data)
+	if err != nil || result.Message == "" {
+		return fmt.Errorf("unexpected response %s: %s", response.Status, string(data))
+	}
+	return errors.New(result.Message)
+}
@@ -0,0 +1,37 @@
+package connector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+// LaunchProgress reports the progress of launching a function to the daemon
+type LaunchProgress func(stage serverless.LaunchStage, state serverless.LaunchState, message string)
+
+// Backend runs plugin packages as functions on a platform
+type Backend interface {
+	// Instances returns the functions launched from the package, empty if not launched yet
+	Instances(filename string) ([]serverless.RunnerInstance, error)
+
+	// Launch builds the package and runs it as a function, it blocks until the function is ready
+	// launching the same package twice returns the existing function
+	Launch(filename string, pkg []byte, verified bool, progress LaunchProgress) (*serverless.RunnerInstance, error)
+}
+
+var functionNameInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// functionName generates a stable function name from the package
+// names are restricted to lowercase letters, digits and dashes so that most platforms accept them
+func functionName(manifest plugin_entities.PluginDeclaration, checksum string) string {
+	if len(checksum) > 12 {
+		checksum = checksum[:12]
+	}
+
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s-%s", manifest.Author, manifest.Name, manifest.Version, checksum))
+	name = functionNameInvalidChars.ReplaceAllString(name, "-")
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,128 @@
+package connector
+
+import (
+	"fmt"
+)
+
+type BackendType string
+
+const (
+	BACKEND_LOCAL      BackendType = "local"
+	BACKEND_KUBERNETES BackendType = "kubernetes"
+)
+
+type Config struct {
+	ServerHost string `envconfig:"SERVERLESS_CONNECTOR_HOST"`
+	ServerPort uint16 `envconfig:"SERVERLESS_CONNECTOR_PORT"`
+	// same as DIFY_PLUGIN_SERVERLESS_CONNECTOR_API_KEY of the daemon
+	ServerKey string `envconfig:"SERVERLESS_CONNECTOR_API_KEY"`
+
+	Backend BackendType `envconfig:"SERVERLESS_CONNECTOR_BACKEND"`
+
+	// timeout of launching a function, including building the environment, in seconds
+	LaunchTimeout int `envconfig:"SERVERLESS_CONNECTOR_LAUNCH_TIMEOUT"`
+
+	// local backend, packages are extracted and launched under the working path
+	LocalWorkingPath string `envconfig:"SERVERLESS_CONNECTOR_LOCAL_WORKING_PATH"`
+	// host the function servers listen on, it must be reachable from the daemon
+	LocalFunctionHost string `envconfig:"SERVERLESS_CONNECTOR_LOCAL_FUNCTION_HOST"`
+
+	PythonInterpreterPath string `envconfig:"PYTHON_INTERPRETER_PATH"`
+	UvPath                string `envconfig:"UV_PATH"`
+	PythonEnvInitTimeout  int    `envconfig:"PYTHON_ENV_INIT_TIMEOUT"`
+	PipMirrorUrl          string `envconfig:"PIP_MIRROR_URL"`
+	PipExtraArgs          string `envconfig:"PIP_EXTRA_ARGS"`
+
+	// kubernetes backend, the in-cluster service account is used by default
+	KubernetesAPIServer     string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_API_SERVER"`
+	KubernetesToken         string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_TOKEN"`
+	KubernetesTokenFile     string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_TOKEN_FILE"`
+	KubernetesCAFile        string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_CA_FILE"`
+	KubernetesInsecure      bool           `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_INSECURE"`
+	KubernetesNamespace     string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_NAMESPACE"`
+	KubernetesClusterDomain string         `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_CLUSTER_DOMAIN"`
+	KubernetesMode          KubernetesMode `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_MODE"`
+	// registry the images are pushed to, e.g. registry.example.com/dify-plugins
+	KubernetesImageRegistry string `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_IMAGE_REGISTRY"`
+	// kaniko executor image used to build the functions
+	KubernetesBuilderImage string `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_BUILDER_IMAGE"`
+	// docker config secret mounted into the builder to push images, optional
+	KubernetesRegistrySecret string `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_REGISTRY_SECRET"`
+	// url of this connector reachable from the builder pods, build contexts are fetched from it
+	KubernetesConnectorURL string `envconfig:"SERVERLESS_CONNECTOR_KUBERNETES_CONNECTOR_URL"`
+}
+
+func (c *Config) SetDefault() {
+	if c.ServerHost == "" {
+		c.ServerHost = "0.0.0.0"
+	}
+	if c.ServerPort == 0 {
+		c.ServerPort = 5004
+	}
+	if c.Backend == "" {
+		c.Backend = BACKEND_LOCAL
+	}
+	if c.LaunchTimeout == 0 {
+		c.LaunchTimeout = 240
+	}
+	if c.LocalWorkingPath == "" {
+		c.LocalWorkingPath = "serverless_functions"
+	}
+	if c.LocalFunctionHost == "" {
+		c.LocalFunctionHost = "127.0.0.1"
+	}
+	if c.PythonInterpreterPath == "" {
+		c.PythonInterpreterPath = "/usr/bin/python3"
+	}
+	if c.PythonEnvInitTimeout == 0 {
+		c.PythonEnvInitTimeout = 120
+	}
+	if c.KubernetesAPIServer == "" {
+		c.KubernetesAPIServer = "https://kubernetes.default.svc"
+	}
+	if c.KubernetesTokenFile == "" {
+		c.KubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	}
+	if c.KubernetesCAFile == "" {
+		c.KubernetesCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	}
+	if c.KubernetesNamespace == "" {
+		c.KubernetesNamespace = "default"
+	}
+	if c.KubernetesClusterDomain == "" {
+		c.KubernetesClusterDomain = "cluster.local"
+	}
+	if c.KubernetesMode == "" {
+		c.KubernetesMode = KUBERNETES_MODE_DEPLOYMENT
+	}
+	if c.KubernetesBuilderImage == "" {
+		c.KubernetesBuilderImage = "gcr.io/kaniko-project/executor:latest"
+	}
+}
+
+func (c *Config) Validate() error {
+	if c.ServerKey == "" {
+		return fmt.Errorf("serverless connector api key is empty")
+	}
+
+	switch c.Backend {
+	case BACKEND_LOCAL:
+		if c.LocalWorkingPath == "" {
+			return fmt.Errorf("local working path is empty")
+		}
+	case BACKEND_KUBERNETES:
+		if c.KubernetesMode != KUBERNETES_MODE_DEPLOYMENT && c.KubernetesMode != KUBERNETES_MODE_KNATIVE {
+			return fmt.Errorf("unsupported kubernetes mode: %s", c.KubernetesMode)
+		}
+		if c.KubernetesImageRegistry == "" {
+			return fmt.Errorf("kubernetes image registry is empty")
+		}
+		if c.KubernetesConnectorURL == "" {
+			return fmt.Errorf("kubernetes connector url is empty")
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %s", c.Backend)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package connector
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/stream"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+// sessionIO is the part of a plugin runtime used to serve invocations
+type sessionIO interface {
+	Listen(session_id string) *entities.Broadcast[plugin_entities.SessionMessage]
+	Write(session_id string, action access_types.PluginAccessAction, data []byte)
+}
+
+const (
+	websocketWriteTimeout   = 30 * time.Second
+	websocketMaxMessageSize = 5 * 1024 * 1024
+)
+
+var upgrader = websocket.Upgrader{
+	// the daemon is not a browser
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// sessionIdOf returns the session id of the invocation, from the header or the request itself
+func sessionIdOf(header string, body []byte) (string, error) {
+	if header != "" {
+		return header, nil
+	}
+
+	message, err := parser.UnmarshalJsonBytes[struct {
+		SessionId string `json:"session_id"`
+	}](body)
+	if err != nil || message.SessionId == "" {
+		return "", errors.New("session id is required")
+	}
+	return message.SessionId, nil
+}
+
+// functionHandler serves `POST /invoke?action=` which is what ServerlessPluginRuntime.Write expects,
+// the request is forwarded to the plugin and the session messages are streamed back line by line
+// until the session ends.
+//
+// `GET /invoke?action=` upgraded to a WebSocket serves the session in full duplex, the first frame
+// is the request and the following frames are backwards responses, which are forwarded to the plugin
+// as they are
+//
+// invocations must carry the function token derived from the api key of the connector, /health is left open
+// for keep-alive pings
+func functionHandler(runtime sessionIO, apiKey string) http.Handler {
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+
+	engine.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, "ok")
+	})
+
+	token := serverless.FunctionToken(apiKey)
+	checkingToken := func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader(serverless.HEADER_FUNCTION_TOKEN)), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+
+	engine.POST("/invoke", checkingToken, func(c *gin.Context) {
+		action := access_types.PluginAccessAction(c.Query("action"))
+		if action == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action is required"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		sessionId, err := sessionIdOf(c.GetHeader("Dify-Plugin-Session-ID"), body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		messages := stream.NewStream[plugin_entities.SessionMessage](512)
+		listener := runtime.Listen(sessionId)
+		listener.Listen(func(message plugin_entities.SessionMessage) {
+			messages.WriteBlocking(message)
+			if message.Type == plugin_entities.SESSION_MESSAGE_TYPE_END ||
+				message.Type == plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+				messages.Close()
+			}
+		})
+		defer listener.Close()
+
+		// stop streaming once the daemon goes away
+		go func() {
+			<-c.Request.Context().Done()
+			messages.Close()
+		}()
+
+		runtime.Write(sessionId, action, body)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		for messages.Next() {
+			message, err := messages.Read()
+			if err != nil {
+				break
+			}
+
+			c.Writer.Write(parser.MarshalJsonBytes(plugin_entities.PluginUniversalEvent{
+				SessionId: sessionId,
+				Event:     plugin_entities.PLUGIN_EVENT_SESSION,
+				Data:      parser.MarshalJsonBytes(message),
+			}))
+			c.Writer.Write([]byte("\n"))
+			c.Writer.Flush()
+		}
+	})
+
+	engine.GET("/invoke", checkingToken, func(c *gin.Context) {
+		action := access_types.PluginAccessAction(c.Query("action"))
+		if action == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action is required"})
+			return
+		}
+
+		if !websocket.IsWebSocketUpgrade(c.Request) {
+			c.JSON(http.StatusUpgradeRequired, gin.H{"error": "websocket upgrade is required"})
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveDuplex(conn, runtime, action, c.GetHeader("Dify-Plugin-Session-ID"))
+	})
+
+	return engine
+}
+
+// serveDuplex forwards frames of the daemon to the plugin and session messages of the plugin
+// to the daemon until the session ends or the daemon goes away
+func serveDuplex(
+	conn *websocket.Conn,
+	runtime sessionIO,
+	action access_types.PluginAccessAction,
+	sessionIdHeader string,
+) {
+	conn.SetReadLimit(websocketMaxMessageSize)
+
+	_, request, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	sessionId, err := sessionIdOf(sessionIdHeader, request)
+	if err != nil {
+		conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+			time.Now().Add(websocketWriteTimeout),
+		)
+		return
+	}
+
+	messages := stream.NewStream[plugin_entities.SessionMessage](512)
+	listener := runtime.Listen(sessionId)
+	listener.Listen(func(message plugin_entities.SessionMessage) {
+		messages.WriteBlocking(message)
+		if message.Type == plugin_entities.SESSION_MESSAGE_TYPE_END ||
+			message.Type == plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+			messages.Close()
+		}
+	})
+	defer listener.Close()
+
+	// backwards responses of the daemon, reading stops once the connection is closed
+	go func() {
+		defer messages.Close()
+		for {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			runtime.Write(sessionId, action, frame)
+		}
+	}()
+
+	runtime.Write(sessionId, action, request)
+
+	for messages.Next() {
+		message, err := messages.Read()
+		if err != nil {
+			break
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, parser.MarshalJsonBytes(plugin_entities.PluginUniversalEvent{
+			SessionId: sessionId,
+			Event:     plugin_entities.PLUGIN_EVENT_SESSION,
+			Data:      parser.MarshalJsonBytes(message),
+		})); err != nil {
+			return
+		}
+	}
+
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(websocketWriteTimeout),
+	)
+}
@@ -0,0 +1,638 @@
+package connector
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_runtime/dockerfile"
+	"github.com/langgenius/dify-plugin-daemon/pkg/plugin_packager/decoder"
+)
+
+/*
+ KubernetesBackend runs every function as a container on a kubernetes cluster.
+
+ The image is built inside the cluster by a kaniko job from the Dockerfile generated by
+ dockerfile.GenerateDockerfile, the build context is fetched from the connector itself
+ through `GET /v1/build-contexts/:name?token=`, so KubernetesConnectorURL must be reachable
+ from the builder pods.
+
+ Depending on KubernetesMode, the function is exposed as
+ - deployment: a Deployment with a ClusterIP Service in front of it
+ - knative:    a Knative Service which scales to zero when idle
+
+ Resources are named after the package filename, so that they can be looked up
+ without any local state and the connector itself stays stateless.
+*/
+
+type KubernetesMode string
+
+const (
+	KUBERNETES_MODE_DEPLOYMENT KubernetesMode = "deployment"
+	KUBERNETES_MODE_KNATIVE    KubernetesMode = "knative"
+)
+
+const (
+	KUBERNETES_LABEL_FUNCTION      = "dify.ai/function"
+	KUBERNETES_ANNOTATION_FILENAME = "dify.ai/filename"
+
+	// port the plugin listens on inside the container
+	KUBERNETES_FUNCTION_PORT = 8080
+
+	// max length of the resource names, leaves room for the suffix of build jobs
+	KUBERNETES_MAX_NAME_LENGTH = 50
+)
+
+type kubernetesBuildContext struct {
+	token string
+	data  []byte
+}
+
+type KubernetesBackend struct {
+	config *Config
+	client *kubernetesClient
+
+	pollInterval time.Duration
+
+	// build contexts waiting to be fetched by the builders, mapping function name to the context
+	contexts     map[string]*kubernetesBuildContext
+	contextsLock sync.Mutex
+
+	// serializes launches of the same function
+	launching     map[string]*sync.Mutex
+	launchingLock sync.Mutex
+}
+
+func NewKubernetesBackend(config *Config) (*KubernetesBackend, error) {
+	client, err := newKubernetesClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KubernetesBackend{
+		config:       config,
+		client:       client,
+		pollInterval: 2 * time.Second,
+		contexts:     make(map[string]*kubernetesBuildContext),
+		launching:    make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// kubernetesName generates a DNS-1035 compliant resource name from the package filename
+// which is in the format of `author@name@version@checksum.difypkg`
+func kubernetesName(filename string) string {
+	name := strings.ToLower(strings.TrimSuffix(filename, ".difypkg"))
+	name = functionNameInvalidChars.ReplaceAllString(name, "-")
+	name = strings.Trim(name, "-")
+
+	// names must start with a letter
+	name = "dify-" + name
+	if len(name) <= KUBERNETES_MAX_NAME_LENGTH {
+		return name
+	}
+
+	// keep it unique once truncated
+	hash := sha256.Sum256([]byte(filename))
+	suffix := hex.EncodeToString(hash[:])[:8]
+	name = strings.TrimRight(name[:KUBERNETES_MAX_NAME_LENGTH-len(suffix)-1], "-")
+	return name + "-" + suffix
+}
+
+func (b *KubernetesBackend) namespacePath(group string, resource string, name string) string {
+	prefix := "/api/v1"
+	if group != "" {
+		prefix = "/apis/" + group
+	}
+
+	p := fmt.Sprintf("%s/namespaces/%s/%s", prefix, b.config.KubernetesNamespace, resource)
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+func (b *KubernetesBackend) image(name string, filename string) string {
+	// tag with the hash of the filename, it changes once the package changes
+	hash := sha256.Sum256([]byte(filename))
+	return fmt.Sprintf(
+		"%s/%s:%s",
+		strings.TrimSuffix(b.config.KubernetesImageRegistry, "/"),
+		name,
+		hex.EncodeToString(hash[:])[:12],
+	)
+}
+
+func (b *KubernetesBackend) functionEnv() []map[string]any {
+	return []map[string]any{
+		{"name": "INSTALL_METHOD", "value": "serverless"},
+		{"name": "SERVERLESS_HOST", "value": "0.0.0.0"},
+		{"name": "SERVERLESS_PORT", "value": fmt.Sprintf("%d", KUBERNETES_FUNCTION_PORT)},
+	}
+}
+
+type kubernetesObjectMeta struct {
+	Name        string            `json:"name"`
+	UID         string            `json:"uid"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type kubernetesCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type kubernetesJob struct {
+	Metadata kubernetesObjectMeta `json:"metadata"`
+	Status   struct {
+		Succeeded  int                   `json:"succeeded"`
+		Failed     int                   `json:"failed"`
+		Conditions []kubernetesCondition `json:"conditions"`
+	} `json:"status"`
+}
+
+type kubernetesDeployment struct {
+	Metadata kubernetesObjectMeta `json:"metadata"`
+	Status   struct {
+		AvailableReplicas int `json:"availableReplicas"`
+	} `json:"status"`
+}
+
+type knativeService struct {
+	Metadata kubernetesObjectMeta `json:"metadata"`
+	Status   struct {
+		URL     string `json:"url"`
+		Address struct {
+			URL string `json:"url"`
+		} `json:"address"`
+		Conditions []kubernetesCondition `json:"conditions"`
+	} `json:"status"`
+}
+
+func (s *knativeService) ready() (bool, error) {
+	for _, condition := range s.Status.Conditions {
+		if condition.Type != "Ready" {
+			continue
+		}
+		switch condition.Status {
+		case "True":
+			return true, nil
+		case "False":
+			return false, fmt.Errorf("knative service is not ready: %s %s", condition.Reason, condition.Message)
+		}
+	}
+	return false, nil
+}
+
+func (s *knativeService) endpoint() string {
+	// prefer the cluster local address, the daemon runs in the same cluster in most cases
+	if s.Status.Address.URL != "" {
+		return s.Status.Address.URL
+	}
+	return s.Status.URL
+}
+
+// instance returns the running function, nil if it's not ready yet
+func (b *KubernetesBackend) instance(name string) (*serverless.RunnerInstance, error) {
+	instance := serverless.RunnerInstance{Name: name}
+
+	switch b.config.KubernetesMode {
+	case KUBERNETES_MODE_KNATIVE:
+		var service knativeService
+		if err := b.client.get(b.namespacePath("serving.knative.dev/v1", "services", name), &service); err != nil {
+			return nil, err
+		}
+
+		ready, err := service.ready()
+		if err != nil {
+			return nil, err
+		}
+		if !ready || service.endpoint() == "" {
+			return nil, nil
+		}
+
+		instance.ID = service.Metadata.UID
+		instance.Endpoint = service.endpoint()
+		instance.ResourceName = fmt.Sprintf("kubernetes:%s/services.serving.knative.dev/%s", b.config.KubernetesNamespace, name)
+	default:
+		var deployment kubernetesDeployment
+		if err := b.client.get(b.namespacePath("apps/v1", "deployments", name), &deployment); err != nil {
+			return nil, err
+		}
+
+		if deployment.Status.AvailableReplicas < 1 {
+			return nil, nil
+		}
+
+		// the service is created along with the deployment
+		if err := b.client.get(b.namespacePath("", "services", name), nil); err != nil {
+			return nil, err
+		}
+
+		instance.ID = deployment.Metadata.UID
+		instance.Endpoint = fmt.Sprintf("http://%s.%s.svc.%s", name, b.config.KubernetesNamespace, b.config.KubernetesClusterDomain)
+		instance.ResourceName = fmt.Sprintf("kubernetes:%s/deployments/%s", b.config.KubernetesNamespace, name)
+	}
+
+	instance.Status.State = "Running"
+	return &instance, nil
+}
+
+func (b *KubernetesBackend) Instances(filename string) ([]serverless.RunnerInstance, error) {
+	instance, err := b.instance(kubernetesName(filename))
+	if errors.Is(err, errKubernetesNotFound) {
+		return []serverless.RunnerInstance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return []serverless.RunnerInstance{}, nil
+	}
+
+	return []serverless.RunnerInstance{*instance}, nil
+}
+
+func (b *KubernetesBackend) lockFunction(name string) func() {
+	b.launchingLock.Lock()
+	lock, ok := b.launching[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.launching[name] = lock
+	}
+	b.launchingLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (b *KubernetesBackend) Launch(
+	filename string,
+	pkg []byte,
+	verified bool,
+	progress LaunchProgress,
+) (*serverless.RunnerInstance, error) {
+	progress(serverless.LAUNCH_STAGE_START, serverless.LAUNCH_STATE_RUNNING, "preparing package")
+
+	name := kubernetesName(filename)
+	unlock := b.lockFunction(name)
+	defer unlock()
+
+	deadline := time.Now().Add(time.Duration(b.config.LaunchTimeout) * time.Second)
+
+	// launching the same package twice returns the existing function
+	instance, err := b.instance(name)
+	if err != nil && !errors.Is(err, errKubernetesNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		if instance == nil {
+			progress(serverless.LAUNCH_STAGE_RUN, serverless.LAUNCH_STATE_RUNNING, "waiting for the function to be ready")
+			return b.waitFunction(name, deadline)
+		}
+		return instance, nil
+	}
+
+	buildContext, err := b.buildContext(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	image := b.image(name, filename)
+
+	progress(serverless.LAUNCH_STAGE_BUILD, serverless.LAUNCH_STATE_RUNNING, "building image "+image)
+	if err := b.buildImage(name, filename, image, buildContext, deadline); err != nil {
+		return nil, err
+	}
+	progress(serverless.LAUNCH_STAGE_BUILD, serverless.LAUNCH_STATE_SUCCESS, "image built")
+
+	progress(serverless.LAUNCH_STAGE_RUN, serverless.LAUNCH_STATE_RUNNING, "deploying function "+name)
+	if err := b.deploy(name, filename, image, verified); err != nil {
+		return nil, err
+	}
+
+	return b.waitFunction(name, deadline)
+}
+
+// buildContext generates the Dockerfile and packs it with the plugin files into a tar.gz
+func (b *KubernetesBackend) buildContext(pkg []byte) ([]byte, error) {
+	pluginDecoder, err := decoder.NewZipPluginDecoder(pkg)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("decode package error"))
+	}
+
+	manifest, err := pluginDecoder.Manifest()
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("get manifest error"))
+	}
+
+	dockerfileContent, err := dockerfile.GenerateDockerfile(&manifest)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("generate dockerfile error"))
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	gzipWriter := gzip.NewWriter(buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	writeFile := func(name string, data []byte) error {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(data)),
+		}); err != nil {
+			return err
+		}
+		_, err := tarWriter.Write(data)
+		return err
+	}
+
+	err = pluginDecoder.Walk(func(filename string, dir string) error {
+		// directories
+		if filename == "" {
+			return nil
+		}
+
+		fullPath := path.Join(dir, filename)
+		data, err := pluginDecoder.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		return writeFile(fullPath, data)
+	})
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("pack build context error"))
+	}
+
+	// the generated Dockerfile takes precedence over the one shipped in the package
+	if err := writeFile("Dockerfile", []byte(dockerfileContent)); err != nil {
+		return nil, err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// BuildContext returns the build context of the function, it's fetched by the builder job
+func (b *KubernetesBackend) BuildContext(name string, token string) ([]byte, bool) {
+	b.contextsLock.Lock()
+	defer b.contextsLock.Unlock()
+
+	context, ok := b.contexts[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(context.token), []byte(token)) != 1 {
+		return nil, false
+	}
+
+	return context.data, true
+}
+
+func (b *KubernetesBackend) buildImage(name string, filename string, image string, data []byte, deadline time.Time) error {
+	token := strings.ReplaceAll(uuid.New().String(), "-", "")
+
+	b.contextsLock.Lock()
+	b.contexts[name] = &kubernetesBuildContext{token: token, data: data}
+	b.contextsLock.Unlock()
+
+	defer func() {
+		b.contextsLock.Lock()
+		delete(b.contexts, name)
+		b.contextsLock.Unlock()
+	}()
+
+	contextURL := fmt.Sprintf(
+		"%s/v1/build-contexts/%s?token=%s",
+		strings.TrimSuffix(b.config.KubernetesConnectorURL, "/"),
+		name,
+		url.QueryEscape(token),
+	)
+
+	container := map[string]any{
+		"name":  "builder",
+		"image": b.config.KubernetesBuilderImage,
+		"args": []string{
+			"--context=" + contextURL,
+			"--dockerfile=Dockerfile",
+			"--destination=" + image,
+		},
+	}
+	podSpec := map[string]any{
+		"restartPolicy": "Never",
+		"containers":    []map[string]any{container},
+	}
+
+	if b.config.KubernetesRegistrySecret != "" {
+		container["volumeMounts"] = []map[string]any{
+			{"name": "docker-config", "mountPath": "/kaniko/.docker"},
+		}
+		podSpec["volumes"] = []map[string]any{
+			{
+				"name": "docker-config",
+				"secret": map[string]any{
+					"secretName": b.config.KubernetesRegistrySecret,
+					"items": []map[string]any{
+						{"key": ".dockerconfigjson", "path": "config.json"},
+					},
+				},
+			},
+		}
+	}
+
+	jobName := name + "-build"
+	jobPath := b.namespacePath("batch/v1", "jobs", jobName)
+
+	// a failed job of the previous launch blocks the new one
+	var job kubernetesJob
+	err := b.client.get(jobPath, &job)
+	if err == nil && job.Status.Failed > 0 {
+		if err := b.client.delete(jobPath); err != nil {
+			return err
+		}
+		if err := b.waitDeleted(jobPath, deadline); err != nil {
+			return err
+		}
+	} else if err != nil && !errors.Is(err, errKubernetesNotFound) {
+		return err
+	}
+
+	if err := b.client.create(b.namespacePath("batch/v1", "jobs", ""), map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":        jobName,
+			"labels":      map[string]string{KUBERNETES_LABEL_FUNCTION: name},
+			"annotations": map[string]string{KUBERNETES_ANNOTATION_FILENAME: filename},
+		},
+		"spec": map[string]any{
+			"backoffLimit":            0,
+			"ttlSecondsAfterFinished": 600,
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"labels": map[string]string{KUBERNETES_LABEL_FUNCTION: name},
+				},
+				"spec": podSpec,
+			},
+		},
+	}); err != nil {
+		return errors.Join(err, fmt.Errorf("create build job error"))
+	}
+
+	for {
+		var job kubernetesJob
+		if err := b.client.get(jobPath, &job); err != nil {
+			return errors.Join(err, fmt.Errorf("get build job error"))
+		}
+
+		if job.Status.Succeeded > 0 {
+			return nil
+		}
+
+		if job.Status.Failed > 0 {
+			message := "build job failed"
+			for _, condition := range job.Status.Conditions {
+				if condition.Type == "Failed" && condition.Message != "" {
+					message = fmt.Sprintf("build job failed: %s", condition.Message)
+				}
+			}
+			return errors.New(message)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("build image %s timeout", image)
+		}
+
+		time.Sleep(b.pollInterval)
+	}
+}
+
+func (b *KubernetesBackend) waitDeleted(resourcePath string, deadline time.Time) error {
+	for {
+		err := b.client.get(resourcePath, nil)
+		if errors.Is(err, errKubernetesNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wait for %s to be deleted timeout", resourcePath)
+		}
+		time.Sleep(b.pollInterval)
+	}
+}
+
+func (b *KubernetesBackend) deploy(name string, filename string, image string, verified bool) error {
+	labels := map[string]string{KUBERNETES_LABEL_FUNCTION: name}
+	metadata := map[string]any{
+		"name":   name,
+		"labels": labels,
+		"annotations": map[string]string{
+			KUBERNETES_ANNOTATION_FILENAME: filename,
+			"dify.ai/verified":             fmt.Sprintf("%t", verified),
+		},
+	}
+
+	container := map[string]any{
+		"name":  "plugin",
+		"image": image,
+		"env":   b.functionEnv(),
+		"ports": []map[string]any{
+			{"containerPort": KUBERNETES_FUNCTION_PORT},
+		},
+	}
+
+	if b.config.KubernetesMode == KUBERNETES_MODE_KNATIVE {
+		return b.client.create(b.namespacePath("serving.knative.dev/v1", "services", ""), map[string]any{
+			"apiVersion": "serving.knative.dev/v1",
+			"kind":       "Service",
+			"metadata":   metadata,
+			"spec": map[string]any{
+				"template": map[string]any{
+					"metadata": map[string]any{
+						"labels": labels,
+						"annotations": map[string]string{
+							"autoscaling.knative.dev/min-scale": "0",
+						},
+					},
+					"spec": map[string]any{
+						"containers": []map[string]any{container},
+					},
+				},
+			},
+		})
+	}
+
+	container["readinessProbe"] = map[string]any{
+		"tcpSocket": map[string]any{"port": KUBERNETES_FUNCTION_PORT},
+	}
+
+	if err := b.client.create(b.namespacePath("apps/v1", "deployments", ""), map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"replicas": 1,
+			"selector": map[string]any{"matchLabels": labels},
+			"template": map[string]any{
+				"metadata": map[string]any{"labels": labels},
+				"spec": map[string]any{
+					"containers": []map[string]any{container},
+				},
+			},
+		},
+	}); err != nil {
+		return errors.Join(err, fmt.Errorf("create deployment error"))
+	}
+
+	if err := b.client.create(b.namespacePath("", "services", ""), map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   metadata,
+		"spec": map[string]any{
+			"selector": labels,
+			"ports": []map[string]any{
+				{"port": 80, "targetPort": KUBERNETES_FUNCTION_PORT},
+			},
+		},
+	}); err != nil {
+		return errors.Join(err, fmt.Errorf("create service error"))
+	}
+
+	return nil
+}
+
+func (b *KubernetesBackend) waitFunction(name string, deadline time.Time) (*serverless.RunnerInstance, error) {
+	for {
+		instance, err := b.instance(name)
+		if err != nil {
+			return nil, err
+		}
+		if instance != nil {
+			return instance, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("launch function %s timeout", name)
+		}
+
+		time.Sleep(b.pollInterval)
+	}
+}
@@ -0,0 +1,137 @@
+package connector
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	errKubernetesNotFound = errors.New("kubernetes resource not found")
+	errKubernetesConflict = errors.New("kubernetes resource already exists")
+)
+
+// kubernetesClient is a minimal client of the kubernetes rest api, only json resources are supported
+type kubernetesClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func newKubernetesClient(config *Config) (*kubernetesClient, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.KubernetesInsecure,
+	}
+
+	if config.KubernetesCAFile != "" {
+		ca, err := os.ReadFile(config.KubernetesCAFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("invalid kubernetes ca file: %s", config.KubernetesCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	token := config.KubernetesToken
+	if token == "" && config.KubernetesTokenFile != "" {
+		data, err := os.ReadFile(config.KubernetesTokenFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	return &kubernetesClient{
+		server: strings.TrimSuffix(config.KubernetesAPIServer, "/"),
+		token:  token,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   30 * time.Second,
+		},
+	}, nil
+}
+
+func (k *kubernetesClient) request(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, k.server+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if k.token != "" {
+		request.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	response, err := k.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return errKubernetesNotFound
+	case response.StatusCode == http.StatusConflict:
+		return errKubernetesConflict
+	case response.StatusCode >= 300:
+		return fmt.Errorf("kubernetes api %s %s failed with status %d: %s", method, path, response.StatusCode, data)
+	}
+
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+
+	return nil
+}
+
+func (k *kubernetesClient) get(path string, out any) error {
+	return k.request(http.MethodGet, path, nil, out)
+}
+
+// create creates the resource, it's not an error if the resource already exists
+func (k *kubernetesClient) create(path string, resource any) error {
+	err := k.request(http.MethodPost, path, resource, nil)
+	if errors.Is(err, errKubernetesConflict) {
+		return nil
+	}
+	return err
+}
+
+func (k *kubernetesClient) delete(path string) error {
+	err := k.request(http.MethodDelete, path, map[string]any{
+		"propagationPolicy": "Background",
+	}, nil)
+	if errors.Is(err, errKubernetesNotFound) {
+		return nil
+	}
+	return err
+}
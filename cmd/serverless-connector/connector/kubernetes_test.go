@@ -0,0 +1,365 @@
+package connector
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/pkg/plugin_packager/decoder"
+	"github.com/langgenius/dify-plugin-daemon/pkg/plugin_packager/packager"
+)
+
+const testFilename = "yeuoly@neko@0.0.1@0123456789abcdef0123456789abcdef.difypkg"
+
+// fakeKubernetes is a fake kubernetes api server which keeps resources in memory,
+// jobs succeed, deployments become available and knative services become ready once created
+type fakeKubernetes struct {
+	lock      sync.Mutex
+	resources map[string]map[string]any
+	// fetched build contexts, mapping job name to the files in the context
+	contexts map[string]map[string][]byte
+	failJobs bool
+}
+
+func (f *fakeKubernetes) fetchContext(t *testing.T, args []any) map[string][]byte {
+	for _, arg := range args {
+		contextURL, ok := strings.CutPrefix(arg.(string), "--context=")
+		if !ok {
+			continue
+		}
+
+		response, err := http.Get(contextURL)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("fetch build context failed with status %d", response.StatusCode)
+			return nil
+		}
+
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+
+		files := map[string][]byte{}
+		tarReader := tar.NewReader(gzipReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Error(err)
+				return nil
+			}
+			data, _ := io.ReadAll(tarReader)
+			files[header.Name] = data
+		}
+		return files
+	}
+
+	t.Error("context is not specified")
+	return nil
+}
+
+func (f *fakeKubernetes) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			f.lock.Lock()
+			resource, ok := f.resources[r.URL.Path]
+			f.lock.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(resource)
+		case http.MethodPost:
+			var resource map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			metadata := resource["metadata"].(map[string]any)
+			name := metadata["name"].(string)
+			metadata["uid"] = uuid.New().String()
+			resourcePath := r.URL.Path + "/" + name
+
+			switch resource["kind"] {
+			case "Job":
+				spec := resource["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)
+				container := spec["containers"].([]any)[0].(map[string]any)
+				files := f.fetchContext(t, container["args"].([]any))
+
+				f.lock.Lock()
+				f.contexts[name] = files
+				f.lock.Unlock()
+
+				if f.failJobs {
+					resource["status"] = map[string]any{
+						"failed": 1,
+						"conditions": []map[string]any{
+							{"type": "Failed", "status": "True", "message": "push denied"},
+						},
+					}
+				} else {
+					resource["status"] = map[string]any{"succeeded": 1}
+				}
+			case "Deployment":
+				resource["status"] = map[string]any{"availableReplicas": 1}
+			case "Service":
+				if resource["apiVersion"] == "serving.knative.dev/v1" {
+					resource["status"] = map[string]any{
+						"url":     "http://" + name + ".example.com",
+						"address": map[string]any{"url": "http://" + name + ".default.svc.cluster.local"},
+						"conditions": []map[string]any{
+							{"type": "Ready", "status": "True"},
+						},
+					}
+				}
+			}
+
+			f.lock.Lock()
+			defer f.lock.Unlock()
+			if _, ok := f.resources[resourcePath]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			f.resources[resourcePath] = resource
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(resource)
+		case http.MethodDelete:
+			f.lock.Lock()
+			delete(f.resources, r.URL.Path)
+			f.lock.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+func testPackage(t *testing.T) []byte {
+	testdata := "../../../pkg/plugin_packager/testdata"
+	dir := t.TempDir()
+
+	for _, file := range []string{"manifest.yaml", "neko.yaml", "_assets/test.svg"} {
+		data, err := os.ReadFile(path.Join(testdata, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(path.Join(dir, path.Dir(file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(dir, file), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pluginDecoder, err := decoder.NewFSPluginDecoder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := packager.NewPackager(pluginDecoder).Pack(52428800)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pkg
+}
+
+func setupKubernetesBackend(t *testing.T, mode KubernetesMode) (*KubernetesBackend, *fakeKubernetes) {
+	fake := &fakeKubernetes{
+		resources: map[string]map[string]any{},
+		contexts:  map[string]map[string][]byte{},
+	}
+	apiServer := httptest.NewServer(fake.handler(t))
+	t.Cleanup(apiServer.Close)
+
+	config := &Config{
+		ServerKey:               testKey,
+		Backend:                 BACKEND_KUBERNETES,
+		KubernetesAPIServer:     apiServer.URL,
+		KubernetesToken:         "test-token",
+		KubernetesMode:          mode,
+		KubernetesImageRegistry: "registry.example.com/plugins",
+	}
+	config.SetDefault()
+
+	backend, err := NewKubernetesBackend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.pollInterval = 10 * time.Millisecond
+
+	// builders fetch the build contexts from the connector
+	connector := httptest.NewServer(NewServer(config, backend).Handler())
+	t.Cleanup(connector.Close)
+	config.KubernetesConnectorURL = connector.URL
+
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return backend, fake
+}
+
+func noProgress(stage serverless.LaunchStage, state serverless.LaunchState, message string) {}
+
+func TestKubernetesName(t *testing.T) {
+	name := kubernetesName(testFilename)
+	if len(name) > KUBERNETES_MAX_NAME_LENGTH {
+		t.Fatalf("name %s is too long", name)
+	}
+	if !strings.HasPrefix(name, "dify-yeuoly-neko-0-0-1-") {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name != kubernetesName(testFilename) {
+		t.Fatal("name should be stable")
+	}
+	if name == kubernetesName(strings.Replace(testFilename, "0123", "3210", 1)) {
+		t.Fatal("names of different packages should be different")
+	}
+}
+
+func TestKubernetesLaunchDeployment(t *testing.T) {
+	backend, fake := setupKubernetesBackend(t, KUBERNETES_MODE_DEPLOYMENT)
+
+	instances, err := backend.Instances(testFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Fatalf("expected no instances, got %v", instances)
+	}
+
+	instance, err := backend.Launch(testFilename, testPackage(t), true, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := kubernetesName(testFilename)
+	if instance.Name != name || instance.ID == "" {
+		t.Fatalf("unexpected instance %v", instance)
+	}
+	if instance.Endpoint != "http://"+name+".default.svc.cluster.local" {
+		t.Fatalf("unexpected endpoint %s", instance.Endpoint)
+	}
+
+	files := fake.contexts[name+"-build"]
+	if !bytes.Contains(files["Dockerfile"], []byte(`"-m", "main"`)) {
+		t.Fatalf("unexpected Dockerfile %s", files["Dockerfile"])
+	}
+	if _, ok := files["manifest.yaml"]; !ok {
+		t.Fatal("plugin files should be in the build context")
+	}
+
+	deployment := fake.resources["/apis/apps/v1/namespaces/default/deployments/"+name]
+	if deployment == nil || fake.resources["/api/v1/namespaces/default/services/"+name] == nil {
+		t.Fatal("deployment and service should be created")
+	}
+	container := deployment["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+	if container["image"] != backend.image(name, testFilename) {
+		t.Fatalf("unexpected image %s", container["image"])
+	}
+
+	instances, err = backend.Instances(testFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 1 || instances[0].ID != instance.ID {
+		t.Fatalf("unexpected instances %v", instances)
+	}
+
+	// launching again returns the existing function
+	again, err := backend.Launch(testFilename, testPackage(t), true, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != instance.ID {
+		t.Fatal("function should not be launched twice")
+	}
+
+	// build contexts are dropped once built
+	if _, ok := backend.BuildContext(name, ""); ok {
+		t.Fatal("build context should be dropped")
+	}
+}
+
+func TestKubernetesLaunchKnative(t *testing.T) {
+	backend, fake := setupKubernetesBackend(t, KUBERNETES_MODE_KNATIVE)
+
+	instance, err := backend.Launch(testFilename, testPackage(t), false, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := kubernetesName(testFilename)
+	if instance.Endpoint != "http://"+name+".default.svc.cluster.local" {
+		t.Fatalf("unexpected endpoint %s", instance.Endpoint)
+	}
+	if fake.resources["/apis/serving.knative.dev/v1/namespaces/default/services/"+name] == nil {
+		t.Fatal("knative service should be created")
+	}
+	if fake.resources["/apis/apps/v1/namespaces/default/deployments/"+name] != nil {
+		t.Fatal("deployment should not be created in knative mode")
+	}
+}
+
+func TestKubernetesBuildFailed(t *testing.T) {
+	backend, fake := setupKubernetesBackend(t, KUBERNETES_MODE_DEPLOYMENT)
+	fake.failJobs = true
+
+	_, err := backend.Launch(testFilename, testPackage(t), true, noProgress)
+	if err == nil || !strings.Contains(err.Error(), "push denied") {
+		t.Fatalf("expected build failure, got %v", err)
+	}
+
+	instances, err := backend.Instances(testFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 0 {
+		t.Fatal("failed function should not be listed")
+	}
+}
+
+func TestKubernetesBuildContextToken(t *testing.T) {
+	backend, _ := setupKubernetesBackend(t, KUBERNETES_MODE_DEPLOYMENT)
+	backend.contexts["function"] = &kubernetesBuildContext{token: "token", data: []byte("context")}
+
+	server := httptest.NewServer(NewServer(backend.config, backend).Handler())
+	defer server.Close()
+
+	for token, status := range map[string]int{"token": http.StatusOK, "wrong": http.StatusNotFound} {
+		response, err := http.Get(server.URL + "/v1/build-contexts/function?token=" + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != status {
+			t.Fatalf("expected %d with token %s, got %d", status, token, response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/lifecycle"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/local_runtime"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/plugin_packager/decoder"
+)
+
+/*
+ LocalBackend runs every function as a local plugin process behind its own http server.
+
+ Layout of the working path:
+
+	<working_path>/<function_name>/function.json   metadata of the function
+	<working_path>/<function_name>/package.difypkg the uploaded package
+	<working_path>/<function_name>/plugin/         the extracted package
+
+ Functions are relaunched on the same ports once the connector restarts,
+ so that the function urls stored by the daemon stay valid.
+*/
+
+const (
+	LOCAL_FUNCTION_METADATA = "function.json"
+	LOCAL_FUNCTION_PACKAGE  = "package.difypkg"
+	LOCAL_FUNCTION_PLUGIN   = "plugin"
+)
+
+type localFunctionMetadata struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Filename string `json:"filename"`
+	Port     int    `json:"port"`
+	Verified bool   `json:"verified"`
+}
+
+type localFunction struct {
+	metadata localFunctionMetadata
+
+	runtime  *local_runtime.LocalPluginRuntime
+	listener net.Listener
+	server   *http.Server
+
+	// closed once the function is ready or failed to launch
+	launched chan bool
+	err      error
+}
+
+func (f *localFunction) instance(host string) serverless.RunnerInstance {
+	instance := serverless.RunnerInstance{
+		ID:           f.metadata.ID,
+		Name:         f.metadata.Name,
+		Endpoint:     fmt.Sprintf("http://%s:%d", host, f.metadata.Port),
+		ResourceName: "local:" + f.metadata.Name,
+	}
+	instance.Status.State = "Running"
+	return instance
+}
+
+type LocalBackend struct {
+	config *Config
+
+	// functions mapping filename to the function
+	functions     map[string]*localFunction
+	functionsLock sync.Mutex
+}
+
+func NewLocalBackend(config *Config) (*LocalBackend, error) {
+	if err := os.MkdirAll(config.LocalWorkingPath, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalBackend{
+		config:    config,
+		functions: make(map[string]*localFunction),
+	}, nil
+}
+
+func (b *LocalBackend) Instances(filename string) ([]serverless.RunnerInstance, error) {
+	b.functionsLock.Lock()
+	function, ok := b.functions[filename]
+	b.functionsLock.Unlock()
+
+	if !ok {
+		return []serverless.RunnerInstance{}, nil
+	}
+
+	// launching functions are not ready to serve
+	select {
+	case <-function.launched:
+	default:
+		return []serverless.RunnerInstance{}, nil
+	}
+
+	if function.err != nil {
+		return []serverless.RunnerInstance{}, nil
+	}
+
+	return []serverless.RunnerInstance{function.instance(b.config.LocalFunctionHost)}, nil
+}
+
+func (b *LocalBackend) Launch(
+	filename string,
+	pkg []byte,
+	verified bool,
+	progress LaunchProgress,
+) (*serverless.RunnerInstance, error) {
+	progress(serverless.LAUNCH_STAGE_START, serverless.LAUNCH_STATE_RUNNING, "preparing package")
+
+	pluginDecoder, err := decoder.NewZipPluginDecoder(pkg)
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("decode package error"))
+	}
+
+	manifest, err := pluginDecoder.Manifest()
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("get manifest error"))
+	}
+
+	checksum, err := pluginDecoder.Checksum()
+	if err != nil {
+		return nil, errors.Join(err, fmt.Errorf("calculate checksum error"))
+	}
+
+	b.functionsLock.Lock()
+	function, ok := b.functions[filename]
+	if ok {
+		select {
+		case <-function.launched:
+			// relaunch failed functions
+			if function.err != nil {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		function = &localFunction{
+			metadata: localFunctionMetadata{
+				ID:       uuid.New().String(),
+				Name:     functionName(manifest, checksum),
+				Filename: filename,
+				Verified: verified,
+			},
+			launched: make(chan bool),
+		}
+		b.functions[filename] = function
+	}
+	b.functionsLock.Unlock()
+
+	if !ok {
+		b.launchFunction(function, pkg, progress)
+	} else {
+		progress(serverless.LAUNCH_STAGE_RUN, serverless.LAUNCH_STATE_RUNNING, "waiting for the function to be ready")
+	}
+
+	select {
+	case <-function.launched:
+	case <-time.After(time.Duration(b.config.LaunchTimeout) * time.Second):
+		return nil, fmt.Errorf("launch function %s timeout", function.metadata.Name)
+	}
+
+	if function.err != nil {
+		return nil, function.err
+	}
+
+	instance := function.instance(b.config.LocalFunctionHost)
+	return &instance, nil
+}
+
+// Restore relaunches the functions found in the working path
+func (b *LocalBackend) Restore() error {
+	entries, err := os.ReadDir(b.config.LocalWorkingPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		functionPath := path.Join(b.config.LocalWorkingPath, entry.Name())
+		metadataBytes, err := os.ReadFile(path.Join(functionPath, LOCAL_FUNCTION_METADATA))
+		if err != nil {
+			continue
+		}
+
+		metadata, err := parser.UnmarshalJsonBytes[localFunctionMetadata](metadataBytes)
+		if err != nil {
+			log.Error("invalid function metadata in %s: %s", functionPath, err.Error())
+			continue
+		}
+
+		pkg, err := os.ReadFile(path.Join(functionPath, LOCAL_FUNCTION_PACKAGE))
+		if err != nil {
+			log.Error("failed to read package of function %s: %s", metadata.Name, err.Error())
+			continue
+		}
+
+		function := &localFunction{
+			metadata: metadata,
+			launched: make(chan bool),
+		}
+
+		b.functionsLock.Lock()
+		b.functions[metadata.Filename] = function
+		b.functionsLock.Unlock()
+
+		routine.Submit(map[string]string{
+			"module":   "serverless_connector",
+			"function": "Restore",
+			"name":     metadata.Name,
+		}, func() {
+			b.launchFunction(function, pkg, func(stage serverless.LaunchStage, state serverless.LaunchState, message string) {
+				log.Info("restoring function %s: [%s] %s", metadata.Name, stage, message)
+			})
+		})
+	}
+
+	return nil
+}
+
+// launchFunction builds the environment, starts the plugin and serves it
+// function.launched is closed once it's done
+func (b *LocalBackend) launchFunction(function *localFunction, pkg []byte, progress LaunchProgress) {
+	err := b.startFunction(function, pkg, progress)
+	if err != nil {
+		function.err = err
+		if function.runtime != nil {
+			function.runtime.Stop()
+		}
+		if function.listener != nil {
+			function.listener.Close()
+		}
+	}
+	close(function.launched)
+}
+
+func (b *LocalBackend) startFunction(function *localFunction, pkg []byte, progress LaunchProgress) error {
+	functionPath := path.Join(b.config.LocalWorkingPath, function.metadata.Name)
+	pluginPath := path.Join(functionPath, LOCAL_FUNCTION_PLUGIN)
+
+	pluginDecoder, err := decoder.NewZipPluginDecoder(pkg)
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("decode package error"))
+	}
+
+	manifest, err := pluginDecoder.Manifest()
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("get manifest error"))
+	}
+
+	if err := os.MkdirAll(functionPath, 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path.Join(functionPath, LOCAL_FUNCTION_PACKAGE), pkg, 0644); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(pluginPath); err != nil {
+		if err := pluginDecoder.ExtractTo(pluginPath); err != nil {
+			return errors.Join(err, fmt.Errorf("extract package error"))
+		}
+	}
+
+	// reserve the port first, the function url is returned only if the plugin is ready
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", b.config.LocalFunctionHost, function.metadata.Port))
+	if err != nil {
+		return errors.Join(err, fmt.Errorf("listen function port error"))
+	}
+	function.listener = listener
+	function.metadata.Port = listener.Addr().(*net.TCPAddr).Port
+
+	if err := os.WriteFile(
+		path.Join(functionPath, LOCAL_FUNCTION_METADATA),
+		parser.MarshalJsonBytes(function.metadata),
+		0644,
+	); err != nil {
+		return err
+	}
+
+	runtime := local_runtime.NewLocalPluginRuntime(local_runtime.LocalPluginRuntimeConfig{
+		PythonInterpreterPath: b.config.PythonInterpreterPath,
+		UvPath:                b.config.UvPath,
+		PythonEnvInitTimeout:  b.config.PythonEnvInitTimeout,
+		PipMirrorUrl:          b.config.PipMirrorUrl,
+		PipExtraArgs:          b.config.PipExtraArgs,
+	})
+	runtime.PluginRuntime = plugin_entities.PluginRuntime{
+		Config: manifest,
+		State: plugin_entities.PluginRuntimeState{
+			Status:      plugin_entities.PLUGIN_RUNTIME_STATUS_PENDING,
+			Verified:    function.metadata.Verified,
+			WorkingPath: pluginPath,
+		},
+	}
+	runtime.BasicChecksum = basic_runtime.BasicChecksum{
+		WorkingPath: pluginPath,
+		Decoder:     pluginDecoder,
+	}
+	function.runtime = runtime
+
+	progress(serverless.LAUNCH_STAGE_BUILD, serverless.LAUNCH_STATE_RUNNING, "building environment")
+
+	launchedChan := make(chan bool)
+	errChan := make(chan error, 1)
+
+	// started events are dropped if nobody is waiting, keep waiting from now on
+	startedChan := make(chan bool, 1)
+	stopWaitingStarted := make(chan bool)
+	defer close(stopWaitingStarted)
+	waitStarted := runtime.WaitStarted()
+	go func() {
+		select {
+		case <-waitStarted:
+			startedChan <- true
+		case <-stopWaitingStarted:
+		}
+	}()
+
+	routine.Submit(map[string]string{
+		"module":   "serverless_connector",
+		"function": "startFunction",
+		"name":     function.metadata.Name,
+	}, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error("function %s panic: %v", function.metadata.Name, r)
+			}
+		}()
+
+		// the plugin is restarted by the lifecycle if it exits unexpectedly
+		lifecycle.FullDuplex(runtime, launchedChan, errChan)
+	})
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return err
+		}
+	case <-launchedChan:
+	}
+
+	progress(serverless.LAUNCH_STAGE_RUN, serverless.LAUNCH_STATE_RUNNING, "starting plugin")
+
+	select {
+	case <-startedChan:
+	case <-time.After(time.Duration(b.config.LaunchTimeout) * time.Second):
+		return fmt.Errorf("plugin %s did not start in time", manifest.Identity())
+	}
+
+	function.server = &http.Server{Handler: functionHandler(runtime, b.config.ServerKey)}
+	routine.Submit(map[string]string{
+		"module":   "serverless_connector",
+		"function": "serveFunction",
+		"name":     function.metadata.Name,
+	}, func() {
+		if err := function.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("function %s server stopped: %s", function.metadata.Name, err.Error())
+		}
+	})
+
+	log.Info("function %s is serving on %s", function.metadata.Name, listener.Addr().String())
+	return nil
+}
+
+// Stop stops all the functions, they are relaunched by Restore
+func (b *LocalBackend) Stop() {
+	b.functionsLock.Lock()
+	defer b.functionsLock.Unlock()
+
+	for _, function := range b.functions {
+		if function.server != nil {
+			function.server.Close()
+		}
+		if function.runtime != nil {
+			function.runtime.Stop()
+		}
+	}
+}
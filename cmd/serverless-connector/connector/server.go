@@ -0,0 +1,192 @@
+package connector
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+)
+
+/*
+ Server implements the serverless connector api used by the daemon on PLATFORM_SERVERLESS,
+ see internal/core/plugin_manager/serverless_connector for the client side.
+
+ - POST /ping                 returns "pong"
+ - GET  /v1/runner/instances  returns the functions launched from a package
+ - POST /v1/launch            builds a package and runs it as a function, progress is streamed as events
+
+ Backends building images remotely additionally serve `GET /v1/build-contexts/:name?token=`,
+ it's protected by the one-time token instead of the api key as builders have no access to the key.
+*/
+
+// BuildContextProvider is implemented by backends whose builders fetch the build context from the connector
+type BuildContextProvider interface {
+	BuildContext(name string, token string) ([]byte, bool)
+}
+
+type Server struct {
+	config  *Config
+	backend Backend
+}
+
+func NewServer(config *Config, backend Backend) *Server {
+	return &Server{
+		config:  config,
+		backend: backend,
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+
+	if provider, ok := s.backend.(BuildContextProvider); ok {
+		engine.GET("/v1/build-contexts/:name", s.buildContext(provider))
+	}
+
+	api := engine.Group("")
+	api.Use(s.checkingKey())
+	api.POST("/ping", s.ping)
+	api.GET("/v1/runner/instances", s.instances)
+	api.POST("/v1/launch", s.launch)
+
+	return engine
+}
+
+func (s *Server) Run() error {
+	addr := fmt.Sprintf("%s:%d", s.config.ServerHost, s.config.ServerPort)
+	log.Info("serverless connector is listening on %s", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func (s *Server) checkingKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.config.ServerKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) ping(c *gin.Context) {
+	c.JSON(http.StatusOK, "pong")
+}
+
+func (s *Server) buildContext(provider BuildContextProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, ok := provider.BuildContext(c.Param("name"), c.Query("token"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "build context not found"})
+			return
+		}
+
+		c.Data(http.StatusOK, "application/gzip", data)
+	}
+}
+
+func (s *Server) instances(c *gin.Context) {
+	filename := c.Query("filename")
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, serverless.RunnerInstances{
+			Error: "filename is required",
+			Items: []serverless.RunnerInstance{},
+		})
+		return
+	}
+
+	instances, err := s.backend.Instances(filename)
+	if err != nil {
+		c.JSON(http.StatusOK, serverless.RunnerInstances{
+			Error: err.Error(),
+			Items: []serverless.RunnerInstance{},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, serverless.RunnerInstances{
+		Items: instances,
+	})
+}
+
+func (s *Server) launch(c *gin.Context) {
+	contextFile, err := c.FormFile("context")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "context is required"})
+		return
+	}
+
+	file, err := contextFile.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	pkg, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	verified := c.PostForm("verified") == "true"
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+
+	var (
+		lock      sync.Mutex
+		finished  bool
+		lastStage = serverless.LAUNCH_STAGE_START
+	)
+
+	writeChunk := func(chunk serverless.LaunchFunctionResponseChunk) {
+		lock.Lock()
+		defer lock.Unlock()
+		if finished {
+			return
+		}
+
+		lastStage = chunk.Stage
+		c.Writer.Write([]byte("data: "))
+		c.Writer.Write(parser.MarshalJsonBytes(chunk))
+		c.Writer.Write([]byte("\n\n"))
+		c.Writer.Flush()
+	}
+
+	progress := func(stage serverless.LaunchStage, state serverless.LaunchState, message string) {
+		writeChunk(serverless.LaunchFunctionResponseChunk{
+			Stage:   stage,
+			Obj:     contextFile.Filename,
+			State:   state,
+			Message: message,
+		})
+	}
+
+	instance, err := s.backend.Launch(contextFile.Filename, pkg, verified, progress)
+	if err != nil {
+		log.Error("launch function %s failed: %s", contextFile.Filename, err.Error())
+		lock.Lock()
+		stage := lastStage
+		lock.Unlock()
+		progress(stage, serverless.LAUNCH_STATE_FAILED, err.Error())
+	} else {
+		progress(serverless.LAUNCH_STAGE_RUN, serverless.LAUNCH_STATE_SUCCESS, fmt.Sprintf(
+			"endpoint=%s,name=%s,id=%s", instance.Endpoint, instance.Name, instance.ID,
+		))
+		progress(serverless.LAUNCH_STAGE_END, serverless.LAUNCH_STATE_SUCCESS, "")
+	}
+
+	// the backend may keep reporting after a failure, drop them
+	lock.Lock()
+	finished = true
+	lock.Unlock()
+}
@@ -0,0 +1,396 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/manifest_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+const testKey = "test-connector-key"
+
+type fakeBackend struct {
+	instances map[string]serverless.RunnerInstance
+	fail      bool
+}
+
+func (b *fakeBackend) Instances(filename string) ([]serverless.RunnerInstance, error) {
+	if instance, ok := b.instances[filename]; ok {
+		return []serverless.RunnerInstance{instance}, nil
+	}
+	return []serverless.RunnerInstance{}, nil
+}
+
+func (b *fakeBackend) Launch(
+	filename string,
+	pkg []byte,
+	verified bool,
+	progress LaunchProgress,
+) (*serverless.RunnerInstance, error) {
+	progress(serverless.LAUNCH_STAGE_START, serverless.LAUNCH_STATE_RUNNING, "preparing package")
+	progress(serverless.LAUNCH_STAGE_BUILD, serverless.LAUNCH_STATE_RUNNING, "building environment")
+	if b.fail {
+		return nil, errors.New("build failed")
+	}
+
+	instance := serverless.RunnerInstance{
+		ID:           "id",
+		Name:         "function",
+		Endpoint:     "http://127.0.0.1:12345",
+		ResourceName: "local:function",
+	}
+	instance.Status.State = "Running"
+	b.instances[filename] = instance
+	return &instance, nil
+}
+
+func testManifest() plugin_entities.PluginDeclaration {
+	return plugin_entities.PluginDeclaration{
+		PluginDeclarationWithoutAdvancedFields: plugin_entities.PluginDeclarationWithoutAdvancedFields{
+			Version: manifest_entities.Version("0.0.1"),
+			Author:  "langgenius",
+			Name:    "test",
+		},
+	}
+}
+
+func setupConnector(t *testing.T, backend Backend) {
+	routine.InitPool(1024)
+
+	config := &Config{ServerKey: testKey}
+	config.SetDefault()
+	server := httptest.NewServer(NewServer(config, backend).Handler())
+	t.Cleanup(server.Close)
+
+	key := testKey
+	serverless.Init(&app.Config{
+		DifyPluginServerlessConnectorURL:    &server.URL,
+		DifyPluginServerlessConnectorAPIKey: &key,
+	})
+}
+
+func TestConnectorLaunchAndFetch(t *testing.T) {
+	setupConnector(t, &fakeBackend{instances: map[string]serverless.RunnerInstance{}})
+
+	manifest := testManifest()
+	if _, err := serverless.FetchFunction(manifest, "checksum"); err != serverless.ErrFunctionNotFound {
+		t.Fatalf("expected ErrFunctionNotFound, got %v", err)
+	}
+
+	response, err := serverless.SetupFunction(manifest, "checksum", bytes.NewReader([]byte("package")), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := map[serverless.LaunchFunctionEvent]string{}
+	for response.Next() {
+		event, err := response.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events[event.Event] = event.Message
+	}
+
+	if events[serverless.FunctionUrl] != "http://127.0.0.1:12345" || events[serverless.Function] != "function" {
+		t.Fatalf("unexpected launch events: %v", events)
+	}
+	if _, ok := events[serverless.Done]; !ok {
+		t.Fatal("launch should be done")
+	}
+	if _, ok := events[serverless.Error]; ok {
+		t.Fatalf("unexpected error: %s", events[serverless.Error])
+	}
+
+	function, err := serverless.FetchFunction(manifest, "checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if function.FunctionURL != "http://127.0.0.1:12345" {
+		t.Fatalf("unexpected function url %s", function.FunctionURL)
+	}
+}
+
+func TestConnectorLaunchFailed(t *testing.T) {
+	setupConnector(t, &fakeBackend{instances: map[string]serverless.RunnerInstance{}, fail: true})
+
+	response, err := serverless.SetupFunction(testManifest(), "checksum", bytes.NewReader([]byte("package")), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := false
+	for response.Next() {
+		event, err := response.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Event == serverless.Error && event.Message == "build failed" {
+			failed = true
+		}
+	}
+
+	if !failed {
+		t.Fatal("launch should be failed")
+	}
+}
+
+func TestConnectorUnauthorized(t *testing.T) {
+	config := &Config{ServerKey: testKey}
+	config.SetDefault()
+	server := httptest.NewServer(NewServer(config, &fakeBackend{}).Handler())
+	defer server.Close()
+
+	request, _ := http.NewRequest("POST", server.URL+"/ping", nil)
+	request.Header.Set("Authorization", "wrong-key")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", response.StatusCode)
+	}
+}
+
+// echoRuntime replies every request with a stream chunk and an end message
+type echoRuntime struct {
+	listeners map[string]*entities.Broadcast[plugin_entities.SessionMessage]
+}
+
+func (r *echoRuntime) Listen(session_id string) *entities.Broadcast[plugin_entities.SessionMessage] {
+	listener := entities.NewBroadcast[plugin_entities.SessionMessage]()
+	r.listeners[session_id] = listener
+	return listener
+}
+
+func (r *echoRuntime) Write(session_id string, action access_types.PluginAccessAction, data []byte) {
+	listener := r.listeners[session_id]
+	go func() {
+		listener.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_STREAM,
+			Data: parser.MarshalJsonBytes(map[string]string{"action": string(action)}),
+		})
+		listener.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_END,
+			Data: []byte("null"),
+		})
+	}()
+}
+
+func TestFunctionInvoke(t *testing.T) {
+	server := httptest.NewServer(functionHandler(&echoRuntime{
+		listeners: map[string]*entities.Broadcast[plugin_entities.SessionMessage]{},
+	}, "api-key"))
+	defer server.Close()
+
+	newRequest := func(token string) *http.Request {
+		request, err := http.NewRequest(
+			http.MethodPost,
+			server.URL+"/invoke?action=invoke_tool",
+			strings.NewReader(`{"session_id":"session","event":"request","data":{}}`),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set(serverless.HEADER_FUNCTION_TOKEN, token)
+		}
+		return request
+	}
+
+	// invocations without the function token are refused, the api key itself is not accepted either
+	for _, token := range []string{"", "api-key"} {
+		response, err := http.DefaultClient.Do(newRequest(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 with token %q, got %d", token, response.StatusCode)
+		}
+	}
+
+	response, err := http.DefaultClient.Do(newRequest(serverless.FunctionToken("api-key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	messages := []plugin_entities.SessionMessage{}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		plugin_entities.ParsePluginUniversalEvent(
+			scanner.Bytes(),
+			response.Status,
+			func(session_id string, data []byte) {
+				if session_id != "session" {
+					t.Errorf("unexpected session id %s", session_id)
+				}
+				message, err := parser.UnmarshalJsonBytes[plugin_entities.SessionMessage](data)
+				if err != nil {
+					t.Error(err)
+				}
+				messages = append(messages, message)
+			},
+			func() {},
+			func(err string) { t.Error(err) },
+			func(message string) {},
+		)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	if string(messages[0].Data) != `{"action":"invoke_tool"}` {
+		t.Fatalf("unexpected message %s", messages[0].Data)
+	}
+	if messages[1].Type != plugin_entities.SESSION_MESSAGE_TYPE_END {
+		t.Fatalf("expected end message, got %s", messages[1].Type)
+	}
+}
+
+// duplexRuntime asks for a backwards invocation and replies the backwards response as a stream chunk
+type duplexRuntime struct {
+	lock      sync.Mutex
+	listeners map[string]*entities.Broadcast[plugin_entities.SessionMessage]
+	writes    int
+}
+
+func (r *duplexRuntime) Listen(session_id string) *entities.Broadcast[plugin_entities.SessionMessage] {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	listener := entities.NewBroadcast[plugin_entities.SessionMessage]()
+	r.listeners[session_id] = listener
+	return listener
+}
+
+func (r *duplexRuntime) Write(session_id string, action access_types.PluginAccessAction, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	listener := r.listeners[session_id]
+	r.writes++
+
+	if r.writes == 1 {
+		go listener.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_INVOKE,
+			Data: []byte(`{"type":"tool"}`),
+		})
+		return
+	}
+
+	go func() {
+		listener.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_STREAM,
+			Data: data,
+		})
+		listener.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_END,
+			Data: []byte("null"),
+		})
+	}()
+}
+
+func TestFunctionInvokeFullDuplex(t *testing.T) {
+	server := httptest.NewServer(functionHandler(&duplexRuntime{
+		listeners: map[string]*entities.Broadcast[plugin_entities.SessionMessage]{},
+	}, "api-key"))
+	defer server.Close()
+
+	token := serverless.FunctionToken("api-key")
+
+	// plain requests are told to upgrade, the daemon falls back to http on it
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/invoke?action=invoke_tool", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(serverless.HEADER_FUNCTION_TOKEN, token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("expected 426, got %d", response.StatusCode)
+	}
+
+	// upgrades without the function token are refused
+	if _, response, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http")+"/invoke?action=invoke_tool",
+		http.Header{"Dify-Plugin-Session-ID": []string{"session"}},
+	); err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatal("upgrade without the function token should be refused")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(server.URL, "http")+"/invoke?action=invoke_tool",
+		http.Header{
+			"Dify-Plugin-Session-ID":         []string{"session"},
+			serverless.HEADER_FUNCTION_TOKEN: []string{token},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"request","data":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []plugin_entities.SessionMessage{}
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatal(err)
+			}
+			break
+		}
+
+		event, err := parser.UnmarshalJsonBytes[plugin_entities.PluginUniversalEvent](frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := parser.UnmarshalJsonBytes[plugin_entities.SessionMessage](event.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+
+		// answer the backwards invocation on the same stream
+		if message.Type == plugin_entities.SESSION_MESSAGE_TYPE_INVOKE {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"backwards_response"}`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %v", messages)
+	}
+	if messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_INVOKE ||
+		string(messages[1].Data) != `{"event":"backwards_response"}` ||
+		messages[2].Type != plugin_entities.SESSION_MESSAGE_TYPE_END {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+}
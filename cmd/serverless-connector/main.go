@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/langgenius/dify-plugin-daemon/cmd/serverless-connector/connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+)
+
+/*
+ A reference implementation of the serverless connector, it makes PLATFORM_SERVERLESS
+ work without any cloud provider. With the local backend every plugin is launched as a
+ local process and exposed as a function through a local http server, with the kubernetes
+ backend every plugin is built into an image and deployed to the cluster.
+
+ Point DIFY_PLUGIN_SERVERLESS_CONNECTOR_URL of the daemon to this server and set
+ SERVERLESS_CONNECTOR_API_KEY to the same value as DIFY_PLUGIN_SERVERLESS_CONNECTOR_API_KEY.
+*/
+
+func main() {
+	var config connector.Config
+
+	// load env
+	godotenv.Load()
+
+	err := envconfig.Process("", &config)
+	if err != nil {
+		log.Panic("Error processing environment variables: %s", err.Error())
+	}
+
+	config.SetDefault()
+
+	if err := config.Validate(); err != nil {
+		log.Panic("Invalid configuration: %s", err.Error())
+	}
+
+	routine.InitPool(10000)
+
+	var backend connector.Backend
+
+	switch config.Backend {
+	case connector.BACKEND_KUBERNETES:
+		backend, err = connector.NewKubernetesBackend(&config)
+		if err != nil {
+			log.Panic("Failed to init kubernetes backend: %s", err.Error())
+		}
+	default:
+		localBackend, err := connector.NewLocalBackend(&config)
+		if err != nil {
+			log.Panic("Failed to init local backend: %s", err.Error())
+		}
+
+		if err := localBackend.Restore(); err != nil {
+			log.Error("Failed to restore functions: %s", err.Error())
+		}
+
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+			<-c
+
+			localBackend.Stop()
+			os.Exit(0)
+		}()
+
+		backend = localBackend
+	}
+
+	if err := connector.NewServer(&config, backend).Run(); err != nil {
+		log.Panic("Serverless connector stopped: %s", err.Error())
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/getsentry/sentry-go v0.30.0
 	github.com/go-git/go-git v4.7.0+incompatible
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/hashicorp/go-version v1.7.0
 	github.com/langgenius/dify-cloud-kit v0.0.0-20250611112407-c54203d9e948
 	github.com/panjf2000/ants/v2 v2.10.0
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
 )
 
@@ -21,6 +23,12 @@ type Cluster struct {
 	// main http port of the current node
 	port uint16
 
+	// certificates used to protect inter-node traffic, nil if mTLS is disabled
+	tls *clusterTLS
+
+	// http client used to talk to other nodes
+	httpClient *http.Client
+
 	// plugins stores all the plugin life time of the current node
 	plugins    mapping.Map[string, *pluginLifeTime]
 	pluginLock sync.RWMutex
@@ -63,7 +71,7 @@ type Cluster struct {
 }
 
 func NewCluster(config *app.Config, plugin_manager *plugin_manager.PluginManager) *Cluster {
-	return &Cluster{
+	cluster := &Cluster{
 		id:                            uuid.New().String(),
 		port:                          uint16(config.ServerPort),
 		stopChan:                      make(chan bool),
@@ -91,6 +99,38 @@ func NewCluster(config *app.Config, plugin_manager *plugin_manager.PluginManager
 		notifyNodeUpdateCompletedChan:     make(chan bool),
 		notifyClusterStoppedChan:          make(chan bool),
 	}
+
+	if config.ClusterMTLSEnabled {
+		clusterTLS, err := loadClusterTLS(
+			config.ClusterMTLSCAFile,
+			config.ClusterMTLSCertFile,
+			config.ClusterMTLSKeyFile,
+		)
+		if err != nil {
+			log.Panic("failed to init cluster mTLS: %s", err.Error())
+		}
+
+		// the node is identified by its certificate, peers check the claimed id against it
+		cluster.id = clusterTLS.nodeId
+
+		// other nodes reach the current node through the mTLS listener
+		cluster.tls = clusterTLS
+		cluster.port = config.ClusterMTLSPort
+		cluster.httpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: clusterTLS.clientConfig(),
+			},
+		}
+	}
+
+	return cluster
+}
+
+func (c *Cluster) client() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return http.DefaultClient
 }
 
 func (c *Cluster) Launch() {
@@ -6,8 +6,8 @@ import (
 	"net/http"
 )
 
-func constructRedirectUrl(ip address, request *http.Request) string {
-	url := "http://" + ip.fullAddress() + request.URL.Path
+func (c *Cluster) constructRedirectUrl(ip address, request *http.Request) string {
+	url := c.scheme() + "://" + ip.fullAddress() + request.URL.Path
 	if request.URL.RawQuery != "" {
 		url += "?" + request.URL.RawQuery
 	}
@@ -15,8 +15,8 @@ func constructRedirectUrl(ip address, request *http.Request) string {
 }
 
 // basic redirect request
-func redirectRequestToIp(ip address, request *http.Request) (int, http.Header, io.ReadCloser, error) {
-	url := constructRedirectUrl(ip, request)
+func (c *Cluster) redirectRequestToIp(ip address, request *http.Request) (int, http.Header, io.ReadCloser, error) {
+	url := c.constructRedirectUrl(ip, request)
 
 	// create a new request
 	redirectedRequest, err := http.NewRequest(
@@ -36,8 +36,12 @@ func redirectRequestToIp(ip address, request *http.Request) (int, http.Header, i
 		}
 	}
 
-	client := http.DefaultClient
-	resp, err := client.Do(redirectedRequest)
+	// attach the identity of the current node
+	if err := c.signRequest(redirectedRequest); err != nil {
+		return 0, nil, nil, err
+	}
+
+	resp, err := c.client().Do(redirectedRequest)
 
 	if err != nil {
 		return 0, nil, nil, err
@@ -62,5 +66,5 @@ func (c *Cluster) RedirectRequest(
 
 	ip := ips[0]
 
-	return redirectRequestToIp(ip, request)
+	return c.redirectRequestToIp(ip, request)
 }
@@ -196,7 +196,7 @@ func TestRedirectTrafficWithQueryParams(t *testing.T) {
 		Port: 8080,
 	}
 
-	redirectedRequest := constructRedirectUrl(ip, request)
+	redirectedRequest := (&Cluster{}).constructRedirectUrl(ip, request)
 	if redirectedRequest != "http://127.0.0.1:8080/plugin/invoke/tool?a=1&b=2" {
 		t.Fatal("redirected request is not correct")
 	}
@@ -215,7 +215,7 @@ func TestRedirectTrafficWithOutQueryParams(t *testing.T) {
 		Port: 8080,
 	}
 
-	redirectedRequest := constructRedirectUrl(ip, request)
+	redirectedRequest := (&Cluster{}).constructRedirectUrl(ip, request)
 	if redirectedRequest != "http://127.0.0.1:8080/plugin/invoke/tool" {
 		t.Fatal("redirected request is not correct")
 	}
@@ -262,7 +262,7 @@ func TestRedirectTrafficWithPathStyle(t *testing.T) {
 	}
 
 	// redirect to srv
-	statusCode, _, reader, err := redirectRequestToIp(address{
+	statusCode, _, reader, err := (&Cluster{}).redirectRequestToIp(address{
 		Ip:   "127.0.0.1",
 		Port: port,
 	}, request)
@@ -0,0 +1,385 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+)
+
+const (
+	// headers used to carry the identity of the node which redirected the request
+	HEADER_CLUSTER_NODE_ID   = "X-Dify-Cluster-Node-Id"
+	HEADER_CLUSTER_TIMESTAMP = "X-Dify-Cluster-Timestamp"
+	HEADER_CLUSTER_SIGNATURE = "X-Dify-Cluster-Signature"
+	HEADER_CLUSTER_NONCE     = "X-Dify-Cluster-Nonce"
+
+	// max clock skew allowed between two nodes
+	CLUSTER_SIGNATURE_MAX_SKEW = time.Minute * 5
+
+	// nonces are remembered as long as a signature may be accepted,
+	// a timestamp is accepted from skew in the past to skew in the future
+	CLUSTER_NONCE_KEY_PREFIX = "cluster:identity_nonce"
+	CLUSTER_NONCE_TTL        = CLUSTER_SIGNATURE_MAX_SKEW * 2
+)
+
+var (
+	ErrClusterIdentityMissing  = errors.New("cluster identity is missing")
+	ErrClusterIdentityExpired  = errors.New("cluster identity is expired")
+	ErrClusterIdentityInvalid  = errors.New("cluster identity signature is invalid")
+	ErrClusterIdentityUnknown  = errors.New("cluster identity does not belong to a known node")
+	ErrClusterIdentityMismatch = errors.New("cluster identity does not match the peer certificate")
+	ErrClusterIdentityReplayed = errors.New("cluster identity has already been used")
+	ErrClusterPeerCertNotFound = errors.New("peer certificate not found")
+)
+
+// clusterTLS holds the certificates used for inter-node traffic
+type clusterTLS struct {
+	certificate tls.Certificate
+	caPool      *x509.CertPool
+	// nodeId is the identity carried by the node certificate, used as the id of the current node
+	nodeId string
+}
+
+// certificateIdentity returns the node id carried by the certificate,
+// the common name is preferred and the first dns name is used as a fallback
+func certificateIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// certificateHasIdentity checks whether the node id appears in the subject or SAN of the certificate
+func certificateHasIdentity(cert *x509.Certificate, nodeId string) bool {
+	if cert.Subject.CommonName == nodeId {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if name == nodeId {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+func loadClusterTLS(caFile string, certFile string, keyFile string) (*clusterTLS, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cluster node certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster node certificate: %w", err)
+	}
+
+	nodeId := certificateIdentity(leaf)
+	if nodeId == "" {
+		return nil, errors.New("cluster node certificate carries neither a common name nor a dns name")
+	}
+
+	caContent, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster ca: %w", err)
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caContent) {
+		return nil, errors.New("failed to parse cluster ca")
+	}
+
+	return &clusterTLS{
+		certificate: certificate,
+		caPool:      caPool,
+		nodeId:      nodeId,
+	}, nil
+}
+
+// serverConfig requires every peer to present a certificate signed by the cluster ca
+func (t *clusterTLS) serverConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{t.certificate},
+		ClientCAs:    t.caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// clientConfig presents the node certificate and only trusts peers signed by the cluster ca
+//
+// nodes are addressed by ip, certificates are verified against the ca only
+// and the identity is bound to the request by the signature headers
+func (t *clusterTLS) clientConfig() *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{t.certificate},
+		RootCAs:            t.caPool,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return ErrClusterPeerCertNotFound
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         t.caPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			return err
+		},
+	}
+}
+
+// clusterIdentityPayload binds the identity to the whole request, including its query and body
+func clusterIdentityPayload(
+	nodeId string,
+	timestamp string,
+	nonce string,
+	request *http.Request,
+	body []byte,
+) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(strings.Join([]string{
+		nodeId,
+		timestamp,
+		nonce,
+		request.Method,
+		request.URL.Path,
+		request.URL.RawQuery,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+// bufferRequestBody reads the body of the request into memory and rewinds it,
+// redirected requests are buffered as the signature covers the body
+func bufferRequestBody(request *http.Request) ([]byte, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return body, nil
+}
+
+// signIdentity signs the node id with the private key of the node certificate
+func (t *clusterTLS) signIdentity(nodeId string, request *http.Request) error {
+	signer, ok := t.certificate.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("cluster node private key does not support signing")
+	}
+
+	body, err := bufferRequestBody(request)
+	if err != nil {
+		return err
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	payload := clusterIdentityPayload(nodeId, timestamp, nonce, request, body)
+
+	var signature []byte
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		signature, err = signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		hashed := sha256.Sum256(payload)
+		signature, err = signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	}
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set(HEADER_CLUSTER_NODE_ID, nodeId)
+	request.Header.Set(HEADER_CLUSTER_TIMESTAMP, timestamp)
+	request.Header.Set(HEADER_CLUSTER_NONCE, nonce)
+	request.Header.Set(HEADER_CLUSTER_SIGNATURE, base64.StdEncoding.EncodeToString(signature))
+
+	return nil
+}
+
+// verifyIdentity verifies the identity headers against the certificate presented by the peer
+// and returns the node id of the peer, the node id must be carried by the certificate
+// so that a member of the cluster is not able to impersonate another one
+//
+// the nonce is returned as well, it's up to the caller to reject nonces which were used already
+func verifyIdentity(request *http.Request) (string, string, error) {
+	if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 {
+		return "", "", ErrClusterPeerCertNotFound
+	}
+
+	nodeId := request.Header.Get(HEADER_CLUSTER_NODE_ID)
+	timestamp := request.Header.Get(HEADER_CLUSTER_TIMESTAMP)
+	nonce := request.Header.Get(HEADER_CLUSTER_NONCE)
+	signature := request.Header.Get(HEADER_CLUSTER_SIGNATURE)
+	if nodeId == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", "", ErrClusterIdentityMissing
+	}
+
+	if !certificateHasIdentity(request.TLS.PeerCertificates[0], nodeId) {
+		return "", "", ErrClusterIdentityMismatch
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "", ErrClusterIdentityInvalid
+	}
+
+	skew := time.Since(time.Unix(signedAt, 0))
+	if skew > CLUSTER_SIGNATURE_MAX_SKEW || skew < -CLUSTER_SIGNATURE_MAX_SKEW {
+		return "", "", ErrClusterIdentityExpired
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", "", ErrClusterIdentityInvalid
+	}
+
+	body, err := bufferRequestBody(request)
+	if err != nil {
+		return "", "", ErrClusterIdentityInvalid
+	}
+
+	payload := clusterIdentityPayload(nodeId, timestamp, nonce, request, body)
+	hashed := sha256.Sum256(payload)
+
+	valid := false
+	switch publicKey := request.TLS.PeerCertificates[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signatureBytes) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(publicKey, hashed[:], signatureBytes)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(publicKey, payload, signatureBytes)
+	}
+
+	if !valid {
+		return "", "", ErrClusterIdentityInvalid
+	}
+
+	return nodeId, nonce, nil
+}
+
+// MTLSEnabled returns whether the inter-node traffic is protected by mutual tls
+func (c *Cluster) MTLSEnabled() bool {
+	return c.tls != nil
+}
+
+// claimIdentityNonce marks the nonce as used, false is returned if it was used already
+//
+// nonces are shared across the cluster, a request captured on its way to a node
+// is not accepted by any other node either
+func claimIdentityNonce(nodeId string, nonce string) (bool, error) {
+	return cache.SetNX(
+		strings.Join([]string{CLUSTER_NONCE_KEY_PREFIX, nodeId, nonce}, ":"),
+		true,
+		CLUSTER_NONCE_TTL,
+	)
+}
+
+type peerNodeIdKey struct{}
+
+// PeerNodeID returns the node id of the peer which sent the request,
+// only requests verified by VerifyPeer carry one
+func PeerNodeID(request *http.Request) (string, bool) {
+	nodeId, ok := request.Context().Value(peerNodeIdKey{}).(string)
+	return nodeId, ok
+}
+
+// ServerTLSConfig returns the tls config of the cluster listener, nil if mTLS is disabled
+func (c *Cluster) ServerTLSConfig() *tls.Config {
+	if c.tls == nil {
+		return nil
+	}
+	return c.tls.serverConfig()
+}
+
+// VerifyPeer wraps the handler, only requests signed by a known cluster member are accepted
+func (c *Cluster) VerifyPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodeId, nonce, err := verifyIdentity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if !c.nodes.Exists(nodeId) && !c.IsNodeAlive(nodeId) {
+			http.Error(w, ErrClusterIdentityUnknown.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		claimed, err := claimIdentityNonce(nodeId, nonce)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			http.Error(w, ErrClusterIdentityReplayed.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerNodeIdKey{}, nodeId)))
+	})
+}
+
+func (c *Cluster) scheme() string {
+	if c.tls != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// signRequest attaches the identity of the current node to the request if mTLS is enabled
+func (c *Cluster) signRequest(request *http.Request) error {
+	if c.tls == nil {
+		return nil
+	}
+	return c.tls.signIdentity(c.id, request)
+}
@@ -0,0 +1,300 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+)
+
+func writePem(t *testing.T, path string, blockType string, content []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: content}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createClusterCertificates creates a ca and a node certificate signed by the ca, the node id is used as common name
+func createClusterCertificates(t *testing.T, dir string, nodeId string) (string, string, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dify-cluster-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: nodeId},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	nodeDer, err := x509.CreateCertificate(rand.Reader, nodeTemplate, caTemplate, &nodeKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeKeyDer, err := x509.MarshalECPrivateKey(nodeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "node.pem")
+	keyFile := filepath.Join(dir, "node.key")
+	writePem(t, caFile, "CERTIFICATE", caDer)
+	writePem(t, certFile, "CERTIFICATE", nodeDer)
+	writePem(t, keyFile, "EC PRIVATE KEY", nodeKeyDer)
+
+	return caFile, certFile, keyFile
+}
+
+func TestClusterMTLSVerifyPeer(t *testing.T) {
+	if err := cache.InitRedisClient("0.0.0.0:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	caFile, certFile, keyFile := createClusterCertificates(t, t.TempDir(), "node-a")
+
+	clusterTLS, err := loadClusterTLS(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterTLS.nodeId != "node-a" {
+		t.Fatalf("node id should be taken from the certificate, got %s", clusterTLS.nodeId)
+	}
+
+	cluster := &Cluster{
+		id:  clusterTLS.nodeId,
+		tls: clusterTLS,
+		httpClient: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: clusterTLS.clientConfig()},
+		},
+	}
+	cluster.nodes.Store("node-a", node{})
+	cluster.nodes.Store("node-b", node{})
+
+	server := httptest.NewUnstartedServer(cluster.VerifyPeer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		},
+	)))
+	server.TLS = cluster.ServerTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	// signed request from a known node
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.signRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cluster.client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed request should be accepted, got %d", resp.StatusCode)
+	}
+
+	// the same signed request sent again
+	replayed, _ := http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	replayed.Header = request.Header.Clone()
+	resp, err = cluster.client().Do(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("replayed request should be rejected, got %d", resp.StatusCode)
+	}
+
+	// unsigned request
+	request, _ = http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	resp, err = cluster.client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned request should be rejected, got %d", resp.StatusCode)
+	}
+
+	// signature bound to another path
+	request, _ = http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	if err := cluster.signRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	request.URL.Path = "/plugin/invoke/model"
+	resp, err = cluster.client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("tampered request should be rejected, got %d", resp.StatusCode)
+	}
+
+	// claims the id of another member which is not carried by the certificate
+	cluster.id = "node-b"
+	request, _ = http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	if err := cluster.signRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = cluster.client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request impersonating another node should be rejected, got %d", resp.StatusCode)
+	}
+
+	// signed by a node which is not a cluster member
+	cluster.id = "node-a"
+	cluster.nodes.Delete("node-a")
+	request, _ = http.NewRequest(http.MethodGet, server.URL+"/plugin/invoke/tool", nil)
+	if err := cluster.signRequest(request); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = cluster.client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("request from unknown node should be rejected, got %d", resp.StatusCode)
+	}
+
+	// client without certificate is refused at handshake
+	plainClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: server.Client().Transport.(*http.Transport).TLSClientConfig},
+	}
+	if _, err := plainClient.Get(server.URL + "/plugin/invoke/tool"); err == nil {
+		t.Fatal("client without certificate should be refused")
+	}
+}
+
+func TestClusterIdentityCoversQueryAndBody(t *testing.T) {
+	caFile, certFile, keyFile := createClusterCertificates(t, t.TempDir(), "node-a")
+
+	clusterTLS, err := loadClusterTLS(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, _, err := verifyIdentity(r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		},
+	))
+	server.TLS = clusterTLS.serverConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: clusterTLS.clientConfig()},
+	}
+
+	cases := []struct {
+		name   string
+		tamper func(request *http.Request)
+		status int
+	}{
+		{
+			name:   "untouched",
+			tamper: func(request *http.Request) {},
+			status: http.StatusOK,
+		},
+		{
+			name: "query swapped",
+			tamper: func(request *http.Request) {
+				request.URL.RawQuery = "plugin_id=other"
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "body swapped",
+			tamper: func(request *http.Request) {
+				body := []byte(`{"tenant_id":"other"}`)
+				request.Body = io.NopCloser(bytes.NewReader(body))
+				request.ContentLength = int64(len(body))
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			request, err := http.NewRequest(
+				http.MethodPost,
+				server.URL+"/plugin/invoke/tool?plugin_id=origin",
+				strings.NewReader(`{"tenant_id":"origin"}`),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := clusterTLS.signIdentity("node-a", request); err != nil {
+				t.Fatal(err)
+			}
+			c.tamper(request)
+
+			resp, err := client.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != c.status {
+				t.Fatalf("expected status %d, got %d: %s", c.status, resp.StatusCode, body)
+			}
+			// the body is still readable by the handler once verified
+			if c.status == http.StatusOK && string(body) != `{"tenant_id":"origin"}` {
+				t.Fatalf("unexpected body %s", body)
+			}
+		})
+	}
+}
@@ -113,16 +113,37 @@ func (c *Cluster) voteAddress(addr address) error {
 		Status string `json:"status"`
 	}
 
-	healthcheckEndpoint, err := url.JoinPath(fmt.Sprintf("http://%s:%d", addr.Ip, addr.Port), "health/check")
+	healthcheckEndpoint, err := url.JoinPath(fmt.Sprintf("%s://%s:%d", c.scheme(), addr.Ip, addr.Port), "health/check")
 	if err != nil {
 		return err
 	}
 
-	resp, err := http_requests.GetAndParse[healthcheck](
-		http.DefaultClient,
-		healthcheckEndpoint,
+	options := []http_requests.HttpOptions{
 		http_requests.HttpWriteTimeout(500),
 		http_requests.HttpReadTimeout(500),
+	}
+
+	// peers only accept signed requests once mTLS is enabled
+	if c.tls != nil {
+		request, err := http.NewRequest(http.MethodGet, healthcheckEndpoint, nil)
+		if err != nil {
+			return err
+		}
+		if err := c.signRequest(request); err != nil {
+			return err
+		}
+		options = append(options, http_requests.HttpHeader(map[string]string{
+			HEADER_CLUSTER_NODE_ID:   request.Header.Get(HEADER_CLUSTER_NODE_ID),
+			HEADER_CLUSTER_TIMESTAMP: request.Header.Get(HEADER_CLUSTER_TIMESTAMP),
+			HEADER_CLUSTER_NONCE:     request.Header.Get(HEADER_CLUSTER_NONCE),
+			HEADER_CLUSTER_SIGNATURE: request.Header.Get(HEADER_CLUSTER_SIGNATURE),
+		}))
+	}
+
+	resp, err := http_requests.GetAndParse[healthcheck](
+		c.client(),
+		healthcheckEndpoint,
+		options...,
 	)
 
 	if err != nil {
@@ -1,6 +1,8 @@
 package dify_invocation
 
 import (
+	"context"
+
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/stream"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/model_entities"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/tool_entities"
@@ -38,4 +40,6 @@ type BackwardsInvocation interface {
 	UploadFile(payload *UploadFileRequest) (*UploadFileResponse, error)
 	// FetchApp
 	FetchApp(payload *FetchAppRequest) (map[string]any, error)
+	// WithContext returns a copy whose requests are aborted once ctx is done
+	WithContext(ctx context.Context) BackwardsInvocation
 }
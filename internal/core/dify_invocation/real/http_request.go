@@ -1,6 +1,7 @@
 package real
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 
@@ -22,6 +23,9 @@ func Request[T any](i *RealBackwardsInvocation, method string, path string, opti
 		http_requests.HttpWriteTimeout(i.writeTimeout),
 		http_requests.HttpReadTimeout(i.readTimeout),
 	)
+	if i.ctx != nil {
+		options = append(options, http_requests.HttpContext(i.ctx))
+	}
 
 	req, err := http_requests.RequestAndParse[BaseBackwardsInvocationResponse[T]](i.client, i.difyPath(path), method, options...)
 	if err != nil {
@@ -59,6 +63,9 @@ func StreamResponse[T any](i *RealBackwardsInvocation, method string, path strin
 		http_requests.HttpReadTimeout(i.readTimeout),
 		http_requests.HttpUsingLengthPrefixed(true),
 	)
+	if i.ctx != nil {
+		options = append(options, http_requests.HttpContext(i.ctx))
+	}
 
 	response, err := http_requests.RequestAndParseStream[BaseBackwardsInvocationResponse[T]](
 		i.client,
@@ -111,6 +118,12 @@ func StreamResponse[T any](i *RealBackwardsInvocation, method string, path strin
 	return newResponse, nil
 }
 
+func (i *RealBackwardsInvocation) WithContext(ctx context.Context) dify_invocation.BackwardsInvocation {
+	invocation := *i
+	invocation.ctx = ctx
+	return &invocation
+}
+
 func (i *RealBackwardsInvocation) InvokeLLM(payload *dify_invocation.InvokeLLMRequest) (*stream.Stream[model_entities.LLMResultChunk], error) {
 	return StreamResponse[model_entities.LLMResultChunk](i, "POST", "invoke/llm", http_requests.HttpPayloadJson(payload))
 }
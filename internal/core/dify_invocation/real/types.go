@@ -1,6 +1,7 @@
 package real
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 )
@@ -11,6 +12,9 @@ type RealBackwardsInvocation struct {
 	client              *http.Client
 	writeTimeout        int64
 	readTimeout         int64
+
+	// ctx aborts requests, nil means never
+	ctx context.Context
 }
 
 type BaseBackwardsInvocationResponse[T any] struct {
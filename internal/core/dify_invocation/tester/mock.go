@@ -1,6 +1,7 @@
 package tester
 
 import (
+	"context"
 	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
@@ -18,6 +19,10 @@ func NewMockedDifyInvocation() dify_invocation.BackwardsInvocation {
 	return &MockedDifyInvocation{}
 }
 
+func (m *MockedDifyInvocation) WithContext(ctx context.Context) dify_invocation.BackwardsInvocation {
+	return m
+}
+
 func (m *MockedDifyInvocation) InvokeLLM(payload *dify_invocation.InvokeLLMRequest) (*stream.Stream[model_entities.LLMResultChunk], error) {
 	stream := stream.NewStream[model_entities.LLMResultChunk](5)
 	routine.Submit(nil, func() {
@@ -111,11 +111,18 @@ const (
 	STORAGE_OPT_SET   StorageOpt = "set"
 	STORAGE_OPT_DEL   StorageOpt = "del"
 	STORAGE_OPT_EXIST StorageOpt = "exist"
+	// list returns keys, scan returns keys and their values, both are paginated by cursor
+	STORAGE_OPT_LIST StorageOpt = "list"
+	STORAGE_OPT_SCAN StorageOpt = "scan"
+	// cas sets the value only if the hash of the current value equals expected, incr adds delta to an integer
+	STORAGE_OPT_CAS  StorageOpt = "cas"
+	STORAGE_OPT_INCR StorageOpt = "incr"
 )
 
 func isStorageOpt(fl validator.FieldLevel) bool {
 	opt := StorageOpt(fl.Field().String())
-	return opt == STORAGE_OPT_GET || opt == STORAGE_OPT_SET || opt == STORAGE_OPT_DEL || opt == STORAGE_OPT_EXIST
+	return opt == STORAGE_OPT_GET || opt == STORAGE_OPT_SET || opt == STORAGE_OPT_DEL || opt == STORAGE_OPT_EXIST ||
+		opt == STORAGE_OPT_LIST || opt == STORAGE_OPT_SCAN || opt == STORAGE_OPT_CAS || opt == STORAGE_OPT_INCR
 }
 
 func init() {
@@ -124,8 +131,21 @@ func init() {
 
 type InvokeStorageRequest struct {
 	Opt   StorageOpt `json:"opt" validate:"required,storage_opt"`
-	Key   string     `json:"key" validate:"required"`
+	Key   string     `json:"key" validate:"required_if=Opt get,required_if=Opt set,required_if=Opt del,required_if=Opt exist,required_if=Opt cas,required_if=Opt incr"`
 	Value string     `json:"value"` // encoded in hex, optional
+
+	// seconds until the key expires, used by set, cas and incr, 0 means never for set and cas,
+	// incr keeps the current expiration if it's 0
+	TTL int64 `json:"ttl" validate:"omitempty,min=0"`
+	// used by cas only, sha256 of the current value in hex, empty means the key must not exist
+	Expected string `json:"expected"`
+	// used by incr only, defaults to 1
+	Delta *int64 `json:"delta"`
+
+	// used by list and scan only
+	Prefix string `json:"prefix"`
+	Cursor string `json:"cursor"`
+	Limit  int    `json:"limit" validate:"omitempty,min=1,max=1000"`
 }
 
 type InvokeAppRequest struct {
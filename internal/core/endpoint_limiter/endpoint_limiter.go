@@ -0,0 +1,356 @@
+package endpoint_limiter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+ Rate limits, concurrency limits and body size limits of endpoint requests.
+
+ Every request takes a token and a concurrency slot from the tenant and from the endpoint, buckets refill
+ continuously at `requests_per_minute` up to `burst`, slots are held until the request finishes or
+ its lease expires in case the node holding it dies.
+
+ Buckets and slots live in redis so that limits hold across the cluster, all of them are checked and
+ consumed by one script, a request rejected by any of them consumes nothing.
+
+ Limits of tenants come from the config and overrides set by admins, limits of endpoints come from the
+ config and the endpoint itself, the stricter one applies so that tenants can only tighten them.
+
+ Requests are let through if redis fails unless the limiter is configured to fail closed,
+ either way the failure is logged and counted.
+*/
+
+const (
+	SCOPE_TENANT   = "tenant"
+	SCOPE_ENDPOINT = "endpoint"
+
+	// the tenant bucket shares keys with endpoints, ids of endpoints never collide with it
+	TENANT_BUCKET_ID = "*"
+
+	OVERRIDE_KEY = "endpoint:limit:override:%s"
+	BUCKET_KEY   = "endpoint:limit:bucket:%s:%s"
+	SLOTS_KEY    = "endpoint:limit:slots:%s:%s"
+
+	// slots are released at the latest after the max execution time and this grace period
+	SLOT_LEASE_GRACE = time.Minute
+	// retry after of a concurrency breach, there is no telling when a slot is released
+	CONCURRENCY_RETRY_AFTER = time.Second
+	// retry after of a request rejected because limits failed to be checked
+	UNAVAILABLE_RETRY_AFTER = time.Second
+)
+
+type Limit struct {
+	// tokens refilled per minute, 0 means unlimited
+	RequestsPerMinute int `json:"requests_per_minute" validate:"min=0"`
+	// capacity of the bucket, defaults to requests_per_minute
+	Burst int `json:"burst" validate:"min=0"`
+	// max requests being processed at the same time, 0 means unlimited
+	MaxConcurrency int `json:"max_concurrency" validate:"min=0"`
+}
+
+func (l Limit) unlimited() bool {
+	return l.RequestsPerMinute <= 0 && l.MaxConcurrency <= 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.RequestsPerMinute
+}
+
+// stricter returns the smaller one of both values, 0 means unlimited
+func stricter[T int | int64](a T, b T) T {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
+
+// Stricter combines two limits, every field takes the stricter value
+func (l Limit) Stricter(other Limit) Limit {
+	return Limit{
+		RequestsPerMinute: stricter(l.RequestsPerMinute, other.RequestsPerMinute),
+		Burst:             stricter(l.burst(), other.burst()),
+		MaxConcurrency:    stricter(l.MaxConcurrency, other.MaxConcurrency),
+	}
+}
+
+type Override struct {
+	TenantID string `json:"tenant_id"`
+	Limit
+}
+
+// ErrLimited is returned once a bucket runs out or all the slots are taken,
+// or limits failed to be checked while the limiter fails closed
+type ErrLimited struct {
+	Scope string `json:"scope"`
+	// true if all the slots are taken, otherwise the bucket runs out
+	Concurrency bool `json:"concurrency"`
+	// true if limits failed to be checked, scope is empty then
+	Unavailable bool          `json:"unavailable"`
+	RetryAfter  time.Duration `json:"-"`
+}
+
+func (e *ErrLimited) Error() string {
+	if e.Unavailable {
+		return "endpoint limits are unavailable, retry later"
+	}
+	if e.Concurrency {
+		return fmt.Sprintf("too many concurrent requests to %s, retry later", e.Scope)
+	}
+	return fmt.Sprintf(
+		"rate limit of %s exceeded, retry after %ds",
+		e.Scope, int(math.Ceil(e.RetryAfter.Seconds())),
+	)
+}
+
+var (
+	enabled       bool
+	failOpen      = true
+	tenantLimit   Limit
+	endpointLimit Limit
+	maxBodySize   int64
+
+	now = time.Now
+
+	// checks failed because of redis since the node started
+	unavailableCount atomic.Int64
+)
+
+func InitEndpointLimiter(config *app.Config) {
+	enabled = config.EndpointLimitEnabled
+	failOpen = config.EndpointLimitFailOpen == nil || *config.EndpointLimitFailOpen
+	tenantLimit = Limit{
+		RequestsPerMinute: config.EndpointTenantRateLimit,
+		Burst:             config.EndpointTenantBurst,
+		MaxConcurrency:    config.EndpointTenantMaxConcurrency,
+	}
+	endpointLimit = Limit{
+		RequestsPerMinute: config.EndpointRateLimit,
+		Burst:             config.EndpointBurst,
+		MaxConcurrency:    config.EndpointMaxConcurrency,
+	}
+	maxBodySize = config.EndpointMaxBodySize
+}
+
+// MaxBodySize returns the max body size of an endpoint, the max body size of the endpoint itself
+// only applies if it's stricter than the config, 0 means no limit of the endpoint
+func MaxBodySize(endpointMaxBodySize int64) int64 {
+	return stricter(endpointMaxBodySize, maxBodySize)
+}
+
+// limitScript checks all the buckets and slots first and consumes them only if all of them allow it,
+// KEYS are pairs of bucket and slots, ARGV[1] is now in milliseconds, ARGV[2] the slot id, ARGV[3]
+// the lease of the slot in milliseconds, followed by tokens per millisecond, burst and max concurrency of each pair.
+// returns {0} if allowed, otherwise {index of the pair, retry after in milliseconds, 1 if concurrency}
+var limitScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local slot = ARGV[2]
+local lease = tonumber(ARGV[3])
+local tokens = {}
+
+for i = 1, #KEYS / 2 do
+	local rate = tonumber(ARGV[3 * i + 1])
+	local burst = tonumber(ARGV[3 * i + 2])
+	local concurrency = tonumber(ARGV[3 * i + 3])
+
+	if rate > 0 then
+		local state = redis.call('HMGET', KEYS[2 * i - 1], 'tokens', 'ts')
+		local available = burst
+		if state[1] then
+			available = math.min(burst, tonumber(state[1]) + (now - tonumber(state[2])) * rate)
+		end
+		if available < 1 then
+			return {i, math.ceil((1 - available) / rate), 0}
+		end
+		tokens[i] = available
+	end
+
+	if concurrency > 0 then
+		redis.call('ZREMRANGEBYSCORE', KEYS[2 * i], '-inf', now)
+		if redis.call('ZCARD', KEYS[2 * i]) >= concurrency then
+			return {i, 0, 1}
+		end
+	end
+end
+
+for i = 1, #KEYS / 2 do
+	local rate = tonumber(ARGV[3 * i + 1])
+	local burst = tonumber(ARGV[3 * i + 2])
+	local concurrency = tonumber(ARGV[3 * i + 3])
+
+	if rate > 0 then
+		redis.call('HSET', KEYS[2 * i - 1], 'tokens', tokens[i] - 1, 'ts', now)
+		redis.call('PEXPIRE', KEYS[2 * i - 1], math.ceil(burst / rate) + 1000)
+	end
+
+	if concurrency > 0 then
+		redis.call('ZADD', KEYS[2 * i], now + lease, slot)
+		redis.call('PEXPIRE', KEYS[2 * i], lease)
+	end
+end
+
+return {0}
+`)
+
+type bucket struct {
+	scope string
+	// endpoint id, or TENANT_BUCKET_ID for the tenant bucket
+	id    string
+	limit Limit
+}
+
+// unavailable handles a check failed because of redis, the request is let through if the limiter fails open
+func unavailable(tenant_id string, err error) (func(), *ErrLimited) {
+	unavailableCount.Add(1)
+
+	if failOpen {
+		log.Warn("failed to check endpoint limits of tenant %s, request let through: %s", tenant_id, err.Error())
+		return func() {}, nil
+	}
+
+	log.Warn("failed to check endpoint limits of tenant %s, request rejected: %s", tenant_id, err.Error())
+	return func() {}, &ErrLimited{Unavailable: true, RetryAfter: UNAVAILABLE_RETRY_AFTER}
+}
+
+// UnavailableCount returns how many checks failed because of redis since the node started
+func UnavailableCount() int64 {
+	return unavailableCount.Load()
+}
+
+// FailOpen returns whether requests are let through if limits fail to be checked
+func FailOpen() bool {
+	return failOpen
+}
+
+// Acquire takes a token and a slot of the tenant and the endpoint for the request, returns the breach if any,
+// release frees the slots once the request finishes, it's never nil.
+// redis failures let the request through unless the limiter fails closed
+func Acquire(tenant_id string, endpoint_id string, limit Limit, lease time.Duration) (func(), *ErrLimited) {
+	release := func() {}
+	if !enabled {
+		return release, nil
+	}
+
+	override, err := GetOverride(tenant_id)
+	if err == cache.ErrDBNotInit {
+		// limits are never checked without redis, e.g. in tests
+		return release, nil
+	} else if err != nil {
+		return unavailable(tenant_id, err)
+	}
+
+	resolvedTenantLimit := tenantLimit
+	if override != nil {
+		resolvedTenantLimit = override.Limit
+	}
+
+	buckets := []bucket{}
+	for _, b := range []bucket{
+		{scope: SCOPE_TENANT, id: TENANT_BUCKET_ID, limit: resolvedTenantLimit},
+		{scope: SCOPE_ENDPOINT, id: endpoint_id, limit: limit.Stricter(endpointLimit)},
+	} {
+		if !b.limit.unlimited() {
+			buckets = append(buckets, b)
+		}
+	}
+	if len(buckets) == 0 {
+		return release, nil
+	}
+
+	slot := uuid.New().String()
+	lease += SLOT_LEASE_GRACE
+
+	keys := []string{}
+	slotKeys := []string{}
+	args := []any{now().UnixMilli(), slot, lease.Milliseconds()}
+	for _, b := range buckets {
+		keys = append(
+			keys,
+			fmt.Sprintf(BUCKET_KEY, tenant_id, b.id),
+			fmt.Sprintf(SLOTS_KEY, tenant_id, b.id),
+		)
+		if b.limit.MaxConcurrency > 0 {
+			slotKeys = append(slotKeys, fmt.Sprintf(SLOTS_KEY, tenant_id, b.id))
+		}
+		args = append(
+			args,
+			float64(b.limit.RequestsPerMinute)/float64(time.Minute.Milliseconds()),
+			b.limit.burst(),
+			b.limit.MaxConcurrency,
+		)
+	}
+
+	result, err := cache.EvalScript(limitScript, keys, args)
+	if err == cache.ErrDBNotInit {
+		return release, nil
+	} else if err != nil {
+		return unavailable(tenant_id, err)
+	}
+
+	values, ok := result.([]any)
+	if !ok || len(values) == 0 {
+		return unavailable(tenant_id, fmt.Errorf("unexpected result of limit script: %v", result))
+	}
+	index, _ := values[0].(int64)
+	if index == 0 || len(values) < 3 {
+		return func() {
+			for _, key := range slotKeys {
+				if err := cache.ZRem(key, slot); err != nil {
+					log.Error("failed to release endpoint slot of tenant %s: %s", tenant_id, err.Error())
+				}
+			}
+		}, nil
+	}
+
+	retryAfter, _ := values[1].(int64)
+	concurrency, _ := values[2].(int64)
+	breach := &ErrLimited{
+		Scope:       buckets[index-1].scope,
+		Concurrency: concurrency == 1,
+		RetryAfter:  time.Duration(retryAfter) * time.Millisecond,
+	}
+	if breach.Concurrency {
+		breach.RetryAfter = CONCURRENCY_RETRY_AFTER
+	}
+	return release, breach
+}
+
+// GetOverride returns the limit of the tenant set by admins, nil if not set
+func GetOverride(tenant_id string) (*Override, error) {
+	override, err := cache.Get[Override](fmt.Sprintf(OVERRIDE_KEY, tenant_id))
+	if err == cache.ErrNotFound {
+		return nil, nil
+	}
+	return override, err
+}
+
+// SetOverride sets the limit of a tenant, it replaces the default limit of tenants in the config
+func SetOverride(override Override) error {
+	if override.TenantID == "" {
+		return errors.New("tenant id is required")
+	}
+
+	return cache.Store(fmt.Sprintf(OVERRIDE_KEY, override.TenantID), override, 0)
+}
+
+// DeleteOverride restores the default limit
+func DeleteOverride(tenant_id string) error {
+	_, err := cache.Del(fmt.Sprintf(OVERRIDE_KEY, tenant_id))
+	return err
+}
@@ -0,0 +1,154 @@
+package endpoint_limiter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/network"
+)
+
+func TestStricter(t *testing.T) {
+	limit := Limit{RequestsPerMinute: 10, MaxConcurrency: 0}.Stricter(Limit{RequestsPerMinute: 60, Burst: 120, MaxConcurrency: 5})
+	if limit.RequestsPerMinute != 10 || limit.Burst != 10 || limit.MaxConcurrency != 5 {
+		t.Fatalf("unexpected limit %+v", limit)
+	}
+
+	maxBodySize = 1024
+	defer func() { maxBodySize = 0 }()
+	if size := MaxBodySize(0); size != 1024 {
+		t.Fatalf("expected the max body size of the config, got %d", size)
+	}
+	if size := MaxBodySize(4096); size != 1024 {
+		t.Fatalf("endpoints should not raise the max body size, got %d", size)
+	}
+	if size := MaxBodySize(512); size != 512 {
+		t.Fatalf("expected the max body size of the endpoint, got %d", size)
+	}
+}
+
+func TestAcquireWithoutRedis(t *testing.T) {
+	enabled = true
+	tenantLimit = Limit{RequestsPerMinute: 1}
+	defer func() {
+		enabled = false
+		tenantLimit = Limit{}
+	}()
+
+	// limits are not worth failing requests for
+	for i := 0; i < 3; i++ {
+		release, err := Acquire("tenant", "endpoint", Limit{}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+}
+
+func TestAcquireRedisUnavailable(t *testing.T) {
+	port, err := network.GetRandomPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing listens on the port, every command fails
+	cache.InitRedisClient(fmt.Sprintf("127.0.0.1:%d", port), "", "", false, 0)
+	defer cache.Close()
+
+	enabled = true
+	tenantLimit = Limit{RequestsPerMinute: 1}
+	defer func() {
+		enabled = false
+		failOpen = true
+		tenantLimit = Limit{}
+	}()
+
+	count := UnavailableCount()
+
+	failOpen = true
+	release, breach := Acquire("tenant", "endpoint", Limit{}, time.Minute)
+	if breach != nil {
+		t.Fatalf("request should be let through, got %v", breach)
+	}
+	release()
+
+	failOpen = false
+	release, breach = Acquire("tenant", "endpoint", Limit{}, time.Minute)
+	if breach == nil || !breach.Unavailable || breach.RetryAfter != UNAVAILABLE_RETRY_AFTER {
+		t.Fatalf("request should be rejected, got %v", breach)
+	}
+	release()
+
+	if UnavailableCount() != count+2 {
+		t.Fatalf("expected 2 failures to be counted, got %d", UnavailableCount()-count)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	if err := cache.InitRedisClient("0.0.0.0:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	enabled = true
+	endpointLimit = Limit{RequestsPerMinute: 60, Burst: 3}
+	defer func() {
+		enabled = false
+		endpointLimit = Limit{}
+	}()
+
+	tenantId := uuid.NewString()
+	endpointId := uuid.NewString()
+	at := time.Now()
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+
+	// the endpoint only allows one request at a time
+	release, err := Acquire(tenantId, endpointId, Limit{MaxConcurrency: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(tenantId, endpointId, Limit{MaxConcurrency: 1}, time.Minute)
+	if err == nil || err.Scope != SCOPE_ENDPOINT || !err.Concurrency {
+		t.Fatalf("expected the slots of the endpoint to be taken, got %v", err)
+	}
+
+	// the rejected request consumed nothing, the slot is free again after release
+	release()
+	release, err = Acquire(tenantId, endpointId, Limit{MaxConcurrency: 1}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the burst is consumed and then the bucket refills at 1 token per second
+	if _, err := Acquire(tenantId, endpointId, Limit{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(tenantId, endpointId, Limit{}, time.Minute)
+	if err == nil || err.Scope != SCOPE_ENDPOINT || err.Concurrency || err.RetryAfter != time.Second {
+		t.Fatalf("expected the bucket of the endpoint to run out, got %v", err)
+	}
+
+	at = at.Add(time.Second)
+	if _, err := Acquire(tenantId, endpointId, Limit{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// overrides replace the limit of the tenant
+	if err := SetOverride(Override{TenantID: tenantId, Limit: Limit{RequestsPerMinute: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	defer DeleteOverride(tenantId)
+
+	otherEndpointId := uuid.NewString()
+	if _, err := Acquire(tenantId, otherEndpointId, Limit{}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Acquire(tenantId, otherEndpointId, Limit{}, time.Minute)
+	if err == nil || err.Scope != SCOPE_TENANT {
+		t.Fatalf("expected the bucket of the tenant to run out, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+ Idempotency of dispatch requests
+
+ The first request carrying an idempotency key records every event of its response,
+ duplicates within the window replay recorded events and follow the first one until it completes,
+ the plugin is invoked only once no matter which node duplicates arrive at.
+
+ The record of a running request is held by a lease refreshed while it runs, a request outliving
+ the window keeps its record and a crashed node releases the key once the lease expires.
+*/
+
+const (
+	RECORD_KEY_PREFIX = "idempotency:record"
+	EVENTS_KEY_PREFIX = "idempotency:events"
+
+	MAX_KEY_LENGTH = 255
+	POLL_INTERVAL  = 100 * time.Millisecond
+
+	LEASE_TTL              = 30 * time.Second
+	LEASE_REFRESH_INTERVAL = 10 * time.Second
+)
+
+type Status string
+
+const (
+	STATUS_RUNNING   Status = "running"
+	STATUS_COMPLETED Status = "completed"
+	// the response exceeded the max response size, it's no longer recorded
+	STATUS_TRUNCATED Status = "truncated"
+)
+
+var (
+	ErrInvalidKey          = errors.New("idempotency key must be at most 255 characters")
+	ErrFingerprintMismatch = errors.New("idempotency key is reused with a different request")
+	ErrResponseTruncated   = errors.New("response of the idempotency key is too large to be replayed")
+	ErrOriginalGone        = errors.New("request of the idempotency key ended without completing its response")
+)
+
+var (
+	enabled         bool
+	window          time.Duration
+	maxResponseSize int64
+)
+
+func InitIdempotency(config *app.Config) {
+	enabled = config.DispatchIdempotencyEnabled
+	window = time.Duration(config.DispatchIdempotencyWindow) * time.Second
+	maxResponseSize = config.DispatchIdempotencyMaxResponseSize
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+type Record struct {
+	// empty until the session of the first request is created
+	SessionID   string `json:"session_id"`
+	Fingerprint string `json:"fingerprint"`
+	Status      Status `json:"status"`
+}
+
+// Fingerprint identifies the request an idempotency key is used with
+func Fingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func recordKey(tenantId string, pluginId string, key string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", RECORD_KEY_PREFIX, tenantId, pluginId, key)
+}
+
+func eventsKey(tenantId string, pluginId string, key string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", EVENTS_KEY_PREFIX, tenantId, pluginId, key)
+}
+
+// Recorder records events of the response of the first request
+type Recorder struct {
+	recordKey string
+	eventsKey string
+	record    Record
+	size      int64
+
+	// leased is true as long as the record is in flight, the lease is refreshed until then
+	lock   sync.Mutex
+	leased bool
+}
+
+// Acquire returns a recorder if the request is the first one of the key, otherwise the existing record,
+// the key is acquired ahead of any work so that duplicates never start one
+func Acquire(tenantId string, pluginId string, key string, fingerprint string) (
+	*Recorder, *Record, error,
+) {
+	if len(key) > MAX_KEY_LENGTH {
+		return nil, nil, ErrInvalidKey
+	}
+
+	recorder := &Recorder{
+		recordKey: recordKey(tenantId, pluginId, key),
+		eventsKey: eventsKey(tenantId, pluginId, key),
+		record: Record{
+			Fingerprint: fingerprint,
+			Status:      STATUS_RUNNING,
+		},
+		leased: true,
+	}
+
+	acquired, err := cache.SetNX(recorder.recordKey, recorder.record, LEASE_TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if acquired {
+		recorder.keepLease()
+		return recorder, nil, nil
+	}
+
+	record, err := cache.Get[Record](recorder.recordKey)
+	if err == cache.ErrNotFound {
+		// expired in between, the caller may retry
+		return nil, nil, ErrOriginalGone
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, nil, ErrFingerprintMismatch
+	}
+
+	return nil, record, nil
+}
+
+// keepLease refreshes the record while the request is in flight
+func (r *Recorder) keepLease() {
+	routine.Submit(map[string]string{
+		"module":   "idempotency",
+		"function": "keepLease",
+	}, func() {
+		ticker := time.NewTicker(LEASE_REFRESH_INTERVAL)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			r.lock.Lock()
+			if !r.leased {
+				r.lock.Unlock()
+				return
+			}
+			if _, err := cache.Expire(r.recordKey, LEASE_TTL); err != nil {
+				log.Warn("failed to refresh idempotency lease: %s", err.Error())
+			}
+			if _, err := cache.Expire(r.eventsKey, window); err != nil {
+				log.Warn("failed to refresh idempotency lease: %s", err.Error())
+			}
+			r.lock.Unlock()
+		}
+	})
+}
+
+// BindSession attaches the session of the first request, duplicates follow it as long as it's alive
+func (r *Recorder) BindSession(sessionId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.record.SessionID = sessionId
+	if err := cache.Store(r.recordKey, r.record, LEASE_TTL); err != nil {
+		log.Error("failed to update idempotency record: %s", err.Error())
+	}
+}
+
+// Abandon releases the key if the first request failed before invoking the plugin, a retry runs again
+func (r *Recorder) Abandon() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.leased = false
+	if _, err := cache.Del(r.recordKey); err != nil {
+		log.Error("failed to release idempotency record: %s", err.Error())
+	}
+	if _, err := cache.Del(r.eventsKey); err != nil {
+		log.Error("failed to release idempotency record: %s", err.Error())
+	}
+}
+
+// Record appends an event of the response, events beyond the max response size are dropped
+// and duplicates are refused from then on
+func (r *Recorder) Record(event []byte) {
+	if r.record.Status != STATUS_RUNNING {
+		return
+	}
+
+	r.size += int64(len(event))
+	if r.size > maxResponseSize {
+		r.finish(STATUS_TRUNCATED)
+		return
+	}
+
+	if err := cache.Transaction(func(p redis.Pipeliner) error {
+		if err := cache.RPush(r.eventsKey, []string{string(event)}, p); err != nil {
+			return err
+		}
+		_, err := cache.Expire(r.eventsKey, window, p)
+		return err
+	}); err != nil {
+		log.Error("failed to record idempotent response: %s", err.Error())
+	}
+}
+
+// Complete marks the response completed, duplicates replay it within the window from now on
+func (r *Recorder) Complete() {
+	if r.record.Status != STATUS_RUNNING {
+		return
+	}
+	r.finish(STATUS_COMPLETED)
+}
+
+func (r *Recorder) finish(status Status) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.leased = false
+	r.record.Status = status
+	if err := cache.Store(r.recordKey, r.record, window); err != nil {
+		log.Error("failed to update idempotency record: %s", err.Error())
+	}
+	if _, err := cache.Expire(r.eventsKey, window); err != nil {
+		log.Error("failed to update idempotency record: %s", err.Error())
+	}
+}
+
+// Replay writes recorded events of the key and follows the first request until it completes,
+// stops once done is closed
+func Replay(tenantId string, pluginId string, key string, write func(event []byte), done <-chan struct{}) error {
+	recordKey := recordKey(tenantId, pluginId, key)
+	eventsKey := eventsKey(tenantId, pluginId, key)
+
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+
+	replayed := int64(0)
+	for {
+		// the status is read ahead of events, events of a completed record are all there
+		record, err := cache.Get[Record](recordKey)
+		if err == cache.ErrNotFound {
+			// the lease of the first request expired, its node is gone
+			return ErrOriginalGone
+		} else if err != nil {
+			return err
+		}
+		if record.Status == STATUS_TRUNCATED {
+			return ErrResponseTruncated
+		}
+
+		events, err := cache.LRange(eventsKey, replayed, -1)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			write([]byte(event))
+		}
+		replayed += int64(len(events))
+
+		if record.Status == STATUS_COMPLETED {
+			return nil
+		}
+
+		// the session of the first request may not be created yet
+		if len(events) == 0 && record.SessionID != "" {
+			alive, err := session_manager.Alive(record.SessionID)
+			if err != nil {
+				return err
+			}
+			if !alive {
+				// the first request may complete right before its session is closed
+				latest, err := cache.Get[Record](recordKey)
+				if err == cache.ErrNotFound {
+					return ErrOriginalGone
+				} else if err != nil {
+					return err
+				}
+				if latest.Status == STATUS_RUNNING {
+					return ErrOriginalGone
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
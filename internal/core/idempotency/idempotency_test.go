@@ -0,0 +1,83 @@
+package idempotency
+
+import (
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/strings"
+)
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint("a", "bc") == Fingerprint("ab", "c") {
+		t.Fatal("parts should be separated")
+	}
+	if Fingerprint("a", "b") != Fingerprint("a", "b") {
+		t.Fatal("fingerprint should be stable")
+	}
+}
+
+func TestAcquireAndReplay(t *testing.T) {
+	if err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	InitIdempotency(&app.Config{
+		DispatchIdempotencyEnabled:         true,
+		DispatchIdempotencyWindow:          60,
+		DispatchIdempotencyMaxResponseSize: 1024,
+	})
+
+	tenantId := strings.RandomString(10)
+	recorder, record, err := Acquire(tenantId, "plugin_id", "key", "fingerprint")
+	if err != nil || recorder == nil || record != nil {
+		t.Fatalf("expected the first request to record, got %v %v", record, err)
+	}
+	recorder.BindSession("session_id")
+	recorder.Record([]byte("first"))
+	recorder.Record([]byte("second"))
+	recorder.Complete()
+
+	if _, _, err := Acquire(tenantId, "plugin_id", "key", "other"); err != ErrFingerprintMismatch {
+		t.Fatalf("expected fingerprint mismatch, got %v", err)
+	}
+
+	recorder, record, err = Acquire(tenantId, "plugin_id", "key", "fingerprint")
+	if err != nil || recorder != nil || record == nil {
+		t.Fatalf("expected the duplicate to replay, got %v", err)
+	}
+
+	events := []string{}
+	if err := Replay(tenantId, "plugin_id", "key", func(event []byte) {
+		events = append(events, string(event))
+	}, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != "first" || events[1] != "second" {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	// responses beyond the max response size are not replayed
+	recorder, _, err = Acquire(tenantId, "plugin_id", "large", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(make([]byte, 2048))
+	recorder.Complete()
+	if err := Replay(tenantId, "plugin_id", "large", func([]byte) {}, make(chan struct{})); err != ErrResponseTruncated {
+		t.Fatalf("expected truncated, got %v", err)
+	}
+
+	// a first request failing before invoking the plugin releases the key
+	recorder, _, err = Acquire(tenantId, "plugin_id", "abandoned", "fingerprint")
+	if err != nil || recorder == nil {
+		t.Fatalf("expected the first request to record, got %v", err)
+	}
+	recorder.Abandon()
+	recorder, record, err = Acquire(tenantId, "plugin_id", "abandoned", "fingerprint")
+	if err != nil || recorder == nil || record != nil {
+		t.Fatalf("expected the retry to record, got %v %v", record, err)
+	}
+	recorder.Complete()
+}
@@ -0,0 +1,312 @@
+package persistence
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+)
+
+/*
+ Archive of persistence storage, used to move storage of tenants between deployments
+
+ It's a tar.gz whose first entry is the manifest, followed by the values of keys named by the manifest,
+ keys are not used as file names as they are not guaranteed to be valid ones.
+*/
+
+const (
+	ARCHIVE_VERSION       = 1
+	ARCHIVE_MANIFEST_FILE = "manifest.json"
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid storage archive")
+)
+
+type ArchiveManifest struct {
+	Version    int             `json:"version"`
+	TenantID   string          `json:"tenant_id"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Plugins    []ArchivePlugin `json:"plugins"`
+}
+
+type ArchivePlugin struct {
+	PluginID string       `json:"plugin_id"`
+	Size     int64        `json:"size"`
+	Keys     []ArchiveKey `json:"keys"`
+}
+
+type ArchiveKey struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	File string `json:"file"`
+	// unix time the key expires at, omitted if it never expires
+	ExpiresAt *int64 `json:"expires_at,omitempty"`
+}
+
+type ArchiveImportResult struct {
+	TenantID string `json:"tenant_id"`
+	Plugins  int    `json:"plugins"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+}
+
+// Manifest lists keys of the plugin, or of all plugins of the tenant if pluginId is empty,
+// expired keys are excluded
+func (c *Persistence) Manifest(tenantId string, pluginId string) (*ArchiveManifest, error) {
+	pluginIds := []string{pluginId}
+	if pluginId == "" {
+		storages, err := db.GetAll[models.TenantStorage](
+			db.Equal("tenant_id", tenantId),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		pluginIds = make([]string, 0, len(storages))
+		for _, storage := range storages {
+			pluginIds = append(pluginIds, storage.PluginID)
+		}
+	}
+
+	manifest := &ArchiveManifest{
+		Version:    ARCHIVE_VERSION,
+		TenantID:   tenantId,
+		ExportedAt: time.Now(),
+		Plugins:    make([]ArchivePlugin, 0, len(pluginIds)),
+	}
+
+	files := 0
+	for _, pluginId := range pluginIds {
+		keys, err := c.storage.List(tenantId, pluginId)
+		if err != nil {
+			return nil, err
+		}
+
+		plugin := ArchivePlugin{PluginID: pluginId, Keys: make([]ArchiveKey, 0, len(keys))}
+		for _, key := range keys {
+			expiresAt, err := c.expiresAt(tenantId, pluginId, key)
+			if err != nil {
+				return nil, err
+			}
+			if expiresAt != nil && *expiresAt <= time.Now().Unix() {
+				continue
+			}
+
+			size, err := c.storage.StateSize(tenantId, pluginId, key)
+			if err != nil {
+				return nil, err
+			}
+
+			files++
+			plugin.Keys = append(plugin.Keys, ArchiveKey{
+				Key:       key,
+				Size:      size,
+				File:      fmt.Sprintf("data/%d", files),
+				ExpiresAt: expiresAt,
+			})
+			plugin.Size += size
+		}
+
+		manifest.Plugins = append(manifest.Plugins, plugin)
+	}
+
+	return manifest, nil
+}
+
+// Export writes the archive of keys listed by the manifest
+func (c *Persistence) Export(w io.Writer, manifest *ArchiveManifest) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: manifest.ExportedAt,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := writeFile(ARCHIVE_MANIFEST_FILE, parser.MarshalJsonBytes(manifest)); err != nil {
+		return err
+	}
+
+	for _, plugin := range manifest.Plugins {
+		for _, key := range plugin.Keys {
+			data, err := c.storage.Load(manifest.TenantID, plugin.PluginID, key.Key)
+			if err != nil {
+				return fmt.Errorf("failed to load key %s of plugin %s: %s", key.Key, plugin.PluginID, err.Error())
+			}
+			if err := writeFile(key.File, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Import restores the archive into the tenant, or the tenant of the archive if tenantId is empty,
+// existing keys are kept unless overwrite, quotas are enforced and sizes of imported plugins are rebuilt
+func (c *Persistence) Import(r io.Reader, tenantId string, overwrite bool) (*ArchiveImportResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != ARCHIVE_MANIFEST_FILE {
+		return nil, fmt.Errorf("%w: %s must be the first entry", ErrInvalidArchive, ARCHIVE_MANIFEST_FILE)
+	}
+	manifestBytes, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	manifest, err := parser.UnmarshalJsonBytes[ArchiveManifest](manifestBytes)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidArchive, err)
+	}
+	if manifest.Version != ARCHIVE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	if tenantId == "" {
+		tenantId = manifest.TenantID
+	}
+	if tenantId == "" {
+		return nil, fmt.Errorf("%w: tenant id is missing", ErrInvalidArchive)
+	}
+
+	// reject the whole archive before anything is written
+	type entry struct {
+		pluginId string
+		key      ArchiveKey
+	}
+	entries := map[string]entry{}
+	for _, plugin := range manifest.Plugins {
+		if err := c.checkPathTraversal(plugin.PluginID); err != nil || plugin.PluginID == "" {
+			return nil, fmt.Errorf("%w: invalid plugin id %s", ErrInvalidArchive, plugin.PluginID)
+		}
+		if plugin.Size > c.maxStorageSize {
+			return nil, fmt.Errorf("storage of plugin %s is greater than max storage size", plugin.PluginID)
+		}
+		for _, key := range plugin.Keys {
+			if err := c.checkKey(key.Key); err != nil {
+				return nil, errors.Join(ErrInvalidArchive, err)
+			}
+			entries[key.File] = entry{pluginId: plugin.PluginID, key: key}
+		}
+	}
+
+	result := &ArchiveImportResult{TenantID: tenantId, Plugins: len(manifest.Plugins)}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return result, errors.Join(ErrInvalidArchive, err)
+		}
+
+		entry, ok := entries[header.Name]
+		if !ok {
+			return result, fmt.Errorf("%w: %s is not in the manifest", ErrInvalidArchive, header.Name)
+		}
+		if header.Size > c.maxStorageSize {
+			return result, fmt.Errorf("key %s of plugin %s is greater than max storage size", entry.key.Key, entry.pluginId)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return result, errors.Join(ErrInvalidArchive, err)
+		}
+
+		imported, err := c.importKey(tenantId, entry.pluginId, entry.key, data, overwrite)
+		if err != nil {
+			return result, fmt.Errorf("failed to import key %s of plugin %s: %s", entry.key.Key, entry.pluginId, err.Error())
+		}
+		if imported {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	for _, plugin := range manifest.Plugins {
+		if err := c.rebuildSize(tenantId, plugin.PluginID); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func (c *Persistence) importKey(tenantId string, pluginId string, key ArchiveKey, data []byte, overwrite bool) (bool, error) {
+	ttl := time.Duration(0)
+	if key.ExpiresAt != nil {
+		ttl = time.Until(time.Unix(*key.ExpiresAt, 0))
+		// expired since exported
+		if ttl <= 0 {
+			return false, nil
+		}
+	}
+
+	unlock, err := c.lock(tenantId, pluginId, key.Key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if !overwrite {
+		if _, err := c.load(tenantId, pluginId, key.Key); err == nil {
+			return false, nil
+		} else if err != ErrKeyNotFound {
+			return false, err
+		}
+	}
+
+	if err := c.save(tenantId, pluginId, -1, key.Key, data, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rebuildSize records the size of the plugin from the storage
+func (c *Persistence) rebuildSize(tenantId string, pluginId string) error {
+	usage, err := c.Usage(tenantId, pluginId)
+	if err != nil {
+		return err
+	}
+
+	storage, err := db.GetOne[models.TenantStorage](
+		db.Equal("tenant_id", tenantId),
+		db.Equal("plugin_id", pluginId),
+	)
+	if err == db.ErrDatabaseNotFound {
+		return db.Create(&models.TenantStorage{
+			TenantID: tenantId,
+			PluginID: pluginId,
+			Size:     usage,
+		})
+	} else if err != nil {
+		return err
+	}
+
+	storage.Size = usage
+	return db.Update(&storage)
+}
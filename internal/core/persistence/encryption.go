@@ -0,0 +1,530 @@
+package persistence
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"gorm.io/gorm"
+)
+
+/*
+ Encryption at rest
+
+ Values are encrypted by a data key of the tenant with AES-256-GCM, data keys are wrapped by a master key
+ provided by the operator and stored in the database, unwrapped data keys never leave the memory of the node.
+
+ A sealed value is laid out as magic | data key version | nonce | ciphertext, the location of the value
+ is authenticated so sealed values can not be moved between keys, plugins or tenants.
+ Values without the magic were written before encryption was enabled and are read as they are,
+ the rotation of the data key of a tenant encrypts them.
+
+ Rotation of a data key retires the active one instead of destroying it, nodes pick up the new data key
+ within ACTIVE_DATA_KEY_TTL and values written by them in between are still readable.
+*/
+
+const (
+	SEALED_MAGIC        = "DPE\x01"
+	SEALED_HEADER_SIZE  = len(SEALED_MAGIC) + 4 + 12
+	SEALED_OVERHEAD     = SEALED_HEADER_SIZE + 16
+	DATA_KEY_SIZE       = 32
+	ACTIVE_DATA_KEY_TTL = time.Minute
+
+	DATA_KEY_LOCK_PREFIX = "persistence:encryption:lock"
+)
+
+var (
+	ErrEncryptionDisabled = errors.New("persistence storage encryption is disabled")
+)
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+// keyring holds master keys, the first one wraps new data keys
+type keyring struct {
+	keys []masterKey
+}
+
+// newKeyring parses `id:base64 key` pairs, returns nil if no key is configured
+func newKeyring(config *app.Config) (*keyring, error) {
+	entries := strings.Split(config.PersistenceStorageEncryptionKeys, ",")
+	if config.PersistenceStorageEncryptionKeysFile != "" {
+		content, err := os.ReadFile(config.PersistenceStorageEncryptionKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption keys file: %s", err.Error())
+		}
+		entries = append(entries, strings.Split(string(content), "\n")...)
+	}
+
+	ring := &keyring{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || len(id) > 64 {
+			return nil, fmt.Errorf("invalid encryption key, expected `id:base64 key`")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %s must be 32 bytes encoded in base64", id)
+		}
+		if ring.get(id) != nil {
+			return nil, fmt.Errorf("duplicated encryption key %s", id)
+		}
+
+		ring.keys = append(ring.keys, masterKey{id: id, key: key})
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, nil
+	}
+	return ring, nil
+}
+
+func (r *keyring) primary() masterKey {
+	return r.keys[0]
+}
+
+func (r *keyring) get(id string) *masterKey {
+	for i := range r.keys {
+		if r.keys[i].id == id {
+			return &r.keys[i]
+		}
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func dataKeyAAD(tenantId string, version int) []byte {
+	return []byte(fmt.Sprintf("%s:%d", tenantId, version))
+}
+
+func (r *keyring) wrap(tenantId string, version int, dataKey []byte) (string, string, error) {
+	primary := r.primary()
+	gcm, err := newGCM(primary.key)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	wrapped := gcm.Seal(nonce, nonce, dataKey, dataKeyAAD(tenantId, version))
+	return primary.id, base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+func (r *keyring) unwrap(row *models.TenantDataKey) ([]byte, error) {
+	master := r.get(row.MasterKeyID)
+	if master == nil {
+		return nil, fmt.Errorf("master key %s is not configured", row.MasterKeyID)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(row.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(master.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("data key %d of tenant %s is corrupted", row.Version, row.TenantID)
+	}
+
+	dataKey, err := gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], dataKeyAAD(row.TenantID, row.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %d of tenant %s: %s", row.Version, row.TenantID, err.Error())
+	}
+	return dataKey, nil
+}
+
+type activeDataKey struct {
+	version  int
+	loadedAt time.Time
+}
+
+// encryptedStorage encrypts values of the underlying storage
+type encryptedStorage struct {
+	PersistenceStorage
+
+	keyring *keyring
+
+	lock sync.RWMutex
+	// tenant -> version -> unwrapped data key
+	dataKeys map[string]map[int][]byte
+	active   map[string]activeDataKey
+}
+
+func newEncryptedStorage(storage PersistenceStorage, keyring *keyring) *encryptedStorage {
+	return &encryptedStorage{
+		PersistenceStorage: storage,
+		keyring:            keyring,
+		dataKeys:           map[string]map[int][]byte{},
+		active:             map[string]activeDataKey{},
+	}
+}
+
+func (s *encryptedStorage) remember(tenantId string, version int, dataKey []byte, active bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.dataKeys[tenantId] == nil {
+		s.dataKeys[tenantId] = map[int][]byte{}
+	}
+	s.dataKeys[tenantId][version] = dataKey
+	if active {
+		s.active[tenantId] = activeDataKey{version: version, loadedAt: time.Now()}
+	}
+}
+
+// dataKey returns the data key of the version
+func (s *encryptedStorage) dataKey(tenantId string, version int) ([]byte, error) {
+	s.lock.RLock()
+	dataKey, ok := s.dataKeys[tenantId][version]
+	s.lock.RUnlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	row, err := db.GetOne[models.TenantDataKey](
+		db.Equal("tenant_id", tenantId),
+		db.Equal("version", version),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key %d of tenant %s: %s", version, tenantId, err.Error())
+	}
+
+	dataKey, err = s.keyring.unwrap(&row)
+	if err != nil {
+		return nil, err
+	}
+	s.remember(tenantId, version, dataKey, false)
+	return dataKey, nil
+}
+
+// activeDataKey returns the data key encrypting new values of the tenant, it's created on the first use
+func (s *encryptedStorage) activeDataKey(tenantId string) (int, []byte, error) {
+	s.lock.RLock()
+	active, ok := s.active[tenantId]
+	s.lock.RUnlock()
+	if ok && time.Since(active.loadedAt) < ACTIVE_DATA_KEY_TTL {
+		dataKey, err := s.dataKey(tenantId, active.version)
+		return active.version, dataKey, err
+	}
+
+	row, err := db.GetOne[models.TenantDataKey](
+		db.Equal("tenant_id", tenantId),
+		db.Equal("active", true),
+	)
+	if err == db.ErrDatabaseNotFound {
+		return s.createDataKey(tenantId, false)
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	dataKey, err := s.keyring.unwrap(&row)
+	if err != nil {
+		return 0, nil, err
+	}
+	s.remember(tenantId, row.Version, dataKey, true)
+	return row.Version, dataKey, nil
+}
+
+// createDataKey creates a data key and retires the active one,
+// the active one created by another node in the meantime is used instead unless rotating
+func (s *encryptedStorage) createDataKey(tenantId string, rotate bool) (int, []byte, error) {
+	lockKey := fmt.Sprintf("%s:%s", DATA_KEY_LOCK_PREFIX, tenantId)
+	if err := cache.Lock(lockKey, KEY_LOCK_EXPIRE, KEY_LOCK_TIMEOUT); err != nil {
+		return 0, nil, err
+	}
+	defer cache.Unlock(lockKey)
+
+	rows, err := db.GetAll[models.TenantDataKey](
+		db.Equal("tenant_id", tenantId),
+		db.OrderBy("version", true),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, row := range rows {
+		if row.Active && !rotate {
+			dataKey, err := s.keyring.unwrap(&row)
+			if err != nil {
+				return 0, nil, err
+			}
+			s.remember(tenantId, row.Version, dataKey, true)
+			return row.Version, dataKey, nil
+		}
+	}
+
+	version := 1
+	if len(rows) > 0 {
+		version = rows[0].Version + 1
+	}
+
+	dataKey := make([]byte, DATA_KEY_SIZE)
+	if _, err := rand.Read(dataKey); err != nil {
+		return 0, nil, err
+	}
+	masterKeyId, wrapped, err := s.keyring.wrap(tenantId, version, dataKey)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := db.WithTransaction(func(tx *gorm.DB) error {
+		for _, row := range rows {
+			if !row.Active {
+				continue
+			}
+			row.Active = false
+			if err := db.Update(&row, tx); err != nil {
+				return err
+			}
+		}
+
+		return db.Create(&models.TenantDataKey{
+			TenantID:    tenantId,
+			Version:     version,
+			MasterKeyID: masterKeyId,
+			WrappedKey:  wrapped,
+			Active:      true,
+		}, tx)
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	s.remember(tenantId, version, dataKey, true)
+	return version, dataKey, nil
+}
+
+func sealedAAD(tenantId string, pluginId string, key string) []byte {
+	return []byte(strings.Join([]string{tenantId, pluginId, key}, "\x00"))
+}
+
+func (s *encryptedStorage) seal(tenantId string, pluginId string, key string, data []byte) ([]byte, error) {
+	version, dataKey, err := s.activeDataKey(tenantId)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, SEALED_HEADER_SIZE, SEALED_OVERHEAD+len(data))
+	copy(sealed, SEALED_MAGIC)
+	binary.BigEndian.PutUint32(sealed[len(SEALED_MAGIC):], uint32(version))
+	nonce := sealed[len(SEALED_MAGIC)+4 : SEALED_HEADER_SIZE]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(sealed, nonce, data, sealedAAD(tenantId, pluginId, key)), nil
+}
+
+// Open decrypts a sealed value, values written before encryption was enabled are returned as they are
+func (s *encryptedStorage) Open(tenantId string, pluginId string, key string, sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(SEALED_MAGIC)) {
+		return sealed, nil
+	}
+	if len(sealed) < SEALED_OVERHEAD {
+		return nil, fmt.Errorf("sealed value of key %s is corrupted", key)
+	}
+
+	version := int(binary.BigEndian.Uint32(sealed[len(SEALED_MAGIC):]))
+	dataKey, err := s.dataKey(tenantId, version)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[len(SEALED_MAGIC)+4 : SEALED_HEADER_SIZE]
+	data, err := gcm.Open(nil, nonce, sealed[SEALED_HEADER_SIZE:], sealedAAD(tenantId, pluginId, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %s", key, err.Error())
+	}
+	return data, nil
+}
+
+// LoadSealed returns the value as it's stored
+func (s *encryptedStorage) LoadSealed(tenantId string, pluginId string, key string) ([]byte, error) {
+	return s.PersistenceStorage.Load(tenantId, pluginId, key)
+}
+
+func (s *encryptedStorage) Save(tenantId string, pluginId string, key string, data []byte) error {
+	sealed, err := s.seal(tenantId, pluginId, key, data)
+	if err != nil {
+		return err
+	}
+	return s.PersistenceStorage.Save(tenantId, pluginId, key, sealed)
+}
+
+func (s *encryptedStorage) Load(tenantId string, pluginId string, key string) ([]byte, error) {
+	sealed, err := s.LoadSealed(tenantId, pluginId, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(tenantId, pluginId, key, sealed)
+}
+
+// StateSize returns the size of the decrypted value, it's what quotas are enforced on
+//
+// NOTE: values written before encryption was enabled are under counted until they are encrypted
+func (s *encryptedStorage) StateSize(tenantId string, pluginId string, key string) (int64, error) {
+	size, err := s.PersistenceStorage.StateSize(tenantId, pluginId, key)
+	if err != nil {
+		return 0, err
+	}
+	return max(size-int64(SEALED_OVERHEAD), 0), nil
+}
+
+func (c *Persistence) encryption() (*encryptedStorage, error) {
+	storage, ok := c.storage.(*encryptedStorage)
+	if !ok {
+		return nil, ErrEncryptionDisabled
+	}
+	return storage, nil
+}
+
+// RotateDataKey replaces the data key of the tenant and encrypts all values with the new one,
+// returns the number of encrypted values
+func (c *Persistence) RotateDataKey(tenantId string) (int, error) {
+	storage, err := c.encryption()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, _, err := storage.createDataKey(tenantId, true); err != nil {
+		return 0, err
+	}
+
+	storages, err := db.GetAll[models.TenantStorage](
+		db.Equal("tenant_id", tenantId),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	encrypted := 0
+	for _, tenantStorage := range storages {
+		keys, err := c.storage.List(tenantId, tenantStorage.PluginID)
+		if err != nil {
+			return encrypted, err
+		}
+
+		for _, key := range keys {
+			reencrypted, err := c.reencrypt(tenantId, tenantStorage.PluginID, key)
+			if err != nil {
+				return encrypted, fmt.Errorf("failed to encrypt key %s of plugin %s: %s", key, tenantStorage.PluginID, err.Error())
+			}
+			if reencrypted {
+				encrypted++
+			}
+		}
+
+		// sizes of values written before encryption was enabled were under counted
+		if err := c.rebuildSize(tenantId, tenantStorage.PluginID); err != nil {
+			return encrypted, err
+		}
+	}
+
+	return encrypted, nil
+}
+
+func (c *Persistence) reencrypt(tenantId string, pluginId string, key string) (bool, error) {
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// expired keys are left to the sweeper
+	data, err := c.load(tenantId, pluginId, key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := c.storage.Save(tenantId, pluginId, key, data); err != nil {
+		return false, err
+	}
+
+	if _, err = cache.Del(c.getCacheKey(tenantId, pluginId, key)); err != nil && err != cache.ErrNotFound {
+		return false, err
+	}
+	return true, nil
+}
+
+// RewrapDataKeys wraps data keys wrapped by retired master keys with the primary one,
+// returns the number of rewrapped data keys, retired master keys can be removed afterwards
+func (c *Persistence) RewrapDataKeys() (int, error) {
+	storage, err := c.encryption()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.GetAll[models.TenantDataKey](
+		db.NotEqual("master_key_id", storage.keyring.primary().id),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, row := range rows {
+		dataKey, err := storage.keyring.unwrap(&row)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		row.MasterKeyID, row.WrappedKey, err = storage.keyring.wrap(row.TenantID, row.Version, dataKey)
+		if err != nil {
+			return rewrapped, err
+		}
+		if err := db.Update(&row); err != nil {
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+
+	if rewrapped > 0 {
+		log.Info("rewrapped %d data keys with master key %s", rewrapped, storage.keyring.primary().id)
+	}
+	return rewrapped, nil
+}
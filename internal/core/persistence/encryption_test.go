@@ -0,0 +1,79 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	cloudoss "github.com/langgenius/dify-cloud-kit/oss"
+	"github.com/langgenius/dify-cloud-kit/oss/factory"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	ring, err := newKeyring(&app.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, ring)
+
+	ring, err = newKeyring(&app.Config{PersistenceStorageEncryptionKeys: "v2:" + key + ", v1:" + key})
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", ring.primary().id)
+	assert.NotNil(t, ring.get("v1"))
+
+	_, err = newKeyring(&app.Config{PersistenceStorageEncryptionKeys: "v1:" + key + ",v1:" + key})
+	assert.NotNil(t, err)
+
+	_, err = newKeyring(&app.Config{PersistenceStorageEncryptionKeys: "v1:c2hvcnQ="})
+	assert.NotNil(t, err)
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: t.TempDir(),
+		},
+	})
+	assert.Nil(t, err)
+
+	ring, err := newKeyring(&app.Config{
+		PersistenceStorageEncryptionKeys: "v1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	})
+	assert.Nil(t, err)
+
+	// data keys are preloaded, the database is never touched
+	plain := NewWrapper(oss, "./persistence_storage")
+	storage := newEncryptedStorage(plain, ring)
+	storage.remember("tenant_id", 1, bytes.Repeat([]byte{2}, DATA_KEY_SIZE), true)
+
+	// values written before encryption was enabled are still readable
+	assert.Nil(t, plain.Save("tenant_id", "plugin_id", "legacy", []byte("legacy")))
+	data, err := storage.Load("tenant_id", "plugin_id", "legacy")
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", string(data))
+
+	assert.Nil(t, storage.Save("tenant_id", "plugin_id", "key", []byte("secret")))
+
+	sealed, err := storage.LoadSealed("tenant_id", "plugin_id", "key")
+	assert.Nil(t, err)
+	assert.NotContains(t, string(sealed), "secret")
+	assert.Len(t, sealed, SEALED_OVERHEAD+len("secret"))
+
+	data, err = storage.Load("tenant_id", "plugin_id", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(data))
+
+	size, err := storage.StateSize("tenant_id", "plugin_id", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("secret")), size)
+
+	// sealed values are bound to their keys
+	_, err = storage.Open("tenant_id", "plugin_id", "other_key", sealed)
+	assert.NotNil(t, err)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = storage.Open("tenant_id", "plugin_id", "key", sealed)
+	assert.NotNil(t, err)
+}
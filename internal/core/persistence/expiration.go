@@ -0,0 +1,204 @@
+package persistence
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+)
+
+/*
+ Expiration of keys
+
+ Expirations of all keys are kept in a sorted set scored by the unix time they expire at,
+ expired keys are invisible immediately and reclaimed by the sweeper of the master node later,
+ which also releases their size from the tenant storage.
+*/
+
+const (
+	EXPIRATION_KEY   = "persistence:expiration"
+	SWEEP_BATCH_SIZE = 256
+
+	// KEEP_TTL keeps the current expiration of the key when it's overwritten
+	KEEP_TTL time.Duration = -1
+)
+
+type expirationMember struct {
+	TenantID string `json:"tenant_id"`
+	PluginID string `json:"plugin_id"`
+	Key      string `json:"key"`
+}
+
+func (c *Persistence) getExpirationMember(tenantId string, pluginId string, key string) string {
+	return parser.MarshalJson(expirationMember{TenantID: tenantId, PluginID: pluginId, Key: key})
+}
+
+// setExpiration sets the key to expire after ttl, 0 means never
+func (c *Persistence) setExpiration(tenantId string, pluginId string, key string, ttl time.Duration) error {
+	if ttl == KEEP_TTL {
+		return nil
+	}
+
+	member := c.getExpirationMember(tenantId, pluginId, key)
+	if ttl == 0 {
+		return cache.ZRem(EXPIRATION_KEY, member)
+	}
+	return cache.ZAdd(EXPIRATION_KEY, float64(time.Now().Add(ttl).Unix()), member)
+}
+
+func (c *Persistence) isExpired(tenantId string, pluginId string, key string) (bool, error) {
+	expiresAt, err := c.expiresAt(tenantId, pluginId, key)
+	if err != nil || expiresAt == nil {
+		return false, err
+	}
+
+	return *expiresAt <= time.Now().Unix(), nil
+}
+
+// expiredKeys reports whether each of the keys is expired, the same as isExpired in one round trip
+func (c *Persistence) expiredKeys(tenantId string, pluginId string, keys []string) ([]bool, error) {
+	members := make([]string, 0, len(keys))
+	for _, key := range keys {
+		members = append(members, c.getExpirationMember(tenantId, pluginId, key))
+	}
+
+	scores, err := cache.ZScores(EXPIRATION_KEY, members)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	expired := make([]bool, len(keys))
+	for i, score := range scores {
+		expired[i] = score != nil && int64(*score) <= now
+	}
+
+	return expired, nil
+}
+
+// expiresAt returns the unix time the key expires at, nil means never
+func (c *Persistence) expiresAt(tenantId string, pluginId string, key string) (*int64, error) {
+	score, err := cache.ZScore(EXPIRATION_KEY, c.getExpirationMember(tenantId, pluginId, key))
+	if err == cache.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	expiresAt := int64(score)
+	return &expiresAt, nil
+}
+
+// Sweep reclaims expired keys, returns the number of reclaimed keys
+func (c *Persistence) Sweep() (int, error) {
+	reclaimed := 0
+	for {
+		members, err := cache.ZRangeByScore(EXPIRATION_KEY, float64(time.Now().Unix()), 0, SWEEP_BATCH_SIZE)
+		if err != nil {
+			return reclaimed, err
+		}
+
+		progressed := false
+		for _, member := range members {
+			m, err := parser.UnmarshalJson[expirationMember](member)
+			if err != nil {
+				// never going to be parsed
+				cache.ZRem(EXPIRATION_KEY, member)
+				continue
+			}
+
+			swept, err := c.sweepKey(m.TenantID, m.PluginID, m.Key)
+			if err != nil {
+				log.Error("failed to sweep expired key %s of plugin %s: %s", m.Key, m.PluginID, err.Error())
+				continue
+			}
+			progressed = true
+			if swept {
+				reclaimed++
+			}
+		}
+
+		// stop once all expired keys are swept or the rest keeps failing
+		if len(members) < SWEEP_BATCH_SIZE || !progressed {
+			return reclaimed, nil
+		}
+	}
+}
+
+// sweepKey deletes the key if it's still expired, it may have been overwritten since listed
+func (c *Persistence) sweepKey(tenantId string, pluginId string, key string) (bool, error) {
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	expired, err := c.isExpired(tenantId, pluginId, key)
+	if err != nil || !expired {
+		return false, err
+	}
+
+	exists, err := c.storage.Exists(tenantId, pluginId, key)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if _, err := c.delete(tenantId, pluginId, key); err != nil {
+			return false, err
+		}
+	}
+
+	return exists, cache.ZRem(EXPIRATION_KEY, c.getExpirationMember(tenantId, pluginId, key))
+}
+
+// LaunchSweeper reclaims expired keys every interval, only the master node sweeps
+func LaunchSweeper(config *app.Config, isMaster func() bool) {
+	if persistence == nil || config.PersistenceStorageSweepInterval <= 0 {
+		return
+	}
+	interval := time.Duration(config.PersistenceStorageSweepInterval) * time.Second
+
+	routine.Submit(map[string]string{
+		"module":   "persistence",
+		"function": "LaunchSweeper",
+	}, func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !isMaster() {
+				continue
+			}
+
+			reclaimed, err := persistence.Sweep()
+			if err != nil {
+				log.Error("failed to sweep expired persistence keys: %s", err.Error())
+			}
+			if reclaimed > 0 {
+				log.Info("reclaimed %d expired persistence keys", reclaimed)
+			}
+		}
+	})
+}
+
+func (c *Persistence) getLockKey(tenantId string, pluginId string, key string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", LOCK_KEY_PREFIX, tenantId, pluginId, key)
+}
+
+// lock serializes writes of the key across the cluster
+func (c *Persistence) lock(tenantId string, pluginId string, key string) (func(), error) {
+	lockKey := c.getLockKey(tenantId, pluginId, key)
+	if err := cache.Lock(lockKey, KEY_LOCK_EXPIRE, KEY_LOCK_TIMEOUT); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := cache.Unlock(lockKey); err != nil {
+			log.Error("failed to unlock persistence key %s: %s", key, err.Error())
+		}
+	}, nil
+}
@@ -12,8 +12,19 @@ var (
 )
 
 func InitPersistence(oss oss.OSS, config *app.Config) {
+	keyring, err := newKeyring(config)
+	if err != nil {
+		log.Panic("failed to load persistence storage encryption keys: %s", err.Error())
+	}
+
+	var storage PersistenceStorage = NewWrapper(oss, config.PersistenceStoragePath)
+	if keyring != nil {
+		storage = newEncryptedStorage(storage, keyring)
+		log.Info("Persistence storage encrypted with master key %s", keyring.primary().id)
+	}
+
 	persistence = &Persistence{
-		storage:        NewWrapper(oss, config.PersistenceStoragePath),
+		storage:        storage,
 		maxStorageSize: config.PersistenceStorageMaxSize,
 	}
 
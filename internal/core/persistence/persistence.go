@@ -1,12 +1,16 @@
 package persistence
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/langgenius/dify-plugin-daemon/internal/db"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
@@ -20,6 +24,15 @@ type Persistence struct {
 
 const (
 	CACHE_KEY_PREFIX = "persistence:cache"
+	LOCK_KEY_PREFIX  = "persistence:lock"
+
+	KEY_LOCK_EXPIRE  = 10 * time.Second
+	KEY_LOCK_TIMEOUT = 5 * time.Second
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrNotInteger  = errors.New("value is not an integer")
 )
 
 func (c *Persistence) getCacheKey(tenantId string, pluginId string, key string) string {
@@ -34,7 +47,7 @@ func (c *Persistence) checkPathTraversal(key string) error {
 	return nil
 }
 
-func (c *Persistence) Save(tenantId string, pluginId string, maxSize int64, key string, data []byte) error {
+func (c *Persistence) checkKey(key string) error {
 	if err := c.checkPathTraversal(key); err != nil {
 		return err
 	}
@@ -42,43 +55,149 @@ func (c *Persistence) Save(tenantId string, pluginId string, maxSize int64, key
 	if len(key) > 256 {
 		return fmt.Errorf("key length must be less than 256 characters")
 	}
+	return nil
+}
+
+// Hash returns the sha256 of the data, it's the version compared by CompareAndSwap
+func (c *Persistence) Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Persistence) Save(tenantId string, pluginId string, maxSize int64, key string, data []byte) error {
+	return c.SaveWithTTL(tenantId, pluginId, maxSize, key, data, 0)
+}
+
+// SaveWithTTL saves the data, the key expires after ttl, 0 means never
+func (c *Persistence) SaveWithTTL(
+	tenantId string, pluginId string, maxSize int64, key string, data []byte, ttl time.Duration,
+) error {
+	if err := c.checkKey(key); err != nil {
+		return err
+	}
+
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.save(tenantId, pluginId, maxSize, key, data, ttl)
+}
+
+// CompareAndSwap saves the data only if the hash of the current data equals expected,
+// an empty expected means the key must not exist
+func (c *Persistence) CompareAndSwap(
+	tenantId string, pluginId string, maxSize int64, key string, expected string, data []byte, ttl time.Duration,
+) (bool, error) {
+	if err := c.checkKey(key); err != nil {
+		return false, err
+	}
+
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := c.load(tenantId, pluginId, key)
+	if err != nil && err != ErrKeyNotFound {
+		return false, err
+	}
+
+	if err == ErrKeyNotFound {
+		if expected != "" {
+			return false, nil
+		}
+	} else if expected != c.Hash(current) {
+		return false, nil
+	}
+
+	if err := c.save(tenantId, pluginId, maxSize, key, data, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Increase adds delta to the integer stored in the key, a missing key counts as 0,
+// the key keeps its expiration unless ttl is set
+func (c *Persistence) Increase(
+	tenantId string, pluginId string, maxSize int64, key string, delta int64, ttl time.Duration,
+) (int64, error) {
+	if err := c.checkKey(key); err != nil {
+		return 0, err
+	}
+
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// the expiration of a missing key is cleared, it may be left by an expired one
+	value := int64(0)
+	current, err := c.load(tenantId, pluginId, key)
+	if err == nil {
+		value, err = strconv.ParseInt(string(current), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		if ttl == 0 {
+			ttl = KEEP_TTL
+		}
+	} else if err != ErrKeyNotFound {
+		return 0, err
+	}
 
+	value += delta
+	if err := c.save(tenantId, pluginId, maxSize, key, []byte(strconv.FormatInt(value, 10)), ttl); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func (c *Persistence) save(
+	tenantId string, pluginId string, maxSize int64, key string, data []byte, ttl time.Duration,
+) error {
 	if maxSize == -1 {
 		maxSize = c.maxStorageSize
 	}
 
-	if err := c.storage.Save(tenantId, pluginId, key, data); err != nil {
+	// an overwrite allocates only the difference from the previous value
+	previousSize, err := c.previousSize(tenantId, pluginId, key)
+	if err != nil {
 		return err
 	}
-
-	allocatedSize := int64(len(data))
+	allocatedSize := int64(len(data)) - previousSize
 
 	storage, err := db.GetOne[models.TenantStorage](
 		db.Equal("tenant_id", tenantId),
 		db.Equal("plugin_id", pluginId),
 	)
-	if err != nil {
-		if allocatedSize > c.maxStorageSize || allocatedSize > maxSize {
-			return fmt.Errorf("allocated size is greater than max storage size")
-		}
+	if err != nil && err != db.ErrDatabaseNotFound {
+		return err
+	}
+	storageNotFound := err == db.ErrDatabaseNotFound
 
-		if err == db.ErrDatabaseNotFound {
-			storage = models.TenantStorage{
-				TenantID: tenantId,
-				PluginID: pluginId,
-				Size:     allocatedSize,
-			}
-			if err := db.Create(&storage); err != nil {
-				return err
-			}
-		} else {
-			return err
+	// shrinking is always allowed, it's the way out of an exceeded quota
+	if allocatedSize > 0 && (allocatedSize+storage.Size > maxSize || allocatedSize+storage.Size > c.maxStorageSize) {
+		return fmt.Errorf("allocated size is greater than max storage size")
+	}
+
+	if err := c.storage.Save(tenantId, pluginId, key, data); err != nil {
+		return err
+	}
+
+	if storageNotFound {
+		storage = models.TenantStorage{
+			TenantID: tenantId,
+			PluginID: pluginId,
+			Size:     max(allocatedSize, 0),
 		}
-	} else {
-		if allocatedSize+storage.Size > maxSize || allocatedSize+storage.Size > c.maxStorageSize {
-			return fmt.Errorf("allocated size is greater than max storage size")
+		if err := db.Create(&storage); err != nil {
+			return err
 		}
-
+	} else if allocatedSize != 0 {
 		err = db.Run(
 			db.Model(&models.TenantStorage{}),
 			db.Equal("tenant_id", tenantId),
@@ -91,10 +210,42 @@ func (c *Persistence) Save(tenantId string, pluginId string, maxSize int64, key
 	}
 
 	// delete from cache
-	if _, err = cache.Del(c.getCacheKey(tenantId, pluginId, key)); err == cache.ErrNotFound {
-		return nil
+	if _, err = cache.Del(c.getCacheKey(tenantId, pluginId, key)); err != nil && err != cache.ErrNotFound {
+		return err
+	}
+
+	return c.setExpiration(tenantId, pluginId, key, ttl)
+}
+
+// previousSize returns the size of the current value of the key, 0 if it does not exist
+func (c *Persistence) previousSize(tenantId string, pluginId string, key string) (int64, error) {
+	exists, err := c.storage.Exists(tenantId, pluginId, key)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return c.storage.StateSize(tenantId, pluginId, key)
+}
+
+// load returns ErrKeyNotFound if the key does not exist or is expired
+func (c *Persistence) load(tenantId string, pluginId string, key string) ([]byte, error) {
+	expired, err := c.isExpired(tenantId, pluginId, key)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrKeyNotFound
+	}
+
+	exists, err := c.storage.Exists(tenantId, pluginId, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrKeyNotFound
 	}
-	return err
+
+	return c.storage.Load(tenantId, pluginId, key)
 }
 
 // TODO: raises specific error to avoid confusion
@@ -103,28 +254,73 @@ func (c *Persistence) Load(tenantId string, pluginId string, key string) ([]byte
 		return nil, err
 	}
 
+	expired, err := c.isExpired(tenantId, pluginId, key)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrKeyNotFound
+	}
+
 	// check if the key exists in cache
 	h, err := cache.GetString(c.getCacheKey(tenantId, pluginId, key))
 	if err != nil && err != cache.ErrNotFound {
 		return nil, err
 	}
 	if err == nil {
-		return hex.DecodeString(h)
+		sealed, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, err
+		}
+		return c.open(tenantId, pluginId, key, sealed)
 	}
 
 	// load from storage
-	data, err := c.storage.Load(tenantId, pluginId, key)
+	sealed, err := c.loadSealed(tenantId, pluginId, key)
 	if err != nil {
 		return nil, err
 	}
 
-	// add to cache
-	cache.Store(c.getCacheKey(tenantId, pluginId, key), hex.EncodeToString(data), time.Minute*5)
+	// add to cache, encrypted values are cached as they are stored
+	cache.Store(c.getCacheKey(tenantId, pluginId, key), hex.EncodeToString(sealed), time.Minute*5)
+
+	return c.open(tenantId, pluginId, key, sealed)
+}
+
+func (c *Persistence) loadSealed(tenantId string, pluginId string, key string) ([]byte, error) {
+	if storage, ok := c.storage.(SealedStorage); ok {
+		return storage.LoadSealed(tenantId, pluginId, key)
+	}
+	return c.storage.Load(tenantId, pluginId, key)
+}
 
-	return data, nil
+func (c *Persistence) open(tenantId string, pluginId string, key string, sealed []byte) ([]byte, error) {
+	if storage, ok := c.storage.(SealedStorage); ok {
+		return storage.Open(tenantId, pluginId, key, sealed)
+	}
+	return sealed, nil
 }
 
 func (c *Persistence) Delete(tenantId string, pluginId string, key string) (int64, error) {
+	unlock, err := c.lock(tenantId, pluginId, key)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	deletedNum, err := c.delete(tenantId, pluginId, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.setExpiration(tenantId, pluginId, key, 0); err != nil {
+		return 0, err
+	}
+
+	return deletedNum, nil
+}
+
+func (c *Persistence) delete(tenantId string, pluginId string, key string) (int64, error) {
 	// delete from cache and storage
 	deletedNum, err := cache.Del(c.getCacheKey(tenantId, pluginId, key))
 	if err != nil {
@@ -157,6 +353,14 @@ func (c *Persistence) Delete(tenantId string, pluginId string, key string) (int6
 }
 
 func (c *Persistence) Exist(tenantId string, pluginId string, key string) (int64, error) {
+	expired, err := c.isExpired(tenantId, pluginId, key)
+	if err != nil {
+		return 0, err
+	}
+	if expired {
+		return 0, nil
+	}
+
 	existNum, err := cache.Exist(c.getCacheKey(tenantId, pluginId, key))
 	if err != nil {
 		return 0, err
@@ -174,3 +378,146 @@ func (c *Persistence) Exist(tenantId string, pluginId string, key string) (int64
 	}
 	return 0, nil
 }
+
+const (
+	DEFAULT_LIST_LIMIT = 100
+	MAX_LIST_LIMIT     = 1000
+
+	// keys matched by the first page are kept for the following ones, the storage is listed once per listing
+	LIST_SNAPSHOT_KEY_PREFIX = "persistence:list"
+	LIST_SNAPSHOT_TTL        = 10 * time.Minute
+	LIST_SNAPSHOT_BATCH_SIZE = 1000
+)
+
+var (
+	ErrInvalidListCursor = errors.New("list cursor is invalid or expired")
+)
+
+func (c *Persistence) getListSnapshotKey(tenantId string, pluginId string, snapshotId string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", LIST_SNAPSHOT_KEY_PREFIX, tenantId, pluginId, snapshotId)
+}
+
+// createListSnapshot lists keys starting with prefix from the storage and keeps them in the cache,
+// returns an empty snapshot id if no key matches
+func (c *Persistence) createListSnapshot(tenantId string, pluginId string, prefix string) (string, error) {
+	keys, err := c.storage.List(tenantId, pluginId)
+	if err != nil {
+		return "", err
+	}
+
+	matched := make([]string, 0)
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+	}
+	if len(matched) == 0 {
+		return "", nil
+	}
+
+	snapshotId := uuid.New().String()
+	snapshotKey := c.getListSnapshotKey(tenantId, pluginId, snapshotId)
+	for i := 0; i < len(matched); i += LIST_SNAPSHOT_BATCH_SIZE {
+		end := min(i+LIST_SNAPSHOT_BATCH_SIZE, len(matched))
+		if err := cache.RPush(snapshotKey, matched[i:end]); err != nil {
+			return "", err
+		}
+	}
+	if _, err := cache.Expire(snapshotKey, LIST_SNAPSHOT_TTL); err != nil {
+		return "", err
+	}
+
+	return snapshotId, nil
+}
+
+// List returns keys starting with prefix in ascending order, at most limit keys after cursor,
+// expired keys are skipped the same way Load does, the returned cursor is empty once all keys are listed
+// the first page takes a snapshot of the keys, the cursor points into it and the prefix of following pages is ignored
+func (c *Persistence) List(tenantId string, pluginId string, prefix string, cursor string, limit int) (
+	[]string, string, error,
+) {
+	if prefix != "" {
+		if err := c.checkPathTraversal(prefix); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if limit <= 0 {
+		limit = DEFAULT_LIST_LIMIT
+	} else if limit > MAX_LIST_LIMIT {
+		limit = MAX_LIST_LIMIT
+	}
+
+	var snapshotId string
+	var offset int64
+	if cursor == "" {
+		var err error
+		snapshotId, err = c.createListSnapshot(tenantId, pluginId, prefix)
+		if err != nil {
+			return nil, "", err
+		}
+		if snapshotId == "" {
+			return []string{}, "", nil
+		}
+	} else {
+		id, position, ok := strings.Cut(cursor, ":")
+		parsed, err := strconv.ParseInt(position, 10, 64)
+		if !ok || err != nil || parsed < 0 {
+			return nil, "", ErrInvalidListCursor
+		}
+		snapshotId, offset = id, parsed
+	}
+
+	snapshotKey := c.getListSnapshotKey(tenantId, pluginId, snapshotId)
+	if cursor != "" {
+		if refreshed, err := cache.Expire(snapshotKey, LIST_SNAPSHOT_TTL); err != nil {
+			return nil, "", err
+		} else if !refreshed {
+			return nil, "", ErrInvalidListCursor
+		}
+	}
+
+	page := make([]string, 0, limit)
+	for {
+		batch, err := cache.LRange(snapshotKey, offset, offset+int64(limit)-1)
+		if err != nil {
+			return nil, "", err
+		}
+
+		expired, err := c.expiredKeys(tenantId, pluginId, batch)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i, key := range batch {
+			if len(page) == limit {
+				// the rest of the batch is left to the next page
+				return page, fmt.Sprintf("%s:%d", snapshotId, offset), nil
+			}
+
+			offset++
+			if !expired[i] {
+				page = append(page, key)
+			}
+		}
+
+		if len(batch) < limit {
+			// all keys are listed
+			cache.Del(snapshotKey)
+			return page, "", nil
+		}
+
+		if len(page) == limit {
+			// the batch ends at the page, more keys are left only if the snapshot goes on
+			next, err := cache.LRange(snapshotKey, offset, offset)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(next) == 0 {
+				cache.Del(snapshotKey)
+				return page, "", nil
+			}
+			return page, fmt.Sprintf("%s:%d", snapshotId, offset), nil
+		}
+	}
+}
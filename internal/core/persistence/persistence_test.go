@@ -1,13 +1,16 @@
 package persistence
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
+	"time"
 
 	cloudoss "github.com/langgenius/dify-cloud-kit/oss"
 	"github.com/langgenius/dify-cloud-kit/oss/factory"
 	"github.com/langgenius/dify-plugin-daemon/internal/db"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/strings"
 	"github.com/stretchr/testify/assert"
@@ -240,3 +243,373 @@ func TestPersistencePathTraversal(t *testing.T) {
 		})
 	}
 }
+
+func TestPersistenceList(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: t.TempDir(),
+		},
+	})
+	assert.Nil(t, err)
+
+	// listing never touches the database
+	storage := NewWrapper(oss, "./persistence_storage")
+	p := &Persistence{storage: storage, maxStorageSize: 1024}
+
+	for _, key := range []string{"user/b", "user/a", "user/c", "config", "users"} {
+		assert.Nil(t, storage.Save("tenant_id", "plugin_id", key, []byte("data")))
+	}
+	assert.Nil(t, storage.Save("tenant_id", "other_plugin_id", "user/d", []byte("data")))
+
+	keys, cursor, err := p.List("tenant_id", "plugin_id", "", "", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"config", "user/a", "user/b", "user/c", "users"}, keys)
+	assert.Equal(t, "", cursor)
+
+	keys, cursor, err = p.List("tenant_id", "plugin_id", "user/", "", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user/a", "user/b"}, keys)
+	assert.NotEqual(t, "", cursor)
+
+	// keys saved after the first page are not listed by the following ones
+	assert.Nil(t, storage.Save("tenant_id", "plugin_id", "user/0", []byte("data")))
+
+	keys, next, err := p.List("tenant_id", "plugin_id", "user/", cursor, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user/c"}, keys)
+	assert.Equal(t, "", next)
+
+	// cursors are bound to the plugin and gone once all keys are listed
+	_, _, err = p.List("tenant_id", "other_plugin_id", "user/", cursor, 2)
+	assert.Equal(t, ErrInvalidListCursor, err)
+	_, _, err = p.List("tenant_id", "plugin_id", "user/", cursor, 2)
+	assert.Equal(t, ErrInvalidListCursor, err)
+	_, _, err = p.List("tenant_id", "plugin_id", "user/", "invalid", 2)
+	assert.Equal(t, ErrInvalidListCursor, err)
+
+	keys, _, err = p.List("tenant_id", "empty_plugin_id", "", "", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	_, _, err = p.List("tenant_id", "plugin_id", "../other_plugin_id", "", 0)
+	assert.NotNil(t, err)
+}
+
+func TestPersistenceListExpiredKeys(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: t.TempDir(),
+		},
+	})
+	assert.Nil(t, err)
+
+	storage := NewWrapper(oss, "./persistence_storage")
+	p := &Persistence{storage: storage, maxStorageSize: 1024}
+
+	pluginId := strings.RandomString(10)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, storage.Save("tenant_id", pluginId, key, []byte("data")))
+	}
+	for _, key := range []string{"a", "c"} {
+		assert.Nil(t, p.setExpiration("tenant_id", pluginId, key, time.Second))
+		defer p.setExpiration("tenant_id", pluginId, key, 0)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	// expired keys are invisible before they are swept, pages are filled with the following keys
+	keys, cursor, err := p.List("tenant_id", pluginId, "", "", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, keys)
+
+	keys, cursor, err = p.List("tenant_id", pluginId, "", cursor, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d"}, keys)
+	assert.Equal(t, "", cursor)
+}
+
+func TestPersistenceCompareAndSwapAndIncrease(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+	db.Init(&app.Config{
+		DBType:     "postgresql",
+		DBUsername: "postgres",
+		DBPassword: "difyai123456",
+		DBHost:     "localhost",
+		DBPort:     5432,
+		DBDatabase: "dify_plugin_daemon",
+		DBSslMode:  "disable",
+	})
+	defer db.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: "./storage",
+		},
+	})
+	assert.Nil(t, err)
+
+	InitPersistence(oss, &app.Config{
+		PersistenceStoragePath:    "./persistence_storage",
+		PersistenceStorageMaxSize: 1024 * 1024 * 1024,
+	})
+
+	key := strings.RandomString(10)
+
+	// an empty expected value means the key must not exist
+	swapped, err := persistence.CompareAndSwap("tenant_id", "plugin_checksum", -1, key, "", []byte("a"), 0)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+
+	swapped, err = persistence.CompareAndSwap("tenant_id", "plugin_checksum", -1, key, "", []byte("b"), 0)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = persistence.CompareAndSwap(
+		"tenant_id", "plugin_checksum", -1, key, persistence.Hash([]byte("a")), []byte("b"), 0,
+	)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+
+	data, err := persistence.Load("tenant_id", "plugin_checksum", key)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(data))
+
+	_, err = persistence.Increase("tenant_id", "plugin_checksum", -1, key, 1, 0)
+	assert.Equal(t, ErrNotInteger, err)
+
+	counter := strings.RandomString(10)
+	value, err := persistence.Increase("tenant_id", "plugin_checksum", -1, counter, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), value)
+
+	value, err = persistence.Increase("tenant_id", "plugin_checksum", -1, counter, -3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), value)
+
+	persistence.Delete("tenant_id", "plugin_checksum", key)
+	persistence.Delete("tenant_id", "plugin_checksum", counter)
+}
+
+func TestPersistenceExpiration(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+	db.Init(&app.Config{
+		DBType:     "postgresql",
+		DBUsername: "postgres",
+		DBPassword: "difyai123456",
+		DBHost:     "localhost",
+		DBPort:     5432,
+		DBDatabase: "dify_plugin_daemon",
+		DBSslMode:  "disable",
+	})
+	defer db.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: "./storage",
+		},
+	})
+	assert.Nil(t, err)
+
+	InitPersistence(oss, &app.Config{
+		PersistenceStoragePath:    "./persistence_storage",
+		PersistenceStorageMaxSize: 1024 * 1024 * 1024,
+	})
+
+	key := strings.RandomString(10)
+	assert.Nil(t, persistence.SaveWithTTL("tenant_id", "plugin_checksum", -1, key, []byte("data"), time.Second))
+
+	data, err := persistence.Load("tenant_id", "plugin_checksum", key)
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(data))
+
+	time.Sleep(2 * time.Second)
+
+	// expired keys are invisible before they are swept
+	_, err = persistence.Load("tenant_id", "plugin_checksum", key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	exist, err := persistence.Exist("tenant_id", "plugin_checksum", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), exist)
+
+	_, err = persistence.Sweep()
+	assert.Nil(t, err)
+
+	if _, err := oss.Load("./persistence_storage/tenant_id/plugin_checksum/" + key); err == nil {
+		t.Fatal("expired key is not swept")
+	}
+
+	// a plain set clears the expiration
+	assert.Nil(t, persistence.SaveWithTTL("tenant_id", "plugin_checksum", -1, key, []byte("data"), time.Second))
+	assert.Nil(t, persistence.Save("tenant_id", "plugin_checksum", -1, key, []byte("data")))
+	time.Sleep(2 * time.Second)
+	_, err = persistence.Load("tenant_id", "plugin_checksum", key)
+	assert.Nil(t, err)
+
+	persistence.Delete("tenant_id", "plugin_checksum", key)
+}
+
+func TestPersistenceUsage(t *testing.T) {
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: t.TempDir(),
+		},
+	})
+	assert.Nil(t, err)
+
+	storage := NewWrapper(oss, "./persistence_storage")
+	p := &Persistence{storage: storage, maxStorageSize: 1024}
+
+	assert.Nil(t, storage.Save("tenant_id", "plugin_id", "a", []byte("data")))
+	assert.Nil(t, storage.Save("tenant_id", "plugin_id", "b/c", []byte("more data")))
+	assert.Nil(t, storage.Save("tenant_id", "other_plugin_id", "a", []byte("data")))
+
+	usage, err := p.Usage("tenant_id", "plugin_id")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), usage)
+
+	usage, err = p.Usage("tenant_id", "empty_plugin_id")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), usage)
+}
+
+func TestPersistenceOverwriteAccounting(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+	db.Init(&app.Config{
+		DBType:     "postgresql",
+		DBUsername: "postgres",
+		DBPassword: "difyai123456",
+		DBHost:     "localhost",
+		DBPort:     5432,
+		DBDatabase: "dify_plugin_daemon",
+		DBSslMode:  "disable",
+	})
+	defer db.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: "./storage",
+		},
+	})
+	assert.Nil(t, err)
+
+	InitPersistence(oss, &app.Config{
+		PersistenceStoragePath:    "./persistence_storage",
+		PersistenceStorageMaxSize: 1024 * 1024 * 1024,
+	})
+
+	tenantId := strings.RandomString(10)
+	size := func() int64 {
+		storage, err := db.GetOne[models.TenantStorage](
+			db.Equal("tenant_id", tenantId),
+			db.Equal("plugin_id", "plugin_checksum"),
+		)
+		assert.Nil(t, err)
+		return storage.Size
+	}
+
+	// overwriting a key is not allocating it again
+	assert.Nil(t, persistence.Save(tenantId, "plugin_checksum", 8, "key", []byte("data")))
+	assert.Nil(t, persistence.Save(tenantId, "plugin_checksum", 8, "key", []byte("data")))
+	assert.Equal(t, int64(4), size())
+
+	assert.Nil(t, persistence.Save(tenantId, "plugin_checksum", 8, "key", []byte("da")))
+	assert.Equal(t, int64(2), size())
+
+	// a rejected write leaves the previous value
+	assert.NotNil(t, persistence.Save(tenantId, "plugin_checksum", 8, "key", []byte("too much data")))
+	data, err := persistence.Load(tenantId, "plugin_checksum", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "da", string(data))
+
+	purged, err := persistence.Purge(tenantId, "plugin_checksum")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, purged)
+}
+
+func TestPersistenceExportAndImport(t *testing.T) {
+	err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0)
+	assert.Nil(t, err)
+	defer cache.Close()
+	db.Init(&app.Config{
+		DBType:     "postgresql",
+		DBUsername: "postgres",
+		DBPassword: "difyai123456",
+		DBHost:     "localhost",
+		DBPort:     5432,
+		DBDatabase: "dify_plugin_daemon",
+		DBSslMode:  "disable",
+	})
+	defer db.Close()
+
+	oss, err := factory.Load("local", cloudoss.OSSArgs{
+		Local: &cloudoss.Local{
+			Path: "./storage",
+		},
+	})
+	assert.Nil(t, err)
+
+	InitPersistence(oss, &app.Config{
+		PersistenceStoragePath:    "./persistence_storage",
+		PersistenceStorageMaxSize: 1024 * 1024 * 1024,
+	})
+
+	source := strings.RandomString(10)
+	target := strings.RandomString(10)
+	defer persistence.Purge(source, "plugin_checksum")
+	defer persistence.Purge(target, "plugin_checksum")
+
+	assert.Nil(t, persistence.Save(source, "plugin_checksum", -1, "a", []byte("data")))
+	assert.Nil(t, persistence.SaveWithTTL(source, "plugin_checksum", -1, "b", []byte("expiring"), time.Hour))
+	assert.Nil(t, persistence.Save(target, "plugin_checksum", -1, "a", []byte("kept")))
+
+	manifest, err := persistence.Manifest(source, "")
+	assert.Nil(t, err)
+	assert.Len(t, manifest.Plugins, 1)
+	assert.Equal(t, int64(12), manifest.Plugins[0].Size)
+
+	archive := &bytes.Buffer{}
+	assert.Nil(t, persistence.Export(archive, manifest))
+
+	// existing keys are kept unless overwrite
+	result, err := persistence.Import(bytes.NewReader(archive.Bytes()), target, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 1, result.Skipped)
+
+	data, err := persistence.Load(target, "plugin_checksum", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "kept", string(data))
+
+	expiresAt, err := persistence.expiresAt(target, "plugin_checksum", "b")
+	assert.Nil(t, err)
+	assert.NotNil(t, expiresAt)
+
+	result, err = persistence.Import(bytes.NewReader(archive.Bytes()), target, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Imported)
+
+	storage, err := db.GetOne[models.TenantStorage](
+		db.Equal("tenant_id", target),
+		db.Equal("plugin_id", "plugin_checksum"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), storage.Size)
+
+	// archives are not trusted
+	_, err = persistence.Import(bytes.NewReader([]byte("not an archive")), target, false)
+	assert.ErrorIs(t, err, ErrInvalidArchive)
+}
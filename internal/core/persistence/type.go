@@ -6,4 +6,13 @@ type PersistenceStorage interface {
 	Delete(tenant_id string, plugin_checksum string, key string) error
 	StateSize(tenant_id string, plugin_checksum string, key string) (int64, error)
 	Exists(tenant_id string, plugin_checksum string, key string) (bool, error)
+	// List returns all keys of the plugin in ascending order
+	List(tenant_id string, plugin_checksum string) ([]string, error)
+}
+
+// SealedStorage is implemented by storages encrypting values at rest,
+// sealed values are safe to be kept anywhere outside the storage, such as the cache
+type SealedStorage interface {
+	LoadSealed(tenant_id string, plugin_checksum string, key string) ([]byte, error)
+	Open(tenant_id string, plugin_checksum string, key string, sealed []byte) ([]byte, error)
 }
@@ -0,0 +1,126 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+)
+
+// MaxStorageSize is the storage size every plugin is limited to, regardless of what it declares
+func (c *Persistence) MaxStorageSize() int64 {
+	return c.maxStorageSize
+}
+
+// Usage returns the bytes stored by the plugin, computed from the storage instead of the recorded size
+func (c *Persistence) Usage(tenantId string, pluginId string) (int64, error) {
+	keys, err := c.storage.List(tenantId, pluginId)
+	if err != nil {
+		return 0, err
+	}
+
+	usage := int64(0)
+	for _, key := range keys {
+		size, err := c.storage.StateSize(tenantId, pluginId, key)
+		if err != nil {
+			return 0, err
+		}
+		usage += size
+	}
+
+	return usage, nil
+}
+
+// Reconcile corrects recorded sizes which drifted from the storage, returns the number of corrected ones
+//
+// NOTE: writes during the reconciliation of a plugin may drift again, the next reconciliation corrects them
+func (c *Persistence) Reconcile() (int, error) {
+	storages, err := db.GetAll[models.TenantStorage]()
+	if err != nil {
+		return 0, err
+	}
+
+	corrected := 0
+	for _, storage := range storages {
+		usage, err := c.Usage(storage.TenantID, storage.PluginID)
+		if err != nil {
+			log.Error(
+				"failed to compute storage usage of tenant %s plugin %s: %s",
+				storage.TenantID, storage.PluginID, err.Error(),
+			)
+			continue
+		}
+
+		if usage == storage.Size {
+			continue
+		}
+
+		log.Warn(
+			"storage size of tenant %s plugin %s drifted from %d to %d bytes",
+			storage.TenantID, storage.PluginID, storage.Size, usage,
+		)
+		storage.Size = usage
+		if err := db.Update(&storage); err != nil {
+			return corrected, err
+		}
+		corrected++
+	}
+
+	return corrected, nil
+}
+
+// Purge deletes all keys of the plugin and resets its size, returns the number of deleted keys
+func (c *Persistence) Purge(tenantId string, pluginId string) (int, error) {
+	keys, err := c.storage.List(tenantId, pluginId)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, key := range keys {
+		if _, err := c.Delete(tenantId, pluginId, key); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	// nothing is left, whatever is recorded is a drift
+	err = db.DeleteByCondition(models.TenantStorage{
+		TenantID: tenantId,
+		PluginID: pluginId,
+	})
+	return purged, err
+}
+
+// LaunchReconciler reconciles recorded sizes every interval, only the master node reconciles
+func LaunchReconciler(config *app.Config, isMaster func() bool) {
+	if persistence == nil || config.PersistenceStorageReconcileInterval <= 0 {
+		return
+	}
+	interval := time.Duration(config.PersistenceStorageReconcileInterval) * time.Second
+
+	routine.Submit(map[string]string{
+		"module":   "persistence",
+		"function": "LaunchReconciler",
+	}, func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !isMaster() {
+				continue
+			}
+
+			corrected, err := persistence.Reconcile()
+			if err != nil {
+				log.Error("failed to reconcile persistence storage sizes: %s", err.Error())
+			}
+			if corrected > 0 {
+				log.Info("corrected %d drifted persistence storage sizes", corrected)
+			}
+		}
+	})
+}
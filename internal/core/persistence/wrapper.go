@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"path"
+	"sort"
 
 	"github.com/langgenius/dify-cloud-kit/oss"
 )
@@ -52,3 +53,21 @@ func (s *wrapper) StateSize(tenant_id string, plugin_checksum string, key string
 
 	return state.Size, nil
 }
+
+func (s *wrapper) List(tenant_id string, plugin_checksum string) ([]string, error) {
+	paths, err := s.oss.List(path.Join(s.persistenceStoragePath, tenant_id, plugin_checksum))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p.IsDir {
+			continue
+		}
+		keys = append(keys, p.Path)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+)
+
+/*
+ Audit log of backwards invocations.
+
+ Records are queued and written to the database in batches by a background writer, invocations never
+ wait for the database, records are dropped with a warning if the queue is full.
+
+ Prompts, inputs and other payloads are never stored, only their sha256 if payload hashing is enabled.
+*/
+
+const (
+	STATUS_SUCCEEDED    = "succeeded"
+	STATUS_FAILED       = "failed"
+	STATUS_DENIED       = "denied"
+	STATUS_RATE_LIMITED = "rate_limited"
+	STATUS_CANCELLED    = "cancelled"
+
+	PAYLOAD_HASH = "hash"
+	PAYLOAD_NONE = "none"
+)
+
+const (
+	QUEUE_SIZE     = 4096
+	BATCH_SIZE     = 256
+	FLUSH_INTERVAL = time.Second
+)
+
+var (
+	enabled     bool
+	hashPayload bool
+	queue       chan models.BackwardsInvocationAudit
+
+	// insert writes a batch of records, replaced in tests
+	insert = func(records []models.BackwardsInvocationAudit) error {
+		return db.Create(&records)
+	}
+)
+
+func InitAuditor(config *app.Config) {
+	if !config.PluginBackwardsInvocationAuditEnabled {
+		return
+	}
+
+	enabled = true
+	hashPayload = config.PluginBackwardsInvocationAuditPayload == PAYLOAD_HASH
+	queue = make(chan models.BackwardsInvocationAudit, QUEUE_SIZE)
+
+	go write(queue)
+
+	log.Info("backwards invocation audit log enabled")
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+// Record queues the record, it never blocks
+func Record(record models.BackwardsInvocationAudit) {
+	if !enabled {
+		return
+	}
+
+	select {
+	case queue <- record:
+	default:
+		log.Warn(
+			"audit queue of backwards invocations is full, dropping record of tenant %s plugin %s",
+			record.TenantID, record.PluginID,
+		)
+	}
+}
+
+func write(queue <-chan models.BackwardsInvocationAudit) {
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	batch := make([]models.BackwardsInvocationAudit, 0, BATCH_SIZE)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := insert(batch); err != nil {
+			log.Error("failed to write %d audit records of backwards invocations: %s", len(batch), err.Error())
+		}
+		batch = make([]models.BackwardsInvocationAudit, 0, BATCH_SIZE)
+	}
+
+	for {
+		select {
+		case record, ok := <-queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= BATCH_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// HashPayload returns the sha256 of the request, empty if payloads are excluded
+func HashPayload(request map[string]any) string {
+	if !hashPayload || request == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(parser.MarshalJsonBytes(request))
+	return hex.EncodeToString(sum[:])
+}
+
+// Target extracts what the invocation targets from the request, nothing else of the request is kept
+func Target(invoke_type dify_invocation.InvokeType, request map[string]any) (
+	appId string, provider string, model string, tool string,
+) {
+	str := func(m map[string]any, key string) string {
+		value, _ := m[key].(string)
+		return value
+	}
+
+	switch invoke_type {
+	case dify_invocation.INVOKE_TYPE_APP, dify_invocation.INVOKE_TYPE_FETCH_APP:
+		appId = str(request, "app_id")
+	case dify_invocation.INVOKE_TYPE_TOOL:
+		provider = str(request, "provider")
+		tool = str(request, "tool")
+	case dify_invocation.INVOKE_TYPE_NODE_PARAMETER_EXTRACTOR, dify_invocation.INVOKE_TYPE_NODE_QUESTION_CLASSIFIER:
+		if config, ok := request["model"].(map[string]any); ok {
+			provider = str(config, "provider")
+			model = str(config, "name")
+		}
+	default:
+		provider = str(request, "provider")
+		model = str(request, "model")
+	}
+
+	return
+}
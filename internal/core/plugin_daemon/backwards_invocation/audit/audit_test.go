@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+)
+
+func TestTarget(t *testing.T) {
+	appId, _, _, _ := Target(dify_invocation.INVOKE_TYPE_APP, map[string]any{
+		"app_id": "app",
+		"query":  "secret",
+	})
+	if appId != "app" {
+		t.Fatalf("unexpected app id %s", appId)
+	}
+
+	_, provider, _, tool := Target(dify_invocation.INVOKE_TYPE_TOOL, map[string]any{
+		"provider": "google",
+		"tool":     "search",
+	})
+	if provider != "google" || tool != "search" {
+		t.Fatalf("unexpected tool %s/%s", provider, tool)
+	}
+
+	_, provider, model, _ := Target(dify_invocation.INVOKE_TYPE_LLM, map[string]any{
+		"provider": "openai",
+		"model":    "gpt-4o",
+	})
+	if provider != "openai" || model != "gpt-4o" {
+		t.Fatalf("unexpected model %s/%s", provider, model)
+	}
+
+	_, provider, model, _ = Target(dify_invocation.INVOKE_TYPE_NODE_QUESTION_CLASSIFIER, map[string]any{
+		"model": map[string]any{"provider": "openai", "name": "gpt-4o"},
+	})
+	if provider != "openai" || model != "gpt-4o" {
+		t.Fatalf("unexpected model of node %s/%s", provider, model)
+	}
+}
+
+func TestHashPayload(t *testing.T) {
+	hashPayload = true
+	defer func() { hashPayload = false }()
+
+	a := HashPayload(map[string]any{"query": "a"})
+	if len(a) != 64 || a == HashPayload(map[string]any{"query": "b"}) {
+		t.Fatalf("unexpected hash %s", a)
+	}
+	if a != HashPayload(map[string]any{"query": "a"}) {
+		t.Fatal("hash should be stable")
+	}
+
+	hashPayload = false
+	if HashPayload(map[string]any{"query": "a"}) != "" {
+		t.Fatal("payload should be excluded")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	written := []models.BackwardsInvocationAudit{}
+	lock := sync.Mutex{}
+	insert = func(records []models.BackwardsInvocationAudit) error {
+		lock.Lock()
+		defer lock.Unlock()
+		written = append(written, records...)
+		return nil
+	}
+
+	enabled = true
+	queue = make(chan models.BackwardsInvocationAudit, 2)
+	done := make(chan bool)
+	go func() {
+		write(queue)
+		close(done)
+	}()
+	defer func() { enabled = false }()
+
+	Record(models.BackwardsInvocationAudit{TenantID: "a", Status: STATUS_SUCCEEDED})
+	Record(models.BackwardsInvocationAudit{TenantID: "b", Status: STATUS_DENIED})
+
+	// flushed by the ticker
+	time.Sleep(FLUSH_INTERVAL + 200*time.Millisecond)
+	lock.Lock()
+	if len(written) != 2 || written[0].TenantID != "a" || written[1].TenantID != "b" {
+		t.Fatalf("unexpected records %v", written)
+	}
+	lock.Unlock()
+
+	// the rest is flushed once the queue is closed
+	Record(models.BackwardsInvocationAudit{TenantID: "c", Status: STATUS_FAILED})
+	close(queue)
+	<-done
+	if len(written) != 3 {
+		t.Fatalf("unexpected records %v", written)
+	}
+}
@@ -1,5 +1,11 @@
 package backwards_invocation
 
+import (
+	"math"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/rate_limiter"
+)
+
 type RequestEvent string
 
 const (
@@ -33,6 +39,27 @@ func NewErrorEvent(request_id string, message string) *BackwardsInvocationRespon
 	}
 }
 
+// NewRateLimitedEvent is an error event, `error_type` of the data tells SDKs it's a rate limit breach
+// and when to retry, SDKs which do not recognize it treat it as a normal error
+func NewRateLimitedEvent(request_id string, err *rate_limiter.ErrRateLimited) *BackwardsInvocationResponseEvent {
+	errorType := "rate_limit_exceeded"
+	if err.QuotaExceeded {
+		errorType = "quota_exceeded"
+	}
+
+	return &BackwardsInvocationResponseEvent{
+		BackwardsRequestId: request_id,
+		Event:              REQUEST_EVENT_ERROR,
+		Message:            err.Error(),
+		Data: map[string]any{
+			"error_type":  errorType,
+			"scope":       err.Scope,
+			"invoke_type": err.InvokeType,
+			"retry_after": int(math.Ceil(err.RetryAfter.Seconds())),
+		},
+	}
+}
+
 func NewEndEvent(request_id string) *BackwardsInvocationResponseEvent {
 	return &BackwardsInvocationResponseEvent{
 		BackwardsRequestId: request_id,
@@ -0,0 +1,331 @@
+package rate_limiter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+ Rate limits and quotas of backwards invocations.
+
+ Every invocation takes a token from two buckets, the tenant bucket shared by all the plugins of the
+ tenant and the plugin bucket of the tenant, both are per invoke type. Buckets refill continuously at
+ `requests_per_minute` up to `burst`, and the daily quota caps the invocations of a UTC day.
+
+ Buckets live in redis so that limits hold across the cluster, both buckets are checked and consumed
+ by one script, an invocation rejected by either of them consumes nothing.
+
+ Limits come from the config, overrides set by admins take precedence, an override of a plugin applies
+ to the plugin bucket and an override without plugin applies to the tenant bucket, an override without
+ invoke type applies to all the invoke types.
+*/
+
+const (
+	SCOPE_TENANT = "tenant"
+	SCOPE_PLUGIN = "plugin"
+
+	// applies to all the plugins or invoke types
+	WILDCARD = "*"
+
+	OVERRIDES_KEY = "backwards_invocation:rate_limit:overrides:%s"
+	BUCKET_KEY    = "backwards_invocation:rate_limit:bucket:%s:%s:%s"
+	QUOTA_KEY     = "backwards_invocation:rate_limit:quota:%s:%s:%s:%s"
+)
+
+type Limit struct {
+	// tokens refilled per minute, 0 means unlimited
+	RequestsPerMinute int `json:"requests_per_minute" validate:"min=0"`
+	// capacity of the bucket, defaults to requests_per_minute
+	Burst int `json:"burst" validate:"min=0"`
+	// max invocations per UTC day, 0 means unlimited
+	DailyQuota int `json:"daily_quota" validate:"min=0"`
+}
+
+func (l Limit) unlimited() bool {
+	return l.RequestsPerMinute <= 0 && l.DailyQuota <= 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.RequestsPerMinute
+}
+
+type Override struct {
+	TenantID string `json:"tenant_id"`
+	// empty means the tenant bucket
+	PluginID string `json:"plugin_id"`
+	// empty means all the invoke types
+	InvokeType string `json:"invoke_type"`
+	Limit
+}
+
+// ErrRateLimited is returned once a bucket or a quota runs out
+type ErrRateLimited struct {
+	Scope      string                     `json:"scope"`
+	InvokeType dify_invocation.InvokeType `json:"invoke_type"`
+	// true if the daily quota runs out, otherwise the bucket
+	QuotaExceeded bool          `json:"quota_exceeded"`
+	RetryAfter    time.Duration `json:"-"`
+}
+
+func (e *ErrRateLimited) Error() string {
+	if e.QuotaExceeded {
+		return fmt.Sprintf(
+			"daily quota of %s invocations exceeded for %s, retry after %ds",
+			e.InvokeType, e.Scope, int(math.Ceil(e.RetryAfter.Seconds())),
+		)
+	}
+	return fmt.Sprintf(
+		"rate limit of %s invocations exceeded for %s, retry after %ds",
+		e.InvokeType, e.Scope, int(math.Ceil(e.RetryAfter.Seconds())),
+	)
+}
+
+var (
+	enabled     bool
+	tenantLimit Limit
+	pluginLimit Limit
+
+	now = time.Now
+)
+
+func InitRateLimiter(config *app.Config) {
+	enabled = config.PluginBackwardsInvocationRateLimitEnabled
+	tenantLimit = Limit{
+		RequestsPerMinute: config.PluginBackwardsInvocationTenantRateLimit,
+		Burst:             config.PluginBackwardsInvocationTenantBurst,
+		DailyQuota:        config.PluginBackwardsInvocationTenantDailyQuota,
+	}
+	pluginLimit = Limit{
+		RequestsPerMinute: config.PluginBackwardsInvocationPluginRateLimit,
+		Burst:             config.PluginBackwardsInvocationPluginBurst,
+		DailyQuota:        config.PluginBackwardsInvocationPluginDailyQuota,
+	}
+}
+
+// bucketScript checks all the buckets and quotas first and consumes them only if all of them allow it,
+// KEYS are pairs of bucket and quota, ARGV[1] is now in milliseconds followed by
+// tokens per millisecond, burst, daily quota and quota ttl in seconds of each pair.
+// returns {0} if allowed, otherwise {index of the pair, retry after in milliseconds, 1 if quota}
+var bucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+
+for i = 1, #KEYS / 2 do
+	local rate = tonumber(ARGV[4 * i - 2])
+	local burst = tonumber(ARGV[4 * i - 1])
+	local quota = tonumber(ARGV[4 * i])
+	local ttl = tonumber(ARGV[4 * i + 1])
+
+	if rate > 0 then
+		local state = redis.call('HMGET', KEYS[2 * i - 1], 'tokens', 'ts')
+		local available = burst
+		if state[1] then
+			available = math.min(burst, tonumber(state[1]) + (now - tonumber(state[2])) * rate)
+		end
+		if available < 1 then
+			return {i, math.ceil((1 - available) / rate), 0}
+		end
+		tokens[i] = available
+	end
+
+	if quota > 0 then
+		local used = tonumber(redis.call('GET', KEYS[2 * i]) or '0')
+		if used >= quota then
+			return {i, ttl * 1000, 1}
+		end
+	end
+end
+
+for i = 1, #KEYS / 2 do
+	local rate = tonumber(ARGV[4 * i - 2])
+	local burst = tonumber(ARGV[4 * i - 1])
+	local quota = tonumber(ARGV[4 * i])
+	local ttl = tonumber(ARGV[4 * i + 1])
+
+	if rate > 0 then
+		redis.call('HSET', KEYS[2 * i - 1], 'tokens', tokens[i] - 1, 'ts', now)
+		redis.call('PEXPIRE', KEYS[2 * i - 1], math.ceil(burst / rate) + 1000)
+	end
+
+	if quota > 0 then
+		if redis.call('INCR', KEYS[2 * i]) == 1 then
+			redis.call('EXPIRE', KEYS[2 * i], ttl)
+		end
+	end
+end
+
+return {0}
+`)
+
+type bucket struct {
+	scope string
+	// plugin id, or WILDCARD for the tenant bucket
+	pluginId string
+	limit    Limit
+}
+
+// Allow takes a token for the invocation, returns the breach if any bucket or quota runs out,
+// redis failures let the invocation through, limits are not worth failing invocations for
+func Allow(tenant_id string, plugin_id string, invoke_type dify_invocation.InvokeType) *ErrRateLimited {
+	if !enabled {
+		return nil
+	}
+
+	overrides, err := overridesOf(tenant_id)
+	if err != nil {
+		if err != cache.ErrDBNotInit {
+			log.Error("failed to load rate limit overrides of tenant %s: %s", tenant_id, err.Error())
+		}
+		return nil
+	}
+
+	buckets := []bucket{}
+	for _, b := range []bucket{
+		{scope: SCOPE_TENANT, pluginId: WILDCARD, limit: resolveLimit(overrides, WILDCARD, invoke_type, tenantLimit)},
+		{scope: SCOPE_PLUGIN, pluginId: plugin_id, limit: resolveLimit(overrides, plugin_id, invoke_type, pluginLimit)},
+	} {
+		if !b.limit.unlimited() {
+			buckets = append(buckets, b)
+		}
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	at := now().UTC()
+	day := at.Format("20060102")
+	quotaTTL := int64(math.Ceil(endOfDay(at).Sub(at).Seconds()))
+	if quotaTTL < 1 {
+		quotaTTL = 1
+	}
+
+	keys := []string{}
+	args := []any{at.UnixMilli()}
+	for _, b := range buckets {
+		keys = append(
+			keys,
+			fmt.Sprintf(BUCKET_KEY, tenant_id, b.pluginId, invoke_type),
+			fmt.Sprintf(QUOTA_KEY, tenant_id, b.pluginId, invoke_type, day),
+		)
+		args = append(
+			args,
+			float64(b.limit.RequestsPerMinute)/float64(time.Minute.Milliseconds()),
+			b.limit.burst(),
+			b.limit.DailyQuota,
+			quotaTTL,
+		)
+	}
+
+	result, err := cache.EvalScript(bucketScript, keys, args)
+	if err != nil {
+		if err != cache.ErrDBNotInit {
+			log.Error("failed to check rate limit of tenant %s: %s", tenant_id, err.Error())
+		}
+		return nil
+	}
+
+	values, ok := result.([]any)
+	if !ok || len(values) == 0 {
+		return nil
+	}
+	index, _ := values[0].(int64)
+	if index == 0 || len(values) < 3 {
+		return nil
+	}
+
+	retryAfter, _ := values[1].(int64)
+	quota, _ := values[2].(int64)
+	return &ErrRateLimited{
+		Scope:         buckets[index-1].scope,
+		InvokeType:    invoke_type,
+		QuotaExceeded: quota == 1,
+		RetryAfter:    time.Duration(retryAfter) * time.Millisecond,
+	}
+}
+
+func endOfDay(at time.Time) time.Time {
+	year, month, day := at.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
+
+// resolveLimit picks the most specific override of the bucket, falls back to the default
+func resolveLimit(
+	overrides map[string]Override,
+	plugin_id string,
+	invoke_type dify_invocation.InvokeType,
+	fallback Limit,
+) Limit {
+	for _, field := range []string{
+		overrideField(plugin_id, string(invoke_type)),
+		overrideField(plugin_id, WILDCARD),
+	} {
+		if override, ok := overrides[field]; ok {
+			return override.Limit
+		}
+	}
+	return fallback
+}
+
+func overrideField(plugin_id string, invoke_type string) string {
+	if plugin_id == "" {
+		plugin_id = WILDCARD
+	}
+	if invoke_type == "" {
+		invoke_type = WILDCARD
+	}
+	return plugin_id + "|" + invoke_type
+}
+
+func overridesOf(tenant_id string) (map[string]Override, error) {
+	overrides, err := cache.GetMap[Override](fmt.Sprintf(OVERRIDES_KEY, tenant_id))
+	if err == cache.ErrNotFound {
+		return map[string]Override{}, nil
+	}
+	return overrides, err
+}
+
+// SetOverride sets the limit of a tenant, a plugin of the tenant, or an invoke type of them
+func SetOverride(override Override) error {
+	if override.TenantID == "" {
+		return errors.New("tenant id is required")
+	}
+
+	return cache.SetMapOneField(
+		fmt.Sprintf(OVERRIDES_KEY, override.TenantID),
+		overrideField(override.PluginID, override.InvokeType),
+		override,
+	)
+}
+
+// DeleteOverride restores the default limit
+func DeleteOverride(tenant_id string, plugin_id string, invoke_type string) error {
+	return cache.DelMapField(
+		fmt.Sprintf(OVERRIDES_KEY, tenant_id),
+		overrideField(plugin_id, invoke_type),
+	)
+}
+
+// ListOverrides returns the overrides of the tenant
+func ListOverrides(tenant_id string) ([]Override, error) {
+	overrides, err := overridesOf(tenant_id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Override, 0, len(overrides))
+	for _, override := range overrides {
+		result = append(result, override)
+	}
+	return result, nil
+}
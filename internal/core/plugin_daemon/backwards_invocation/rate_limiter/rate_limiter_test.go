@@ -0,0 +1,118 @@
+package rate_limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+)
+
+func TestResolveLimit(t *testing.T) {
+	fallback := Limit{RequestsPerMinute: 60}
+	overrides := map[string]Override{
+		overrideField("", ""):                 {Limit: Limit{RequestsPerMinute: 1}},
+		overrideField("langgenius/agent", ""): {Limit: Limit{RequestsPerMinute: 2}},
+		overrideField("langgenius/agent", string(dify_invocation.INVOKE_TYPE_LLM)): {Limit: Limit{RequestsPerMinute: 3}},
+	}
+
+	cases := []struct {
+		pluginId   string
+		invokeType dify_invocation.InvokeType
+		expected   int
+	}{
+		{WILDCARD, dify_invocation.INVOKE_TYPE_LLM, 1},
+		{"langgenius/agent", dify_invocation.INVOKE_TYPE_LLM, 3},
+		{"langgenius/agent", dify_invocation.INVOKE_TYPE_APP, 2},
+		{"langgenius/other", dify_invocation.INVOKE_TYPE_APP, 60},
+	}
+	for _, c := range cases {
+		if limit := resolveLimit(overrides, c.pluginId, c.invokeType, fallback); limit.RequestsPerMinute != c.expected {
+			t.Errorf("expected %d for %s %s, got %d", c.expected, c.pluginId, c.invokeType, limit.RequestsPerMinute)
+		}
+	}
+}
+
+func TestAllowWithoutRedis(t *testing.T) {
+	enabled = true
+	tenantLimit = Limit{RequestsPerMinute: 1}
+	defer func() {
+		enabled = false
+		tenantLimit = Limit{}
+	}()
+
+	// limits are not worth failing invocations for
+	for i := 0; i < 3; i++ {
+		if err := Allow("tenant", "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAllowTokenBucket(t *testing.T) {
+	if err := cache.InitRedisClient("0.0.0.0:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	enabled = true
+	pluginLimit = Limit{RequestsPerMinute: 60, Burst: 2}
+	defer func() {
+		enabled = false
+		pluginLimit = Limit{}
+	}()
+
+	tenantId := uuid.NewString()
+	at := time.Now()
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+
+	// the burst is consumed and then the bucket refills at 1 token per second
+	for i := 0; i < 2; i++ {
+		if err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM)
+	if err == nil || err.Scope != SCOPE_PLUGIN || err.QuotaExceeded || err.RetryAfter != time.Second {
+		t.Fatalf("expected the plugin bucket to run out, got %v", err)
+	}
+
+	// buckets are per plugin and invoke type
+	if err := Allow(tenantId, "langgenius/other", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+		t.Fatal(err)
+	}
+	if err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_TOOL); err != nil {
+		t.Fatal(err)
+	}
+
+	at = at.Add(time.Second)
+	if err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+		t.Fatal(err)
+	}
+
+	// the daily quota of the tenant rejects everything without consuming the plugin bucket
+	if err := SetOverride(Override{TenantID: tenantId, Limit: Limit{DailyQuota: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	at = at.Add(time.Minute)
+	if err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+		t.Fatal(err)
+	}
+	err = Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM)
+	if err == nil || err.Scope != SCOPE_TENANT || !err.QuotaExceeded {
+		t.Fatalf("expected the daily quota of the tenant to run out, got %v", err)
+	}
+
+	overrides, err2 := ListOverrides(tenantId)
+	if err2 != nil || len(overrides) != 1 {
+		t.Fatalf("expected 1 override, got %v %v", overrides, err2)
+	}
+	if err := DeleteOverride(tenantId, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Allow(tenantId, "langgenius/agent", dify_invocation.INVOKE_TYPE_LLM); err != nil {
+		t.Fatal(err)
+	}
+}
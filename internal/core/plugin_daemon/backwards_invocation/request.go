@@ -1,9 +1,12 @@
 package backwards_invocation
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/rate_limiter"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
 )
 
@@ -33,6 +36,10 @@ type BackwardsInvocation struct {
 
 	// backwardsInvocation is the backwards invocation that is used to invoke dify
 	backwardsInvocation dify_invocation.BackwardsInvocation
+
+	// err is the first error written to the plugin, it's the outcome of the invocation
+	err     error
+	errLock sync.Mutex
 }
 
 func NewBackwardsInvocation(
@@ -42,27 +49,58 @@ func NewBackwardsInvocation(
 	writer BackwardsInvocationWriter,
 	detailedRequest map[string]any,
 ) *BackwardsInvocation {
+	// requests to dify are aborted once the session is cancelled
+	backwardsInvocation := session.BackwardsInvocation()
+	if backwardsInvocation != nil {
+		backwardsInvocation = backwardsInvocation.WithContext(session.Context())
+	}
+
 	return &BackwardsInvocation{
 		typ:                 typ,
 		id:                  id,
 		detailedRequest:     detailedRequest,
 		session:             session,
 		writer:              writer,
-		backwardsInvocation: session.BackwardsInvocation(),
+		backwardsInvocation: backwardsInvocation,
 	}
 }
 
+// Context is done once the session is cancelled, e.g. the caller disconnected
+func (bi *BackwardsInvocation) Context() context.Context {
+	return bi.session.Context()
+}
+
 func (bi *BackwardsInvocation) GetID() string {
 	return bi.id
 }
 
 func (bi *BackwardsInvocation) WriteError(err error) {
+	bi.errLock.Lock()
+	if bi.err == nil {
+		bi.err = err
+	}
+	bi.errLock.Unlock()
+
 	bi.writer.Write(
 		session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE,
 		NewErrorEvent(bi.id, err.Error()),
 	)
 }
 
+func (bi *BackwardsInvocation) WriteRateLimited(err *rate_limiter.ErrRateLimited) {
+	bi.writer.Write(
+		session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE,
+		NewRateLimitedEvent(bi.id, err),
+	)
+}
+
+// Err returns the first error written to the plugin
+func (bi *BackwardsInvocation) Err() error {
+	bi.errLock.Lock()
+	defer bi.errLock.Unlock()
+	return bi.err
+}
+
 func (bi *BackwardsInvocation) WriteResponse(message string, data any) {
 	bi.writer.Write(
 		session_manager.PLUGIN_IN_STREAM_EVENT_RESPONSE,
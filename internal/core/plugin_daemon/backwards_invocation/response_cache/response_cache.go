@@ -0,0 +1,177 @@
+package response_cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+ Response cache of deterministic backwards invocations, i.e. text embedding and rerank.
+
+ Entries are scoped to the tenant, backwards invocations always use the credentials of the tenant,
+ so the tenant is the credentials scope. The key is a hash of the tenant, provider, model and inputs.
+
+ The cache is bounded by a ttl, a max size of each entry and a budget of bytes each tenant is able
+ to write within a ttl window, entries exceeding them are not cached.
+*/
+
+const (
+	ENTRY_KEY  = "backwards_invocation:cache:%s:%s:%s"
+	BUDGET_KEY = "backwards_invocation:cache:budget:%s"
+	STATS_KEY  = "backwards_invocation:cache:stats:%s:%s:%s"
+
+	STAT_HIT  = "hit"
+	STAT_MISS = "miss"
+)
+
+// CACHEABLE_INVOKE_TYPES are the invoke types whose responses depend on the inputs only
+var CACHEABLE_INVOKE_TYPES = []dify_invocation.InvokeType{
+	dify_invocation.INVOKE_TYPE_TEXT_EMBEDDING,
+	dify_invocation.INVOKE_TYPE_RERANK,
+}
+
+var (
+	enabled        bool
+	ttl            time.Duration
+	maxEntrySize   int
+	maxTenantBytes int64
+)
+
+func InitResponseCache(config *app.Config) {
+	enabled = config.PluginBackwardsInvocationCacheEnabled
+	ttl = time.Duration(config.PluginBackwardsInvocationCacheTTL) * time.Second
+	maxEntrySize = config.PluginBackwardsInvocationCacheMaxEntrySize
+	maxTenantBytes = config.PluginBackwardsInvocationCacheMaxTenantSize
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+// Key returns the hash of the inputs, the tenant is a part of it besides the scope of the entry
+func Key(tenant_id string, provider string, model string, inputs ...any) string {
+	hash := sha256.New()
+	hash.Write([]byte(tenant_id))
+	hash.Write([]byte{0})
+	hash.Write([]byte(provider))
+	hash.Write([]byte{0})
+	hash.Write([]byte(model))
+	for _, input := range inputs {
+		hash.Write([]byte{0})
+		hash.Write(parser.MarshalJsonBytes(input))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Get returns the cached response and records the hit or miss
+func Get[T any](tenant_id string, invoke_type dify_invocation.InvokeType, key string) (*T, bool) {
+	if !enabled {
+		return nil, false
+	}
+
+	value, err := cache.GetString(fmt.Sprintf(ENTRY_KEY, tenant_id, invoke_type, key))
+	if err != nil {
+		if err != cache.ErrNotFound && err != cache.ErrDBNotInit {
+			log.Error("failed to get cached response of tenant %s: %s", tenant_id, err.Error())
+		}
+		record(tenant_id, invoke_type, STAT_MISS)
+		return nil, false
+	}
+
+	response, err := parser.UnmarshalJson[T](value)
+	if err != nil {
+		record(tenant_id, invoke_type, STAT_MISS)
+		return nil, false
+	}
+
+	record(tenant_id, invoke_type, STAT_HIT)
+	return &response, true
+}
+
+// budgetScript reserves bytes of the budget of the tenant, the budget resets every ttl window,
+// returns 1 if reserved
+var budgetScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+if used + tonumber(ARGV[1]) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('TTL', KEYS[1]) < 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// Set caches the response if it fits in the size limits
+func Set(tenant_id string, invoke_type dify_invocation.InvokeType, key string, response any) {
+	if !enabled {
+		return
+	}
+
+	value := parser.MarshalJson(response)
+	if maxEntrySize > 0 && len(value) > maxEntrySize {
+		return
+	}
+
+	if maxTenantBytes > 0 {
+		reserved, err := cache.EvalScript(
+			budgetScript,
+			[]string{fmt.Sprintf(BUDGET_KEY, tenant_id)},
+			[]any{len(value), maxTenantBytes, int64(ttl.Seconds())},
+		)
+		if err != nil {
+			if err != cache.ErrDBNotInit {
+				log.Error("failed to reserve cache budget of tenant %s: %s", tenant_id, err.Error())
+			}
+			return
+		}
+		if reserved, _ := reserved.(int64); reserved != 1 {
+			return
+		}
+	}
+
+	if err := cache.Store(fmt.Sprintf(ENTRY_KEY, tenant_id, invoke_type, key), value, ttl); err != nil {
+		log.Error("failed to cache response of tenant %s: %s", tenant_id, err.Error())
+	}
+}
+
+func record(tenant_id string, invoke_type dify_invocation.InvokeType, stat string) {
+	if _, err := cache.Increase(fmt.Sprintf(STATS_KEY, tenant_id, invoke_type, stat)); err != nil &&
+		err != cache.ErrDBNotInit {
+		log.Error("failed to record cache stats of tenant %s: %s", tenant_id, err.Error())
+	}
+}
+
+type Stat struct {
+	Hit  int64 `json:"hit"`
+	Miss int64 `json:"miss"`
+}
+
+// Stats returns hits and misses of the tenant by invoke type
+func Stats(tenant_id string) (map[dify_invocation.InvokeType]Stat, error) {
+	stats := map[dify_invocation.InvokeType]Stat{}
+	for _, invokeType := range CACHEABLE_INVOKE_TYPES {
+		stat := Stat{}
+		for name, value := range map[string]*int64{STAT_HIT: &stat.Hit, STAT_MISS: &stat.Miss} {
+			count, err := cache.GetString(fmt.Sprintf(STATS_KEY, tenant_id, invokeType, name))
+			if err == cache.ErrNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			*value, _ = strconv.ParseInt(count, 10, 64)
+		}
+		stats[invokeType] = stat
+	}
+	return stats, nil
+}
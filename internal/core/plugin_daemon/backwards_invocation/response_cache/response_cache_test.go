@@ -0,0 +1,56 @@
+package response_cache
+
+import (
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/model_entities"
+)
+
+func TestKey(t *testing.T) {
+	key := Key("tenant", "openai", "text-embedding-3-small", "document", []string{"a", "b"})
+	if len(key) != 64 {
+		t.Fatalf("unexpected key %s", key)
+	}
+	if key != Key("tenant", "openai", "text-embedding-3-small", "document", []string{"a", "b"}) {
+		t.Fatal("key should be stable")
+	}
+
+	// every part of the key matters, including where the boundaries are
+	others := []string{
+		Key("other", "openai", "text-embedding-3-small", "document", []string{"a", "b"}),
+		Key("tenant", "cohere", "text-embedding-3-small", "document", []string{"a", "b"}),
+		Key("tenant", "openai", "text-embedding-3-large", "document", []string{"a", "b"}),
+		Key("tenant", "openai", "text-embedding-3-small", "query", []string{"a", "b"}),
+		Key("tenant", "openai", "text-embedding-3-small", "document", []string{"b", "a"}),
+		Key("tenant", "openai", "text-embedding-3-small", "document", []string{"ab"}),
+		Key("tenan", "topenai", "text-embedding-3-small", "document", []string{"a", "b"}),
+	}
+	for i, other := range others {
+		if other == key {
+			t.Errorf("key %d should differ", i)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	enabled = false
+
+	Set("tenant", dify_invocation.INVOKE_TYPE_RERANK, "key", &model_entities.RerankResult{})
+	if _, ok := Get[model_entities.RerankResult]("tenant", dify_invocation.INVOKE_TYPE_RERANK, "key"); ok {
+		t.Fatal("nothing should be cached while disabled")
+	}
+}
+
+func TestWithoutRedis(t *testing.T) {
+	enabled = true
+	maxEntrySize = 1024
+	maxTenantBytes = 1024 * 1024
+	defer func() { enabled = false }()
+
+	// the cache is not worth failing invocations for
+	Set("tenant", dify_invocation.INVOKE_TYPE_RERANK, "key", &model_entities.RerankResult{})
+	if _, ok := Get[model_entities.RerankResult]("tenant", dify_invocation.INVOKE_TYPE_RERANK, "key"); ok {
+		t.Fatal("expected a miss without redis")
+	}
+}
@@ -4,14 +4,22 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/persistence"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/audit"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/rate_limiter"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/response_cache"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/model_entities"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
 )
 
@@ -43,9 +51,14 @@ func InvokeDify(
 		return err
 	}
 
+	// the payload is hashed before dispatching, which fills it with tenant and user
+	record := newAuditRecord(requestHandle, invoke_from)
+
 	if invoke_from == access_types.PLUGIN_ACCESS_TYPE_MODEL {
-		requestHandle.WriteError(fmt.Errorf("you can not invoke dify from %s", invoke_from))
+		err := fmt.Errorf("you can not invoke dify from %s", invoke_from)
+		requestHandle.WriteError(err)
 		requestHandle.EndResponse()
+		finishAuditRecord(record, audit.STATUS_DENIED, err)
 		return nil
 	}
 
@@ -53,6 +66,15 @@ func InvokeDify(
 	if err := checkPermission(declaration, requestHandle); err != nil {
 		requestHandle.WriteError(err)
 		requestHandle.EndResponse()
+		finishAuditRecord(record, audit.STATUS_DENIED, err)
+		return nil
+	}
+
+	// check rate limits and quotas of the tenant and the plugin
+	if err := checkRateLimit(requestHandle); err != nil {
+		requestHandle.WriteRateLimited(err)
+		requestHandle.EndResponse()
+		finishAuditRecord(record, audit.STATUS_RATE_LIMITED, err)
 		return nil
 	}
 
@@ -61,8 +83,24 @@ func InvokeDify(
 		"module":   "plugin_daemon",
 		"function": "InvokeDify",
 	}, func() {
-		dispatchDifyInvocationTask(requestHandle)
 		defer requestHandle.EndResponse()
+
+		// the caller may be gone while the invocation was queued
+		if err := requestHandle.Context().Err(); err != nil {
+			requestHandle.WriteError(fmt.Errorf("session cancelled: %s", err.Error()))
+			finishAuditRecord(record, audit.STATUS_CANCELLED, err)
+			return
+		}
+
+		dispatchDifyInvocationTask(requestHandle)
+
+		if err := requestHandle.Context().Err(); err != nil {
+			finishAuditRecord(record, audit.STATUS_CANCELLED, err)
+		} else if err := requestHandle.Err(); err != nil {
+			finishAuditRecord(record, audit.STATUS_FAILED, err)
+		} else {
+			finishAuditRecord(record, audit.STATUS_SUCCEEDED, nil)
+		}
 	})
 
 	return nil
@@ -178,9 +216,137 @@ func checkPermission(runtime *plugin_entities.PluginDeclaration, requestHandle *
 		return fmt.Errorf(permission["error"].(string))
 	}
 
+	return checkPermissionScope(runtime, requestHandle)
+}
+
+// fetchAppBindings returns the apps bound to the plugin by the tenant admin
+var fetchAppBindings = func(tenant_id string, plugin_id string) ([]string, error) {
+	installation, err := db.GetOne[models.PluginInstallation](
+		db.Equal("tenant_id", tenant_id),
+		db.Equal("plugin_id", plugin_id),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return installation.AppBindings(), nil
+}
+
+// checkPermissionScope checks the target of the invocation against the scopes of the manifest,
+// e.g. the providers of models and tools, and the apps bound by the tenant admin
+func checkPermissionScope(runtime *plugin_entities.PluginDeclaration, requestHandle *BackwardsInvocation) error {
+	permission := runtime.Resource.Permission
+	request := requestHandle.RequestData()
+	str := func(m map[string]any, key string) string {
+		value, _ := m[key].(string)
+		return value
+	}
+
+	switch requestHandle.Type() {
+	case dify_invocation.INVOKE_TYPE_TOOL:
+		provider := str(request, "provider")
+		if !permission.AllowInvokeToolProvider(provider) {
+			return fmt.Errorf("permission denied, tool provider %s is not allowed in plugin manifest", provider)
+		}
+	case dify_invocation.INVOKE_TYPE_LLM,
+		dify_invocation.INVOKE_TYPE_LLM_STRUCTURED_OUTPUT,
+		dify_invocation.INVOKE_TYPE_TEXT_EMBEDDING,
+		dify_invocation.INVOKE_TYPE_RERANK,
+		dify_invocation.INVOKE_TYPE_TTS,
+		dify_invocation.INVOKE_TYPE_SPEECH2TEXT,
+		dify_invocation.INVOKE_TYPE_MODERATION:
+		provider := str(request, "provider")
+		if !permission.AllowInvokeModelProvider(provider) {
+			return fmt.Errorf("permission denied, model provider %s is not allowed in plugin manifest", provider)
+		}
+	case dify_invocation.INVOKE_TYPE_NODE_PARAMETER_EXTRACTOR,
+		dify_invocation.INVOKE_TYPE_NODE_QUESTION_CLASSIFIER:
+		// nodes invoke the model on behalf of the plugin
+		if !permission.AllowInvokeModel() {
+			return nil
+		}
+		model, _ := request["model"].(map[string]any)
+		provider := str(model, "provider")
+		if !permission.AllowInvokeModelProvider(provider) {
+			return fmt.Errorf("permission denied, model provider %s is not allowed in plugin manifest", provider)
+		}
+	case dify_invocation.INVOKE_TYPE_APP,
+		dify_invocation.INVOKE_TYPE_FETCH_APP:
+		if !permission.AppScoped() {
+			return nil
+		}
+
+		appId := str(request, "app_id")
+		if requestHandle.session == nil {
+			return fmt.Errorf("permission denied, app %s is not bound to the plugin", appId)
+		}
+
+		bindings, err := fetchAppBindings(
+			requestHandle.session.TenantID,
+			requestHandle.session.PluginUniqueIdentifier.PluginID(),
+		)
+		if err != nil {
+			return fmt.Errorf("permission denied, failed to fetch app bindings: %s", err.Error())
+		}
+		if !slices.Contains(bindings, appId) {
+			return fmt.Errorf("permission denied, app %s is not bound to the plugin", appId)
+		}
+	}
+
 	return nil
 }
 
+func checkRateLimit(requestHandle *BackwardsInvocation) *rate_limiter.ErrRateLimited {
+	if requestHandle.session == nil {
+		return nil
+	}
+
+	return rate_limiter.Allow(
+		requestHandle.session.TenantID,
+		requestHandle.session.PluginUniqueIdentifier.PluginID(),
+		requestHandle.Type(),
+	)
+}
+
+// newAuditRecord starts the audit record of the invocation, nil if audit log is disabled
+func newAuditRecord(
+	handle *BackwardsInvocation,
+	invoke_from access_types.PluginAccessType,
+) *models.BackwardsInvocationAudit {
+	if !audit.Enabled() || handle.session == nil {
+		return nil
+	}
+
+	appId, provider, model, tool := audit.Target(handle.Type(), handle.RequestData())
+	return &models.BackwardsInvocationAudit{
+		TenantID:               handle.session.TenantID,
+		UserID:                 handle.session.UserID,
+		PluginID:               handle.session.PluginUniqueIdentifier.PluginID(),
+		PluginUniqueIdentifier: handle.session.PluginUniqueIdentifier.String(),
+		SessionID:              handle.session.ID,
+		InvokeFrom:             string(invoke_from),
+		InvokeType:             string(handle.Type()),
+		AppID:                  appId,
+		Provider:               provider,
+		ModelName:              model,
+		Tool:                   tool,
+		PayloadHash:            audit.HashPayload(handle.RequestData()),
+		StartedAt:              time.Now(),
+	}
+}
+
+func finishAuditRecord(record *models.BackwardsInvocationAudit, status string, err error) {
+	if record == nil {
+		return
+	}
+
+	record.Status = status
+	record.DurationMs = time.Since(record.StartedAt).Milliseconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	audit.Record(*record)
+}
+
 func prepareDifyInvocationArguments(
 	session *session_manager.Session,
 	writer BackwardsInvocationWriter,
@@ -370,12 +536,27 @@ func executeDifyInvocationTextEmbeddingTask(
 	handle *BackwardsInvocation,
 	request *dify_invocation.InvokeTextEmbeddingRequest,
 ) {
+	key := ""
+	if cacheable(handle) {
+		key = response_cache.Key(request.TenantId, request.Provider, request.Model, request.InputType, request.Texts)
+		if response, ok := response_cache.Get[model_entities.TextEmbeddingResult](
+			request.TenantId, handle.Type(), key,
+		); ok {
+			handle.WriteResponse("struct", response)
+			return
+		}
+	}
+
 	response, err := handle.backwardsInvocation.InvokeTextEmbedding(request)
 	if err != nil {
 		handle.WriteError(fmt.Errorf("invoke text-embedding model failed: %s", err.Error()))
 		return
 	}
 
+	if key != "" {
+		response_cache.Set(request.TenantId, handle.Type(), key, response)
+	}
+
 	handle.WriteResponse("struct", response)
 }
 
@@ -383,15 +564,44 @@ func executeDifyInvocationRerankTask(
 	handle *BackwardsInvocation,
 	request *dify_invocation.InvokeRerankRequest,
 ) {
+	key := ""
+	if cacheable(handle) {
+		key = response_cache.Key(
+			request.TenantId, request.Provider, request.Model,
+			request.Query, request.Docs, request.ScoreThreshold, request.TopN,
+		)
+		if response, ok := response_cache.Get[model_entities.RerankResult](
+			request.TenantId, handle.Type(), key,
+		); ok {
+			handle.WriteResponse("struct", response)
+			return
+		}
+	}
+
 	response, err := handle.backwardsInvocation.InvokeRerank(request)
 	if err != nil {
 		handle.WriteError(fmt.Errorf("invoke rerank model failed: %s", err.Error()))
 		return
 	}
 
+	if key != "" {
+		response_cache.Set(request.TenantId, handle.Type(), key, response)
+	}
+
 	handle.WriteResponse("struct", response)
 }
 
+// cacheable returns true if the response cache is enabled and not bypassed by the plugin
+// through `bypass_cache` of the request
+func cacheable(handle *BackwardsInvocation) bool {
+	if !response_cache.Enabled() {
+		return false
+	}
+
+	bypass, _ := handle.RequestData()["bypass_cache"].(bool)
+	return !bypass
+}
+
 func executeDifyInvocationTTSTask(
 	handle *BackwardsInvocation,
 	request *dify_invocation.InvokeTTSRequest,
@@ -527,6 +737,8 @@ func executeDifyInvocationStorageTask(
 
 		handle.WriteResponse("struct", map[string]any{
 			"data": hex.EncodeToString(data),
+			// expected by cas
+			"hash": persistence.Hash(data),
 		})
 	} else if request.Opt == dify_invocation.STORAGE_OPT_SET {
 		data, err := hex.DecodeString(request.Value)
@@ -535,32 +747,16 @@ func executeDifyInvocationStorageTask(
 			return
 		}
 
-		session := handle.session
-		if session == nil {
-			handle.WriteError(fmt.Errorf("session not found"))
-			return
-		}
-
-		declaration := session.Declaration
-		if declaration == nil {
-			handle.WriteError(fmt.Errorf("declaration not found"))
-			return
-		}
-
-		resource := declaration.Resource.Permission
-		if resource == nil {
-			handle.WriteError(fmt.Errorf("resource not found"))
+		maxStorageSize, err := maxStorageSizeOf(handle.session)
+		if err != nil {
+			handle.WriteError(err)
 			return
 		}
 
-		maxStorageSize := int64(-1)
-
-		storage := resource.Storage
-		if storage != nil {
-			maxStorageSize = int64(storage.Size)
-		}
-
-		if err := persistence.Save(tenantId, pluginId.PluginID(), maxStorageSize, request.Key, data); err != nil {
+		if err := persistence.SaveWithTTL(
+			tenantId, pluginId.PluginID(), maxStorageSize, request.Key, data,
+			time.Duration(request.TTL)*time.Second,
+		); err != nil {
 			handle.WriteError(fmt.Errorf("save data failed: %s", err.Error()))
 			return
 		}
@@ -591,7 +787,112 @@ func executeDifyInvocationStorageTask(
 			"data":      isExist,
 			"exist_num": existNum,
 		})
+	} else if request.Opt == dify_invocation.STORAGE_OPT_CAS {
+		data, err := hex.DecodeString(request.Value)
+		if err != nil {
+			handle.WriteError(fmt.Errorf("decode data failed: %s", err.Error()))
+			return
+		}
+
+		maxStorageSize, err := maxStorageSizeOf(handle.session)
+		if err != nil {
+			handle.WriteError(err)
+			return
+		}
+
+		swapped, err := persistence.CompareAndSwap(
+			tenantId, pluginId.PluginID(), maxStorageSize, request.Key, request.Expected, data,
+			time.Duration(request.TTL)*time.Second,
+		)
+		if err != nil {
+			handle.WriteError(fmt.Errorf("compare and swap data failed: %s", err.Error()))
+			return
+		}
+
+		handle.WriteResponse("struct", map[string]any{
+			"data": swapped,
+		})
+	} else if request.Opt == dify_invocation.STORAGE_OPT_INCR {
+		maxStorageSize, err := maxStorageSizeOf(handle.session)
+		if err != nil {
+			handle.WriteError(err)
+			return
+		}
+
+		delta := int64(1)
+		if request.Delta != nil {
+			delta = *request.Delta
+		}
+
+		value, err := persistence.Increase(
+			tenantId, pluginId.PluginID(), maxStorageSize, request.Key, delta,
+			time.Duration(request.TTL)*time.Second,
+		)
+		if err != nil {
+			handle.WriteError(fmt.Errorf("increase data failed: %s", err.Error()))
+			return
+		}
+
+		handle.WriteResponse("struct", map[string]any{
+			"data": value,
+		})
+	} else if request.Opt == dify_invocation.STORAGE_OPT_LIST || request.Opt == dify_invocation.STORAGE_OPT_SCAN {
+		keys, cursor, err := persistence.List(
+			tenantId, pluginId.PluginID(), request.Prefix, request.Cursor, request.Limit,
+		)
+		if err != nil {
+			handle.WriteError(fmt.Errorf("list data failed: %s", err.Error()))
+			return
+		}
+
+		if request.Opt == dify_invocation.STORAGE_OPT_LIST {
+			handle.WriteResponse("struct", map[string]any{
+				"data":   keys,
+				"cursor": cursor,
+			})
+			return
+		}
+
+		items := make([]map[string]any, 0, len(keys))
+		for _, key := range keys {
+			data, err := persistence.Load(tenantId, pluginId.PluginID(), key)
+			if err != nil {
+				// deleted since listed
+				continue
+			}
+			items = append(items, map[string]any{
+				"key":   key,
+				"value": hex.EncodeToString(data),
+			})
+		}
+
+		handle.WriteResponse("struct", map[string]any{
+			"data":   items,
+			"cursor": cursor,
+		})
+	}
+}
+
+// maxStorageSizeOf returns the storage size declared by the plugin, -1 means the default one
+func maxStorageSizeOf(session *session_manager.Session) (int64, error) {
+	if session == nil {
+		return 0, fmt.Errorf("session not found")
+	}
+
+	declaration := session.Declaration
+	if declaration == nil {
+		return 0, fmt.Errorf("declaration not found")
+	}
+
+	resource := declaration.Resource.Permission
+	if resource == nil {
+		return 0, fmt.Errorf("resource not found")
+	}
+
+	if resource.Storage != nil {
+		return int64(resource.Storage.Size), nil
 	}
+	return -1, nil
 }
 
 func executeDifyInvocationSystemSummaryTask(
@@ -168,3 +168,66 @@ func TestBackwardsInvocationAllDeniedPermission(t *testing.T) {
 		t.Errorf("checkPermission failed: expected error, got nil")
 	}
 }
+
+func TestBackwardsInvocationScopedPermission(t *testing.T) {
+	scopedRuntime := plugin_entities.PluginDeclaration{
+		PluginDeclarationWithoutAdvancedFields: plugin_entities.PluginDeclarationWithoutAdvancedFields{
+			Resource: plugin_entities.PluginResourceRequirement{
+				Permission: &plugin_entities.PluginPermissionRequirement{
+					Tool: &plugin_entities.PluginPermissionToolRequirement{
+						Enabled:   true,
+						Providers: []string{"langgenius/google/google"},
+					},
+					Model: &plugin_entities.PluginPermissionModelRequirement{
+						Enabled:   true,
+						LLM:       true,
+						Providers: []string{"langgenius/openai"},
+					},
+					Node: &plugin_entities.PluginPermissionNodeRequirement{
+						Enabled: true,
+					},
+					App: &plugin_entities.PluginPermissionAppRequirement{
+						Enabled: true,
+						Scoped:  true,
+					},
+				},
+			},
+		},
+	}
+
+	fetchAppBindings = func(tenant_id string, plugin_id string) ([]string, error) {
+		return []string{"bound"}, nil
+	}
+
+	cases := []struct {
+		typ     dify_invocation.InvokeType
+		request map[string]any
+		allowed bool
+	}{
+		{dify_invocation.INVOKE_TYPE_TOOL, map[string]any{"provider": "langgenius/google/google"}, true},
+		{dify_invocation.INVOKE_TYPE_TOOL, map[string]any{"provider": "google"}, true},
+		{dify_invocation.INVOKE_TYPE_TOOL, map[string]any{"provider": "langgenius/bing/bing"}, false},
+		{dify_invocation.INVOKE_TYPE_LLM, map[string]any{"provider": "langgenius/openai/openai"}, true},
+		{dify_invocation.INVOKE_TYPE_LLM, map[string]any{"provider": "openai"}, true},
+		{dify_invocation.INVOKE_TYPE_LLM, map[string]any{"provider": "langgenius/anthropic/anthropic"}, false},
+		{dify_invocation.INVOKE_TYPE_NODE_PARAMETER_EXTRACTOR, map[string]any{
+			"model": map[string]any{"provider": "langgenius/openai/openai", "name": "gpt-4o"},
+		}, true},
+		{dify_invocation.INVOKE_TYPE_NODE_QUESTION_CLASSIFIER, map[string]any{
+			"model": map[string]any{"provider": "langgenius/anthropic/anthropic", "name": "claude"},
+		}, false},
+		{dify_invocation.INVOKE_TYPE_APP, map[string]any{"app_id": "bound"}, true},
+		{dify_invocation.INVOKE_TYPE_FETCH_APP, map[string]any{"app_id": "bound"}, true},
+		{dify_invocation.INVOKE_TYPE_APP, map[string]any{"app_id": "other"}, false},
+	}
+
+	for _, c := range cases {
+		request := NewBackwardsInvocation(c.typ, "", getTestSession(), nil, c.request)
+		err := checkPermission(&scopedRuntime, request)
+		if c.allowed && err != nil {
+			t.Errorf("expected %s %v to be allowed, got %s", c.typ, c.request, err.Error())
+		} else if !c.allowed && err == nil {
+			t.Errorf("expected %s %v to be denied", c.typ, c.request)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package plugin_daemon
 
 import (
 	"encoding/hex"
+	"io"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
@@ -11,9 +13,51 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
+const (
+	REQUEST_BODY_CHUNK_SIZE = 64 * 1024
+)
+
+// RequestBody is the body of an endpoint request streamed to the plugin, callers owning the reader
+// have to Wait before the reader becomes invalid, e.g. before the http handler returns
+type RequestBody struct {
+	reader  io.Reader
+	started atomic.Bool
+	done    chan struct{}
+}
+
+func NewRequestBody(reader io.Reader) *RequestBody {
+	return &RequestBody{
+		reader: reader,
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *RequestBody) start(session *session_manager.Session) {
+	b.started.Store(true)
+	routine.Submit(map[string]string{
+		"module":   "plugin_daemon",
+		"function": "InvokeEndpoint",
+		"type":     "body_read",
+	}, func() {
+		defer close(b.done)
+		writeRequestBody(session, b.reader)
+	})
+}
+
+// Wait blocks until the body is no longer read, the session has to be closed first
+// and a pending read has to be interrupted by the owner of the reader
+func (b *RequestBody) Wait() {
+	if b.started.Load() {
+		<-b.done
+	}
+}
+
+// InvokeEndpoint invokes the endpoint of the plugin, body is streamed to the plugin following the request
+// if the request is marked as streaming body, it's ignored otherwise
 func InvokeEndpoint(
 	session *session_manager.Session,
 	request *requests.RequestInvokeEndpoint,
+	body *RequestBody,
 ) (
 	int, *http.Header, *stream.Stream[[]byte], error,
 ) {
@@ -27,6 +71,11 @@ func InvokeEndpoint(
 		return http.StatusInternalServerError, nil, nil, err
 	}
 
+	// plugins may wait for the body before responding, it's written while waiting for the response
+	if request.StreamingBody && body != nil {
+		body.start(session)
+	}
+
 	statusCode := http.StatusContinue
 	headers := &http.Header{}
 	response := stream.NewStream[[]byte](128)
@@ -90,3 +139,38 @@ func InvokeEndpoint(
 
 	return statusCode, headers, response, nil
 }
+
+// writeRequestBody writes the body to the plugin chunk by chunk, stops once the session is closed
+func writeRequestBody(session *session_manager.Session, body io.Reader) {
+	buf := make([]byte, REQUEST_BODY_CHUNK_SIZE)
+	for {
+		n, err := body.Read(buf)
+		if session.Context().Err() != nil {
+			return
+		}
+
+		if n > 0 {
+			session.Write(
+				session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST_BODY,
+				session.Action,
+				endpoint_entities.EndpointRequestBodyChunk{Chunk: buf[:n]},
+			)
+		}
+
+		if err == io.EOF {
+			session.Write(
+				session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST_BODY,
+				session.Action,
+				endpoint_entities.EndpointRequestBodyChunk{End: true},
+			)
+			return
+		} else if err != nil {
+			session.Write(
+				session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST_BODY,
+				session.Action,
+				endpoint_entities.EndpointRequestBodyChunk{End: true, Error: err.Error()},
+			)
+			return
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package plugin_daemon
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/endpoint_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func readBodyChunks(t *testing.T, runtime *fakeRuntime) ([]byte, []endpoint_entities.EndpointRequestBodyChunk) {
+	body := []byte{}
+	chunks := []endpoint_entities.EndpointRequestBodyChunk{}
+	for i, event := range runtime.events {
+		if event != string(session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST_BODY) {
+			t.Fatalf("unexpected event %s", event)
+		}
+		message, err := parser.UnmarshalJsonBytes[struct {
+			Data endpoint_entities.EndpointRequestBodyChunk `json:"data"`
+		}](runtime.payloads[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = append(body, message.Data.Chunk...)
+		chunks = append(chunks, message.Data)
+	}
+	return body, chunks
+}
+
+func TestWriteRequestBody(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	body := bytes.Repeat([]byte("dify"), REQUEST_BODY_CHUNK_SIZE/2)
+	writeRequestBody(session, bytes.NewReader(body))
+
+	received, chunks := readBodyChunks(t, runtime)
+	if len(chunks) != 3 || !chunks[2].End || chunks[2].Error != "" {
+		t.Fatalf("expected 2 chunks followed by the end, got %d chunks", len(chunks))
+	}
+	if !bytes.Equal(received, body) {
+		t.Fatal("body is not equal")
+	}
+}
+
+func TestWriteRequestBodyFailed(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	body := io.MultiReader(bytes.NewReader([]byte("partial")), &failingReader{})
+	writeRequestBody(session, body)
+
+	received, chunks := readBodyChunks(t, runtime)
+	last := chunks[len(chunks)-1]
+	if !last.End || last.Error != "connection reset" {
+		t.Fatalf("expected the body to end with the error, got %+v", last)
+	}
+	if string(received) != "partial" {
+		t.Fatalf("unexpected body %s", received)
+	}
+}
+
+func TestWriteRequestBodyAfterClose(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	// nothing is written once the session is closed
+	writeRequestBody(session, bytes.NewReader([]byte("dify")))
+	if len(runtime.events) != 0 {
+		t.Fatalf("expected no events, got %v", runtime.events)
+	}
+}
+
+func TestInvokeEndpointRespondsBeforeBody(t *testing.T) {
+	routine.InitPool(1024)
+
+	runtime := &fakeRuntime{}
+	runtime.onRequest = func() {
+		// the plugin responds without reading the body
+		go func() {
+			status := uint16(http.StatusOK)
+			result := hex.EncodeToString([]byte("ok"))
+			runtime.listener.Send(plugin_entities.SessionMessage{
+				Type: plugin_entities.SESSION_MESSAGE_TYPE_STREAM,
+				Data: parser.MarshalJsonBytes(endpoint_entities.EndpointResponseChunk{
+					Status: &status,
+					Result: &result,
+				}),
+			})
+			runtime.listener.Send(plugin_entities.SessionMessage{
+				Type: plugin_entities.SESSION_MESSAGE_TYPE_END,
+				Data: []byte("{}"),
+			})
+		}()
+	}
+	session := newTestSession(runtime)
+
+	// the client never sends the body
+	reader, writer := io.Pipe()
+	body := NewRequestBody(reader)
+
+	statusCode, _, response, err := InvokeEndpoint(session, &requests.RequestInvokeEndpoint{
+		StreamingBody: true,
+	}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", statusCode)
+	}
+	response.Close()
+
+	// the handler returns, the session is closed and the pending read is interrupted
+	session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+	writer.CloseWithError(os.ErrDeadlineExceeded)
+
+	waited := make(chan struct{})
+	go func() {
+		body.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("body is still read after the handler returned")
+	}
+
+	runtime.lock.Lock()
+	defer runtime.lock.Unlock()
+	for _, event := range runtime.events {
+		if event == string(session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST_BODY) {
+			t.Fatal("body is written after the session is closed")
+		}
+	}
+}
+
+func TestRequestBodyWaitWithoutStreaming(t *testing.T) {
+	body := NewRequestBody(bytes.NewReader([]byte("dify")))
+
+	// bodies never streamed are not waited for
+	waited := make(chan struct{})
+	go func() {
+		body.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("waiting for a body never streamed")
+	}
+}
+
+type failingReader struct{}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
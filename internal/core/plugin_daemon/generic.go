@@ -3,6 +3,7 @@ package plugin_daemon
 import (
 	"errors"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/transaction"
@@ -24,8 +25,15 @@ func GenericInvokePlugin[Req any, Rsp any](
 	}
 
 	response := stream.NewStream[Rsp](response_buffer_size)
+	// finished is set once the plugin ends the session by itself
+	finished := new(int32)
 	listener := runtime.Listen(session.ID)
 	listener.Listen(func(chunk plugin_entities.SessionMessage) {
+		if chunk.Type != plugin_entities.SESSION_MESSAGE_TYPE_STREAM &&
+			chunk.Type != plugin_entities.SESSION_MESSAGE_TYPE_INVOKE {
+			atomic.StoreInt32(finished, 1)
+		}
+
 		switch chunk.Type {
 		case plugin_entities.SESSION_MESSAGE_TYPE_STREAM:
 			chunk, err := parser.UnmarshalJsonBytes[Rsp](chunk.Data)
@@ -40,8 +48,9 @@ func GenericInvokePlugin[Req any, Rsp any](
 				response.WriteBlocking(chunk)
 			}
 		case plugin_entities.SESSION_MESSAGE_TYPE_INVOKE:
-			// check if the request contains a aws_event_id
-			if runtime.Type() == plugin_entities.PLUGIN_RUNTIME_TYPE_SERVERLESS {
+			// serverless runtimes are able to answer backwards invocations only through a full duplex stream,
+			// otherwise they go through the transaction endpoint
+			if runtime.Type() == plugin_entities.PLUGIN_RUNTIME_TYPE_SERVERLESS && !session.AcceptsMessages() {
 				response.WriteError(errors.New(parser.MarshalJson(map[string]string{
 					"error_type": "aws_event_not_supported",
 					"message":    "aws event is not supported by full duplex",
@@ -82,8 +91,21 @@ func GenericInvokePlugin[Req any, Rsp any](
 	})
 
 	// close the listener if stream outside is closed due to close of connection
+	// and tell the plugin to stop if it's still working on the session
 	response.OnClose(func() {
 		listener.Close()
+		if atomic.LoadInt32(finished) == 0 {
+			session.Interrupt()
+		}
+	})
+
+	// an operator terminating the session ends the response the same way the caller leaving does
+	session.OnTerminate(func() {
+		response.WriteError(errors.New(parser.MarshalJson(map[string]string{
+			"error_type": "session_terminated",
+			"message":    "session is terminated by the administrator",
+		})))
+		response.Close()
 	})
 
 	session.Write(
@@ -0,0 +1,124 @@
+package plugin_daemon
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+type fakeRuntime struct {
+	plugin_entities.PluginLifetime
+
+	lock     sync.Mutex
+	listener *entities.Broadcast[plugin_entities.SessionMessage]
+	events   []string
+	payloads [][]byte
+	// called once the request of a session is written
+	onRequest func()
+}
+
+func (r *fakeRuntime) Type() plugin_entities.PluginRuntimeType {
+	return plugin_entities.PLUGIN_RUNTIME_TYPE_LOCAL
+}
+
+func (r *fakeRuntime) Listen(session_id string) *entities.Broadcast[plugin_entities.SessionMessage] {
+	r.listener = entities.NewBroadcast[plugin_entities.SessionMessage]()
+	return r.listener
+}
+
+func (r *fakeRuntime) Write(session_id string, action access_types.PluginAccessAction, data []byte) {
+	message, _ := parser.UnmarshalJsonBytes2Map(data)
+	r.lock.Lock()
+	r.events = append(r.events, message["event"].(string))
+	r.payloads = append(r.payloads, data)
+	r.lock.Unlock()
+
+	if message["event"] == string(session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST) && r.onRequest != nil {
+		r.onRequest()
+	}
+}
+
+func newTestSession(runtime *fakeRuntime) *session_manager.Session {
+	session := session_manager.NewSession(session_manager.NewSessionPayload{
+		TenantID:    "tenant",
+		Action:      access_types.PLUGIN_ACCESS_ACTION_INVOKE_TOOL,
+		IgnoreCache: true,
+	})
+	session.BindRuntime(runtime)
+	return session
+}
+
+func TestGenericInvokePluginCancelledByCaller(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	response, err := GenericInvokePlugin[map[string]any, map[string]any](session, &map[string]any{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller disconnects before the plugin ends the session
+	response.Close()
+
+	if len(runtime.events) != 2 || runtime.events[1] != string(session_manager.PLUGIN_IN_STREAM_EVENT_CANCEL) {
+		t.Fatalf("expected the plugin to be cancelled, got %v", runtime.events)
+	}
+	if session.Context().Err() == nil {
+		t.Fatal("expected the session to be cancelled")
+	}
+}
+
+func TestGenericInvokePluginEndedByPlugin(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	_, err := GenericInvokePlugin[map[string]any, map[string]any](session, &map[string]any{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.listener.Send(plugin_entities.SessionMessage{Type: plugin_entities.SESSION_MESSAGE_TYPE_END})
+
+	if len(runtime.events) != 1 || runtime.events[0] != string(session_manager.PLUGIN_IN_STREAM_EVENT_REQUEST) {
+		t.Fatalf("expected the request only, got %v", runtime.events)
+	}
+	if session.Context().Err() != nil {
+		t.Fatal("session should not be cancelled")
+	}
+}
+
+func TestGenericInvokePluginTerminated(t *testing.T) {
+	runtime := &fakeRuntime{}
+	session := newTestSession(runtime)
+	defer session.Close(session_manager.CloseSessionPayload{IgnoreCache: true})
+
+	response, err := GenericInvokePlugin[map[string]any, map[string]any](session, &map[string]any{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session_manager.Terminate(session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller receives the termination, the plugin is cancelled
+	for response.Next() {
+		if _, err := response.Read(); err == nil {
+			t.Fatal("expected the termination error")
+		}
+	}
+	if len(runtime.events) != 2 || runtime.events[1] != string(session_manager.PLUGIN_IN_STREAM_EVENT_CANCEL) {
+		t.Fatalf("expected the plugin to be cancelled, got %v", runtime.events)
+	}
+
+	if err := session_manager.Terminate("unknown"); err != session_manager.ErrSessionNotFound {
+		t.Fatalf("expected session not found, got %v", err)
+	}
+}
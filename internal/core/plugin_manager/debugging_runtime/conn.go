@@ -0,0 +1,200 @@
+package debugging_runtime
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/gnet/v2"
+)
+
+// remoteConn is the connection between the daemon and a remote debugging plugin
+// it hides whether the plugin is served by the gnet engine or a plain net.Conn
+type remoteConn interface {
+	// Write writes data synchronously
+	Write(data []byte) (int, error)
+	// AsyncWrite writes data without waiting for the peer
+	AsyncWrite(data []byte)
+	Close() error
+	RemoteAddr() net.Addr
+}
+
+// gnetConn wraps a connection accepted by the gnet engine
+type gnetConn struct {
+	conn gnet.Conn
+}
+
+func (c *gnetConn) Write(data []byte) (int, error) {
+	return c.conn.Write(data)
+}
+
+func (c *gnetConn) AsyncWrite(data []byte) {
+	c.conn.AsyncWrite(data, func(c gnet.Conn, err error) error {
+		return nil
+	})
+}
+
+func (c *gnetConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *gnetConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// STREAM_WRITE_TIMEOUT bounds a single write of a blocking connection, a plugin which
+// stops reading is disconnected instead of holding the writer forever
+const STREAM_WRITE_TIMEOUT = 10 * time.Second
+
+type queuedWrite struct {
+	data []byte
+	// nil for async writes
+	done chan error
+}
+
+// writeQueue writes to a blocking connection from a dedicated goroutine,
+// AsyncWrite only appends to the queue like gnet.Conn.AsyncWrite does
+type writeQueue struct {
+	write func(data []byte) error
+	// called once a write failed, the connection is broken and pending writes are dropped
+	fail func()
+
+	lock    sync.Mutex
+	pending []queuedWrite
+	closed  bool
+	notify  chan struct{}
+}
+
+func newWriteQueue(write func(data []byte) error, fail func()) *writeQueue {
+	q := &writeQueue{
+		write:  write,
+		fail:   fail,
+		notify: make(chan struct{}, 1),
+	}
+	go q.run()
+	return q
+}
+
+func (q *writeQueue) push(w queuedWrite) bool {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return false
+	}
+	q.pending = append(q.pending, w)
+	q.lock.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Write waits until data is written, messages queued before are written first
+func (q *writeQueue) Write(data []byte) (int, error) {
+	done := make(chan error, 1)
+	if !q.push(queuedWrite{data: data, done: done}) {
+		return 0, net.ErrClosed
+	}
+
+	if err := <-done; err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (q *writeQueue) AsyncWrite(data []byte) {
+	q.push(queuedWrite{data: data})
+}
+
+// close stops the writer, pending writes fail with net.ErrClosed
+func (q *writeQueue) close() {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return
+	}
+	q.closed = true
+	pending := q.pending
+	q.pending = nil
+	q.lock.Unlock()
+
+	for _, w := range pending {
+		if w.done != nil {
+			w.done <- net.ErrClosed
+		}
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *writeQueue) run() {
+	for range q.notify {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return
+		}
+		pending := q.pending
+		q.pending = nil
+		q.lock.Unlock()
+
+		for i, w := range pending {
+			err := q.write(w.data)
+			if w.done != nil {
+				w.done <- err
+			}
+
+			if err != nil {
+				for _, rest := range pending[i+1:] {
+					if rest.done != nil {
+						rest.done <- err
+					}
+				}
+				q.close()
+				q.fail()
+				return
+			}
+		}
+	}
+}
+
+// streamConn wraps a blocking net.Conn, like a tls connection
+type streamConn struct {
+	conn  net.Conn
+	queue *writeQueue
+}
+
+func newStreamConn(conn net.Conn) *streamConn {
+	c := &streamConn{conn: conn}
+	c.queue = newWriteQueue(func(data []byte) error {
+		c.conn.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT))
+		_, err := c.conn.Write(data)
+		return err
+	}, func() {
+		// the reader fails as well and the connection is released
+		c.conn.Close()
+	})
+	return c
+}
+
+func (c *streamConn) Write(data []byte) (int, error) {
+	return c.queue.Write(data)
+}
+
+func (c *streamConn) AsyncWrite(data []byte) {
+	c.queue.AsyncWrite(data)
+}
+
+func (c *streamConn) Close() error {
+	c.queue.close()
+	return c.conn.Close()
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
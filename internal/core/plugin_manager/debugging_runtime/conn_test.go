@@ -0,0 +1,69 @@
+package debugging_runtime
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStreamConnAsyncWriteDoesNotBlock(t *testing.T) {
+	// writes to a pipe block until the other side reads
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := newStreamConn(server)
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			conn.AsyncWrite([]byte("async\n"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("AsyncWrite should not wait for the peer")
+	}
+
+	written := make(chan error)
+	go func() {
+		_, err := conn.Write([]byte("sync\n"))
+		written <- err
+	}()
+
+	reader := bufio.NewReader(client)
+	for i := 0; i < 101; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "async\n"
+		if i == 100 {
+			expected = "sync\n"
+		}
+		if line != expected {
+			t.Fatalf("messages should keep their order, got %q at %d", line, i)
+		}
+	}
+
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamConnWriteAfterClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := newStreamConn(server)
+	conn.Close()
+
+	if _, err := conn.Write([]byte("message\n")); err == nil {
+		t.Fatal("write should fail after close")
+	}
+}
@@ -0,0 +1,216 @@
+package debugging_runtime
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrConnectionNotFound = errors.New("debugging connection not found")
+)
+
+// ConnectionStatus describes a live debugging connection of a tenant
+type ConnectionStatus struct {
+	ConnectionID string `json:"connection_id"`
+	// empty until the plugin is initialized
+	PluginUniqueIdentifier string    `json:"plugin_unique_identifier"`
+	Initialized            bool      `json:"initialized"`
+	ConnectedAt            time.Time `json:"connected_at"`
+	LastHeartbeatAt        time.Time `json:"last_heartbeat_at"`
+	RemoteAddress          string    `json:"remote_address"`
+}
+
+// status must be called with the plugins lock of the server held
+func (r *RemotePluginRuntime) status() ConnectionStatus {
+	status := ConnectionStatus{
+		ConnectionID:    r.connectionId,
+		Initialized:     r.initialized,
+		ConnectedAt:     r.connectedAt,
+		LastHeartbeatAt: r.getLastActiveAt(),
+	}
+
+	// the declaration is not modified once the plugin is initialized
+	if r.initialized {
+		if identity, err := r.Identity(); err == nil {
+			status.PluginUniqueIdentifier = identity.String()
+		}
+	}
+
+	if addr := r.conn.RemoteAddr(); addr != nil {
+		status.RemoteAddress = addr.String()
+	}
+
+	return status
+}
+
+func (r *RemotePluginRuntime) setLastActiveAt(t time.Time) {
+	atomic.StoreInt64(&r.lastActiveAt, t.UnixNano())
+}
+
+func (r *RemotePluginRuntime) getLastActiveAt() time.Time {
+	lastActiveAt := atomic.LoadInt64(&r.lastActiveAt)
+	if lastActiveAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastActiveAt)
+}
+
+const (
+	TENANT_SLOTS_KEY_PREFIX      = "debugging:tenant_slots"
+	TENANT_SLOT_LEASE            = 60 * time.Second
+	TENANT_SLOT_REFRESH_INTERVAL = 20 * time.Second
+)
+
+// tenantSlotScript takes a slot of the tenant if it has less than the limit across the cluster,
+// slots are leased and dropped once their lease expires, a held slot is always refreshed.
+// ARGV[1] is now in milliseconds, ARGV[2] the lease in milliseconds, ARGV[3] the limit and ARGV[4] the connection id,
+// returns 1 if the slot is taken
+var tenantSlotScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if not redis.call('ZSCORE', KEYS[1], ARGV[4]) and redis.call('ZCARD', KEYS[1]) >= limit then
+	return 0
+end
+
+redis.call('ZADD', KEYS[1], now + lease, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], lease)
+return 1
+`)
+
+// tenantSlotRefreshScript extends the lease of a slot, released slots are left released
+var tenantSlotRefreshScript = redis.NewScript(`
+if redis.call('ZADD', KEYS[1], 'XX', 'CH', ARGV[1], ARGV[2]) == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+func tenantSlotsKey(tenant_id string) string {
+	return TENANT_SLOTS_KEY_PREFIX + ":" + tenant_id
+}
+
+// takeTenantSlot counts the runtime in the connections of its tenant, returns false if the tenant has too many,
+// connections are counted across the cluster, a tenant has at most the limit no matter how many nodes there are
+func (s *DifyServer) takeTenantSlot(runtime *RemotePluginRuntime) bool {
+	if s.maxSingleTenantConn > 0 {
+		now := time.Now()
+		result, err := cache.EvalScript(
+			tenantSlotScript,
+			[]string{tenantSlotsKey(runtime.tenantId)},
+			[]any{now.UnixMilli(), TENANT_SLOT_LEASE.Milliseconds(), s.maxSingleTenantConn, runtime.connectionId},
+		)
+		if err != nil {
+			log.Error("failed to take debugging slot of tenant %s: %s", runtime.tenantId, err.Error())
+			return false
+		}
+		if taken, ok := result.(int64); !ok || taken != 1 {
+			return false
+		}
+	}
+
+	s.pluginsLock.Lock()
+	runtime.tenantSlot = true
+	s.pluginsLock.Unlock()
+
+	if s.maxSingleTenantConn > 0 {
+		s.keepTenantSlot(runtime)
+	}
+
+	return true
+}
+
+// keepTenantSlot refreshes the lease of the slot until the connection is closed
+func (s *DifyServer) keepTenantSlot(runtime *RemotePluginRuntime) {
+	routine.Submit(map[string]string{
+		"module":   "debugging_runtime",
+		"function": "keepTenantSlot",
+	}, func() {
+		ticker := time.NewTicker(TENANT_SLOT_REFRESH_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := cache.EvalScript(
+					tenantSlotRefreshScript,
+					[]string{tenantSlotsKey(runtime.tenantId)},
+					[]any{
+						time.Now().Add(TENANT_SLOT_LEASE).UnixMilli(),
+						runtime.connectionId,
+						TENANT_SLOT_LEASE.Milliseconds(),
+					},
+				); err != nil {
+					log.Warn("failed to refresh debugging slot of tenant %s: %s", runtime.tenantId, err.Error())
+				}
+			case <-runtime.shutdownChan:
+				return
+			}
+		}
+	})
+}
+
+// releaseTenantSlot frees the slot of a closed connection
+func (s *DifyServer) releaseTenantSlot(runtime *RemotePluginRuntime) {
+	if s.maxSingleTenantConn <= 0 {
+		return
+	}
+
+	if err := cache.ZRem(tenantSlotsKey(runtime.tenantId), runtime.connectionId); err != nil {
+		log.Error("failed to release debugging slot of tenant %s: %s", runtime.tenantId, err.Error())
+	}
+}
+
+// findConnection returns the runtime of the connection if it belongs to the tenant
+func (s *DifyServer) findConnection(tenant_id string, connection_id string) *RemotePluginRuntime {
+	s.pluginsLock.RLock()
+	defer s.pluginsLock.RUnlock()
+
+	for _, runtime := range s.plugins {
+		if runtime.handshake && runtime.tenantId == tenant_id && runtime.connectionId == connection_id {
+			return runtime
+		}
+	}
+
+	return nil
+}
+
+// Connections lists the debugging connections of the tenant served by this node,
+// connections served by other nodes are collected by the http server
+func (r *RemotePluginServer) Connections(tenant_id string) []ConnectionStatus {
+	r.server.pluginsLock.RLock()
+	defer r.server.pluginsLock.RUnlock()
+
+	connections := make([]ConnectionStatus, 0)
+	for _, runtime := range r.server.plugins {
+		if runtime.handshake && runtime.tenantId == tenant_id {
+			connections = append(connections, runtime.status())
+		}
+	}
+
+	return connections
+}
+
+// HasConnection returns whether the debugging connection of the tenant is served by this node
+func (r *RemotePluginServer) HasConnection(tenant_id string, connection_id string) bool {
+	return r.server.findConnection(tenant_id, connection_id) != nil
+}
+
+// Disconnect closes a debugging connection of the tenant
+// the plugin is unregistered once the connection is closed, same as the plugin disconnects itself
+func (r *RemotePluginServer) Disconnect(tenant_id string, connection_id string) error {
+	runtime := r.server.findConnection(tenant_id, connection_id)
+	if runtime == nil {
+		return ErrConnectionNotFound
+	}
+
+	return runtime.conn.Close()
+}
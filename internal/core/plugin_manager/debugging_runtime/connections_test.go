@@ -0,0 +1,164 @@
+package debugging_runtime
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/stream"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/strings"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+// fakeConn behaves like gnet, closing the connection triggers closeConnection
+type fakeConn struct {
+	server *DifyServer
+	connId int
+	closed bool
+}
+
+func (c *fakeConn) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (c *fakeConn) AsyncWrite(data []byte) {}
+
+func (c *fakeConn) Close() error {
+	if !c.closed {
+		c.closed = true
+		c.server.closeConnection(c.connId)
+	}
+	return nil
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}
+}
+
+func TestListAndDisconnectConnections(t *testing.T) {
+	s := &DifyServer{
+		response:    stream.NewStream[plugin_entities.PluginFullDuplexLifetime](1),
+		plugins:     make(map[int]*RemotePluginRuntime),
+		pluginsLock: &sync.RWMutex{},
+	}
+	server := &RemotePluginServer{server: s}
+
+	conns := []*fakeConn{}
+	for i, tenant := range []string{"tenant-a", "tenant-a", "tenant-b"} {
+		conn := &fakeConn{server: s, connId: i}
+		runtime := s.openConnection(conn, i)
+		runtime.handshake = true
+		runtime.tenantId = tenant
+		conns = append(conns, conn)
+	}
+
+	connections := server.Connections("tenant-a")
+	if len(connections) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(connections))
+	}
+	if connections[0].RemoteAddress != "127.0.0.1:12345" {
+		t.Fatalf("unexpected remote address %s", connections[0].RemoteAddress)
+	}
+
+	// a tenant is not able to disconnect connections of other tenants
+	if err := server.Disconnect("tenant-b", connections[0].ConnectionID); err != ErrConnectionNotFound {
+		t.Fatalf("expected ErrConnectionNotFound, got %v", err)
+	}
+
+	if err := server.Disconnect("tenant-a", connections[0].ConnectionID); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.Connections("tenant-a")) != 1 {
+		t.Fatal("connection should be removed after disconnect")
+	}
+	if len(server.Connections("tenant-b")) != 1 {
+		t.Fatal("connections of other tenants should not be affected")
+	}
+
+	closed := 0
+	for _, conn := range conns {
+		if conn.closed {
+			closed++
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("expected 1 closed connection, got %d", closed)
+	}
+}
+
+func TestTakeTenantSlotConcurrently(t *testing.T) {
+	if err := cache.InitRedisClient("localhost:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	routine.InitPool(1024)
+
+	tenantId := strings.RandomString(10)
+	s := &DifyServer{
+		response:            stream.NewStream[plugin_entities.PluginFullDuplexLifetime](1),
+		plugins:             make(map[int]*RemotePluginRuntime),
+		pluginsLock:         &sync.RWMutex{},
+		maxSingleTenantConn: 2,
+	}
+
+	runtimes := []*RemotePluginRuntime{}
+	for i := 0; i < 16; i++ {
+		runtime := s.openConnection(&fakeConn{server: s, connId: i}, i)
+		runtime.handshake = true
+		runtime.tenantId = tenantId
+		runtimes = append(runtimes, runtime)
+	}
+
+	var taken atomic.Int32
+	wg := sync.WaitGroup{}
+	for _, runtime := range runtimes {
+		wg.Add(1)
+		go func(runtime *RemotePluginRuntime) {
+			defer wg.Done()
+			if s.takeTenantSlot(runtime) {
+				taken.Add(1)
+			}
+		}(runtime)
+	}
+	wg.Wait()
+
+	if taken.Load() != 2 {
+		t.Fatalf("expected 2 slots taken, got %d", taken.Load())
+	}
+
+	// slots of closed connections are released
+	var closed, waiting *RemotePluginRuntime
+	for _, runtime := range runtimes {
+		if runtime.tenantSlot && closed == nil {
+			closed = runtime
+		} else if !runtime.tenantSlot && waiting == nil {
+			waiting = runtime
+		}
+	}
+	closed.conn.Close()
+	if !s.takeTenantSlot(waiting) {
+		t.Fatal("slot of the closed connection should be released")
+	}
+
+	// slots are shared by all the nodes of the cluster
+	other := &DifyServer{
+		response:            stream.NewStream[plugin_entities.PluginFullDuplexLifetime](1),
+		plugins:             make(map[int]*RemotePluginRuntime),
+		pluginsLock:         &sync.RWMutex{},
+		maxSingleTenantConn: 2,
+	}
+	runtime := other.openConnection(&fakeConn{server: other, connId: 0}, 0)
+	runtime.handshake = true
+	runtime.tenantId = tenantId
+	if other.takeTenantSlot(runtime) {
+		t.Fatal("slots taken on another node should be counted")
+	}
+
+	for _, runtime := range runtimes {
+		runtime.conn.Close()
+	}
+}
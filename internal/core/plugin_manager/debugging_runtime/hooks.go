@@ -3,16 +3,20 @@ package debugging_runtime
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/media_transport"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/stream"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
 	"github.com/panjf2000/gnet/v2"
@@ -31,6 +35,10 @@ type DifyServer struct {
 
 	mediaManager *media_transport.MediaBucket
 
+	// traces of debugging sessions are recorded if set
+	traceBucket  *media_transport.TraceBucket
+	traceMaxSize int64
+
 	// listening address
 	addr string
 	port uint16
@@ -51,6 +59,26 @@ type DifyServer struct {
 
 	maxConn     int32
 	currentConn int32
+
+	// max initialized connections of a single tenant
+	maxSingleTenantConn int
+
+	// tls, the gnet engine does not support tls, a blocking tls listener is used instead
+	tlsEnabled  bool
+	tlsCertFile string
+	tlsKeyFile  string
+
+	// listener and connections accepted by it, closed on stop
+	listener     net.Listener
+	listenerConn map[net.Conn]struct{}
+	listenerLock sync.Mutex
+	stopped      bool
+
+	// id of connections not served by gnet, negative to avoid conflicts with fds
+	streamConnId int64
+
+	// secret used to verify debugging tokens, nil if tokens are disabled
+	tokenSecret []byte
 }
 
 func (s *DifyServer) OnBoot(c gnet.Engine) (action gnet.Action) {
@@ -61,12 +89,25 @@ func (s *DifyServer) OnBoot(c gnet.Engine) (action gnet.Action) {
 func (s *DifyServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
 	// new plugin connected
 	c.SetContext(&codec{})
+	s.openConnection(&gnetConn{conn: c}, c.Fd())
+	return nil, gnet.None
+}
+
+func (s *DifyServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+	s.closeConnection(c.Fd())
+	return gnet.None
+}
+
+// openConnection creates a runtime for the new connection and waits for the handshake
+func (s *DifyServer) openConnection(conn remoteConn, connId int) *RemotePluginRuntime {
 	runtime := &RemotePluginRuntime{
 		MediaTransport: basic_runtime.NewMediaTransport(
 			s.mediaManager,
 		),
 
-		conn:                      c,
+		conn:                      conn,
+		connectionId:              uuid.New().String(),
+		connectedAt:               time.Now(),
 		response:                  stream.NewStream[[]byte](512),
 		messageCallbacks:          make(map[string][]func([]byte)),
 		messageCallbacksLock:      &sync.RWMutex{},
@@ -82,42 +123,61 @@ func (s *DifyServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
 		alive: true,
 	}
 
+	if s.traceBucket != nil {
+		runtime.recorder = newTraceRecorder(s.traceMaxSize)
+	}
+
 	// store plugin runtime
 	s.pluginsLock.Lock()
-	s.plugins[c.Fd()] = runtime
+	s.plugins[connId] = runtime
 	s.pluginsLock.Unlock()
 
 	// start a timer to check if handshake is completed in 10 seconds
 	time.AfterFunc(time.Second*10, func() {
-		if !runtime.handshake {
+		s.pluginsLock.RLock()
+		handshake := runtime.handshake
+		s.pluginsLock.RUnlock()
+
+		if !handshake {
 			// close connection
-			c.Close()
+			conn.Close()
 		}
 	})
 
-	// verified
-	verified := true
-	if verified {
-		return nil, gnet.None
-	}
-
-	return nil, gnet.Close
+	return runtime
 }
 
-func (s *DifyServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
+// closeConnection releases the runtime of the closed connection
+func (s *DifyServer) closeConnection(connId int) {
 	// plugin disconnected
 	s.pluginsLock.Lock()
-	plugin := s.plugins[c.Fd()]
-	delete(s.plugins, c.Fd())
+	plugin := s.plugins[connId]
+	delete(s.plugins, connId)
+	tenantSlot := plugin != nil && plugin.tenantSlot
 	s.pluginsLock.Unlock()
 
 	if plugin == nil {
-		return gnet.None
+		return
 	}
 
 	// close plugin
 	plugin.onDisconnected()
 
+	// the refresh of the slot stops along with the plugin
+	if tenantSlot {
+		s.releaseTenantSlot(plugin)
+	}
+
+	// upload the trace of the session
+	if plugin.recorder != nil && plugin.initialized {
+		routine.Submit(map[string]string{
+			"module": "debugging_runtime",
+			"method": "saveTrace",
+		}, func() {
+			s.saveTrace(plugin)
+		})
+	}
+
 	// uninstall plugin
 	if plugin.assetsTransferred {
 		if _mode != _PLUGIN_RUNTIME_MODE_CI {
@@ -146,8 +206,6 @@ func (s *DifyServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
 	plugin.waitLaunchedChanOnce.Do(func() {
 		close(plugin.waitLaunchedChan)
 	})
-
-	return gnet.None
 }
 
 func (s *DifyServer) OnShutdown(c gnet.Engine) {
@@ -181,6 +239,15 @@ func (s *DifyServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	return gnet.None
 }
 
+// getConnectionInfo resolves the tenant of the connection from a debugging token or a random key
+func (s *DifyServer) getConnectionInfo(key string) (*ConnectionInfo, error) {
+	if s.tokenSecret != nil && IsDebuggingToken(key) {
+		return VerifyDebuggingToken(s.tokenSecret, key)
+	}
+
+	return GetConnectionInfo(key)
+}
+
 func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 	// handle message
 	if runtime.handshakeFailed {
@@ -218,8 +285,10 @@ func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 				return
 			}
 
-			info, err := GetConnectionInfo(key.Key)
-			if err == cache.ErrNotFound {
+			info, err := s.getConnectionInfo(key.Key)
+			if err == cache.ErrNotFound ||
+				errors.Is(err, ErrInvalidDebuggingToken) ||
+				errors.Is(err, ErrExpiredDebuggingToken) {
 				// close connection if handshake failed
 				closeConn([]byte("handshake failed, invalid key\n"))
 				runtime.handshakeFailed = true
@@ -231,10 +300,11 @@ func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 				return
 			}
 
-			runtime.tenantId = info.TenantId
-
 			// handshake completed
+			s.pluginsLock.Lock()
+			runtime.tenantId = info.TenantId
 			runtime.handshake = true
+			s.pluginsLock.Unlock()
 		} else if registerPayload.Type == plugin_entities.REGISTER_EVENT_TYPE_ASSET_CHUNK {
 			if runtime.assetsTransferred {
 				return
@@ -292,8 +362,17 @@ func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 				return
 			}
 
-			atomic.AddInt32(&s.currentConn, 1)
-			if atomic.LoadInt32(&s.currentConn) > int32(s.maxConn) {
+			if !s.takeTenantSlot(runtime) {
+				closeConn([]byte(fmt.Sprintf(
+					"too many debugging connections of the tenant, at most %d, disconnect one of them and try again\n",
+					s.maxSingleTenantConn,
+				)))
+				return
+			}
+
+			// the connection is counted once its assets are transferred, a refused one gives it back
+			if atomic.AddInt32(&s.currentConn, 1) > int32(s.maxConn) {
+				atomic.AddInt32(&s.currentConn, -1)
 				closeConn([]byte("server is busy now, please try again later\n"))
 				return
 			}
@@ -330,7 +409,9 @@ func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 			})
 
 			// mark initialized
+			s.pluginsLock.Lock()
 			runtime.initialized = true
+			s.pluginsLock.Unlock()
 
 			// publish runtime to watcher
 			s.response.Write(runtime)
@@ -426,6 +507,7 @@ func (s *DifyServer) onMessage(runtime *RemotePluginRuntime, message []byte) {
 		}
 	} else {
 		// continue handle messages if handshake completed
+		runtime.recordTrace(plugin_entities.DEBUGGING_TRACE_DIRECTION_FROM_PLUGIN, message)
 		runtime.response.WriteBlocking(message)
 	}
 }
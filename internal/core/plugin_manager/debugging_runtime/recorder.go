@@ -0,0 +1,187 @@
+package debugging_runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+// TRACE_REDACTED replaces the values of sensitive fields in recorded messages
+const TRACE_REDACTED = "[REDACTED]"
+
+// traceSensitiveFields are the fields carrying decrypted credentials or settings,
+// traces are uploaded to the storage in plaintext so their values are never recorded
+var traceSensitiveFields = map[string]bool{
+	"credentials":        true,
+	"system_credentials": true,
+	"settings":           true,
+}
+
+// traceRecorder keeps the traffic of a debugging session in memory
+// the trace is uploaded to the trace bucket once the plugin disconnects
+type traceRecorder struct {
+	id        string
+	startedAt time.Time
+	maxSize   int64
+
+	lock      sync.Mutex
+	buffer    bytes.Buffer
+	truncated bool
+}
+
+func newTraceRecorder(maxSize int64) *traceRecorder {
+	return &traceRecorder{
+		id:        uuid.New().String(),
+		startedAt: time.Now(),
+		maxSize:   maxSize,
+	}
+}
+
+func (t *traceRecorder) record(direction plugin_entities.DebuggingTraceDirection, data []byte) {
+	line := parser.MarshalJsonBytes(plugin_entities.DebuggingTraceRecord{
+		Direction: direction,
+		Timestamp: time.Now().UnixMilli(),
+		Data:      data,
+	})
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.truncated {
+		return
+	}
+
+	if int64(t.buffer.Len()+len(line)+1) > t.maxSize {
+		t.truncated = true
+		return
+	}
+
+	t.buffer.Write(line)
+	t.buffer.WriteByte('\n')
+}
+
+// dump returns the whole trace file
+func (t *traceRecorder) dump(meta plugin_entities.DebuggingTraceMeta) []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	meta.TraceID = t.id
+	meta.StartedAt = t.startedAt.Unix()
+	meta.EndedAt = time.Now().Unix()
+	meta.Truncated = t.truncated
+
+	result := bytes.NewBuffer(parser.MarshalJsonBytes(meta))
+	result.WriteByte('\n')
+	result.Write(t.buffer.Bytes())
+
+	return result.Bytes()
+}
+
+// recordTrace records the message if the session is being recorded
+func (r *RemotePluginRuntime) recordTrace(direction plugin_entities.DebuggingTraceDirection, data []byte) {
+	if r.recorder == nil {
+		return
+	}
+
+	// messages are not always valid json, the raw bytes are kept as a json string then
+	if !json.Valid(data) {
+		data = parser.MarshalJsonBytes(string(data))
+	} else {
+		data = redactTraceMessage(data)
+	}
+
+	r.recorder.record(direction, data)
+}
+
+// redactTraceMessage replaces the values of sensitive fields, the message is kept untouched
+// if there is nothing to redact
+func redactTraceMessage(data []byte) []byte {
+	var message any
+	if err := json.Unmarshal(data, &message); err != nil {
+		return data
+	}
+
+	message, redacted := redactTraceValue(message)
+	if !redacted {
+		return data
+	}
+
+	return parser.MarshalJsonBytes(message)
+}
+
+func redactTraceValue(value any) (any, bool) {
+	redacted := false
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if traceSensitiveFields[key] {
+				v[key] = redactTraceLeaves(field)
+				redacted = true
+				continue
+			}
+
+			var changed bool
+			v[key], changed = redactTraceValue(field)
+			redacted = redacted || changed
+		}
+	case []any:
+		for i, item := range v {
+			var changed bool
+			v[i], changed = redactTraceValue(item)
+			redacted = redacted || changed
+		}
+	}
+
+	return value, redacted
+}
+
+// redactTraceLeaves keeps the shape of a sensitive field so that it's still clear
+// which fields were sent, only the values are replaced
+func redactTraceLeaves(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			v[key] = redactTraceLeaves(field)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = redactTraceLeaves(item)
+		}
+		return v
+	case nil:
+		return nil
+	default:
+		return TRACE_REDACTED
+	}
+}
+
+// saveTrace uploads the trace of the session
+func (s *DifyServer) saveTrace(runtime *RemotePluginRuntime) {
+	if runtime.recorder == nil || s.traceBucket == nil || !runtime.initialized {
+		return
+	}
+
+	identity, err := runtime.Identity()
+	if err != nil {
+		log.Error("failed to get identity of debugging plugin, trace dropped: %s", err.Error())
+		return
+	}
+
+	trace := runtime.recorder.dump(plugin_entities.DebuggingTraceMeta{
+		TenantID:               runtime.tenantId,
+		PluginUniqueIdentifier: identity.String(),
+		Declaration:            runtime.Config,
+	})
+
+	if err := s.traceBucket.Save(runtime.tenantId, runtime.recorder.id, trace); err != nil {
+		log.Error("failed to save debugging trace: %s", err.Error())
+	}
+}
@@ -0,0 +1,114 @@
+package debugging_runtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+func TestTraceRecorder(t *testing.T) {
+	recorder := newTraceRecorder(1024)
+	runtime := &RemotePluginRuntime{recorder: recorder}
+
+	runtime.recordTrace(plugin_entities.DEBUGGING_TRACE_DIRECTION_TO_PLUGIN, []byte(`{"session_id":"1","event":"request"}`))
+	runtime.recordTrace(plugin_entities.DEBUGGING_TRACE_DIRECTION_FROM_PLUGIN, []byte("not a json message"))
+
+	meta, records, err := plugin_entities.ParseDebuggingTrace(recorder.dump(plugin_entities.DebuggingTraceMeta{
+		TenantID: "tenant",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.TraceID != recorder.id || meta.TenantID != "tenant" || meta.Truncated {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	if records[0].Direction != plugin_entities.DEBUGGING_TRACE_DIRECTION_TO_PLUGIN ||
+		string(records[0].Data) != `{"session_id":"1","event":"request"}` {
+		t.Fatalf("unexpected record: %+v", records[0])
+	}
+
+	if string(records[1].Data) != `"not a json message"` {
+		t.Fatalf("invalid json should be kept as a string, got %s", records[1].Data)
+	}
+}
+
+func TestTraceRecorderTruncated(t *testing.T) {
+	recorder := newTraceRecorder(128)
+	runtime := &RemotePluginRuntime{recorder: recorder}
+
+	for i := 0; i < 10; i++ {
+		runtime.recordTrace(plugin_entities.DEBUGGING_TRACE_DIRECTION_FROM_PLUGIN, []byte(`{"event":"heartbeat"}`))
+	}
+
+	meta, records, err := plugin_entities.ParseDebuggingTrace(recorder.dump(plugin_entities.DebuggingTraceMeta{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !meta.Truncated {
+		t.Fatal("trace should be truncated")
+	}
+
+	if len(records) == 0 || len(records) == 10 {
+		t.Fatalf("unexpected records count %d", len(records))
+	}
+}
+
+func TestTraceRecorderRedactsCredentials(t *testing.T) {
+	recorder := newTraceRecorder(4096)
+	runtime := &RemotePluginRuntime{recorder: recorder}
+
+	runtime.recordTrace(plugin_entities.DEBUGGING_TRACE_DIRECTION_TO_PLUGIN, []byte(
+		`{"session_id":"1","event":"request","data":{"action":"invoke_tool","credentials":{"api_key":"sk-123","region":{"name":"us"}},`+
+			`"tool_parameters":{"query":"hello"},"requests":[{"settings":{"token":"abc","retries":3}}]}}`,
+	))
+
+	_, records, err := plugin_entities.ParseDebuggingTrace(recorder.dump(plugin_entities.DebuggingTraceMeta{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+
+	var message struct {
+		Data struct {
+			Action         string         `json:"action"`
+			Credentials    map[string]any `json:"credentials"`
+			ToolParameters map[string]any `json:"tool_parameters"`
+			Requests       []struct {
+				Settings map[string]any `json:"settings"`
+			} `json:"requests"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(records[0].Data, &message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message.Data.Credentials["api_key"] != TRACE_REDACTED {
+		t.Fatalf("credentials should be redacted, got %v", message.Data.Credentials)
+	}
+
+	region, _ := message.Data.Credentials["region"].(map[string]any)
+	if region["name"] != TRACE_REDACTED {
+		t.Fatalf("nested credentials should be redacted, got %v", message.Data.Credentials)
+	}
+
+	if len(message.Data.Requests) != 1 ||
+		message.Data.Requests[0].Settings["token"] != TRACE_REDACTED ||
+		message.Data.Requests[0].Settings["retries"] != TRACE_REDACTED {
+		t.Fatalf("settings should be redacted, got %+v", message.Data.Requests)
+	}
+
+	if message.Data.Action != "invoke_tool" || message.Data.ToolParameters["query"] != "hello" {
+		t.Fatalf("other fields should be kept, got %+v", message.Data)
+	}
+}
@@ -43,14 +43,14 @@ func (r *RemotePluginRuntime) StartPlugin() error {
 		"function":  "StartPlugin",
 		"plugin_id": identity.String(),
 	}, func() {
-		r.lastActiveAt = time.Now()
+		r.setLastActiveAt(time.Now())
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				if time.Since(r.lastActiveAt) > 60*time.Second {
+				if time.Since(r.getLastActiveAt()) > 60*time.Second {
 					// kill this connection if it's not active for a long time
 					r.conn.Close()
 					exitError = plugin_errors.ErrPluginNotActive
@@ -77,7 +77,7 @@ func (r *RemotePluginRuntime) StartPlugin() error {
 				}
 			},
 			func() {
-				r.lastActiveAt = time.Now()
+				r.setLastActiveAt(time.Now())
 			},
 			func(err string) {
 				log.Error("plugin %s: %s", r.Configuration().Identity(), err)
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -30,6 +32,10 @@ type RemotePluginServerInterface interface {
 	Wrap(f func(plugin_entities.PluginFullDuplexLifetime))
 	Stop() error
 	Launch() error
+	ServeWebSocket(w http.ResponseWriter, r *http.Request) error
+	Connections(tenant_id string) []ConnectionStatus
+	HasConnection(tenant_id string, connection_id string) bool
+	Disconnect(tenant_id string, connection_id string) error
 }
 
 // continue accepting new connections
@@ -61,6 +67,10 @@ func (r *RemotePluginServer) Stop() error {
 		return errors.New("plugin server not started")
 	}
 	r.server.response.Close()
+	if r.server.tlsEnabled {
+		return r.server.stopTLS()
+	}
+
 	err := r.server.engine.Stop(context.Background())
 
 	if err == gnet_errors.ErrEmptyEngine || err == gnet_errors.ErrEngineInShutdown {
@@ -77,6 +87,11 @@ func (r *RemotePluginServer) Launch() error {
 
 	time.Sleep(time.Millisecond * 100)
 
+	if r.server.tlsEnabled {
+		go r.collectShutdownSignal()
+		return r.server.listenTLS(strings.TrimPrefix(r.server.addr, "tcp://"))
+	}
+
 	err := gnet.Run(
 		r.server, r.server.addr, gnet.WithMulticore(r.server.multicore),
 		gnet.WithNumEventLoop(r.server.numLoops),
@@ -102,7 +117,12 @@ func (s *RemotePluginServer) collectShutdownSignal() {
 }
 
 // NewRemotePluginServer creates a new RemotePluginServer
-func NewRemotePluginServer(config *app.Config, media_transport *media_transport.MediaBucket) *RemotePluginServer {
+// trace_bucket is optional, sessions are recorded into it if set
+func NewRemotePluginServer(
+	config *app.Config,
+	media_bucket *media_transport.MediaBucket,
+	trace_bucket *media_transport.TraceBucket,
+) *RemotePluginServer {
 	addr := fmt.Sprintf(
 		"tcp://%s:%d",
 		config.PluginRemoteInstallingHost,
@@ -115,7 +135,9 @@ func NewRemotePluginServer(config *app.Config, media_transport *media_transport.
 
 	multicore := true
 	s := &DifyServer{
-		mediaManager: media_transport,
+		mediaManager: media_bucket,
+		traceBucket:  trace_bucket,
+		traceMaxSize: config.PluginRemoteDebuggingTraceMaxSize,
 		addr:         addr,
 		port:         config.PluginRemoteInstallingPort,
 		multicore:    multicore,
@@ -127,7 +149,16 @@ func NewRemotePluginServer(config *app.Config, media_transport *media_transport.
 
 		shutdownChan: make(chan bool),
 
-		maxConn: int32(config.PluginRemoteInstallingMaxConn),
+		maxConn:             int32(config.PluginRemoteInstallingMaxConn),
+		maxSingleTenantConn: config.PluginRemoteInstallingMaxSingleTenantConn,
+
+		tlsEnabled:  config.PluginRemoteInstallingTLSEnabled,
+		tlsCertFile: config.PluginRemoteInstallingTLSCertFile,
+		tlsKeyFile:  config.PluginRemoteInstallingTLSKeyFile,
+	}
+
+	if config.PluginRemoteInstallingTokenEnabled {
+		s.tokenSecret = []byte(config.PluginRemoteInstallingTokenSecret)
 	}
 
 	manager := &RemotePluginServer{
@@ -1,14 +1,28 @@
 package debugging_runtime
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	cloudoss "github.com/langgenius/dify-cloud-kit/oss"
 	"github.com/langgenius/dify-cloud-kit/oss/factory"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/media_transport"
@@ -75,7 +89,7 @@ func preparePluginServer(t *testing.T) (*RemotePluginServer, uint16) {
 		PluginRemoteInstallingPort:             port,
 		PluginRemoteInstallingMaxConn:          1,
 		PluginRemoteInstallServerEventLoopNums: 8,
-	}, media_transport.NewAssetsBucket(oss, "assets", 10)), port
+	}, media_transport.NewAssetsBucket(oss, "assets", 10), nil), port
 }
 
 // TestLaunchAndClosePluginServer tests the launch and close of the plugin server
@@ -429,3 +443,268 @@ func TestIncorrectHandshake(t *testing.T) {
 		return
 	}
 }
+
+func createSelfSignedCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSIncorrectHandshake(t *testing.T) {
+	port, err := network.GetRandomPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := createSelfSignedCertificate(t, t.TempDir())
+
+	server := NewRemotePluginServer(&app.Config{
+		PluginRemoteInstallingHost:             "127.0.0.1",
+		PluginRemoteInstallingPort:             port,
+		PluginRemoteInstallingMaxConn:          1,
+		PluginRemoteInstallServerEventLoopNums: 8,
+		PluginRemoteInstallingTLSEnabled:       true,
+		PluginRemoteInstallingTLSCertFile:      certFile,
+		PluginRemoteInstallingTLSKeyFile:       keyFile,
+		PluginRemoteInstallingTokenEnabled:     true,
+		PluginRemoteInstallingTokenSecret:      "a-secret-which-is-long-enough-for-hmac",
+	}, nil, nil)
+	defer server.Stop()
+	go func() {
+		server.Launch()
+	}()
+
+	// wait for the server to start
+	time.Sleep(time.Second * 1)
+
+	// plain tcp clients can not talk to the tls listener
+	plainConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("failed to connect to plugin server: %s", err.Error())
+	}
+	plainConn.Write([]byte("hello world\n"))
+	plainConn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if content, _ := io.ReadAll(plainConn); strings.Contains(string(content), "handshake failed") {
+		t.Fatalf("plain tcp connection should not reach the handshake")
+	}
+	plainConn.Close()
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to plugin server: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// send a token signed by another secret
+	token, _, err := IssueDebuggingToken(
+		[]byte("another-secret-which-is-long-enough"),
+		ConnectionInfo{TenantId: uuid.New().String()},
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write(parser.MarshalJsonBytes(plugin_entities.RemotePluginRegisterPayload{
+		Type: plugin_entities.REGISTER_EVENT_TYPE_HAND_SHAKE,
+		Data: parser.MarshalJsonBytes(plugin_entities.RemotePluginRegisterHandshake{
+			Key: token,
+		}),
+	}))
+	conn.Write([]byte("\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	content, _ := io.ReadAll(conn)
+	if !strings.Contains(string(content), "handshake failed, invalid key") {
+		t.Fatalf("failed to detect invalid token, got: %s", string(content))
+	}
+}
+
+func TestWebSocketIncorrectHandshake(t *testing.T) {
+	server := NewRemotePluginServer(&app.Config{
+		PluginRemoteInstallingMaxConn:      1,
+		PluginRemoteInstallingTokenEnabled: true,
+		PluginRemoteInstallingTokenSecret:  "a-secret-which-is-long-enough-for-hmac",
+	}, nil, nil)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.ServeWebSocket(w, r)
+	}))
+	defer httpServer.Close()
+
+	upgradeToken, _, err := IssueDebuggingToken(
+		[]byte("a-secret-which-is-long-enough-for-hmac"),
+		ConnectionInfo{TenantId: uuid.New().String()},
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(httpServer.URL, "http"),
+		http.Header{HEADER_DEBUGGING_KEY: []string{upgradeToken}},
+	)
+	if err != nil {
+		t.Fatalf("failed to connect to plugin server: %s", err.Error())
+	}
+	defer conn.Close()
+
+	token, _, err := IssueDebuggingToken(
+		[]byte("another-secret-which-is-long-enough"),
+		ConnectionInfo{TenantId: uuid.New().String()},
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := parser.MarshalJsonBytes(plugin_entities.RemotePluginRegisterPayload{
+		Type: plugin_entities.REGISTER_EVENT_TYPE_HAND_SHAKE,
+		Data: parser.MarshalJsonBytes(plugin_entities.RemotePluginRegisterHandshake{
+			Key: token,
+		}),
+	})
+
+	// a single message split into multiple frames
+	conn.WriteMessage(websocket.TextMessage, handshake[:10])
+	conn.WriteMessage(websocket.TextMessage, append(handshake[10:], '\n'))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read message: %s", err.Error())
+	}
+
+	if !strings.Contains(string(message), "handshake failed, invalid key") {
+		t.Fatalf("failed to detect invalid token, got: %s", string(message))
+	}
+}
+
+func TestWebSocketUnauthenticatedUpgrade(t *testing.T) {
+	server := NewRemotePluginServer(&app.Config{
+		PluginRemoteInstallingMaxConn:      1,
+		PluginRemoteInstallingTokenEnabled: true,
+		PluginRemoteInstallingTokenSecret:  "a-secret-which-is-long-enough-for-hmac",
+	}, nil, nil)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := server.ServeWebSocket(w, r); errors.Is(err, ErrUnauthenticatedDebuggingConnection) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer httpServer.Close()
+
+	token, _, err := IssueDebuggingToken(
+		[]byte("another-secret-which-is-long-enough"),
+		ConnectionInfo{TenantId: uuid.New().String()},
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, header := range map[string]http.Header{
+		"no key":      nil,
+		"invalid key": {HEADER_DEBUGGING_KEY: []string{token}},
+	} {
+		conn, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), header)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("%s: upgrade should be rejected", name)
+		}
+
+		if response == nil || response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %v", name, response)
+		}
+	}
+}
+
+func TestTLSStopClosesConnections(t *testing.T) {
+	port, err := network.GetRandomPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := createSelfSignedCertificate(t, t.TempDir())
+
+	server := NewRemotePluginServer(&app.Config{
+		PluginRemoteInstallingHost:        "127.0.0.1",
+		PluginRemoteInstallingPort:        port,
+		PluginRemoteInstallingMaxConn:     1,
+		PluginRemoteInstallingTLSEnabled:  true,
+		PluginRemoteInstallingTLSCertFile: certFile,
+		PluginRemoteInstallingTLSKeyFile:  keyFile,
+	}, nil, nil)
+
+	launched := make(chan error)
+	go func() {
+		launched <- server.Launch()
+	}()
+
+	// wait for the server to start
+	time.Sleep(time.Second * 1)
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to plugin server: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// wait for the connection to be accepted
+	time.Sleep(time.Millisecond * 100)
+
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-launched:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("listener should be closed after stop")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("connection should be closed after stop, got %v", err)
+	}
+}
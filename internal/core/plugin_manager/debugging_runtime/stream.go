@@ -0,0 +1,106 @@
+package debugging_runtime
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+)
+
+// listenTLS starts a blocking tls listener, it returns once the listener is closed
+func (s *DifyServer) listenTLS(addr string) error {
+	certificate, err := tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+	if err != nil {
+		return err
+	}
+
+	listener, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.listenerLock.Lock()
+	if s.stopped {
+		// stopped before the listener is ready
+		s.listenerLock.Unlock()
+		return listener.Close()
+	}
+	s.listener = listener
+	s.listenerConn = make(map[net.Conn]struct{})
+	s.listenerLock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Error("accept debugging connection failed: %s", err.Error())
+			continue
+		}
+
+		s.listenerLock.Lock()
+		if s.stopped {
+			s.listenerLock.Unlock()
+			conn.Close()
+			continue
+		}
+		s.listenerConn[conn] = struct{}{}
+		s.listenerLock.Unlock()
+
+		go func() {
+			defer func() {
+				s.listenerLock.Lock()
+				delete(s.listenerConn, conn)
+				s.listenerLock.Unlock()
+			}()
+			s.serveStreamConn(newStreamConn(conn), conn)
+		}()
+	}
+}
+
+// stopTLS closes the tls listener and the connections accepted by it
+func (s *DifyServer) stopTLS() error {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+
+	s.stopped = true
+	for conn := range s.listenerConn {
+		conn.Close()
+	}
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// serveStreamConn reads newline-delimited messages from a blocking connection
+// and feeds them to the same handlers as the gnet engine
+func (s *DifyServer) serveStreamConn(conn remoteConn, reader io.Reader) {
+	connId := int(-atomic.AddInt64(&s.streamConnId, 1))
+	runtime := s.openConnection(conn, connId)
+	defer s.closeConnection(connId)
+	defer conn.Close()
+
+	bufReader := bufio.NewReader(reader)
+	for {
+		line, err := bufReader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > 0 && err == nil {
+			s.onMessage(runtime, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
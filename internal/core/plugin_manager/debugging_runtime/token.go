@@ -0,0 +1,96 @@
+package debugging_runtime
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+/*
+ * Debugging tokens are an alternative to the random key stored in redis.
+ * A token carries the tenant and the expiry, signed by the daemon, so it can be verified
+ * without any lookup and becomes useless once expired.
+ *
+ * dbg.$base64(claims).$base64(hmac_sha256(secret, $base64(claims)))
+ * */
+
+const (
+	DEBUGGING_TOKEN_PREFIX = "dbg."
+)
+
+var (
+	ErrInvalidDebuggingToken = errors.New("invalid debugging token")
+	ErrExpiredDebuggingToken = errors.New("debugging token expired")
+)
+
+type debuggingTokenClaims struct {
+	TenantId  string `json:"tenant_id"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func signDebuggingToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueDebuggingToken returns a signed token bound to the tenant which expires after ttl
+func IssueDebuggingToken(secret []byte, info ConnectionInfo, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims, err := json.Marshal(debuggingTokenClaims{
+		TenantId:  info.TenantId,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return DEBUGGING_TOKEN_PREFIX + payload + "." + signDebuggingToken(secret, payload), expiresAt, nil
+}
+
+// IsDebuggingToken returns true if the key is a signed token instead of a random key
+func IsDebuggingToken(key string) bool {
+	return strings.HasPrefix(key, DEBUGGING_TOKEN_PREFIX)
+}
+
+// VerifyDebuggingToken verifies the signature and the expiry of the token
+func VerifyDebuggingToken(secret []byte, token string) (*ConnectionInfo, error) {
+	if !IsDebuggingToken(token) {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, DEBUGGING_TOKEN_PREFIX), ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	expected := signDebuggingToken(secret, parts[0])
+	if !hmac.Equal([]byte(expected), []byte(parts[1])) {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	var claims debuggingTokenClaims
+	if err := json.Unmarshal(claimsBytes, &claims); err != nil {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	if claims.TenantId == "" {
+		return nil, ErrInvalidDebuggingToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredDebuggingToken
+	}
+
+	return &ConnectionInfo{TenantId: claims.TenantId}, nil
+}
@@ -0,0 +1,63 @@
+package debugging_runtime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebuggingToken(t *testing.T) {
+	secret := []byte("a-secret-which-is-long-enough-for-hmac")
+
+	token, expiresAt, err := IssueDebuggingToken(secret, ConnectionInfo{TenantId: "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsDebuggingToken(token) {
+		t.Fatalf("token should be recognized: %s", token)
+	}
+
+	if time.Until(expiresAt) > time.Minute {
+		t.Fatalf("unexpected expiry: %v", expiresAt)
+	}
+
+	info, err := VerifyDebuggingToken(secret, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.TenantId != "abc" {
+		t.Fatalf("tenant id not matched: %s", info.TenantId)
+	}
+
+	// signed by another secret
+	if _, err := VerifyDebuggingToken([]byte("another-secret-which-is-long-enough"), token); err != ErrInvalidDebuggingToken {
+		t.Fatalf("token signed by another secret should be rejected, got %v", err)
+	}
+
+	// tampered claims
+	parts := strings.Split(token, ".")
+	parts[1] = parts[1][:len(parts[1])-2] + "AA"
+	if _, err := VerifyDebuggingToken(secret, strings.Join(parts, ".")); err != ErrInvalidDebuggingToken {
+		t.Fatalf("tampered token should be rejected, got %v", err)
+	}
+
+	// random key
+	if _, err := VerifyDebuggingToken(secret, "b5a2ab4c-5f3f-4c6b-9a0e-4f4a1d3d2c1b"); err != ErrInvalidDebuggingToken {
+		t.Fatalf("random key should be rejected, got %v", err)
+	}
+}
+
+func TestExpiredDebuggingToken(t *testing.T) {
+	secret := []byte("a-secret-which-is-long-enough-for-hmac")
+
+	token, _, err := IssueDebuggingToken(secret, ConnectionInfo{TenantId: "abc"}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyDebuggingToken(secret, token); err != ErrExpiredDebuggingToken {
+		t.Fatalf("expired token should be rejected, got %v", err)
+	}
+}
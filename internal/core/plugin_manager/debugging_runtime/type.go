@@ -9,7 +9,6 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/stream"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
-	"github.com/panjf2000/gnet/v2"
 )
 
 type pluginRuntimeMode string
@@ -21,9 +20,13 @@ type RemotePluginRuntime struct {
 	plugin_entities.PluginRuntime
 
 	// connection
-	conn   gnet.Conn
+	conn   remoteConn
 	closed int32
 
+	// unique id of the connection, used to disconnect it manually
+	connectionId string
+	connectedAt  time.Time
+
 	// response entity to accept new events
 	response *stream.Stream[[]byte]
 
@@ -38,13 +41,14 @@ type RemotePluginRuntime struct {
 	// channel to notify all waiting routines
 	shutdownChan chan bool
 
-	// heartbeat
-	lastActiveAt time.Time
+	// heartbeat, unix nano, accessed atomically
+	lastActiveAt int64
 
 	assets      map[string]*bytes.Buffer
 	assetsBytes int64
 
 	// hand shake process completed
+	// handshake, tenantId, initialized and tenantSlot are written with the plugins lock of the server held
 	handshake       bool
 	handshakeFailed bool
 
@@ -63,6 +67,9 @@ type RemotePluginRuntime struct {
 	// tenant id
 	tenantId string
 
+	// counted in the connections of the tenant across the cluster
+	tenantSlot bool
+
 	alive bool
 
 	// checksum
@@ -71,6 +78,9 @@ type RemotePluginRuntime struct {
 	// installation id
 	installationId string
 
+	// recorder of the session traffic, nil if recording is disabled
+	recorder *traceRecorder
+
 	// wait for started event
 	waitChanLock         sync.Mutex
 	waitStartedChan      []chan bool
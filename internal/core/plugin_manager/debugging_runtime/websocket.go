@@ -0,0 +1,133 @@
+package debugging_runtime
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+)
+
+/*
+ * WebSocket transport for remote debugging.
+ *
+ * Developers behind HTTP proxies are not able to reach the raw TCP port, so the same
+ * newline-delimited protocol is also served over a WebSocket on the main http server.
+ * Frames are treated as a byte stream, a message may span multiple frames and a frame
+ * may contain multiple messages.
+ *
+ * The route sits outside of the server key group, the debugging key is its only authentication.
+ * It's sent on the upgrade request as X-Dify-Debugging-Key and requests without a valid key are
+ * rejected before the upgrade, the key in the handshake message is verified afterwards as usual.
+ * */
+
+// HEADER_DEBUGGING_KEY carries the debugging key on the upgrade request
+const HEADER_DEBUGGING_KEY = "X-Dify-Debugging-Key"
+
+var ErrUnauthenticatedDebuggingConnection = errors.New("missing or invalid debugging key")
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// plugins are not browsers, the connection is authenticated by the debugging key
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// wsConn wraps a websocket connection as a remoteConn, frames are written by a writer goroutine
+type wsConn struct {
+	conn  *websocket.Conn
+	queue *writeQueue
+}
+
+func newWsConn(conn *websocket.Conn) *wsConn {
+	c := &wsConn{conn: conn}
+	c.queue = newWriteQueue(func(data []byte) error {
+		c.conn.SetWriteDeadline(time.Now().Add(STREAM_WRITE_TIMEOUT))
+		return c.conn.WriteMessage(websocket.TextMessage, data)
+	}, func() {
+		c.conn.Close()
+	})
+	return c
+}
+
+func (c *wsConn) Write(data []byte) (int, error) {
+	return c.queue.Write(data)
+}
+
+func (c *wsConn) AsyncWrite(data []byte) {
+	c.queue.AsyncWrite(data)
+}
+
+func (c *wsConn) Close() error {
+	c.queue.close()
+	// WriteControl is safe to be called concurrently with the writer goroutine
+	c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second),
+	)
+	return c.conn.Close()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// wsReader concatenates websocket frames into a byte stream
+type wsReader struct {
+	conn    *websocket.Conn
+	current io.Reader
+}
+
+func (r *wsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			_, reader, err := r.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			r.current = reader
+		}
+
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// ServeWebSocket upgrades the request and serves the debugging protocol until the connection is closed
+// ErrUnauthenticatedDebuggingConnection is returned before upgrading if the key is missing or invalid
+func (r *RemotePluginServer) ServeWebSocket(w http.ResponseWriter, req *http.Request) error {
+	key := req.Header.Get(HEADER_DEBUGGING_KEY)
+	if key == "" {
+		return ErrUnauthenticatedDebuggingConnection
+	}
+
+	if _, err := r.server.getConnectionInfo(key); err == cache.ErrNotFound ||
+		errors.Is(err, ErrInvalidDebuggingToken) ||
+		errors.Is(err, ErrExpiredDebuggingToken) {
+		return ErrUnauthenticatedDebuggingConnection
+	} else if err != nil {
+		return err
+	}
+
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return err
+	}
+
+	r.server.serveStreamConn(newWsConn(conn), &wsReader{conn: conn})
+	return nil
+}
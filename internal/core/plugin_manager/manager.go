@@ -36,6 +36,9 @@ type PluginManager struct {
 	// installedBucket is used to manage installed plugins, all the installed plugins will be saved here
 	installedBucket *media_transport.InstalledBucket
 
+	// traceBucket is used to store traces of remote debugging sessions, nil if recording is disabled
+	traceBucket *media_transport.TraceBucket
+
 	// register plugin
 	pluginRegisters []func(lifetime plugin_entities.PluginLifetime) error
 
@@ -79,6 +82,13 @@ func InitGlobalManager(oss oss.OSS, configuration *app.Config) *PluginManager {
 		config:           configuration,
 	}
 
+	if configuration.PluginRemoteDebuggingTraceEnabled {
+		manager.traceBucket = media_transport.NewTraceBucket(
+			oss,
+			configuration.PluginRemoteDebuggingTracePath,
+		)
+	}
+
 	return manager
 }
 
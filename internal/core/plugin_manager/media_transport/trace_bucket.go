@@ -0,0 +1,53 @@
+package media_transport
+
+import (
+	"path"
+	"strings"
+
+	"github.com/langgenius/dify-cloud-kit/oss"
+)
+
+// TraceBucket stores the traces of remote debugging sessions, grouped by tenant
+type TraceBucket struct {
+	oss       oss.OSS
+	tracePath string
+}
+
+func NewTraceBucket(oss oss.OSS, trace_path string) *TraceBucket {
+	return &TraceBucket{oss: oss, tracePath: trace_path}
+}
+
+func (b *TraceBucket) Save(tenant_id string, trace_id string, trace []byte) error {
+	return b.oss.Save(path.Join(b.tracePath, tenant_id, trace_id), trace)
+}
+
+func (b *TraceBucket) Get(tenant_id string, trace_id string) ([]byte, error) {
+	return b.oss.Load(path.Join(b.tracePath, tenant_id, trace_id))
+}
+
+func (b *TraceBucket) Delete(tenant_id string, trace_id string) error {
+	return b.oss.Delete(path.Join(b.tracePath, tenant_id, trace_id))
+}
+
+// List lists the ids of all the traces of the tenant
+func (b *TraceBucket) List(tenant_id string) ([]string, error) {
+	prefix := path.Join(b.tracePath, tenant_id)
+	paths, err := b.oss.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if p.IsDir {
+			continue
+		}
+		id := strings.TrimPrefix(strings.TrimPrefix(p.Path, prefix), "/")
+		if id == "" || strings.HasPrefix(id, ".") {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
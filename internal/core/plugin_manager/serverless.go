@@ -7,6 +7,7 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache/helper"
@@ -53,6 +54,18 @@ func (p *PluginManager) getServerlessPluginRuntime(
 		LambdaURL:                 model.FunctionURL,
 		LambdaName:                model.FunctionName,
 		PluginMaxExecutionTimeout: p.config.PluginMaxExecutionTimeout,
+		RetryMaxAttempts:          p.config.PluginServerlessRetryMaxAttempts,
+		RetryBackoff:              time.Duration(p.config.PluginServerlessRetryBackoff) * time.Millisecond,
+		RetryMaxBackoff:           time.Duration(p.config.PluginServerlessRetryMaxBackoff) * time.Millisecond,
+
+		CircuitBreakerFailureThreshold: p.config.PluginServerlessCircuitBreakerFailureThreshold,
+		CircuitBreakerOpenTimeout:      time.Duration(p.config.PluginServerlessCircuitBreakerOpenTimeout) * time.Second,
+
+		Transport: serverless_runtime.Transport(p.config.PluginServerlessTransport),
+	}
+
+	if p.config.PluginServerlessKeepAliveEnabled {
+		pluginRuntime.KeepAliveTrafficWindow = time.Duration(p.config.PluginServerlessKeepAliveTrafficWindow) * time.Second
 	}
 
 	if err := pluginRuntime.InitEnvironment(); err != nil {
@@ -101,3 +114,29 @@ func (p *PluginManager) clearServerlessRuntimeCache(
 	_, err := cache.Del(p.getServerlessRuntimeCacheKey(identity))
 	return err
 }
+
+// LaunchServerlessKeepAlive starts pinging the serverless functions to avoid cold starts,
+// isMaster decides whether the current node is responsible for it, it's a no-op on other platforms
+func (p *PluginManager) LaunchServerlessKeepAlive(isMaster func() bool) error {
+	if p.config.Platform != app.PLATFORM_SERVERLESS {
+		return nil
+	}
+
+	scheduler, err := serverless_runtime.NewKeepAliveScheduler(serverless_runtime.KeepAliveConfig{
+		Interval:      time.Duration(p.config.PluginServerlessKeepAliveInterval) * time.Second,
+		Path:          p.config.PluginServerlessKeepAlivePath,
+		BusinessHours: p.config.PluginServerlessKeepAliveBusinessHours,
+		BusinessDays:  p.config.PluginServerlessKeepAliveBusinessDays,
+		Timezone:      p.config.PluginServerlessKeepAliveTimezone,
+		TrafficWindow: time.Duration(p.config.PluginServerlessKeepAliveTrafficWindow) * time.Second,
+		Concurrency:   p.config.PluginServerlessKeepAliveConcurrency,
+	}, isMaster, func() ([]models.ServerlessRuntime, error) {
+		return db.GetAll[models.ServerlessRuntime]()
+	})
+	if err != nil {
+		return err
+	}
+
+	scheduler.Launch()
+	return nil
+}
@@ -0,0 +1,18 @@
+package serverless
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HEADER_FUNCTION_TOKEN authenticates invocations of functions launched by the connector
+const HEADER_FUNCTION_TOKEN = "X-Dify-Function-Token"
+
+// FunctionToken derives the token of function invocations from the api key of the connector,
+// functions may run plugin code, so they are never handed the api key itself
+func FunctionToken(apiKey string) string {
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte("function-invoke"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,133 @@
+package serverless_runtime
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+var ErrCircuitBreakerOpen = errors.New("circuit breaker is open, the function is considered unhealthy")
+
+// circuitBreaker tracks the health of a function
+//
+//   - closed: invocations go through, it opens after `threshold` continuous failures
+//   - open: invocations are rejected until `openTimeout` passes, then it becomes half open
+//   - half open: a single probe invocation goes through, it closes on success and reopens on failure
+type circuitBreaker struct {
+	lock sync.Mutex
+
+	threshold   int
+	openTimeout time.Duration
+
+	status    plugin_entities.CircuitBreakerStatus
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+// breakers mapping function url to the circuit breaker,
+// runtimes are created for every invocation so the breakers have to outlive them
+var breakers mapping.Map[string, *circuitBreaker]
+
+// getCircuitBreaker returns the breaker of the function, the threshold and the open timeout
+// of the runtime take effect on the shared breaker as the config of a function may change
+func getCircuitBreaker(functionURL string, threshold int, openTimeout time.Duration) *circuitBreaker {
+	breaker, loaded := breakers.LoadOrStore(functionURL, &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		status:      plugin_entities.CIRCUIT_BREAKER_STATUS_CLOSED,
+	})
+	if loaded {
+		breaker.configure(threshold, openTimeout)
+	}
+	return breaker
+}
+
+func (b *circuitBreaker) configure(threshold int, openTimeout time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.threshold = threshold
+	b.openTimeout = openTimeout
+}
+
+// allow checks if an invocation is allowed, the caller must report the result
+// through success or failure once it's allowed
+func (b *circuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.status {
+	case plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return ErrCircuitBreakerOpen
+		}
+		b.status = plugin_entities.CIRCUIT_BREAKER_STATUS_HALF_OPEN
+		b.probing = true
+		return nil
+	case plugin_entities.CIRCUIT_BREAKER_STATUS_HALF_OPEN:
+		// only one probe at a time
+		if b.probing {
+			return ErrCircuitBreakerOpen
+		}
+		b.probing = true
+		return nil
+	}
+
+	return nil
+}
+
+func (b *circuitBreaker) success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.status = plugin_entities.CIRCUIT_BREAKER_STATUS_CLOSED
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+
+	if b.status == plugin_entities.CIRCUIT_BREAKER_STATUS_HALF_OPEN || b.failures >= b.threshold {
+		b.status = plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN
+		b.openedAt = time.Now()
+	}
+	b.probing = false
+}
+
+func (b *circuitBreaker) state() plugin_entities.CircuitBreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state := plugin_entities.CircuitBreakerState{
+		Status:              b.status,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+
+	if b.status != plugin_entities.CIRCUIT_BREAKER_STATUS_CLOSED {
+		openedAt := b.openedAt
+		state.OpenedAt = &openedAt
+	}
+
+	return state
+}
+
+// CircuitBreakerStates returns the state of all the tracked functions, mapping function url to the state
+func CircuitBreakerStates() map[string]plugin_entities.CircuitBreakerState {
+	states := map[string]plugin_entities.CircuitBreakerState{}
+	breakers.Range(func(functionURL string, breaker *circuitBreaker) bool {
+		states[functionURL] = breaker.state()
+		return true
+	})
+	return states
+}
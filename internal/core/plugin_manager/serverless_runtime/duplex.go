@@ -0,0 +1,175 @@
+package serverless_runtime
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+/*
+ Full duplex invocations of serverless functions.
+
+ With TRANSPORT_WEBSOCKET, a session is served through `GET <function_url>/invoke?action=` upgraded
+ to a WebSocket instead of `POST <function_url>/invoke?action=`:
+
+ - the daemon sends the request as the first text frame, the same payload as the http body
+ - the function sends events as text frames, one PluginUniversalEvent per frame
+ - backwards invocations of the plugin are answered with `backwards_response` frames on the same stream,
+   so they flow through the same InvokeDify writer as local plugins
+ - the function closes the stream once the session ends
+
+ Functions which do not support it fall back to the http transport, where backwards invocations
+ go through the `/backwards-invocation/transaction` endpoint.
+*/
+
+type Transport string
+
+const (
+	TRANSPORT_HTTP      Transport = "http"
+	TRANSPORT_WEBSOCKET Transport = "websocket"
+)
+
+const (
+	// functions could be redeployed with websocket support, check it again after a while
+	WEBSOCKET_UNSUPPORTED_TTL = 10 * time.Minute
+
+	WEBSOCKET_HANDSHAKE_TIMEOUT = 30 * time.Second
+	WEBSOCKET_WRITE_TIMEOUT     = 30 * time.Second
+	WEBSOCKET_MAX_MESSAGE_SIZE  = 5 * 1024 * 1024
+)
+
+// websocketUnsupported mapping function url to the time it's found not supporting websocket
+var websocketUnsupported mapping.Map[string, time.Time]
+
+type duplexConn struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (c *duplexConn) write(data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(WEBSOCKET_WRITE_TIMEOUT))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// IsFullDuplex returns true if the session is held by a bidirectional stream
+func (r *ServerlessPluginRuntime) IsFullDuplex(session_id string) bool {
+	_, ok := r.duplexSessions.Load(session_id)
+	return ok
+}
+
+func websocketURL(invokeURL string, action access_types.PluginAccessAction) (string, error) {
+	u, err := url.Parse(invokeURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	u.RawQuery = url.Values{"action": {string(action)}}.Encode()
+	return u.String(), nil
+}
+
+// invokeFullDuplex serves the session through a websocket, returns false if the stream could not be
+// established, the caller falls back to http which takes care of retries and the circuit breaker
+func (r *ServerlessPluginRuntime) invokeFullDuplex(
+	invokeURL string,
+	sessionId string,
+	action access_types.PluginAccessAction,
+	data []byte,
+	l *entities.Broadcast[plugin_entities.SessionMessage],
+) bool {
+	if at, ok := websocketUnsupported.Load(r.LambdaURL); ok && time.Since(at) < WEBSOCKET_UNSUPPORTED_TTL {
+		return false
+	}
+
+	wsURL, err := websocketURL(invokeURL, action)
+	if err != nil {
+		return false
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: WEBSOCKET_HANDSHAKE_TIMEOUT,
+	}
+	conn, response, err := dialer.Dial(wsURL, http.Header{
+		"Dify-Plugin-Session-ID":         []string{sessionId},
+		serverless.HEADER_FUNCTION_TOKEN: []string{serverless.FunctionToken(serverless.SERVERLESS_CONNECTOR_API_KEY)},
+	})
+	if err != nil {
+		if response != nil {
+			response.Body.Close()
+			switch response.StatusCode {
+			case http.StatusBadRequest,
+				http.StatusNotFound,
+				http.StatusMethodNotAllowed,
+				http.StatusUpgradeRequired:
+				websocketUnsupported.Store(r.LambdaURL, time.Now())
+			}
+		}
+		return false
+	}
+	defer conn.Close()
+
+	r.breaker.success()
+
+	duplex := &duplexConn{conn: conn}
+	r.duplexSessions.Store(sessionId, duplex)
+	defer r.duplexSessions.Delete(sessionId)
+
+	sendError := func(message string) {
+		l.Send(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
+			Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
+				ErrorType: "PluginDaemonInnerError",
+				Message:   message,
+			}),
+		})
+	}
+
+	if err := duplex.write(data); err != nil {
+		sendError(fmt.Sprintf("Error sending request to serverless function: %v", err))
+		return true
+	}
+
+	conn.SetReadLimit(WEBSOCKET_MAX_MESSAGE_SIZE)
+	conn.SetReadDeadline(time.Now().Add(time.Duration(r.PluginMaxExecutionTimeout) * time.Second))
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				sendError(fmt.Sprintf("failed to read from serverless function: %v", err))
+			}
+			return true
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		if !dispatchEvent(l, message, "websocket") {
+			return true
+		}
+	}
+}
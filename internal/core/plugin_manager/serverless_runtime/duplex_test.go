@@ -0,0 +1,122 @@
+package serverless_runtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+func sessionEvent(message plugin_entities.SessionMessage) []byte {
+	return parser.MarshalJsonBytes(plugin_entities.PluginUniversalEvent{
+		SessionId: "session",
+		Event:     plugin_entities.PLUGIN_EVENT_SESSION,
+		Data:      parser.MarshalJsonBytes(message),
+	})
+}
+
+func TestWriteFullDuplex(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("action") != string(access_types.PLUGIN_ACCESS_ACTION_INVOKE_TOOL) {
+			t.Errorf("unexpected action %s", r.URL.Query().Get("action"))
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, request, err := conn.ReadMessage(); err != nil || string(request) != "{}" {
+			t.Errorf("unexpected request %s, err: %v", request, err)
+			return
+		}
+
+		// ask for a backwards invocation and wait for the response on the same stream
+		conn.WriteMessage(websocket.TextMessage, sessionEvent(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_INVOKE,
+			Data: []byte(`{"type":"tool"}`),
+		}))
+		_, response, err := conn.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn.WriteMessage(websocket.TextMessage, sessionEvent(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_STREAM,
+			Data: response,
+		}))
+		conn.WriteMessage(websocket.TextMessage, sessionEvent(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_END,
+			Data: []byte("null"),
+		}))
+	}))
+	defer server.Close()
+
+	runtime := newTestRuntime(t, server.URL)
+	runtime.Transport = TRANSPORT_WEBSOCKET
+
+	messages := []plugin_entities.SessionMessage{}
+	done := make(chan bool)
+
+	listener := runtime.Listen("session")
+	listener.Listen(func(message plugin_entities.SessionMessage) {
+		messages = append(messages, message)
+		if message.Type == plugin_entities.SESSION_MESSAGE_TYPE_INVOKE {
+			if !runtime.IsFullDuplex("session") {
+				t.Error("session should be full duplex")
+			}
+			runtime.Write("session", access_types.PLUGIN_ACCESS_ACTION_INVOKE_TOOL, []byte(`{"event":"backwards_response"}`))
+		}
+	})
+	listener.OnClose(func() {
+		close(done)
+	})
+
+	runtime.Write("session", access_types.PLUGIN_ACCESS_ACTION_INVOKE_TOOL, []byte("{}"))
+	<-done
+
+	if len(messages) < 3 {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	if messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_INVOKE ||
+		string(messages[1].Data) != `{"event":"backwards_response"}` ||
+		messages[2].Type != plugin_entities.SESSION_MESSAGE_TYPE_END {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	if runtime.IsFullDuplex("session") {
+		t.Fatal("session should be released once it ends")
+	}
+}
+
+func TestWriteFullDuplexFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeSessionMessage(w)
+	}))
+	defer server.Close()
+
+	runtime := newTestRuntime(t, server.URL)
+	runtime.Transport = TRANSPORT_WEBSOCKET
+	t.Cleanup(func() { websocketUnsupported.Delete(server.URL) })
+
+	messages := invoke(runtime)
+	if len(messages) == 0 || messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_STREAM {
+		t.Fatalf("expected to fall back to http, got %v", messages)
+	}
+
+	at, ok := websocketUnsupported.Load(server.URL)
+	if !ok || time.Since(at) > time.Minute {
+		t.Fatal("function should be marked as not supporting websocket")
+	}
+}
@@ -29,6 +29,14 @@ func (r *ServerlessPluginRuntime) InitEnvironment() error {
 		},
 	}
 
+	if r.RetryMaxAttempts < 1 {
+		r.RetryMaxAttempts = 1
+	}
+	if r.CircuitBreakerFailureThreshold < 1 {
+		r.CircuitBreakerFailureThreshold = 1
+	}
+	r.breaker = getCircuitBreaker(r.LambdaURL, r.CircuitBreakerFailureThreshold, r.CircuitBreakerOpenTimeout)
+
 	return nil
 }
 
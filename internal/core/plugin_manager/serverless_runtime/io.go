@@ -3,11 +3,19 @@ package serverless_runtime
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	serverless "github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_connector"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/http_requests"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
@@ -24,7 +32,16 @@ func (r *ServerlessPluginRuntime) Listen(sessionId string) *entities.Broadcast[p
 }
 
 // For AWS Lambda, write is equivalent to http request, it's not a normal stream like stdio and tcp
+// unless the session is held by a full duplex stream, see duplex.go
 func (r *ServerlessPluginRuntime) Write(sessionId string, action access_types.PluginAccessAction, data []byte) {
+	// backwards responses go through the stream of the session
+	if conn, ok := r.duplexSessions.Load(sessionId); ok {
+		if err := conn.write(data); err != nil {
+			log.Error("failed to write to full duplex session %s: %s", sessionId, err.Error())
+		}
+		return
+	}
+
 	l, ok := r.listeners.Load(sessionId)
 	if !ok {
 		log.Error("session %s not found", sessionId)
@@ -59,19 +76,32 @@ func (r *ServerlessPluginRuntime) Write(sessionId string, action access_types.Pl
 			Data: []byte(""),
 		})
 
+		// reject the invocation directly if the function is unhealthy
+		if err := r.breaker.allow(); err != nil {
+			l.Send(plugin_entities.SessionMessage{
+				Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
+				Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
+					ErrorType: "PluginDaemonInnerError",
+					Message:   fmt.Sprintf("serverless function %s is unavailable: %v", r.LambdaName, err),
+				}),
+			})
+			return
+		}
+
+		if r.KeepAliveTrafficWindow > 0 {
+			recordTraffic(r.LambdaURL, r.KeepAliveTrafficWindow)
+		}
+
+		// hold a bidirectional stream if possible, backwards invocations flow through it
+		if r.Transport == TRANSPORT_WEBSOCKET && r.invokeFullDuplex(url, sessionId, action, data, l) {
+			return
+		}
+
 		// create a new http request to serverless runtimes
 		url += "?action=" + string(action)
-		response, err := http_requests.Request(
-			r.client, url, "POST",
-			http_requests.HttpHeader(map[string]string{
-				"Content-Type":           "application/json",
-				"Accept":                 "text/event-stream",
-				"Dify-Plugin-Session-ID": sessionId,
-			}),
-			http_requests.HttpPayloadReader(io.NopCloser(bytes.NewReader(data))),
-			http_requests.HttpReadTimeout(int64(r.PluginMaxExecutionTimeout*1000)),
-		)
+		response, err := r.invoke(url, sessionId, data)
 		if err != nil {
+			r.breaker.failure(err)
 			l.Send(plugin_entities.SessionMessage{
 				Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
 				Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
@@ -82,10 +112,32 @@ func (r *ServerlessPluginRuntime) Write(sessionId string, action access_types.Pl
 			r.Error(fmt.Sprintf("Error sending request to aws lambda: %v", err))
 			return
 		}
+		defer response.Body.Close()
+
+		if response.StatusCode >= 400 {
+			body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+			err := fmt.Errorf("function responded with status %s: %s", response.Status, body)
+			// the function is reachable, client errors are not its fault
+			if isUnhealthyStatus(response.StatusCode) {
+				r.breaker.failure(err)
+			} else {
+				r.breaker.success()
+			}
+			l.Send(plugin_entities.SessionMessage{
+				Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
+				Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
+					ErrorType: "PluginDaemonInnerError",
+					Message:   err.Error(),
+				}),
+			})
+			r.Error(err.Error())
+			return
+		}
+
+		r.breaker.success()
 
 		// write to data stream
 		scanner := bufio.NewScanner(response.Body)
-		defer response.Body.Close()
 
 		// TODO: set a reasonable buffer size or use a reader, this is a temporary solution
 		scanner.Buffer(make([]byte, 1024), 5*1024*1024)
@@ -98,35 +150,7 @@ func (r *ServerlessPluginRuntime) Write(sessionId string, action access_types.Pl
 				continue
 			}
 
-			plugin_entities.ParsePluginUniversalEvent(
-				bytes,
-				response.Status,
-				func(session_id string, data []byte) {
-					sessionMessage, err := parser.UnmarshalJsonBytes[plugin_entities.SessionMessage](data)
-					if err != nil {
-						l.Send(plugin_entities.SessionMessage{
-							Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
-							Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
-								ErrorType: "PluginDaemonInnerError",
-								Message:   fmt.Sprintf("failed to parse session message %s, err: %v", bytes, err),
-							}),
-						})
-						sessionAlive = false
-					}
-					l.Send(sessionMessage)
-				},
-				func() {},
-				func(err string) {
-					l.Send(plugin_entities.SessionMessage{
-						Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
-						Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
-							ErrorType: "PluginDaemonInnerError",
-							Message:   fmt.Sprintf("encountered an error: %v", err),
-						}),
-					})
-				},
-				func(message string) {},
-			)
+			sessionAlive = dispatchEvent(l, bytes, response.Status)
 		}
 
 		if err := scanner.Err(); err != nil {
@@ -140,3 +164,142 @@ func (r *ServerlessPluginRuntime) Write(sessionId string, action access_types.Pl
 		}
 	})
 }
+
+// invoke sends the request to the function, failures of connecting and 429/503 responses are retried
+// with backoff, nothing has been streamed to the session yet so it's safe to retry
+func (r *ServerlessPluginRuntime) invoke(url string, sessionId string, data []byte) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		response, err := http_requests.Request(
+			r.client, url, "POST",
+			http_requests.HttpHeader(map[string]string{
+				"Content-Type":                   "application/json",
+				"Accept":                         "text/event-stream",
+				"Dify-Plugin-Session-ID":         sessionId,
+				serverless.HEADER_FUNCTION_TOKEN: serverless.FunctionToken(serverless.SERVERLESS_CONNECTOR_API_KEY),
+			}),
+			http_requests.HttpPayloadReader(io.NopCloser(bytes.NewReader(data))),
+			http_requests.HttpReadTimeout(int64(r.PluginMaxExecutionTimeout*1000)),
+		)
+
+		retryAfter := time.Duration(0)
+		if err != nil {
+			if !isRetriableError(err) || attempt >= r.RetryMaxAttempts {
+				return nil, err
+			}
+		} else {
+			if !isRetriableStatus(response.StatusCode) || attempt >= r.RetryMaxAttempts {
+				return response, nil
+			}
+			retryAfter = parseRetryAfter(response.Header.Get("Retry-After"))
+			io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+			response.Body.Close()
+		}
+
+		backoff := r.backoff(attempt, retryAfter)
+		log.Warn(
+			"serverless function %s is not available, retrying in %s, attempt %d/%d",
+			r.LambdaName, backoff, attempt, r.RetryMaxAttempts,
+		)
+		time.Sleep(backoff)
+	}
+}
+
+// backoff returns the exponential backoff with jitter, Retry-After of the function takes precedence
+func (r *ServerlessPluginRuntime) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	backoff := retryAfter
+	if backoff == 0 {
+		backoff = r.RetryBackoff << (attempt - 1)
+		backoff += time.Duration(rand.Int63n(int64(backoff)/2 + 1))
+	}
+	if r.RetryMaxBackoff > 0 && backoff > r.RetryMaxBackoff {
+		backoff = r.RetryMaxBackoff
+	}
+	return backoff
+}
+
+// isRetriableError checks if the request failed before the function could have handled it,
+// e.g. the function is cold starting or scaled to zero, only failures of connecting are such,
+// resets and EOFs may happen after the function received the request and invoking twice is not safe
+func isRetriableError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func isRetriableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+func isUnhealthyStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// dispatchEvent parses an event of the function and sends it to the session listener,
+// returns false if the session should not be read anymore
+func dispatchEvent(
+	l *entities.Broadcast[plugin_entities.SessionMessage],
+	event []byte,
+	status string,
+) bool {
+	sessionAlive := true
+
+	plugin_entities.ParsePluginUniversalEvent(
+		event,
+		status,
+		func(session_id string, data []byte) {
+			sessionMessage, err := parser.UnmarshalJsonBytes[plugin_entities.SessionMessage](data)
+			if err != nil {
+				l.Send(plugin_entities.SessionMessage{
+					Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
+					Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
+						ErrorType: "PluginDaemonInnerError",
+						Message:   fmt.Sprintf("failed to parse session message %s, err: %v", event, err),
+					}),
+				})
+				sessionAlive = false
+				return
+			}
+			l.Send(sessionMessage)
+
+			// nothing follows the end of the session
+			if sessionMessage.Type == plugin_entities.SESSION_MESSAGE_TYPE_END ||
+				sessionMessage.Type == plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+				sessionAlive = false
+			}
+		},
+		func() {},
+		func(err string) {
+			l.Send(plugin_entities.SessionMessage{
+				Type: plugin_entities.SESSION_MESSAGE_TYPE_ERROR,
+				Data: parser.MarshalJsonBytes(plugin_entities.ErrorResponse{
+					ErrorType: "PluginDaemonInnerError",
+					Message:   fmt.Sprintf("encountered an error: %v", err),
+				}),
+			})
+		},
+		func(message string) {},
+	)
+
+	return sessionAlive
+}
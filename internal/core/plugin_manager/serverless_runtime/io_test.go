@@ -0,0 +1,258 @@
+package serverless_runtime
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+func newTestRuntime(t *testing.T, url string) *ServerlessPluginRuntime {
+	routine.InitPool(1024)
+
+	runtime := &ServerlessPluginRuntime{
+		LambdaURL:                      url,
+		LambdaName:                     "test",
+		PluginMaxExecutionTimeout:      10,
+		RetryMaxAttempts:               3,
+		RetryBackoff:                   time.Millisecond,
+		RetryMaxBackoff:                10 * time.Millisecond,
+		CircuitBreakerFailureThreshold: 2,
+		CircuitBreakerOpenTimeout:      50 * time.Millisecond,
+	}
+	if err := runtime.InitEnvironment(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { breakers.Delete(url) })
+	return runtime
+}
+
+// invoke writes a request and collects the session messages until the session ends
+func invoke(runtime *ServerlessPluginRuntime) []plugin_entities.SessionMessage {
+	messages := []plugin_entities.SessionMessage{}
+	done := make(chan bool)
+
+	listener := runtime.Listen("session")
+	listener.Listen(func(message plugin_entities.SessionMessage) {
+		messages = append(messages, message)
+	})
+	listener.OnClose(func() {
+		close(done)
+	})
+
+	runtime.Write("session", access_types.PLUGIN_ACCESS_ACTION_INVOKE_TOOL, []byte("{}"))
+	<-done
+	return messages
+}
+
+func writeSessionMessage(w http.ResponseWriter) {
+	w.Write(parser.MarshalJsonBytes(plugin_entities.PluginUniversalEvent{
+		SessionId: "session",
+		Event:     plugin_entities.PLUGIN_EVENT_SESSION,
+		Data: parser.MarshalJsonBytes(plugin_entities.SessionMessage{
+			Type: plugin_entities.SESSION_MESSAGE_TYPE_STREAM,
+			Data: []byte(`"ok"`),
+		}),
+	}))
+	w.Write([]byte("\n"))
+}
+
+func TestWriteRetriesUnavailableFunction(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// cold start
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeSessionMessage(w)
+	}))
+	defer server.Close()
+
+	runtime := newTestRuntime(t, server.URL)
+	messages := invoke(runtime)
+
+	if requests.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests.Load())
+	}
+	if len(messages) == 0 || messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_STREAM {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	if state := runtime.RuntimeState().CircuitBreaker; state == nil ||
+		state.Status != plugin_entities.CIRCUIT_BREAKER_STATUS_CLOSED {
+		t.Fatalf("circuit breaker should be closed, got %v", state)
+	}
+}
+
+func TestWriteDoesNotRetryServerErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	messages := invoke(newTestRuntime(t, server.URL))
+
+	if requests.Load() != 1 {
+		t.Fatalf("expected 1 request, got %d", requests.Load())
+	}
+	if len(messages) == 0 || messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+		t.Fatalf("expected an error message, got %v", messages)
+	}
+}
+
+func TestWriteDoesNotRetryResetAfterRequest(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// the function received the request and the connection is gone before it responds
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	messages := invoke(newTestRuntime(t, server.URL))
+
+	if requests.Load() != 1 {
+		t.Fatalf("expected 1 request, got %d", requests.Load())
+	}
+	if len(messages) == 0 || messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+		t.Fatalf("expected an error message, got %v", messages)
+	}
+}
+
+func TestWriteRetriesRefusedConnection(t *testing.T) {
+	// nothing listens on the address of a closed server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	runtime := newTestRuntime(t, server.URL)
+	if _, err := runtime.invoke(server.URL+"/invoke", "session", []byte("{}")); err == nil || !isRetriableError(err) {
+		t.Fatalf("expected a retriable error, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeSessionMessage(w)
+	}))
+	defer server.Close()
+
+	runtime := newTestRuntime(t, server.URL)
+
+	// opens after 2 continuous failures
+	invoke(runtime)
+	invoke(runtime)
+	state := runtime.RuntimeState().CircuitBreaker
+	if state.Status != plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN || state.ConsecutiveFailures != 2 {
+		t.Fatalf("circuit breaker should be open, got %v", state)
+	}
+
+	// rejected without reaching the function
+	messages := invoke(runtime)
+	if requests.Load() != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests.Load())
+	}
+	if len(messages) == 0 || messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_ERROR {
+		t.Fatalf("expected an error message, got %v", messages)
+	}
+
+	// runtimes of the same function share the breaker
+	if newTestRuntime(t, server.URL).RuntimeState().CircuitBreaker.Status != plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN {
+		t.Fatal("circuit breaker should be shared by the same function")
+	}
+
+	// a failed probe reopens it
+	time.Sleep(60 * time.Millisecond)
+	invoke(runtime)
+	if runtime.RuntimeState().CircuitBreaker.Status != plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN {
+		t.Fatal("circuit breaker should be reopened")
+	}
+
+	// a successful probe closes it
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	invoke(runtime)
+	state = runtime.RuntimeState().CircuitBreaker
+	if state.Status != plugin_entities.CIRCUIT_BREAKER_STATUS_CLOSED || state.ConsecutiveFailures != 0 {
+		t.Fatalf("circuit breaker should be closed, got %v", state)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	breaker := &circuitBreaker{
+		threshold:   1,
+		openTimeout: 0,
+		status:      plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN,
+	}
+
+	if err := breaker.allow(); err != nil {
+		t.Fatal("probe should be allowed")
+	}
+	if err := breaker.allow(); err != ErrCircuitBreakerOpen {
+		t.Fatal("only one probe should be allowed")
+	}
+}
+
+func TestCircuitBreakerFollowsConfig(t *testing.T) {
+	url := "http://function.local/circuit-breaker-config"
+	t.Cleanup(func() { breakers.Delete(url) })
+
+	breaker := getCircuitBreaker(url, 5, time.Minute)
+	if getCircuitBreaker(url, 1, time.Second) != breaker {
+		t.Fatal("breaker should be shared by the same function")
+	}
+
+	// the updated threshold opens it after a single failure
+	if err := breaker.allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.failure(errors.New("failed"))
+	if breaker.state().Status != plugin_entities.CIRCUIT_BREAKER_STATUS_OPEN {
+		t.Fatal("breaker should follow the updated threshold")
+	}
+}
+
+func TestWriteInvalidSessionMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(parser.MarshalJsonBytes(plugin_entities.PluginUniversalEvent{
+			SessionId: "session",
+			Event:     plugin_entities.PLUGIN_EVENT_SESSION,
+			Data:      []byte(`"not a session message"`),
+		}))
+		w.Write([]byte("\n"))
+		writeSessionMessage(w)
+	}))
+	defer server.Close()
+
+	// nothing but the error is dispatched before the session ends
+	messages := invoke(newTestRuntime(t, server.URL))
+	if len(messages) != 2 ||
+		messages[0].Type != plugin_entities.SESSION_MESSAGE_TYPE_ERROR ||
+		messages[1].Type != plugin_entities.SESSION_MESSAGE_TYPE_END {
+		t.Fatalf("expected an error message and the end of the session, got %v", messages)
+	}
+}
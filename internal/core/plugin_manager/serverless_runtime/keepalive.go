@@ -0,0 +1,348 @@
+package serverless_runtime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
+)
+
+/*
+ KeepAliveScheduler pings the health route of serverless functions periodically to avoid cold starts.
+
+ - during business hours, all the functions are warmed up
+ - otherwise, only the functions invoked within the traffic window are warmed up
+ - if neither business hours nor traffic window is configured, all the functions are warmed up
+
+ Invocations are recorded in redis, so that the traffic of all the nodes is taken into account,
+ while only the master node of the cluster runs the schedule.
+*/
+
+const (
+	SERVERLESS_TRAFFIC_CACHE_KEY = "serverless:traffic:%s"
+)
+
+type KeepAliveConfig struct {
+	Interval time.Duration
+	// health route of the function, relative to the function url
+	Path string
+	// e.g. 09:00-18:00, empty means not configured
+	BusinessHours string
+	// weekdays of the business hours, 0 is sunday, e.g. 1-5 or 0,6, empty means every day
+	BusinessDays string
+	// IANA timezone of the business hours
+	Timezone string
+	// functions invoked within the window are kept warm, 0 disables it
+	TrafficWindow time.Duration
+	// max concurrent pings
+	Concurrency int
+	Timeout     time.Duration
+}
+
+type KeepAliveScheduler struct {
+	config KeepAliveConfig
+
+	hours    *businessHours
+	location *time.Location
+	client   *http.Client
+
+	isMaster         func() bool
+	inventory        func() ([]models.ServerlessRuntime, error)
+	hasRecentTraffic func(functionURL string) bool
+	now              func() time.Time
+
+	stop     chan bool
+	stopped  chan bool
+	stopOnce sync.Once
+}
+
+func NewKeepAliveScheduler(
+	config KeepAliveConfig,
+	isMaster func() bool,
+	inventory func() ([]models.ServerlessRuntime, error),
+) (*KeepAliveScheduler, error) {
+	hours, err := parseBusinessHours(config.BusinessHours, config.BusinessDays)
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.UTC
+	if config.Timezone != "" {
+		location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keep alive timezone %s: %v", config.Timezone, err)
+		}
+	}
+
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("keep alive interval must be positive")
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return &KeepAliveScheduler{
+		config:           config,
+		hours:            hours,
+		location:         location,
+		client:           &http.Client{Timeout: config.Timeout},
+		isMaster:         isMaster,
+		inventory:        inventory,
+		hasRecentTraffic: hasRecentTraffic,
+		now:              time.Now,
+		stop:             make(chan bool),
+		stopped:          make(chan bool),
+	}, nil
+}
+
+// Launch starts the schedule in background
+func (s *KeepAliveScheduler) Launch() {
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !s.isMaster() {
+					continue
+				}
+				s.warmUp()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the schedule and waits for the running warm up, it must be called after Launch
+func (s *KeepAliveScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.stopped
+}
+
+// selectFunctions returns the functions to be warmed up now
+func (s *KeepAliveScheduler) selectFunctions(functions []models.ServerlessRuntime) []models.ServerlessRuntime {
+	if s.hours == nil && s.config.TrafficWindow <= 0 {
+		return functions
+	}
+
+	if s.hours != nil && s.hours.contains(s.now().In(s.location)) {
+		return functions
+	}
+
+	if s.config.TrafficWindow <= 0 {
+		return nil
+	}
+
+	selected := []models.ServerlessRuntime{}
+	for _, function := range functions {
+		if s.hasRecentTraffic(function.FunctionURL) {
+			selected = append(selected, function)
+		}
+	}
+	return selected
+}
+
+func (s *KeepAliveScheduler) warmUp() {
+	functions, err := s.inventory()
+	if err != nil {
+		log.Error("failed to load serverless functions to keep alive: %s", err.Error())
+		return
+	}
+
+	functions = s.selectFunctions(functions)
+	if len(functions) == 0 {
+		return
+	}
+
+	// functions of the same url are shared by multiple plugins
+	pinged := map[string]bool{}
+	semaphore := make(chan bool, s.config.Concurrency)
+	wg := sync.WaitGroup{}
+
+	for _, function := range functions {
+		if function.FunctionURL == "" || pinged[function.FunctionURL] {
+			continue
+		}
+		pinged[function.FunctionURL] = true
+
+		semaphore <- true
+		wg.Add(1)
+		go func(function models.ServerlessRuntime) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			if err := s.ping(function.FunctionURL); err != nil {
+				log.Warn("failed to keep serverless function %s alive: %s", function.FunctionName, err.Error())
+			}
+		}(function)
+	}
+
+	wg.Wait()
+}
+
+func (s *KeepAliveScheduler) ping(functionURL string) error {
+	healthURL, err := url.JoinPath(functionURL, s.config.Path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+
+	// any response means the function is up, cold starts are what we care about
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("health check responded with status %s", response.Status)
+	}
+
+	return nil
+}
+
+// lastRecordedTraffic mapping function url to the last time its traffic was recorded,
+// avoids hitting redis on every invocation
+var lastRecordedTraffic mapping.Map[string, time.Time]
+
+// recordTraffic marks the function as recently invoked for the keep alive scheduler
+func recordTraffic(functionURL string, window time.Duration) {
+	if last, ok := lastRecordedTraffic.Load(functionURL); ok && time.Since(last) < window/10 {
+		return
+	}
+	lastRecordedTraffic.Store(functionURL, time.Now())
+
+	if err := cache.Store(fmt.Sprintf(SERVERLESS_TRAFFIC_CACHE_KEY, functionURL), "1", window); err != nil &&
+		err != cache.ErrDBNotInit {
+		log.Error("failed to record traffic of serverless function %s: %s", functionURL, err.Error())
+	}
+}
+
+func hasRecentTraffic(functionURL string) bool {
+	exists, err := cache.Exist(fmt.Sprintf(SERVERLESS_TRAFFIC_CACHE_KEY, functionURL))
+	return err == nil && exists > 0
+}
+
+type businessHours struct {
+	// minutes of the day
+	start int
+	end   int
+	days  [7]bool
+}
+
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid clock %s, expected HH:MM", clock)
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour of %s", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid minute of %s", clock)
+	}
+
+	return hour*60 + minute, nil
+}
+
+// parseBusinessHours parses hours like `09:00-18:00` and days like `1-5` or `0,6`, nil if hours is empty
+func parseBusinessHours(hours string, days string) (*businessHours, error) {
+	if strings.TrimSpace(hours) == "" {
+		return nil, nil
+	}
+
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid business hours %s, expected HH:MM-HH:MM", hours)
+	}
+
+	result := &businessHours{}
+	var err error
+	if result.start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if result.end, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if result.start == result.end {
+		return nil, fmt.Errorf("invalid business hours %s, start equals to end", hours)
+	}
+
+	if strings.TrimSpace(days) == "" {
+		for i := range result.days {
+			result.days[i] = true
+		}
+		return result, nil
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			to = from
+		}
+
+		fromDay, err := strconv.Atoi(from)
+		if err != nil || fromDay < 0 || fromDay > 6 {
+			return nil, fmt.Errorf("invalid business days %s", days)
+		}
+		toDay, err := strconv.Atoi(to)
+		if err != nil || toDay < fromDay || toDay > 6 {
+			return nil, fmt.Errorf("invalid business days %s", days)
+		}
+
+		for day := fromDay; day <= toDay; day++ {
+			result.days[day] = true
+		}
+	}
+
+	return result, nil
+}
+
+func (h *businessHours) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+
+	// overnight hours like 22:00-06:00 belong to the day they start
+	if h.start > h.end {
+		if minute >= h.start {
+			return h.days[t.Weekday()]
+		}
+		if minute < h.end {
+			return h.days[(t.Weekday()+6)%7]
+		}
+		return false
+	}
+
+	return h.days[t.Weekday()] && minute >= h.start && minute < h.end
+}
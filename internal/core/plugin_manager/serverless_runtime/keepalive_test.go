@@ -0,0 +1,164 @@
+package serverless_runtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+)
+
+func TestParseBusinessHours(t *testing.T) {
+	hours, err := parseBusinessHours("09:00-18:00", "1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-01 is a monday
+	cases := map[string]bool{
+		"2024-01-01T08:59:00Z": false,
+		"2024-01-01T09:00:00Z": true,
+		"2024-01-01T17:59:00Z": true,
+		"2024-01-01T18:00:00Z": false,
+		"2024-01-06T10:00:00Z": false, // saturday
+	}
+	for clock, expected := range cases {
+		at, _ := time.Parse(time.RFC3339, clock)
+		if hours.contains(at) != expected {
+			t.Errorf("expected %v at %s", expected, clock)
+		}
+	}
+
+	// overnight hours belong to the day they start
+	overnight, err := parseBusinessHours("22:00-06:00", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for clock, expected := range map[string]bool{
+		"2024-01-05T23:00:00Z": true,  // friday night
+		"2024-01-06T05:00:00Z": true,  // saturday morning
+		"2024-01-06T23:00:00Z": false, // saturday night
+		"2024-01-05T05:00:00Z": false, // friday morning
+	} {
+		at, _ := time.Parse(time.RFC3339, clock)
+		if overnight.contains(at) != expected {
+			t.Errorf("expected %v at %s", expected, clock)
+		}
+	}
+
+	for _, invalid := range [][2]string{
+		{"09:00", ""},
+		{"9-18", ""},
+		{"09:00-25:00", ""},
+		{"09:00-09:00", ""},
+		{"09:00-18:00", "5-1"},
+		{"09:00-18:00", "7"},
+	} {
+		if _, err := parseBusinessHours(invalid[0], invalid[1]); err == nil {
+			t.Errorf("expected error for %v", invalid)
+		}
+	}
+
+	if hours, err := parseBusinessHours("", ""); err != nil || hours != nil {
+		t.Fatal("empty business hours should not be configured")
+	}
+}
+
+func TestKeepAliveSelectFunctions(t *testing.T) {
+	functions := []models.ServerlessRuntime{
+		{FunctionURL: "http://a", FunctionName: "a"},
+		{FunctionURL: "http://b", FunctionName: "b"},
+	}
+
+	newScheduler := func(config KeepAliveConfig, now string) *KeepAliveScheduler {
+		config.Interval = time.Minute
+		scheduler, err := NewKeepAliveScheduler(config, func() bool { return true }, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at, _ := time.Parse(time.RFC3339, now)
+		scheduler.now = func() time.Time { return at }
+		scheduler.hasRecentTraffic = func(functionURL string) bool { return functionURL == "http://b" }
+		return scheduler
+	}
+
+	// nothing configured, all the functions
+	if selected := newScheduler(KeepAliveConfig{}, "2024-01-01T03:00:00Z").selectFunctions(functions); len(selected) != 2 {
+		t.Fatalf("expected all functions, got %v", selected)
+	}
+
+	config := KeepAliveConfig{
+		BusinessHours: "09:00-18:00",
+		BusinessDays:  "1-5",
+		Timezone:      "Asia/Tokyo",
+		TrafficWindow: time.Hour,
+	}
+
+	// 10:00 in tokyo
+	if selected := newScheduler(config, "2024-01-01T01:00:00Z").selectFunctions(functions); len(selected) != 2 {
+		t.Fatalf("expected all functions during business hours, got %v", selected)
+	}
+
+	// 03:00 in tokyo, only recently invoked ones
+	selected := newScheduler(config, "2024-01-01T18:00:00Z").selectFunctions(functions)
+	if len(selected) != 1 || selected[0].FunctionName != "b" {
+		t.Fatalf("expected recently invoked functions, got %v", selected)
+	}
+
+	// no traffic window, nothing outside of business hours
+	config.TrafficWindow = 0
+	if selected := newScheduler(config, "2024-01-01T18:00:00Z").selectFunctions(functions); len(selected) != 0 {
+		t.Fatalf("expected no functions, got %v", selected)
+	}
+}
+
+func TestKeepAliveWarmUp(t *testing.T) {
+	var pings atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		pings.Add(1)
+	}))
+	defer server.Close()
+
+	var master atomic.Bool
+	scheduler, err := NewKeepAliveScheduler(KeepAliveConfig{
+		Interval: 10 * time.Millisecond,
+		Path:     "/health",
+	}, master.Load, func() ([]models.ServerlessRuntime, error) {
+		// functions sharing the same url are pinged once
+		return []models.ServerlessRuntime{
+			{FunctionURL: server.URL, FunctionName: "a"},
+			{FunctionURL: server.URL, FunctionName: "b"},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler.Launch()
+	defer scheduler.Stop()
+
+	// only the master node runs the schedule
+	time.Sleep(50 * time.Millisecond)
+	if pings.Load() != 0 {
+		t.Fatal("non-master node should not ping functions")
+	}
+
+	master.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	scheduler.Stop()
+
+	count := pings.Load()
+	if count == 0 {
+		t.Fatal("master node should ping functions")
+	}
+
+	scheduler.warmUp()
+	if pings.Load() != count+1 {
+		t.Fatalf("functions sharing the same url should be pinged once, got %d", pings.Load()-count)
+	}
+}
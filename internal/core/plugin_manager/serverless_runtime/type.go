@@ -2,6 +2,7 @@ package serverless_runtime
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
@@ -23,4 +24,32 @@ type ServerlessPluginRuntime struct {
 	client *http.Client
 
 	PluginMaxExecutionTimeout int // in seconds
+
+	// retry policy of invocations
+	RetryMaxAttempts int
+	RetryBackoff     time.Duration
+	RetryMaxBackoff  time.Duration
+
+	// circuit breaker of the function, shared by all the runtimes of the same function url
+	CircuitBreakerFailureThreshold int
+	CircuitBreakerOpenTimeout      time.Duration
+	breaker                        *circuitBreaker
+
+	// invocations are recorded for the keep alive scheduler if it's positive
+	KeepAliveTrafficWindow time.Duration
+
+	// transport of invocations, see duplex.go
+	Transport Transport
+	// duplexSessions mapping session id to the full duplex stream
+	duplexSessions mapping.Map[string, *duplexConn]
+}
+
+// RuntimeState returns the state of the runtime along with the circuit breaker of the function
+func (r *ServerlessPluginRuntime) RuntimeState() plugin_entities.PluginRuntimeState {
+	state := r.PluginRuntime.RuntimeState()
+	if r.breaker != nil {
+		breakerState := r.breaker.state()
+		state.CircuitBreaker = &breakerState
+	}
+	return state
 }
@@ -1,6 +1,8 @@
 package plugin_manager
 
 import (
+	"errors"
+	"net/http"
 	"sync"
 	"time"
 
@@ -28,7 +30,7 @@ func (p *PluginManager) initRemotePluginServer(config *app.Config) {
 	if p.remotePluginServer != nil {
 		return
 	}
-	p.remotePluginServer = debugging_runtime.NewRemotePluginServer(config, p.mediaBucket)
+	p.remotePluginServer = debugging_runtime.NewRemotePluginServer(config, p.mediaBucket, p.traceBucket)
 }
 
 func (p *PluginManager) startRemoteWatcher(config *app.Config) {
@@ -68,6 +70,15 @@ func (p *PluginManager) startRemoteWatcher(config *app.Config) {
 	}
 }
 
+// ServeRemoteDebuggingWebSocket serves a remote debugging connection over websocket
+func (p *PluginManager) ServeRemoteDebuggingWebSocket(w http.ResponseWriter, r *http.Request) error {
+	if p.remotePluginServer == nil {
+		return errors.New("remote debugging is not enabled")
+	}
+
+	return p.remotePluginServer.ServeWebSocket(w, r)
+}
+
 func (p *PluginManager) handleNewLocalPlugins(config *app.Config) {
 	// walk through all plugins
 	plugins, err := p.installedBucket.List()
@@ -152,3 +163,48 @@ func (p *PluginManager) removeUninstalledLocalPlugins() {
 		return true
 	})
 }
+
+// ListDebuggingConnections lists the debugging connections of the tenant served by this node
+func (p *PluginManager) ListDebuggingConnections(tenant_id string) ([]debugging_runtime.ConnectionStatus, error) {
+	if p.remotePluginServer == nil {
+		return nil, errors.New("remote debugging is not enabled")
+	}
+
+	return p.remotePluginServer.Connections(tenant_id), nil
+}
+
+// HasDebuggingConnection returns whether the debugging connection of the tenant is served by this node
+func (p *PluginManager) HasDebuggingConnection(tenant_id string, connection_id string) bool {
+	if p.remotePluginServer == nil {
+		return false
+	}
+
+	return p.remotePluginServer.HasConnection(tenant_id, connection_id)
+}
+
+// DisconnectDebuggingConnection closes a debugging connection of the tenant
+func (p *PluginManager) DisconnectDebuggingConnection(tenant_id string, connection_id string) error {
+	if p.remotePluginServer == nil {
+		return errors.New("remote debugging is not enabled")
+	}
+
+	return p.remotePluginServer.Disconnect(tenant_id, connection_id)
+}
+
+// ListDebuggingTraces lists the ids of the recorded debugging sessions of the tenant
+func (p *PluginManager) ListDebuggingTraces(tenant_id string) ([]string, error) {
+	if p.traceBucket == nil {
+		return nil, errors.New("remote debugging trace is not enabled")
+	}
+
+	return p.traceBucket.List(tenant_id)
+}
+
+// GetDebuggingTrace returns the trace file of a recorded debugging session
+func (p *PluginManager) GetDebuggingTrace(tenant_id string, trace_id string) ([]byte, error) {
+	if p.traceBucket == nil {
+		return nil, errors.New("remote debugging trace is not enabled")
+	}
+
+	return p.traceBucket.Get(tenant_id, trace_id)
+}
@@ -1,6 +1,7 @@
 package plugin_manager
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/langgenius/dify-cloud-kit/oss/factory"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/basic_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/debugging_runtime"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
@@ -105,6 +107,22 @@ func (f *fakeRemotePluginServer) Stop() error {
 	return nil
 }
 
+func (f *fakeRemotePluginServer) ServeWebSocket(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (f *fakeRemotePluginServer) Connections(tenant_id string) []debugging_runtime.ConnectionStatus {
+	return nil
+}
+
+func (f *fakeRemotePluginServer) HasConnection(tenant_id string, connection_id string) bool {
+	return false
+}
+
+func (f *fakeRemotePluginServer) Disconnect(tenant_id string, connection_id string) error {
+	return nil
+}
+
 func (f *fakeRemotePluginServer) Wrap(fn func(plugin_entities.PluginFullDuplexLifetime)) {
 	fn(getRandomPluginRuntime())
 }
@@ -0,0 +1,210 @@
+package session_manager
+
+import (
+	"errors"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
+)
+
+/*
+ Session registry
+
+ Cached sessions of all nodes are registered in a sorted set scored by the unix time they are created at,
+ the info of a session lives as long as its node keeps refreshing it, entries whose info is gone are
+ left by closed sessions or dead nodes and removed while listing.
+*/
+
+const (
+	SESSION_REGISTRY_KEY = "session_registry"
+
+	SESSION_INFO_TTL         = 30 * time.Minute
+	SESSION_REFRESH_INTERVAL = 5 * time.Minute
+
+	MAX_LIST_SESSIONS = 1000
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+func register(s *Session) error {
+	return cache.ZAdd(SESSION_REGISTRY_KEY, float64(s.CreatedAt.Unix()), s.ID)
+}
+
+func unregister(id string) error {
+	return cache.ZRem(SESSION_REGISTRY_KEY, id)
+}
+
+type ListSessionsFilter struct {
+	TenantID string
+	PluginID string
+	Action   access_types.PluginAccessAction
+	// sessions created less than MinAge ago are excluded
+	MinAge time.Duration
+	// number of matched sessions to skip
+	Offset int
+	Limit  int
+}
+
+func (f *ListSessionsFilter) empty() bool {
+	return f.TenantID == "" && f.PluginID == "" && f.Action == ""
+}
+
+func (f *ListSessionsFilter) match(session *Session) bool {
+	if f.TenantID != "" && session.TenantID != f.TenantID {
+		return false
+	}
+	if f.PluginID != "" && session.PluginUniqueIdentifier.PluginID() != f.PluginID {
+		return false
+	}
+	if f.Action != "" && session.Action != f.Action {
+		return false
+	}
+	return true
+}
+
+// ListSessions returns cached sessions of all nodes from the oldest one
+// the registry is read page by page, offset is pushed into the range directly if nothing is filtered
+func ListSessions(filter ListSessionsFilter) ([]*Session, error) {
+	if filter.Limit <= 0 || filter.Limit > MAX_LIST_SESSIONS {
+		filter.Limit = MAX_LIST_SESSIONS
+	}
+
+	maxScore := float64(time.Now().Add(-filter.MinAge).Unix())
+	position, skip := int64(0), filter.Offset
+	if filter.empty() {
+		position, skip = int64(filter.Offset), 0
+	}
+
+	result := make([]*Session, 0)
+	for len(result) < filter.Limit {
+		ids, err := cache.ZRangeByScore(SESSION_REGISTRY_KEY, maxScore, position, int64(filter.Limit))
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		keys := make([]string, 0, len(ids))
+		for _, id := range ids {
+			keys = append(keys, sessionKey(id))
+		}
+
+		sessions, err := cache.MGet[Session](keys)
+		if err != nil {
+			return nil, err
+		}
+
+		// entries whose info is gone are removed, the following ones move forward
+		removed := 0
+		for i, session := range sessions {
+			if session == nil {
+				unregister(ids[i])
+				removed++
+				continue
+			}
+
+			if !filter.match(session) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			result = append(result, session)
+			if len(result) == filter.Limit {
+				break
+			}
+		}
+
+		if len(ids) < filter.Limit {
+			break
+		}
+		position += int64(len(ids) - removed)
+	}
+
+	return result, nil
+}
+
+// OnTerminate registers a handler which stops the session when it's terminated by an operator
+func (s *Session) OnTerminate(fn func()) {
+	s.terminateLock.Lock()
+	defer s.terminateLock.Unlock()
+	s.terminateHandlers = append(s.terminateHandlers, fn)
+}
+
+// Terminate stops the session held by the current node, ErrSessionNotFound if it's not held by the current node
+func Terminate(id string) error {
+	session_lock.RLock()
+	session := sessions[id]
+	session_lock.RUnlock()
+
+	if session == nil {
+		return ErrSessionNotFound
+	}
+
+	session.terminateLock.Lock()
+	handlers := session.terminateHandlers
+	session.terminateHandlers = nil
+	session.terminateLock.Unlock()
+
+	// the plugin is told to stop before the caller is gone, handlers close the session through its caller,
+	// otherwise it's freed here
+	session.Interrupt()
+	for _, handler := range handlers {
+		handler()
+	}
+	if len(handlers) == 0 {
+		session.Close(CloseSessionPayload{IgnoreCache: !session.cached})
+	}
+
+	log.Info("session %s of tenant %s terminated", id, session.TenantID)
+	return nil
+}
+
+// LaunchSessionRefresher keeps info of cached sessions held by the current node from expiring
+func LaunchSessionRefresher() {
+	routine.Submit(map[string]string{
+		"module":   "session_manager",
+		"function": "LaunchSessionRefresher",
+	}, func() {
+		ticker := time.NewTicker(SESSION_REFRESH_INTERVAL)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			session_lock.RLock()
+			ids := make([]string, 0, len(sessions))
+			for id, session := range sessions {
+				if session.cached {
+					ids = append(ids, id)
+				}
+			}
+			session_lock.RUnlock()
+
+			for _, id := range ids {
+				if _, err := cache.Expire(sessionKey(id), SESSION_INFO_TTL); err != nil {
+					log.Error("refresh session info failed, %s", err)
+				}
+			}
+		}
+	})
+}
+
+// Alive reports whether the session is still held by any node
+func Alive(id string) (bool, error) {
+	session_lock.RLock()
+	_, ok := sessions[id]
+	session_lock.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	exists, err := cache.Exist(sessionKey(id))
+	return exists > 0, err
+}
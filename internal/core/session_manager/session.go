@@ -1,6 +1,7 @@
 package session_manager
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -26,6 +27,19 @@ type Session struct {
 	runtime             plugin_entities.PluginLifetime      `json:"-"`
 	backwardsInvocation dify_invocation.BackwardsInvocation `json:"-"`
 
+	// ctx is cancelled once the caller is gone, it aborts backwards invocations of the session
+	ctx    context.Context    `json:"-"`
+	cancel context.CancelFunc `json:"-"`
+
+	// cached sessions are listed by the registry, their info is refreshed until closed
+	cached bool `json:"-"`
+
+	terminateLock     sync.Mutex `json:"-"`
+	terminateHandlers []func()   `json:"-"`
+
+	// the plugin is told to stop at most once
+	interruptOnce sync.Once `json:"-"`
+
 	TenantID               string                                 `json:"tenant_id"`
 	UserID                 string                                 `json:"user_id"`
 	PluginUniqueIdentifier plugin_entities.PluginUniqueIdentifier `json:"plugin_unique_identifier"`
@@ -39,6 +53,8 @@ type Session struct {
 	MessageID      *string `json:"message_id"`
 	AppID          *string `json:"app_id"`
 	EndpointID     *string `json:"endpoint_id"`
+
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func sessionKey(id string) string {
@@ -76,16 +92,22 @@ func NewSession(payload NewSessionPayload) *Session {
 		MessageID:              payload.MessageID,
 		AppID:                  payload.AppID,
 		EndpointID:             payload.EndpointID,
+		CreatedAt:              time.Now(),
+		cached:                 !payload.IgnoreCache,
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	session_lock.Lock()
 	sessions[s.ID] = s
 	session_lock.Unlock()
 
 	if !payload.IgnoreCache {
-		if err := cache.Store(sessionKey(s.ID), s, time.Minute*30); err != nil {
+		if err := cache.Store(sessionKey(s.ID), s, SESSION_INFO_TTL); err != nil {
 			log.Error("set session info to cache failed, %s", err)
 		}
+		if err := register(s); err != nil {
+			log.Error("register session failed, %s", err)
+		}
 	}
 
 	return s
@@ -128,6 +150,9 @@ func DeleteSession(payload DeleteSessionPayload) {
 		if _, err := cache.Del(sessionKey(payload.ID)); err != nil {
 			log.Error("delete session info from cache failed, %s", err)
 		}
+		if err := unregister(payload.ID); err != nil {
+			log.Error("unregister session failed, %s", err)
+		}
 	}
 }
 
@@ -136,12 +161,54 @@ type CloseSessionPayload struct {
 }
 
 func (s *Session) Close(payload CloseSessionPayload) {
+	s.Cancel()
 	DeleteSession(DeleteSessionPayload{
 		ID:          s.ID,
 		IgnoreCache: payload.IgnoreCache,
 	})
 }
 
+// Context returns the context of the session, sessions restored from cache are never cancelled
+// as they are held by another node
+func (s *Session) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Cancel aborts everything tied to the session, it's safe to be called multiple times
+func (s *Session) Cancel() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// AcceptsMessages reports whether the runtime is able to receive messages of the session after the request,
+// a write to a serverless runtime is a new invocation unless the session is held by a full duplex stream
+func (s *Session) AcceptsMessages() bool {
+	if s.runtime == nil {
+		return false
+	}
+	if s.runtime.Type() != plugin_entities.PLUGIN_RUNTIME_TYPE_SERVERLESS {
+		return true
+	}
+
+	duplex, ok := s.runtime.(plugin_entities.PluginSessionDuplexLifetime)
+	return ok && duplex.IsFullDuplex(s.ID)
+}
+
+// Interrupt tells the plugin to stop working on the session and aborts backwards invocations of the session
+func (s *Session) Interrupt() {
+	s.Cancel()
+
+	s.interruptOnce.Do(func() {
+		if s.AcceptsMessages() {
+			s.Write(PLUGIN_IN_STREAM_EVENT_CANCEL, s.Action, map[string]any{})
+		}
+	})
+}
+
 func (s *Session) BindRuntime(runtime plugin_entities.PluginLifetime) {
 	s.runtime = runtime
 }
@@ -163,6 +230,10 @@ type PLUGIN_IN_STREAM_EVENT string
 const (
 	PLUGIN_IN_STREAM_EVENT_REQUEST  PLUGIN_IN_STREAM_EVENT = "request"
 	PLUGIN_IN_STREAM_EVENT_RESPONSE PLUGIN_IN_STREAM_EVENT = "backwards_response"
+	// the caller is gone, the plugin should stop processing the session
+	PLUGIN_IN_STREAM_EVENT_CANCEL PLUGIN_IN_STREAM_EVENT = "cancel"
+	// a chunk of the request body of an endpoint, sent to plugins supporting streaming bodies only
+	PLUGIN_IN_STREAM_EVENT_REQUEST_BODY PLUGIN_IN_STREAM_EVENT = "request_body"
 )
 
 func (s *Session) Message(event PLUGIN_IN_STREAM_EVENT, data any) []byte {
@@ -19,7 +19,9 @@ func autoMigrate() error {
 		models.AIModelInstallation{},
 		models.InstallTask{},
 		models.TenantStorage{},
+		models.TenantDataKey{},
 		models.AgentStrategyInstallation{},
+		models.BackwardsInvocationAudit{},
 	)
 
 	if err != nil {
@@ -4,8 +4,13 @@ const (
 	X_PLUGIN_ID     = "X-Plugin-ID"
 	X_API_KEY       = "X-Api-Key"
 	X_ADMIN_API_KEY = "X-Admin-Api-Key"
+	IDEMPOTENCY_KEY = "Idempotency-Key"
+
+	// carries the one-time token of debugging requests forwarded from other nodes, they are served by the receiving node only
+	X_DEBUGGING_LOCAL = "X-Dify-Debugging-Local"
 
 	CONTEXT_KEY_PLUGIN_INSTALLATION      = "plugin_installation"
 	CONTEXT_KEY_PLUGIN_UNIQUE_IDENTIFIER = "plugin_unique_identifier"
 	CONTEXT_KEY_CLUSTER_ID               = "cluster_id"
+	CONTEXT_KEY_PEER_DEBUGGING_CONNS     = "peer_debugging_connections"
 )
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/service"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func ListBackwardsInvocationRateLimits(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestListBackwardsInvocationRateLimits) {
+		c.JSON(http.StatusOK, service.ListBackwardsInvocationRateLimits(request.TenantID))
+	})
+}
+
+func SetBackwardsInvocationRateLimit(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestSetBackwardsInvocationRateLimit) {
+		c.JSON(http.StatusOK, service.SetBackwardsInvocationRateLimit(request))
+	})
+}
+
+func DeleteBackwardsInvocationRateLimit(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestDeleteBackwardsInvocationRateLimit) {
+		c.JSON(http.StatusOK, service.DeleteBackwardsInvocationRateLimit(
+			request.TenantID, request.PluginID, request.InvokeType,
+		))
+	})
+}
+
+func ListBackwardsInvocationAudits(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestListBackwardsInvocationAudits) {
+		c.JSON(http.StatusOK, service.ListBackwardsInvocationAudits(request))
+	})
+}
+
+func GetBackwardsInvocationCacheStats(c *gin.Context) {
+	BindRequest(c, func(request struct {
+		TenantID string `uri:"tenant_id" validate:"required"`
+	}) {
+		c.JSON(http.StatusOK, service.GetBackwardsInvocationCacheStats(request.TenantID))
+	})
+}
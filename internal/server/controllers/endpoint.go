@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/langgenius/dify-plugin-daemon/internal/service"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
 func SetupEndpoint(ctx *gin.Context) {
@@ -14,6 +15,8 @@ func SetupEndpoint(ctx *gin.Context) {
 			UserID                 string                                 `json:"user_id" validate:"required"`
 			Settings               map[string]any                         `json:"settings" validate:"omitempty"`
 			Name                   string                                 `json:"name" validate:"required"`
+			Auth                   *requests.RequestEndpointAuth          `json:"auth" validate:"omitempty"`
+			Limits                 *requests.RequestEndpointLimits        `json:"limits" validate:"omitempty"`
 		},
 	) {
 		tenantId := request.TenantID
@@ -23,7 +26,7 @@ func SetupEndpoint(ctx *gin.Context) {
 		name := request.Name
 
 		ctx.JSON(200, service.SetupEndpoint(
-			tenantId, userId, pluginUniqueIdentifier, name, settings,
+			tenantId, userId, pluginUniqueIdentifier, name, settings, request.Auth, request.Limits,
 		))
 	})
 }
@@ -72,11 +75,13 @@ func RemoveEndpoint(ctx *gin.Context) {
 
 func UpdateEndpoint(ctx *gin.Context) {
 	BindRequest(ctx, func(request struct {
-		EndpointID string         `json:"endpoint_id" validate:"required"`
-		TenantID   string         `uri:"tenant_id" validate:"required"`
-		UserID     string         `json:"user_id" validate:"required"`
-		Settings   map[string]any `json:"settings" validate:"omitempty"`
-		Name       string         `json:"name" validate:"required"`
+		EndpointID string                          `json:"endpoint_id" validate:"required"`
+		TenantID   string                          `uri:"tenant_id" validate:"required"`
+		UserID     string                          `json:"user_id" validate:"required"`
+		Settings   map[string]any                  `json:"settings" validate:"omitempty"`
+		Name       string                          `json:"name" validate:"required"`
+		Auth       *requests.RequestEndpointAuth   `json:"auth" validate:"omitempty"`
+		Limits     *requests.RequestEndpointLimits `json:"limits" validate:"omitempty"`
 	}) {
 		tenantId := request.TenantID
 		userId := request.UserID
@@ -84,7 +89,9 @@ func UpdateEndpoint(ctx *gin.Context) {
 		settings := request.Settings
 		name := request.Name
 
-		ctx.JSON(200, service.UpdateEndpoint(endpointId, tenantId, userId, name, settings))
+		ctx.JSON(200, service.UpdateEndpoint(
+			endpointId, tenantId, userId, name, settings, request.Auth, request.Limits,
+		))
 	})
 }
 
@@ -111,3 +118,25 @@ func DisableEndpoint(ctx *gin.Context) {
 		ctx.JSON(200, service.DisableEndpoint(endpointId, tenantId))
 	})
 }
+
+func GetEndpointLimit(ctx *gin.Context) {
+	BindRequest(ctx, func(request requests.RequestGetEndpointLimit) {
+		ctx.JSON(200, service.GetEndpointLimit(request.TenantID))
+	})
+}
+
+func GetEndpointLimiterStats(ctx *gin.Context) {
+	ctx.JSON(200, service.GetEndpointLimiterStats())
+}
+
+func SetEndpointLimit(ctx *gin.Context) {
+	BindRequest(ctx, func(request requests.RequestSetEndpointLimit) {
+		ctx.JSON(200, service.SetEndpointLimit(request))
+	})
+}
+
+func DeleteEndpointLimit(ctx *gin.Context) {
+	BindRequest(ctx, func(request requests.RequestDeleteEndpointLimit) {
+		ctx.JSON(200, service.DeleteEndpointLimit(request.TenantID))
+	})
+}
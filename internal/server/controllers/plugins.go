@@ -150,6 +150,10 @@ func ReinstallPluginFromIdentifier(app *app.Config) gin.HandlerFunc {
 	}
 }
 
+func ListServerlessCircuitBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, service.ListServerlessCircuitBreakers())
+}
+
 func DecodePluginFromIdentifier(app *app.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		BindRequest(c, func(request struct {
@@ -266,3 +270,16 @@ func FetchMissingPluginInstallations(c *gin.Context) {
 		c.JSON(http.StatusOK, service.FetchMissingPluginInstallations(request.TenantID, request.PluginUniqueIdentifiers))
 	})
 }
+
+func BindPluginApps(c *gin.Context) {
+	BindRequest(c, func(request struct {
+		TenantID string   `uri:"tenant_id" validate:"required"`
+		PluginID string   `json:"plugin_id" validate:"required"`
+		AppIDs   []string `json:"app_ids" validate:"max=256,dive,required"`
+	}) {
+		if request.AppIDs == nil {
+			request.AppIDs = []string{}
+		}
+		c.JSON(http.StatusOK, service.BindPluginApps(request.TenantID, request.PluginID, request.AppIDs))
+	})
+}
@@ -1,15 +1,85 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/debugging_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/server/constants"
 	"github.com/langgenius/dify-plugin-daemon/internal/service"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
-func GetRemoteDebuggingKey(c *gin.Context) {
+func GetRemoteDebuggingKey(config *app.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		BindRequest(
+			c, func(request requests.RequestGetRemoteDebuggingKey) {
+				c.JSON(200, service.GetRemoteDebuggingKey(config, request.TenantID))
+			},
+		)
+	}
+}
+
+// RemoteDebuggingWebSocket serves the remote debugging protocol over websocket
+// it's not guarded by the server key, the debugging key of the upgrade request is the only authentication
+func RemoteDebuggingWebSocket(c *gin.Context) {
+	pluginManager := plugin_manager.Manager()
+	err := pluginManager.ServeRemoteDebuggingWebSocket(c.Writer, c.Request)
+	if errors.Is(err, debugging_runtime.ErrUnauthenticatedDebuggingConnection) {
+		c.JSON(http.StatusUnauthorized, exception.UnauthorizedError().ToResponse())
+		return
+	}
+
+	// upgrader has already responded if the upgrade failed
+	if err != nil && !c.Writer.Written() {
+		c.JSON(http.StatusInternalServerError, exception.InternalServerError(err).ToResponse())
+	}
+}
+
+func ListDebuggingConnections(c *gin.Context) {
+	BindRequest(
+		c, func(request requests.RequestListDebuggingConnections) {
+			// set only if the request has not been forwarded from another node
+			peerConnections, _ := c.Value(constants.CONTEXT_KEY_PEER_DEBUGGING_CONNS).([]debugging_runtime.ConnectionStatus)
+			c.JSON(200, service.ListDebuggingConnections(request.TenantID, peerConnections))
+		},
+	)
+}
+
+func DisconnectDebuggingConnection(c *gin.Context) {
 	BindRequest(
-		c, func(request requests.RequestGetRemoteDebuggingKey) {
-			c.JSON(200, service.GetRemoteDebuggingKey(request.TenantID))
+		c, func(request requests.RequestDisconnectDebuggingConnection) {
+			c.JSON(200, service.DisconnectDebuggingConnection(request.TenantID, request.ConnectionID))
+		},
+	)
+}
+
+func ListDebuggingTraces(c *gin.Context) {
+	BindRequest(
+		c, func(request requests.RequestListDebuggingTraces) {
+			c.JSON(200, service.ListDebuggingTraces(request.TenantID))
+		},
+	)
+}
+
+// DownloadDebuggingTrace downloads a recorded debugging session, it can be replayed by `dify plugin replay`
+func DownloadDebuggingTrace(c *gin.Context) {
+	BindRequest(
+		c, func(request requests.RequestDownloadDebuggingTrace) {
+			pluginManager := plugin_manager.Manager()
+			trace, err := pluginManager.GetDebuggingTrace(request.TenantID, request.TraceID)
+			if err != nil {
+				c.JSON(http.StatusOK, exception.NotFoundError(err).ToResponse())
+				return
+			}
+
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.jsonl", request.TraceID))
+			c.Data(http.StatusOK, "application/x-ndjson", trace)
 		},
 	)
 }
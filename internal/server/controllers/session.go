@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/service"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func ListSessions(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestListSessions) {
+		c.JSON(http.StatusOK, service.ListSessions(request))
+	})
+}
+
+func TerminateSession(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestTerminateSession) {
+		c.JSON(http.StatusOK, service.TerminateSession(request.SessionID))
+	})
+}
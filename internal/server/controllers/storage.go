@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/service"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func GetStorageUsage(c *gin.Context) {
+	BindRequest(c, func(request struct {
+		TenantID string `uri:"tenant_id" validate:"required"`
+	}) {
+		c.JSON(http.StatusOK, service.GetStorageUsage(request.TenantID))
+	})
+}
+
+func PurgePluginStorage(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestPurgePluginStorage) {
+		c.JSON(http.StatusOK, service.PurgePluginStorage(request.TenantID, request.PluginID))
+	})
+}
+
+func ExportPluginStorage(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestExportPluginStorage) {
+		service.ExportPluginStorage(c, request.TenantID, request.PluginID)
+	})
+}
+
+func ImportPluginStorage(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestImportPluginStorage) {
+		archiveFileHeader, err := c.FormFile("archive")
+		if err != nil {
+			c.JSON(http.StatusOK, exception.BadRequestError(err).ToResponse())
+			return
+		}
+
+		archiveFile, err := archiveFileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusOK, exception.BadRequestError(err).ToResponse())
+			return
+		}
+		defer archiveFile.Close()
+
+		c.JSON(http.StatusOK, service.ImportPluginStorage(request.TenantID, archiveFile, request.Overwrite))
+	})
+}
+
+func RotateStorageEncryption(c *gin.Context) {
+	BindRequest(c, func(request requests.RequestRotateStorageEncryption) {
+		c.JSON(http.StatusOK, service.RotateStorageEncryption(request.TenantID))
+	})
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/langgenius/dify-plugin-daemon/internal/cluster"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/debugging_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/server/constants"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+)
+
+const (
+	DEBUGGING_FORWARD_TOKEN_PREFIX = "debugging:forwarded"
+	DEBUGGING_FORWARD_TOKEN_TTL    = time.Minute
+)
+
+func debuggingForwardTokenKey(token string) string {
+	return DEBUGGING_FORWARD_TOKEN_PREFIX + ":" + token
+}
+
+// debugging connections are held by the node the plugin connected to,
+// requests about them are forwarded to the other nodes unless they were forwarded already
+//
+// a forwarded request carries a one-time token issued by the forwarding node, clients are not able to
+// forge it, with mTLS enabled the request must also come from a verified node
+func (app *App) isDebuggingRequestLocal(ctx *gin.Context) bool {
+	if app.cluster == nil {
+		return true
+	}
+
+	token := ctx.GetHeader(constants.X_DEBUGGING_LOCAL)
+	if token == "" {
+		return false
+	}
+
+	if app.cluster.MTLSEnabled() {
+		if _, ok := cluster.PeerNodeID(ctx.Request); !ok {
+			return false
+		}
+	}
+
+	claimed, err := cache.Del(debuggingForwardTokenKey(token))
+	if err != nil {
+		log.Error("failed to claim debugging forward token: %s", err.Error())
+		return false
+	}
+
+	return claimed == 1
+}
+
+// forwardDebuggingRequest sends a copy of the request to every other node until handle returns false
+func forwardDebuggingRequest[T any](
+	app *App,
+	ctx *gin.Context,
+	body []byte,
+	handle func(nodeId string, response entities.GenericResponse[T]) bool,
+) {
+	nodes, err := app.cluster.GetNodes()
+	if err != nil {
+		log.Error("failed to fetch nodes: %s", err.Error())
+		return
+	}
+
+	for nodeId := range nodes {
+		if nodeId == app.cluster.ID() {
+			continue
+		}
+
+		token := uuid.New().String()
+		if err := cache.Store(debuggingForwardTokenKey(token), true, DEBUGGING_FORWARD_TOKEN_TTL); err != nil {
+			log.Error("failed to issue debugging forward token: %s", err.Error())
+			return
+		}
+
+		request := ctx.Request.Clone(ctx)
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		request.Header.Set(constants.X_DEBUGGING_LOCAL, token)
+
+		statusCode, _, responseBody, err := app.cluster.RedirectRequest(nodeId, request)
+		if err != nil {
+			log.Warn("failed to forward debugging request to node %s: %s", nodeId, err.Error())
+			continue
+		}
+
+		content, err := io.ReadAll(responseBody)
+		responseBody.Close()
+		if err != nil || statusCode != http.StatusOK {
+			log.Warn("failed to forward debugging request to node %s, status: %d", nodeId, statusCode)
+			continue
+		}
+
+		var response entities.GenericResponse[T]
+		if err := json.Unmarshal(content, &response); err != nil {
+			log.Warn("failed to decode debugging response of node %s: %s", nodeId, err.Error())
+			continue
+		}
+
+		if !handle(nodeId, response) {
+			return
+		}
+	}
+}
+
+// CollectDebuggingConnections collects debugging connections of the tenant served by other nodes
+func (app *App) CollectDebuggingConnections() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if app.isDebuggingRequestLocal(ctx) {
+			ctx.Next()
+			return
+		}
+
+		connections := make([]debugging_runtime.ConnectionStatus, 0)
+		forwardDebuggingRequest(
+			app, ctx, nil,
+			func(nodeId string, response entities.GenericResponse[[]debugging_runtime.ConnectionStatus]) bool {
+				if response.Code == 0 {
+					connections = append(connections, response.Data...)
+				}
+				return true
+			},
+		)
+
+		ctx.Set(constants.CONTEXT_KEY_PEER_DEBUGGING_CONNS, connections)
+		ctx.Next()
+	}
+}
+
+// RedirectDebuggingConnectionOwner forwards requests about a debugging connection to the node holding it
+func (app *App) RedirectDebuggingConnectionOwner() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if app.isDebuggingRequestLocal(ctx) {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(400, exception.BadRequestError(err).ToResponse())
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var request struct {
+			ConnectionID string `json:"connection_id"`
+		}
+		json.Unmarshal(body, &request)
+
+		manager := plugin_manager.Manager()
+		if request.ConnectionID == "" || manager == nil ||
+			manager.HasDebuggingConnection(ctx.Param("tenant_id"), request.ConnectionID) {
+			ctx.Next()
+			return
+		}
+
+		var owned *entities.GenericResponse[bool]
+		forwardDebuggingRequest(
+			app, ctx, body,
+			func(nodeId string, response entities.GenericResponse[bool]) bool {
+				if response.Code == 0 {
+					owned = &response
+					return false
+				}
+				return true
+			},
+		)
+
+		if owned != nil {
+			ctx.AbortWithStatusJSON(200, owned)
+			return
+		}
+
+		// not found on any node, the current node responds
+		ctx.Next()
+	}
+}
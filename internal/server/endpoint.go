@@ -69,6 +69,16 @@ func (app *App) EndpointHandler(ctx *gin.Context, hookId string, maxExecutionTim
 		return
 	}
 
+	// bodies are bounded before anything reads them, including signature verification
+	if !service.LimitEndpointBody(ctx, endpoint) {
+		return
+	}
+
+	// reject unauthorized requests before anything is redirected or invoked
+	if !service.VerifyEndpointAuth(ctx, endpoint) {
+		return
+	}
+
 	// get plugin installation
 	pluginInstallationCacheKey := strings.Join(
 		[]string{
@@ -108,6 +118,13 @@ func (app *App) EndpointHandler(ctx *gin.Context, hookId string, maxExecutionTim
 	if ok, originalError := app.cluster.IsPluginOnCurrentNode(pluginUniqueIdentifier); !ok {
 		app.redirectPluginInvokeByPluginIdentifier(ctx, pluginUniqueIdentifier, originalError)
 	} else {
+		// limits are taken by the node serving the request, redirected requests are counted only once
+		release, ok := service.AcquireEndpointLimits(ctx, endpoint, maxExecutionTime)
+		defer release()
+		if !ok {
+			return
+		}
+
 		service.Endpoint(ctx, endpoint, pluginInstallation, maxExecutionTime, path)
 	}
 }
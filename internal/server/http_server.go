@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/cluster"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/transaction"
+	"github.com/langgenius/dify-plugin-daemon/internal/server/constants"
 	"github.com/langgenius/dify-plugin-daemon/internal/server/controllers"
 	"github.com/langgenius/dify-plugin-daemon/internal/service"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
@@ -19,6 +21,10 @@ import (
 // server starts a http server and returns a function to stop it
 func (app *App) server(config *app.Config) func() {
 	engine := gin.New()
+	// client ips are resolved through trusted proxies only, ip allowlists of endpoints rely on it
+	if err := engine.SetTrustedProxies(config.EndpointTrustedProxies); err != nil {
+		log.Panic("invalid endpoint trusted proxies: %s", err.Error())
+	}
 	if *config.HealthApiLogEnabled {
 		engine.Use(gin.Logger())
 	} else {
@@ -32,6 +38,7 @@ func (app *App) server(config *app.Config) func() {
 
 	endpointGroup := engine.Group("/e")
 	awsLambdaTransactionGroup := engine.Group("/backwards-invocation")
+	debuggingGroup := engine.Group("/plugin/debugging")
 	pluginGroup := engine.Group("/plugin/:tenant_id")
 	pprofGroup := engine.Group("/debug/pprof")
 
@@ -61,13 +68,21 @@ func (app *App) server(config *app.Config) func() {
 	}
 
 	app.endpointGroup(endpointGroup, config)
+	app.remoteDebuggingTransportGroup(debuggingGroup, config)
 	app.awsLambdaTransactionGroup(awsLambdaTransactionGroup, config)
 	app.pluginGroup(pluginGroup, config)
 	app.pprofGroup(pprofGroup, config)
 
+	mtlsEnabled := app.cluster != nil && app.cluster.MTLSEnabled()
+
+	var handler http.Handler = engine
+	if mtlsEnabled {
+		handler = refusePeerRequests(engine)
+	}
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.ServerPort),
-		Handler: engine,
+		Handler: handler,
 	}
 
 	go func() {
@@ -76,13 +91,48 @@ func (app *App) server(config *app.Config) func() {
 		}
 	}()
 
+	// requests redirected by other nodes arrive at a dedicated mTLS listener
+	var clusterSrv *http.Server
+	if mtlsEnabled {
+		clusterSrv = &http.Server{
+			Addr:      fmt.Sprintf(":%d", config.ClusterMTLSPort),
+			Handler:   app.cluster.VerifyPeer(engine),
+			TLSConfig: app.cluster.ServerTLSConfig(),
+		}
+
+		go func() {
+			if err := clusterSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Panic("cluster listen: %s\n", err)
+			}
+		}()
+	}
+
 	return func() {
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Panic("Server Shutdown: %s\n", err)
 		}
+		if clusterSrv != nil {
+			if err := clusterSrv.Shutdown(context.Background()); err != nil {
+				log.Panic("Cluster Server Shutdown: %s\n", err)
+			}
+		}
 	}
 }
 
+// refusePeerRequests guards the public listener once mTLS is enabled,
+// requests between nodes are only accepted by the mTLS listener where their identity is verified
+func refusePeerRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(cluster.HEADER_CLUSTER_NODE_ID) != "" ||
+			r.Header.Get(constants.X_DEBUGGING_LOCAL) != "" {
+			http.Error(w, "requests between nodes must be sent to the cluster listener", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *App) pluginGroup(group *gin.RouterGroup, config *app.Config) {
 	group.Use(CheckingKey(config.ServerKey))
 
@@ -107,7 +157,22 @@ func (app *App) pluginDispatchGroup(group *gin.RouterGroup, config *app.Config)
 
 func (app *App) remoteDebuggingGroup(group *gin.RouterGroup, config *app.Config) {
 	if config.PluginRemoteInstallingEnabled != nil && *config.PluginRemoteInstallingEnabled {
-		group.POST("/key", CheckingKey(config.ServerKey), controllers.GetRemoteDebuggingKey)
+		group.POST("/key", CheckingKey(config.ServerKey), controllers.GetRemoteDebuggingKey(config))
+		group.GET("/connections", app.CollectDebuggingConnections(), controllers.ListDebuggingConnections)
+		group.POST("/connections/disconnect", app.RedirectDebuggingConnectionOwner(), controllers.DisconnectDebuggingConnection)
+		if config.PluginRemoteDebuggingTraceEnabled {
+			group.GET("/traces", controllers.ListDebuggingTraces)
+			group.GET("/traces/:trace_id", controllers.DownloadDebuggingTrace)
+		}
+	}
+}
+
+// remoteDebuggingTransportGroup serves remote debugging connections through the main http server
+// plugins behind http proxies are not able to reach the tcp debugging port,
+// the route is not guarded by the server key, plugins authenticate with their debugging key
+func (app *App) remoteDebuggingTransportGroup(group *gin.RouterGroup, config *app.Config) {
+	if config.PluginRemoteInstallingEnabled != nil && *config.PluginRemoteInstallingEnabled {
+		group.GET("/ws", controllers.RemoteDebuggingWebSocket)
 	}
 }
 
@@ -161,16 +226,35 @@ func (app *App) pluginManagementGroup(group *gin.RouterGroup, config *app.Config
 	group.GET("/list", controllers.ListPlugins)
 	group.POST("/installation/fetch/batch", controllers.BatchFetchPluginInstallationByIDs)
 	group.POST("/installation/missing", controllers.FetchMissingPluginInstallations)
+	group.POST("/installation/app_bindings", controllers.BindPluginApps)
 	group.GET("/models", controllers.ListModels)
 	group.GET("/tools", controllers.ListTools)
 	group.GET("/tool", controllers.GetTool)
 	group.POST("/tools/check_existence", controllers.CheckToolExistence)
 	group.GET("/agent_strategies", controllers.ListAgentStrategies)
 	group.GET("/agent_strategy", controllers.GetAgentStrategy)
+	group.GET("/backwards-invocation/audits", controllers.ListBackwardsInvocationAudits)
+	group.GET("/backwards-invocation/cache/stats", controllers.GetBackwardsInvocationCacheStats)
+	group.GET("/storage", controllers.GetStorageUsage)
 }
 
 func (app *App) adminGroup(group *gin.RouterGroup, config *app.Config) {
 	group.POST("/plugin/serverless/reinstall", controllers.ReinstallPluginFromIdentifier(config))
+	group.GET("/plugin/serverless/circuit-breakers", controllers.ListServerlessCircuitBreakers)
+	group.GET("/backwards-invocation/rate-limits", controllers.ListBackwardsInvocationRateLimits)
+	group.POST("/backwards-invocation/rate-limits", controllers.SetBackwardsInvocationRateLimit)
+	group.POST("/backwards-invocation/rate-limits/delete", controllers.DeleteBackwardsInvocationRateLimit)
+	group.GET("/endpoint/limits", controllers.GetEndpointLimit)
+	group.POST("/endpoint/limits", controllers.SetEndpointLimit)
+	group.POST("/endpoint/limits/delete", controllers.DeleteEndpointLimit)
+	group.GET("/endpoint/limits/stats", controllers.GetEndpointLimiterStats)
+	group.GET("/backwards-invocation/audits", controllers.ListBackwardsInvocationAudits)
+	group.POST("/plugin/storage/purge", controllers.PurgePluginStorage)
+	group.GET("/plugin/storage/export", controllers.ExportPluginStorage)
+	group.POST("/plugin/storage/import", controllers.ImportPluginStorage)
+	group.POST("/plugin/storage/encryption/rotate", controllers.RotateStorageEncryption)
+	group.GET("/sessions", controllers.ListSessions)
+	group.POST("/sessions/:session_id/terminate", app.RedirectSessionOwner(), controllers.TerminateSession)
 }
 
 func (app *App) pluginAssetGroup(group *gin.RouterGroup) {
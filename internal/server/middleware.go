@@ -3,8 +3,10 @@ package server
 import (
 	"errors"
 	"io"
+	"net"
 
 	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/db"
 	"github.com/langgenius/dify-plugin-daemon/internal/server/constants"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
@@ -125,7 +127,19 @@ func (app *App) redirectPluginInvokeByPluginIdentifier(
 	}
 
 	// redirect to the correct node
-	nodeId := nodes[0]
+	app.redirectRequest(ctx, nodes[0])
+}
+
+// redirectRequest redirects the request to the node and streams the response back
+func (app *App) redirectRequest(ctx *gin.Context, nodeId string) {
+	// the current node acts as a proxy of the client, the target node resolves the client ip through it
+	if ip, _, err := net.SplitHostPort(ctx.Request.RemoteAddr); err == nil {
+		if forwarded := ctx.Request.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ip = forwarded + ", " + ip
+		}
+		ctx.Request.Header.Set("X-Forwarded-For", ip)
+	}
+
 	statusCode, header, body, err := app.cluster.RedirectRequest(nodeId, ctx.Request)
 	if err != nil {
 		log.Error("redirect request failed: %s", err.Error())
@@ -164,6 +178,27 @@ func (app *App) redirectPluginInvokeByPluginIdentifier(
 	}
 }
 
+// RedirectSessionOwner redirects requests about a session to the node holding it
+func (app *App) RedirectSessionOwner() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		session := session_manager.GetSession(session_manager.GetSessionPayload{
+			ID: ctx.Param("session_id"),
+		})
+		if session == nil {
+			ctx.AbortWithStatusJSON(404, exception.NotFoundError(session_manager.ErrSessionNotFound).ToResponse())
+			return
+		}
+
+		if session.ClusterID != "" && session.ClusterID != app.cluster.ID() {
+			app.redirectRequest(ctx, session.ClusterID)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
 func (app *App) InitClusterID() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ctx.Set(constants.CONTEXT_KEY_CLUSTER_ID, app.cluster.ID())
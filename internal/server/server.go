@@ -5,8 +5,14 @@ import (
 	"github.com/langgenius/dify-cloud-kit/oss"
 	"github.com/langgenius/dify-cloud-kit/oss/factory"
 	"github.com/langgenius/dify-plugin-daemon/internal/cluster"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/endpoint_limiter"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/idempotency"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/persistence"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/audit"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/rate_limiter"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/response_cache"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/db"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
@@ -110,9 +116,40 @@ func (app *App) Run(config *app.Config) {
 	// init persistence
 	persistence.InitPersistence(oss, config)
 
+	// init rate limits of backwards invocations
+	rate_limiter.InitRateLimiter(config)
+
+	// init audit log of backwards invocations
+	audit.InitAuditor(config)
+
+	// init response cache of backwards invocations
+	response_cache.InitResponseCache(config)
+
+	// init idempotency keys of dispatch requests
+	idempotency.InitIdempotency(config)
+
+	// init limits of endpoint requests
+	endpoint_limiter.InitEndpointLimiter(config)
+
 	// launch cluster
 	app.cluster.Launch()
 
+	// reclaim expired keys of persistence storage, swept by the master node
+	persistence.LaunchSweeper(config, app.cluster.IsMaster)
+
+	// correct drifted sizes of persistence storage, reconciled by the master node
+	persistence.LaunchReconciler(config, app.cluster.IsMaster)
+
+	// keep sessions held by this node listed in the session registry
+	session_manager.LaunchSessionRefresher()
+
+	// keep serverless functions warm, scheduled by the master node
+	if config.PluginServerlessKeepAliveEnabled {
+		if err := manager.LaunchServerlessKeepAlive(app.cluster.IsMaster); err != nil {
+			log.Panic("Failed to launch serverless keep alive: %s", err.Error())
+		}
+	}
+
 	// start http server
 	app.server(config)
 
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/rate_limiter"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/backwards_invocation/response_cache"
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func ListBackwardsInvocationRateLimits(tenant_id string) *entities.Response {
+	overrides, err := rate_limiter.ListOverrides(tenant_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(overrides)
+}
+
+func SetBackwardsInvocationRateLimit(request requests.RequestSetBackwardsInvocationRateLimit) *entities.Response {
+	if err := rate_limiter.SetOverride(rate_limiter.Override{
+		TenantID:   request.TenantID,
+		PluginID:   request.PluginID,
+		InvokeType: request.InvokeType,
+		Limit: rate_limiter.Limit{
+			RequestsPerMinute: request.RequestsPerMinute,
+			Burst:             request.Burst,
+			DailyQuota:        request.DailyQuota,
+		},
+	}); err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
+
+func DeleteBackwardsInvocationRateLimit(tenant_id string, plugin_id string, invoke_type string) *entities.Response {
+	if err := rate_limiter.DeleteOverride(tenant_id, plugin_id, invoke_type); err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
+
+func ListBackwardsInvocationAudits(request requests.RequestListBackwardsInvocationAudits) *entities.Response {
+	type responseData struct {
+		List  []models.BackwardsInvocationAudit `json:"list"`
+		Total int64                             `json:"total"`
+	}
+
+	conditions := []db.GenericQuery{}
+	for field, value := range map[string]string{
+		"tenant_id":   request.TenantID,
+		"plugin_id":   request.PluginID,
+		"user_id":     request.UserID,
+		"invoke_type": request.InvokeType,
+		"app_id":      request.AppID,
+		"status":      request.Status,
+	} {
+		if value != "" {
+			conditions = append(conditions, db.Equal(field, value))
+		}
+	}
+	if !request.From.IsZero() {
+		conditions = append(conditions, db.WhereSQL("started_at >= ?", request.From))
+	}
+	if !request.To.IsZero() {
+		conditions = append(conditions, db.WhereSQL("started_at < ?", request.To))
+	}
+
+	total, err := db.GetCount[models.BackwardsInvocationAudit](conditions...)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	records, err := db.GetAll[models.BackwardsInvocationAudit](append(
+		conditions,
+		db.OrderBy("started_at", true),
+		db.Page(request.Page, request.PageSize),
+	)...)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(responseData{
+		List:  records,
+		Total: total,
+	})
+}
+
+func GetBackwardsInvocationCacheStats(tenant_id string) *entities.Response {
+	stats, err := response_cache.Stats(tenant_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(map[string]any{
+		"enabled": response_cache.Enabled(),
+		"stats":   stats,
+	})
+}
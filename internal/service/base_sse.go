@@ -1,13 +1,17 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/idempotency"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/server/constants"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/parser"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
@@ -18,10 +22,13 @@ import (
 
 // baseSSEService is a helper function to handle SSE service
 // it accepts a generator function that returns a stream response to gin context
+//
+// responses with a recorder are recorded and drained even if the caller is gone, so duplicates can attach to them
 func baseSSEService[R any](
 	generator func() (*stream.Stream[R], error),
 	ctx *gin.Context,
 	max_timeout_seconds int,
+	recorder *idempotency.Recorder,
 ) {
 	writer := ctx.Writer
 	writer.WriteHeader(200)
@@ -32,11 +39,15 @@ func baseSSEService[R any](
 	closed := new(int32)
 
 	writeData := func(data interface{}) {
+		event := parser.MarshalJsonBytes(data)
+		if recorder != nil {
+			recorder.Record(event)
+		}
 		if atomic.LoadInt32(closed) == 1 {
 			return
 		}
 		writer.Write([]byte("data: "))
-		writer.Write(parser.MarshalJsonBytes(data))
+		writer.Write(event)
 		writer.Write([]byte("\n\n"))
 		writer.Flush()
 	}
@@ -45,10 +56,15 @@ func baseSSEService[R any](
 
 	if err != nil {
 		writeData(exception.InternalServerError(err).ToResponse())
+		if recorder != nil {
+			recorder.Complete()
+		}
 		close(done)
 		return
 	}
 
+	drained := make(chan struct{})
+
 	routine.Submit(map[string]string{
 		"module":   "service",
 		"function": "baseSSEService",
@@ -62,6 +78,11 @@ func baseSSEService[R any](
 			writeData(entities.NewSuccessResponse(chunk))
 		}
 
+		if recorder != nil {
+			recorder.Complete()
+		}
+		close(drained)
+
 		if atomic.CompareAndSwapInt32(doneClosed, 0, 1) {
 			close(done)
 		}
@@ -74,18 +95,31 @@ func baseSSEService[R any](
 		atomic.StoreInt32(closed, 1)
 	}()
 
-	select {
-	case <-writer.CloseNotify():
-		pluginDaemonResponse.Close()
-		return
-	case <-done:
-		return
-	case <-timer.C:
-		writeData(exception.InternalServerError(errors.New("killed by timeout")).ToResponse())
-		if atomic.CompareAndSwapInt32(doneClosed, 0, 1) {
-			close(done)
+	closeNotify := writer.CloseNotify()
+	for {
+		select {
+		case <-closeNotify:
+			if recorder != nil {
+				// keep the plugin running for duplicates, only stop writing to the caller
+				atomic.StoreInt32(closed, 1)
+				closeNotify = nil
+				continue
+			}
+			pluginDaemonResponse.Close()
+			return
+		case <-done:
+			return
+		case <-timer.C:
+			writeData(exception.InternalServerError(errors.New("killed by timeout")).ToResponse())
+			pluginDaemonResponse.Close()
+			if recorder != nil {
+				<-drained
+			}
+			if atomic.CompareAndSwapInt32(doneClosed, 0, 1) {
+				close(done)
+			}
+			return
 		}
-		return
 	}
 }
 
@@ -97,6 +131,32 @@ func baseSSEWithSession[T any, R any](
 	ctx *gin.Context,
 	max_timeout_seconds int,
 ) {
+	var recorder *idempotency.Recorder
+	if key := ctx.GetHeader(constants.IDEMPOTENCY_KEY); key != "" && idempotency.Enabled() {
+		pluginId := request.UniqueIdentifier.PluginID()
+		fingerprint := idempotency.Fingerprint(string(access_action), parser.MarshalJson(request))
+
+		var record *idempotency.Record
+		var err error
+		recorder, record, err = idempotency.Acquire(request.TenantId, pluginId, key, fingerprint)
+		if errors.Is(err, idempotency.ErrFingerprintMismatch) {
+			ctx.JSON(http.StatusUnprocessableEntity, exception.BadRequestError(err).ToResponse())
+			return
+		} else if errors.Is(err, idempotency.ErrInvalidKey) {
+			ctx.JSON(http.StatusBadRequest, exception.BadRequestError(err).ToResponse())
+			return
+		} else if err != nil {
+			ctx.JSON(500, exception.InternalServerError(err).ToResponse())
+			return
+		}
+
+		// duplicates never create a session of their own
+		if record != nil {
+			replaySSE(ctx, request.TenantId, pluginId, key, max_timeout_seconds)
+			return
+		}
+	}
+
 	session, err := createSession(
 		request,
 		access_type,
@@ -104,6 +164,9 @@ func baseSSEWithSession[T any, R any](
 		ctx.GetString("cluster_id"),
 	)
 	if err != nil {
+		if recorder != nil {
+			recorder.Abandon()
+		}
 		ctx.JSON(500, exception.InternalServerError(err).ToResponse())
 		return
 	}
@@ -111,11 +174,39 @@ func baseSSEWithSession[T any, R any](
 		IgnoreCache: false,
 	})
 
+	if recorder != nil {
+		recorder.BindSession(session.ID)
+	}
+
 	baseSSEService(
 		func() (*stream.Stream[R], error) {
 			return generator(session)
 		},
 		ctx,
 		max_timeout_seconds,
+		recorder,
 	)
 }
+
+// replaySSE writes the response of the first request of the idempotency key instead of invoking the plugin again
+func replaySSE(ctx *gin.Context, tenant_id string, plugin_id string, key string, max_timeout_seconds int) {
+	writer := ctx.Writer
+	writer.Header().Set("Idempotent-Replayed", "true")
+	writer.WriteHeader(200)
+	writer.Header().Set("Content-Type", "text/event-stream")
+
+	writeEvent := func(event []byte) {
+		writer.Write([]byte("data: "))
+		writer.Write(event)
+		writer.Write([]byte("\n\n"))
+		writer.Flush()
+	}
+
+	// stops following the first request once the caller is gone or the timeout is reached
+	replayCtx, cancel := context.WithTimeout(ctx.Request.Context(), time.Duration(max_timeout_seconds)*time.Second)
+	defer cancel()
+
+	if err := idempotency.Replay(tenant_id, plugin_id, key, writeEvent, replayCtx.Done()); err != nil {
+		writeEvent(parser.MarshalJsonBytes(exception.InvokePluginError(err).ToResponse()))
+	}
+}
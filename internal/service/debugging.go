@@ -1,19 +1,43 @@
 package service
 
 import (
+	"errors"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/debugging_runtime"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/app"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
 )
 
-func GetRemoteDebuggingKey(tenant_id string) *entities.Response {
+func GetRemoteDebuggingKey(config *app.Config, tenant_id string) *entities.Response {
 	type response struct {
-		Key string `json:"key"`
+		Key       string `json:"key"`
+		ExpiresAt int64  `json:"expires_at,omitempty"`
 	}
 
-	key, err := debugging_runtime.GetConnectionKey(debugging_runtime.ConnectionInfo{
+	info := debugging_runtime.ConnectionInfo{
 		TenantId: tenant_id,
-	})
+	}
+
+	if config.PluginRemoteInstallingTokenEnabled {
+		token, expiresAt, err := debugging_runtime.IssueDebuggingToken(
+			[]byte(config.PluginRemoteInstallingTokenSecret),
+			info,
+			time.Duration(config.PluginRemoteInstallingTokenTTL)*time.Second,
+		)
+		if err != nil {
+			return exception.InternalServerError(err).ToResponse()
+		}
+
+		return entities.NewSuccessResponse(response{
+			Key:       token,
+			ExpiresAt: expiresAt.Unix(),
+		})
+	}
+
+	key, err := debugging_runtime.GetConnectionKey(info)
 
 	if err != nil {
 		return exception.InternalServerError(err).ToResponse()
@@ -23,3 +47,38 @@ func GetRemoteDebuggingKey(tenant_id string) *entities.Response {
 		Key: key,
 	})
 }
+
+func ListDebuggingTraces(tenant_id string) *entities.Response {
+	traces, err := plugin_manager.Manager().ListDebuggingTraces(tenant_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(traces)
+}
+
+// ListDebuggingConnections lists the debugging connections of the tenant, including the ones served by other nodes
+func ListDebuggingConnections(
+	tenant_id string,
+	peer_connections []debugging_runtime.ConnectionStatus,
+) *entities.Response {
+	connections, err := plugin_manager.Manager().ListDebuggingConnections(tenant_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	connections = append(connections, peer_connections...)
+
+	return entities.NewSuccessResponse(connections)
+}
+
+func DisconnectDebuggingConnection(tenant_id string, connection_id string) *entities.Response {
+	err := plugin_manager.Manager().DisconnectDebuggingConnection(tenant_id, connection_id)
+	if errors.Is(err, debugging_runtime.ErrConnectionNotFound) {
+		return exception.NotFoundError(err).ToResponse()
+	} else if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
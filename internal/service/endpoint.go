@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
 	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/encryption"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/routine"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/endpoint_entities"
@@ -29,7 +31,8 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
-func copyRequest(req *http.Request, hookId string, path string) (*bytes.Buffer, error) {
+// prepareRequest clones the request to be forwarded to the plugin, the body is left to callers
+func prepareRequest(req *http.Request, hookId string, path string) *http.Request {
 	newReq := req.Clone(context.Background())
 	// get query params
 	queryParams := req.URL.Query()
@@ -39,17 +42,6 @@ func copyRequest(req *http.Request, hookId string, path string) (*bytes.Buffer,
 	// set query params
 	newReq.URL.RawQuery = queryParams.Encode()
 
-	// read request body until complete, max 10MB
-	body, err := io.ReadAll(io.LimitReader(req.Body, 10*1024*1024))
-	if err != nil {
-		return nil, err
-	}
-
-	// replace with a new reader
-	newReq.Body = io.NopCloser(bytes.NewReader(body))
-	newReq.ContentLength = int64(len(body))
-	newReq.TransferEncoding = nil
-
 	// remove ip traces for security
 	newReq.Header.Del("X-Forwarded-For")
 	newReq.Header.Del("X-Real-IP")
@@ -74,6 +66,23 @@ func copyRequest(req *http.Request, hookId string, path string) (*bytes.Buffer,
 		)
 	}
 
+	return newReq
+}
+
+func copyRequest(req *http.Request, hookId string, path string) (*bytes.Buffer, error) {
+	newReq := prepareRequest(req, hookId, path)
+
+	// read request body until complete, bounded by the max body size of the endpoint
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// replace with a new reader
+	newReq.Body = io.NopCloser(bytes.NewReader(body))
+	newReq.ContentLength = int64(len(body))
+	newReq.TransferEncoding = nil
+
 	var buffer bytes.Buffer
 	err = newReq.Write(&buffer)
 	if err != nil {
@@ -83,6 +92,27 @@ func copyRequest(req *http.Request, hookId string, path string) (*bytes.Buffer,
 	return &buffer, nil
 }
 
+// copyRequestHead serializes the request line and headers only, the body is streamed to the plugin separately
+func copyRequestHead(req *http.Request, hookId string, path string) *bytes.Buffer {
+	newReq := prepareRequest(req, hookId, path)
+
+	// let the plugin know how much body follows, the body is forwarded as is and never chunk encoded,
+	// bodies of unknown length carry no framing header and end with the last request_body event
+	newReq.Header.Del("Transfer-Encoding")
+	if req.ContentLength > 0 {
+		newReq.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	} else {
+		newReq.Header.Del("Content-Length")
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %s HTTP/1.1\r\nHost: %s\r\n", newReq.Method, newReq.URL.RequestURI(), newReq.Host)
+	newReq.Header.Write(&buffer)
+	buffer.WriteString("\r\n")
+
+	return &buffer
+}
+
 func Endpoint(
 	ctx *gin.Context,
 	endpoint *models.Endpoint,
@@ -95,12 +125,6 @@ func Endpoint(
 		return
 	}
 
-	buffer, err := copyRequest(ctx.Request, endpoint.HookID, path)
-	if err != nil {
-		ctx.JSON(500, exception.InternalServerError(err).ToResponse())
-		return
-	}
-
 	identifier, err := plugin_entities.NewPluginUniqueIdentifier(pluginInstallation.PluginUniqueIdentifier)
 	if err != nil {
 		ctx.JSON(400, exception.UniqueIdentifierError(err).ToResponse())
@@ -122,6 +146,37 @@ func Endpoint(
 		return
 	}
 
+	// plugins supporting it get the body streamed after the request, the others get it hex encoded in the request,
+	// serverless runtimes are not able to receive anything after the request,
+	// endpoints verifying hmac signatures have read the whole body already
+	streamingBody := runtime.Configuration().Meta.HasFeature(plugin_entities.PLUGIN_FEATURE_ENDPOINT_STREAMING_BODY) &&
+		runtime.Type() != plugin_entities.PLUGIN_RUNTIME_TYPE_SERVERLESS &&
+		(endpoint.Auth == nil || endpoint.Auth.HMACHeader == "")
+
+	var buffer *bytes.Buffer
+	var body *plugin_daemon.RequestBody
+	if streamingBody {
+		buffer = copyRequestHead(ctx.Request, endpoint.HookID, path)
+		body = plugin_daemon.NewRequestBody(ctx.Request.Body)
+		// the body must not be read once the handler returns, it runs after the session is closed,
+		// a pending read of the body is interrupted by the read deadline of the connection
+		defer func() {
+			if err := http.NewResponseController(ctx.Writer).SetReadDeadline(time.Now()); err != nil {
+				log.Warn("failed to interrupt reading the endpoint request body: %s", err.Error())
+			}
+			body.Wait()
+		}()
+	} else {
+		buffer, err = copyRequest(ctx.Request, endpoint.HookID, path)
+		if isBodyTooLarge(err) {
+			ctx.JSON(413, exception.PayloadTooLargeError(err).ToResponse())
+			return
+		} else if err != nil {
+			ctx.JSON(500, exception.InternalServerError(err).ToResponse())
+			return
+		}
+	}
+
 	// decrypt settings
 	settings, err := manager.BackwardsInvocation().InvokeEncrypt(&dify_invocation.InvokeEncryptRequest{
 		BaseInvokeDifyRequest: dify_invocation.BaseInvokeDifyRequest{
@@ -167,7 +222,9 @@ func Endpoint(
 		session, &requests.RequestInvokeEndpoint{
 			RawHttpRequest: hex.EncodeToString(buffer.Bytes()),
 			Settings:       settings,
+			StreamingBody:  streamingBody,
 		},
+		body,
 	)
 	if err != nil {
 		ctx.JSON(500, exception.InternalServerError(err).ToResponse())
@@ -252,6 +309,9 @@ func ListEndpoints(tenant_id string, page int, page_size int) *entities.Response
 
 	// decrypt settings
 	for i, endpoint := range endpoints {
+		// secrets of auth are never returned
+		endpoint.Auth = maskEndpointAuth(endpoint.Auth)
+
 		pluginInstallation, err := db.GetOne[models.PluginInstallation](
 			db.Equal("plugin_id", endpoint.PluginID),
 			db.Equal("tenant_id", tenant_id),
@@ -346,6 +406,9 @@ func ListPluginEndpoints(tenant_id string, plugin_id string, page int, page_size
 
 	// decrypt settings
 	for i, endpoint := range endpoints {
+		// secrets of auth are never returned
+		endpoint.Auth = maskEndpointAuth(endpoint.Auth)
+
 		// get installation
 		pluginInstallation, err := db.GetOne[models.PluginInstallation](
 			db.Equal("plugin_id", plugin_id),
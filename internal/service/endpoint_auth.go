@@ -0,0 +1,347 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/dify_invocation"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/mapping"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+const (
+	// secrets of endpoint auth are listed as this value, updates sending it back keep the current secret
+	ENDPOINT_AUTH_HIDDEN_SECRET = "[__HIDDEN__]"
+
+	MIN_ENDPOINT_AUTH_SECRET_LENGTH = 16
+
+	// hmac signatures cover the timestamp of the request, requests signed too long ago are refused
+	ENDPOINT_AUTH_TIMESTAMP_HEADER    = "X-Dify-Endpoint-Timestamp"
+	ENDPOINT_AUTH_TIMESTAMP_TOLERANCE = 5 * time.Minute
+)
+
+var (
+	ErrEndpointUnauthorized = errors.New("endpoint request is not authorized")
+	ErrEndpointIPForbidden  = errors.New("endpoint request is not allowed from this ip")
+)
+
+func hashEndpointToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// buildEndpointAuth resolves the auth to be stored from the request and the current one,
+// nil request keeps the current auth, a newly set hmac secret is left to be encrypted by encryptEndpointAuth
+func buildEndpointAuth(request *requests.RequestEndpointAuth, current *models.EndpointAuth) (*models.EndpointAuth, error) {
+	if request == nil {
+		return current, nil
+	}
+	if current == nil {
+		current = &models.EndpointAuth{}
+	}
+
+	auth := &models.EndpointAuth{
+		HMACHeader: http.CanonicalHeaderKey(strings.TrimSpace(request.HMACHeader)),
+	}
+
+	switch request.HMACSecret {
+	case "":
+	case ENDPOINT_AUTH_HIDDEN_SECRET:
+		if current.HMACSecret == "" {
+			return nil, errors.New("hmac secret is not set yet")
+		}
+		auth.HMACSecret = current.HMACSecret
+	default:
+		if len(request.HMACSecret) < MIN_ENDPOINT_AUTH_SECRET_LENGTH {
+			return nil, fmt.Errorf("hmac secret must be at least %d characters", MIN_ENDPOINT_AUTH_SECRET_LENGTH)
+		}
+		auth.HMACSecret = request.HMACSecret
+	}
+
+	switch request.BearerToken {
+	case "":
+	case ENDPOINT_AUTH_HIDDEN_SECRET:
+		if current.BearerToken == "" {
+			return nil, errors.New("bearer token is not set yet")
+		}
+		auth.BearerToken = current.BearerToken
+	default:
+		if len(request.BearerToken) < MIN_ENDPOINT_AUTH_SECRET_LENGTH {
+			return nil, fmt.Errorf("bearer token must be at least %d characters", MIN_ENDPOINT_AUTH_SECRET_LENGTH)
+		}
+		auth.BearerToken = hashEndpointToken(request.BearerToken)
+	}
+
+	for _, entry := range request.IPAllowlist {
+		entry = strings.TrimSpace(entry)
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return nil, fmt.Errorf("invalid ip or cidr in ip allowlist: %s", entry)
+		}
+		auth.IPAllowlist = append(auth.IPAllowlist, entry)
+	}
+
+	if auth.HMACHeader == "" && auth.BearerToken == "" && len(auth.IPAllowlist) == 0 {
+		return nil, nil
+	}
+
+	return auth, nil
+}
+
+// maskEndpointAuth hides secrets of the auth before it's returned to callers
+func maskEndpointAuth(auth *models.EndpointAuth) *models.EndpointAuth {
+	if auth == nil {
+		return nil
+	}
+
+	masked := *auth
+	if masked.HMACSecret != "" {
+		masked.HMACSecret = ENDPOINT_AUTH_HIDDEN_SECRET
+	}
+	if masked.BearerToken != "" {
+		masked.BearerToken = ENDPOINT_AUTH_HIDDEN_SECRET
+	}
+	return &masked
+}
+
+func ipAllowed(allowlist []string, clientIp string) bool {
+	ip := net.ParseIP(clientIp)
+	if ip == nil {
+		return false
+	}
+
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyEndpointAuth checks the request against the auth of the endpoint,
+// the body is read for signature verification and restored afterwards
+func verifyEndpointAuth(auth *models.EndpointAuth, request *http.Request, clientIp string) error {
+	if auth == nil {
+		return nil
+	}
+
+	if len(auth.IPAllowlist) > 0 && !ipAllowed(auth.IPAllowlist, clientIp) {
+		return ErrEndpointIPForbidden
+	}
+
+	if auth.BearerToken != "" {
+		token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare(
+			[]byte(hashEndpointToken(strings.TrimSpace(token))),
+			[]byte(auth.BearerToken),
+		) != 1 {
+			return ErrEndpointUnauthorized
+		}
+	}
+
+	if auth.HMACHeader != "" {
+		signature, err := hex.DecodeString(
+			strings.TrimPrefix(strings.TrimSpace(request.Header.Get(auth.HMACHeader)), "sha256="),
+		)
+		if err != nil || len(signature) == 0 {
+			return ErrEndpointUnauthorized
+		}
+
+		timestamp := strings.TrimSpace(request.Header.Get(ENDPOINT_AUTH_TIMESTAMP_HEADER))
+		signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrEndpointUnauthorized
+		}
+		if skew := time.Since(time.Unix(signedAt, 0)); skew > ENDPOINT_AUTH_TIMESTAMP_TOLERANCE ||
+			skew < -ENDPOINT_AUTH_TIMESTAMP_TOLERANCE {
+			return ErrEndpointUnauthorized
+		}
+
+		body := []byte{}
+		if request.Body != nil {
+			// bounded by the max body size of the endpoint
+			body, err = io.ReadAll(request.Body)
+			if err != nil {
+				return err
+			}
+			request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		// the signed content is "<timestamp>.<body>"
+		mac := hmac.New(sha256.New, []byte(auth.HMACSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrEndpointUnauthorized
+		}
+	}
+
+	return nil
+}
+
+// secrets of endpoint auth are encrypted by dify like settings of the endpoint, under an identity of their own
+// so that they never mix with the cached settings
+const ENDPOINT_AUTH_HMAC_SECRET = "hmac_secret"
+
+var endpointAuthSecretConfigs = []plugin_entities.ProviderConfig{
+	{
+		Name:  ENDPOINT_AUTH_HMAC_SECRET,
+		Type:  plugin_entities.CONFIG_TYPE_SECRET_INPUT,
+		Label: plugin_entities.I18nObject{EnUS: "HMAC Secret"},
+	},
+}
+
+func endpointAuthIdentity(endpoint_id string) string {
+	return endpoint_id + ":auth"
+}
+
+func invokeEndpointAuthEncrypt(
+	opt dify_invocation.EncryptOpt,
+	tenant_id string,
+	user_id string,
+	endpoint_id string,
+	data map[string]any,
+) (map[string]any, error) {
+	manager := plugin_manager.Manager()
+	if manager == nil {
+		return nil, errors.New("failed to get plugin manager")
+	}
+
+	return manager.BackwardsInvocation().InvokeEncrypt(&dify_invocation.InvokeEncryptRequest{
+		BaseInvokeDifyRequest: dify_invocation.BaseInvokeDifyRequest{
+			TenantId: tenant_id,
+			UserId:   user_id,
+			Type:     dify_invocation.INVOKE_TYPE_ENCRYPT,
+		},
+		InvokeEncryptSchema: dify_invocation.InvokeEncryptSchema{
+			Opt:       opt,
+			Namespace: dify_invocation.ENCRYPT_NAMESPACE_ENDPOINT,
+			Identity:  endpointAuthIdentity(endpoint_id),
+			Data:      data,
+			Config:    endpointAuthSecretConfigs,
+		},
+	})
+}
+
+// encryptEndpointAuth encrypts the hmac secret of the auth if it's newly set, kept secrets are encrypted already
+func encryptEndpointAuth(
+	auth *models.EndpointAuth,
+	current *models.EndpointAuth,
+	tenant_id string,
+	user_id string,
+	endpoint_id string,
+) error {
+	if auth == nil || auth.HMACSecret == "" || (current != nil && auth.HMACSecret == current.HMACSecret) {
+		return nil
+	}
+
+	encrypted, err := invokeEndpointAuthEncrypt(
+		dify_invocation.ENCRYPT_OPT_ENCRYPT, tenant_id, user_id, endpoint_id,
+		map[string]any{ENDPOINT_AUTH_HMAC_SECRET: auth.HMACSecret},
+	)
+	if err != nil {
+		return err
+	}
+
+	secret, ok := encrypted[ENDPOINT_AUTH_HMAC_SECRET].(string)
+	if !ok {
+		return errors.New("failed to encrypt hmac secret")
+	}
+	auth.HMACSecret = secret
+	return nil
+}
+
+type endpointAuthSecret struct {
+	encrypted string
+	decrypted string
+}
+
+// decrypted hmac secrets by endpoint id, an entry only applies to the encrypted secret it was decrypted from,
+// a secret updated on another node never hits the stale entry
+var endpointAuthSecrets mapping.Map[string, endpointAuthSecret]
+
+// decryptEndpointAuth returns a copy of the auth with the hmac secret decrypted
+func decryptEndpointAuth(endpoint *models.Endpoint) (*models.EndpointAuth, error) {
+	if endpoint.Auth == nil || endpoint.Auth.HMACSecret == "" {
+		return endpoint.Auth, nil
+	}
+
+	auth := *endpoint.Auth
+	if cached, ok := endpointAuthSecrets.Load(endpoint.ID); ok && cached.encrypted == endpoint.Auth.HMACSecret {
+		auth.HMACSecret = cached.decrypted
+		return &auth, nil
+	}
+
+	decrypted, err := invokeEndpointAuthEncrypt(
+		dify_invocation.ENCRYPT_OPT_DECRYPT, endpoint.TenantID, "", endpoint.ID,
+		map[string]any{ENDPOINT_AUTH_HMAC_SECRET: endpoint.Auth.HMACSecret},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := decrypted[ENDPOINT_AUTH_HMAC_SECRET].(string)
+	if !ok {
+		return nil, errors.New("failed to decrypt hmac secret")
+	}
+
+	endpointAuthSecrets.Store(endpoint.ID, endpointAuthSecret{
+		encrypted: endpoint.Auth.HMACSecret,
+		decrypted: secret,
+	})
+
+	auth.HMACSecret = secret
+	return &auth, nil
+}
+
+// clearEndpointAuthCache drops decrypted secrets of the auth cached by the daemon and dify
+func clearEndpointAuthCache(tenant_id string, user_id string, endpoint_id string) error {
+	endpointAuthSecrets.Delete(endpoint_id)
+	_, err := invokeEndpointAuthEncrypt(dify_invocation.ENCRYPT_OPT_CLEAR, tenant_id, user_id, endpoint_id, nil)
+	return err
+}
+
+// VerifyEndpointAuth rejects requests failing the auth of the endpoint, returns false if rejected
+func VerifyEndpointAuth(ctx *gin.Context, endpoint *models.Endpoint) bool {
+	auth, err := decryptEndpointAuth(endpoint)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, exception.InternalServerError(
+			fmt.Errorf("failed to decrypt endpoint auth: %v", err),
+		).ToResponse())
+		return false
+	}
+
+	err = verifyEndpointAuth(auth, ctx.Request, ctx.ClientIP())
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrEndpointUnauthorized):
+		ctx.JSON(http.StatusUnauthorized, exception.UnauthorizedError().ToResponse())
+	case errors.Is(err, ErrEndpointIPForbidden):
+		ctx.JSON(http.StatusForbidden, exception.PermissionDeniedError(err.Error()).ToResponse())
+	case isBodyTooLarge(err):
+		ctx.JSON(http.StatusRequestEntityTooLarge, exception.PayloadTooLargeError(err).ToResponse())
+	default:
+		ctx.JSON(http.StatusBadRequest, exception.BadRequestError(err).ToResponse())
+	}
+	return false
+}
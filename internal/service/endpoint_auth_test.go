@@ -0,0 +1,188 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+func TestBuildEndpointAuth(t *testing.T) {
+	auth, err := buildEndpointAuth(&requests.RequestEndpointAuth{
+		HMACHeader:  "x-signature",
+		HMACSecret:  "0123456789abcdef",
+		BearerToken: "fedcba9876543210",
+		IPAllowlist: []string{"10.0.0.0/8", "192.168.1.1"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.HMACHeader != "X-Signature" {
+		t.Fatal("hmac header is not canonicalized, ", auth.HMACHeader)
+	}
+	if auth.BearerToken != hashEndpointToken("fedcba9876543210") {
+		t.Fatal("bearer token is not hashed")
+	}
+
+	masked := maskEndpointAuth(auth)
+	if masked.HMACSecret != ENDPOINT_AUTH_HIDDEN_SECRET || masked.BearerToken != ENDPOINT_AUTH_HIDDEN_SECRET {
+		t.Fatal("secrets are not hidden")
+	}
+
+	// hidden secrets keep the current ones
+	updated, err := buildEndpointAuth(&requests.RequestEndpointAuth{
+		HMACHeader:  masked.HMACHeader,
+		HMACSecret:  masked.HMACSecret,
+		BearerToken: masked.BearerToken,
+	}, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.HMACSecret != auth.HMACSecret || updated.BearerToken != auth.BearerToken {
+		t.Fatal("hidden secrets are not kept")
+	}
+	if len(updated.IPAllowlist) != 0 {
+		t.Fatal("ip allowlist should be replaced")
+	}
+
+	// nil keeps everything, an empty one disables auth
+	if kept, _ := buildEndpointAuth(nil, auth); kept != auth {
+		t.Fatal("auth should be kept")
+	}
+	if disabled, _ := buildEndpointAuth(&requests.RequestEndpointAuth{}, auth); disabled != nil {
+		t.Fatal("auth should be disabled")
+	}
+
+	if _, err := buildEndpointAuth(&requests.RequestEndpointAuth{BearerToken: "short"}, nil); err == nil {
+		t.Fatal("short bearer token should be rejected")
+	}
+	if _, err := buildEndpointAuth(&requests.RequestEndpointAuth{IPAllowlist: []string{"10.0.0"}}, nil); err == nil {
+		t.Fatal("invalid ip should be rejected")
+	}
+	if _, err := buildEndpointAuth(&requests.RequestEndpointAuth{BearerToken: ENDPOINT_AUTH_HIDDEN_SECRET}, nil); err == nil {
+		t.Fatal("hidden bearer token without a current one should be rejected")
+	}
+}
+
+func TestVerifyEndpointAuth(t *testing.T) {
+	auth := &models.EndpointAuth{
+		HMACHeader:  "X-Signature",
+		HMACSecret:  "0123456789abcdef",
+		BearerToken: hashEndpointToken("fedcba9876543210"),
+		IPAllowlist: []string{"10.0.0.0/8", "192.168.1.1"},
+	}
+
+	body := []byte(`{"hello":"world"}`)
+	sign := func(timestamp string) string {
+		mac := hmac.New(sha256.New, []byte(auth.HMACSecret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := sign(timestamp)
+
+	newSignedRequest := func(token string, signature string, timestamp string) *http.Request {
+		req, err := http.NewRequest("POST", "http://localhost:8080/e/123/test", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if signature != "" {
+			req.Header.Set("X-Signature", signature)
+		}
+		if timestamp != "" {
+			req.Header.Set(ENDPOINT_AUTH_TIMESTAMP_HEADER, timestamp)
+		}
+		return req
+	}
+	newRequest := func(token string, signature string) *http.Request {
+		return newSignedRequest(token, signature, timestamp)
+	}
+
+	req := newRequest("fedcba9876543210", "sha256="+signature)
+	if err := verifyEndpointAuth(auth, req, "10.1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, body) {
+		t.Fatal("body is not restored")
+	}
+
+	if err := verifyEndpointAuth(auth, newRequest("fedcba9876543210", signature), "192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyEndpointAuth(auth, newRequest("fedcba9876543210", signature), "192.168.1.2"); err != ErrEndpointIPForbidden {
+		t.Fatal("ip out of the allowlist should be forbidden, ", err)
+	}
+	if err := verifyEndpointAuth(auth, newRequest("wrong-token-0000", signature), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("wrong bearer token should be unauthorized, ", err)
+	}
+	if err := verifyEndpointAuth(auth, newRequest("fedcba9876543210", "deadbeef"), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("wrong signature should be unauthorized, ", err)
+	}
+	if err := verifyEndpointAuth(auth, newRequest("fedcba9876543210", ""), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("missing signature should be unauthorized, ", err)
+	}
+	if err := verifyEndpointAuth(auth, newSignedRequest("fedcba9876543210", signature, ""), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("missing timestamp should be unauthorized, ", err)
+	}
+	if err := verifyEndpointAuth(auth, newSignedRequest("fedcba9876543210", signature, "1"), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("timestamp not covered by the signature should be unauthorized, ", err)
+	}
+	expired := strconv.FormatInt(time.Now().Add(-ENDPOINT_AUTH_TIMESTAMP_TOLERANCE-time.Minute).Unix(), 10)
+	if err := verifyEndpointAuth(auth, newSignedRequest("fedcba9876543210", sign(expired), expired), "10.1.2.3"); err != ErrEndpointUnauthorized {
+		t.Fatal("expired signature should be unauthorized, ", err)
+	}
+	if err := verifyEndpointAuth(nil, newRequest("", ""), ""); err != nil {
+		t.Fatal("endpoints without auth should be open, ", err)
+	}
+}
+
+func TestDecryptEndpointAuthCache(t *testing.T) {
+	endpoint := &models.Endpoint{
+		TenantID: "tenant_id",
+		Auth: &models.EndpointAuth{
+			HMACHeader: "X-Signature",
+			HMACSecret: "encrypted",
+		},
+	}
+	endpoint.ID = "endpoint_id"
+
+	endpointAuthSecrets.Store(endpoint.ID, endpointAuthSecret{encrypted: "encrypted", decrypted: "0123456789abcdef"})
+	defer endpointAuthSecrets.Delete(endpoint.ID)
+
+	// served from the cache without calling dify
+	auth, err := decryptEndpointAuth(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.HMACSecret != "0123456789abcdef" || endpoint.Auth.HMACSecret != "encrypted" {
+		t.Fatal("unexpected secret, ", auth.HMACSecret)
+	}
+
+	// a secret updated elsewhere is decrypted again, the plugin manager is not available here
+	endpoint.Auth.HMACSecret = "updated"
+	if _, err := decryptEndpointAuth(endpoint); err == nil {
+		t.Fatal("stale cache entry should not be used")
+	}
+
+	endpoint.Auth.HMACSecret = "encrypted"
+	clearEndpointAuthCache(endpoint.TenantID, "", endpoint.ID)
+	if _, ok := endpointAuthSecrets.Load(endpoint.ID); ok {
+		t.Fatal("cache entry should be dropped")
+	}
+}
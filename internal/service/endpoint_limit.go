@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/langgenius/dify-plugin-daemon/internal/core/endpoint_limiter"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
+)
+
+// buildEndpointLimits resolves the limits to be stored from the request and the current ones,
+// nil request keeps the current limits
+func buildEndpointLimits(request *requests.RequestEndpointLimits, current *models.EndpointLimits) *models.EndpointLimits {
+	if request == nil {
+		return current
+	}
+
+	limits := &models.EndpointLimits{
+		RequestsPerMinute: request.RequestsPerMinute,
+		Burst:             request.Burst,
+		MaxConcurrency:    request.MaxConcurrency,
+		MaxBodySize:       request.MaxBodySize,
+	}
+	if *limits == (models.EndpointLimits{}) {
+		return nil
+	}
+	return limits
+}
+
+func endpointLimitsOf(endpoint *models.Endpoint) models.EndpointLimits {
+	if endpoint.Limits == nil {
+		return models.EndpointLimits{}
+	}
+	return *endpoint.Limits
+}
+
+// isBodyTooLarge reports whether reading the body failed because of the max body size
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+// LimitEndpointBody rejects requests declaring bodies larger than the max body size of the endpoint,
+// bodies of the others are cut off at the max body size, returns false if rejected
+func LimitEndpointBody(ctx *gin.Context, endpoint *models.Endpoint) bool {
+	maxBodySize := endpoint_limiter.MaxBodySize(endpointLimitsOf(endpoint).MaxBodySize)
+	if maxBodySize <= 0 {
+		return true
+	}
+
+	if ctx.Request.ContentLength > maxBodySize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, exception.PayloadTooLargeError(
+			fmt.Errorf("request body exceeds the max body size of %d bytes", maxBodySize),
+		).ToResponse())
+		return false
+	}
+
+	if ctx.Request.Body != nil {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodySize)
+	}
+	return true
+}
+
+// AcquireEndpointLimits takes a token and a concurrency slot for the request, release has to be called
+// once the request finishes, returns false if the request is rejected
+func AcquireEndpointLimits(ctx *gin.Context, endpoint *models.Endpoint, maxExecutionTime time.Duration) (func(), bool) {
+	limits := endpointLimitsOf(endpoint)
+	release, breach := endpoint_limiter.Acquire(
+		endpoint.TenantID,
+		endpoint.ID,
+		endpoint_limiter.Limit{
+			RequestsPerMinute: limits.RequestsPerMinute,
+			Burst:             limits.Burst,
+			MaxConcurrency:    limits.MaxConcurrency,
+		},
+		maxExecutionTime,
+	)
+	if breach != nil {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(breach.RetryAfter.Seconds()))))
+		if breach.Unavailable {
+			ctx.JSON(http.StatusServiceUnavailable, exception.ErrorWithTypeAndCode(
+				breach.Error(), exception.PluginDaemonInternalServerError, -503,
+			).ToResponse())
+			return release, false
+		}
+		ctx.JSON(http.StatusTooManyRequests, exception.TooManyRequestsError(breach).ToResponse())
+		return release, false
+	}
+	return release, true
+}
+
+// GetEndpointLimiterStats returns how the limiter of this node handles redis failures and how often they happened
+func GetEndpointLimiterStats() *entities.Response {
+	return entities.NewSuccessResponse(map[string]any{
+		"fail_open":         endpoint_limiter.FailOpen(),
+		"unavailable_count": endpoint_limiter.UnavailableCount(),
+	})
+}
+
+func GetEndpointLimit(tenant_id string) *entities.Response {
+	override, err := endpoint_limiter.GetOverride(tenant_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(override)
+}
+
+func SetEndpointLimit(request requests.RequestSetEndpointLimit) *entities.Response {
+	if err := endpoint_limiter.SetOverride(endpoint_limiter.Override{
+		TenantID: request.TenantID,
+		Limit: endpoint_limiter.Limit{
+			RequestsPerMinute: request.RequestsPerMinute,
+			Burst:             request.Burst,
+			MaxConcurrency:    request.MaxConcurrency,
+		},
+	}); err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
+
+func DeleteEndpointLimit(tenant_id string) *entities.Response {
+	if err := endpoint_limiter.DeleteOverride(tenant_id); err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
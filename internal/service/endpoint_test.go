@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/endpoint_entities"
@@ -46,3 +47,34 @@ func TestEndpointWithOriginalHost(t *testing.T) {
 		t.Fatal("request body is not equal, ", str)
 	}
 }
+
+func TestCopyRequestHead(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://localhost:8080/e/123/upload?name=dify", bytes.NewReader([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	str := copyRequestHead(req, "123", "/upload").String()
+	if str != "POST /upload?name=dify HTTP/1.1\r\nHost: localhost:8080\r\nContent-Length: 4\r\nContent-Type: application/octet-stream\r\nDify-Hook-Id: 123\r\nDify-Hook-Url: http://localhost:8080/e/123/upload\r\n\r\n" {
+		t.Fatal("request head is not equal, ", str)
+	}
+
+	// the body is untouched and left to be streamed
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "test" {
+		t.Fatal("request body is consumed")
+	}
+
+	// bodies of unknown length are not chunk encoded, they end with the stream
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	req.Header.Set("Transfer-Encoding", "chunked")
+	str = copyRequestHead(req, "123", "/upload").String()
+	if strings.Contains(str, "Transfer-Encoding") || strings.Contains(str, "Content-Length") {
+		t.Fatal("expected no framing header, ", str)
+	}
+}
@@ -366,7 +366,7 @@ func ReinstallPluginFromIdentifier(
 		}
 
 		return stream, nil
-	}, ctx, 1800)
+	}, ctx, 1800, nil)
 }
 
 /*
@@ -241,9 +241,30 @@ func DisabledEndpoint(endpoint_id string, tenant_id string) error {
 	})
 }
 
-func UpdateEndpoint(endpoint *models.Endpoint, name string, settings map[string]any) error {
+func UpdateEndpoint(
+	endpoint *models.Endpoint,
+	name string,
+	settings map[string]any,
+	auth *models.EndpointAuth,
+	limits *models.EndpointLimits,
+) error {
 	endpoint.Name = name
 	endpoint.Settings = settings
+	endpoint.Auth = auth
+	endpoint.Limits = limits
 
-	return db.Update(endpoint)
+	if err := db.Update(endpoint); err != nil {
+		return err
+	}
+
+	// endpoints are cached by hook id, drop it so changes of auth and limits take effect at once
+	endpointCacheKey := gostrings.Join(
+		[]string{
+			"hook_id",
+			endpoint.HookID,
+		},
+		":",
+	)
+	_, _ = cache.AutoDelete[models.Endpoint](endpointCacheKey)
+	return nil
 }
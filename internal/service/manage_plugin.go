@@ -485,3 +485,28 @@ func GetAgentStrategy(tenant_id string, plugin_id string, provider string) *enti
 		Meta:                      declaration.Meta,
 	})
 }
+
+// BindPluginApps replaces the apps bound to the plugin installation, plugins with scoped app permission
+// are only able to invoke the bound apps
+func BindPluginApps(tenant_id string, plugin_id string, app_ids []string) *entities.Response {
+	installation, err := db.GetOne[models.PluginInstallation](
+		db.Equal("tenant_id", tenant_id),
+		db.Equal("plugin_id", plugin_id),
+	)
+	if err == db.ErrDatabaseNotFound {
+		return exception.ErrPluginNotFound().ToResponse()
+	} else if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	if installation.Meta == nil {
+		installation.Meta = map[string]any{}
+	}
+	installation.Meta[models.PLUGIN_INSTALLATION_META_APP_BINDINGS] = app_ids
+
+	if err := db.Update(&installation); err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
@@ -0,0 +1,11 @@
+package service
+
+import (
+	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager/serverless_runtime"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+)
+
+// ListServerlessCircuitBreakers returns the circuit breakers of the serverless functions invoked by this node
+func ListServerlessCircuitBreakers() *entities.Response {
+	return entities.NewSuccessResponse(serverless_runtime.CircuitBreakerStates())
+}
@@ -2,11 +2,15 @@ package service
 
 import (
 	"errors"
+	"time"
 
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_daemon/access_types"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/plugin_manager"
 	"github.com/langgenius/dify-plugin-daemon/internal/core/session_manager"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
 func createSession[T any](
@@ -48,3 +52,63 @@ func createSession[T any](
 	session.BindRuntime(runtime)
 	return session, nil
 }
+
+func ListSessions(request requests.RequestListSessions) *entities.Response {
+	type sessionInfo struct {
+		ID                     string                                 `json:"id"`
+		TenantID               string                                 `json:"tenant_id"`
+		UserID                 string                                 `json:"user_id"`
+		PluginID               string                                 `json:"plugin_id"`
+		PluginUniqueIdentifier plugin_entities.PluginUniqueIdentifier `json:"plugin_unique_identifier"`
+		InvokeFrom             access_types.PluginAccessType          `json:"invoke_from"`
+		Action                 access_types.PluginAccessAction        `json:"action"`
+		NodeID                 string                                 `json:"node_id"`
+		CreatedAt              time.Time                              `json:"created_at"`
+		// seconds since the session is created
+		Age int64 `json:"age"`
+	}
+
+	sessions, err := session_manager.ListSessions(session_manager.ListSessionsFilter{
+		TenantID: request.TenantID,
+		PluginID: request.PluginID,
+		Action:   access_types.PluginAccessAction(request.Action),
+		MinAge:   time.Duration(request.MinAge) * time.Second,
+		Offset:   request.Offset,
+		Limit:    request.Limit,
+	})
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	list := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, sessionInfo{
+			ID:                     session.ID,
+			TenantID:               session.TenantID,
+			UserID:                 session.UserID,
+			PluginID:               session.PluginUniqueIdentifier.PluginID(),
+			PluginUniqueIdentifier: session.PluginUniqueIdentifier,
+			InvokeFrom:             session.InvokeFrom,
+			Action:                 session.Action,
+			NodeID:                 session.ClusterID,
+			CreatedAt:              session.CreatedAt,
+			Age:                    int64(time.Since(session.CreatedAt).Seconds()),
+		})
+	}
+
+	return entities.NewSuccessResponse(map[string]any{
+		"list": list,
+	})
+}
+
+// TerminateSession terminates the session held by the current node, requests are redirected to it beforehand
+func TerminateSession(session_id string) *entities.Response {
+	err := session_manager.Terminate(session_id)
+	if errors.Is(err, session_manager.ErrSessionNotFound) {
+		return exception.NotFoundError(err).ToResponse()
+	} else if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(true)
+}
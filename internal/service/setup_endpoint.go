@@ -14,6 +14,7 @@ import (
 	"github.com/langgenius/dify-plugin-daemon/internal/utils/encryption"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
 	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/requests"
 )
 
 func SetupEndpoint(
@@ -22,6 +23,8 @@ func SetupEndpoint(
 	pluginUniqueIdentifier plugin_entities.PluginUniqueIdentifier,
 	name string,
 	settings map[string]any,
+	authRequest *requests.RequestEndpointAuth,
+	limitsRequest *requests.RequestEndpointLimits,
 ) *entities.Response {
 	// try find plugin installation
 	installation, err := db.GetOne[models.PluginInstallation](
@@ -55,6 +58,11 @@ func SetupEndpoint(
 		return exception.BadRequestError(fmt.Errorf("failed to validate settings: %v", err)).ToResponse()
 	}
 
+	auth, err := buildEndpointAuth(authRequest, nil)
+	if err != nil {
+		return exception.BadRequestError(fmt.Errorf("failed to validate auth: %v", err)).ToResponse()
+	}
+
 	endpoint, err := install_service.InstallEndpoint(
 		pluginUniqueIdentifier,
 		installation.ID,
@@ -94,7 +102,13 @@ func SetupEndpoint(
 		return exception.InternalServerError(fmt.Errorf("failed to encrypt settings: %v", err)).ToResponse()
 	}
 
-	if err := install_service.UpdateEndpoint(endpoint, name, encryptedSettings); err != nil {
+	if err := encryptEndpointAuth(auth, nil, tenant_id, user_id, endpoint.ID); err != nil {
+		return exception.InternalServerError(fmt.Errorf("failed to encrypt auth: %v", err)).ToResponse()
+	}
+
+	if err := install_service.UpdateEndpoint(
+		endpoint, name, encryptedSettings, auth, buildEndpointLimits(limitsRequest, nil),
+	); err != nil {
 		return exception.InternalServerError(fmt.Errorf("failed to update endpoint: %v", err)).ToResponse()
 	}
 
@@ -136,10 +150,22 @@ func RemoveEndpoint(endpoint_id string, tenant_id string) *entities.Response {
 		return exception.InternalServerError(fmt.Errorf("failed to clear credentials cache: %v", err)).ToResponse()
 	}
 
+	if err := clearEndpointAuthCache(tenant_id, "", endpoint.ID); err != nil {
+		return exception.InternalServerError(fmt.Errorf("failed to clear auth cache: %v", err)).ToResponse()
+	}
+
 	return entities.NewSuccessResponse(true)
 }
 
-func UpdateEndpoint(endpoint_id string, tenant_id string, user_id string, name string, settings map[string]any) *entities.Response {
+func UpdateEndpoint(
+	endpoint_id string,
+	tenant_id string,
+	user_id string,
+	name string,
+	settings map[string]any,
+	authRequest *requests.RequestEndpointAuth,
+	limitsRequest *requests.RequestEndpointLimits,
+) *entities.Response {
 	// get endpoint
 	endpoint, err := db.GetOne[models.Endpoint](
 		db.Equal("id", endpoint_id),
@@ -149,6 +175,12 @@ func UpdateEndpoint(endpoint_id string, tenant_id string, user_id string, name s
 		return exception.NotFoundError(fmt.Errorf("failed to find endpoint: %v", err)).ToResponse()
 	}
 
+	// auth is kept if not provided, hidden secrets are replaced with the current ones
+	auth, err := buildEndpointAuth(authRequest, endpoint.Auth)
+	if err != nil {
+		return exception.BadRequestError(fmt.Errorf("failed to validate auth: %v", err)).ToResponse()
+	}
+
 	// get plugin installation
 	installation, err := db.GetOne[models.PluginInstallation](
 		db.Equal("plugin_id", endpoint.PluginID),
@@ -252,8 +284,15 @@ func UpdateEndpoint(endpoint_id string, tenant_id string, user_id string, name s
 		return exception.InternalServerError(fmt.Errorf("failed to encrypt settings: %v", err)).ToResponse()
 	}
 
+	// encrypt auth
+	if err := encryptEndpointAuth(auth, endpoint.Auth, tenant_id, user_id, endpoint.ID); err != nil {
+		return exception.InternalServerError(fmt.Errorf("failed to encrypt auth: %v", err)).ToResponse()
+	}
+
 	// update endpoint
-	if err := install_service.UpdateEndpoint(&endpoint, name, encryptedSettings); err != nil {
+	if err := install_service.UpdateEndpoint(
+		&endpoint, name, encryptedSettings, auth, buildEndpointLimits(limitsRequest, endpoint.Limits),
+	); err != nil {
 		return exception.InternalServerError(fmt.Errorf("failed to update endpoint: %v", err)).ToResponse()
 	}
 
@@ -275,5 +314,9 @@ func UpdateEndpoint(endpoint_id string, tenant_id string, user_id string, name s
 		return exception.InternalServerError(fmt.Errorf("failed to clear credentials cache: %v", err)).ToResponse()
 	}
 
+	if err := clearEndpointAuthCache(tenant_id, user_id, endpoint.ID); err != nil {
+		return exception.InternalServerError(fmt.Errorf("failed to clear auth cache: %v", err)).ToResponse()
+	}
+
 	return entities.NewSuccessResponse(true)
 }
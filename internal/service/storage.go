@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/langgenius/dify-plugin-daemon/internal/core/persistence"
+	"github.com/langgenius/dify-plugin-daemon/internal/db"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/exception"
+	"github.com/langgenius/dify-plugin-daemon/internal/types/models"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/cache/helper"
+	"github.com/langgenius/dify-plugin-daemon/internal/utils/log"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities"
+	"github.com/langgenius/dify-plugin-daemon/pkg/entities/plugin_entities"
+)
+
+func GetStorageUsage(tenant_id string) *entities.Response {
+	type usage struct {
+		PluginID  string `json:"plugin_id"`
+		Size      int64  `json:"size"`
+		Limit     int64  `json:"limit"`
+		Installed bool   `json:"installed"`
+	}
+
+	p := persistence.GetPersistence()
+	if p == nil {
+		return exception.InternalServerError(errors.New("persistence not found")).ToResponse()
+	}
+
+	storages, err := db.GetAll[models.TenantStorage](
+		db.Equal("tenant_id", tenant_id),
+	)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	installations, err := db.GetAll[models.PluginInstallation](
+		db.Equal("tenant_id", tenant_id),
+		db.OrderBy("created_at", true),
+	)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	sizes := make(map[string]int64, len(storages))
+	for _, storage := range storages {
+		sizes[storage.PluginID] = storage.Size
+	}
+
+	list := make([]usage, 0, len(installations)+len(storages))
+	for _, installation := range installations {
+		limit := p.MaxStorageSize()
+
+		// plugins may declare a smaller storage
+		pluginUniqueIdentifier, err := plugin_entities.NewPluginUniqueIdentifier(installation.PluginUniqueIdentifier)
+		if err != nil {
+			return exception.UniqueIdentifierError(err).ToResponse()
+		}
+		declaration, err := helper.CombinedGetPluginDeclaration(
+			pluginUniqueIdentifier,
+			plugin_entities.PluginRuntimeType(installation.RuntimeType),
+		)
+		if err != nil {
+			return exception.InternalServerError(err).ToResponse()
+		}
+		if declaration.Resource.Permission != nil && declaration.Resource.Permission.Storage != nil {
+			limit = min(limit, int64(declaration.Resource.Permission.Storage.Size))
+		}
+
+		list = append(list, usage{
+			PluginID:  installation.PluginID,
+			Size:      sizes[installation.PluginID],
+			Limit:     limit,
+			Installed: true,
+		})
+		delete(sizes, installation.PluginID)
+	}
+
+	// storage left by uninstalled plugins
+	for _, storage := range storages {
+		if _, ok := sizes[storage.PluginID]; !ok {
+			continue
+		}
+		list = append(list, usage{
+			PluginID: storage.PluginID,
+			Size:     storage.Size,
+			Limit:    p.MaxStorageSize(),
+		})
+	}
+
+	return entities.NewSuccessResponse(map[string]any{
+		"list": list,
+	})
+}
+
+func PurgePluginStorage(tenant_id string, plugin_id string) *entities.Response {
+	p := persistence.GetPersistence()
+	if p == nil {
+		return exception.InternalServerError(errors.New("persistence not found")).ToResponse()
+	}
+
+	purged, err := p.Purge(tenant_id, plugin_id)
+	if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(map[string]any{
+		"purged": purged,
+	})
+}
+
+// ExportPluginStorage streams the storage archive of the tenant, failures before streaming are responded as json
+func ExportPluginStorage(c *gin.Context, tenant_id string, plugin_id string) {
+	p := persistence.GetPersistence()
+	if p == nil {
+		c.JSON(http.StatusOK, exception.InternalServerError(errors.New("persistence not found")).ToResponse())
+		return
+	}
+
+	manifest, err := p.Manifest(tenant_id, plugin_id)
+	if err != nil {
+		c.JSON(http.StatusOK, exception.InternalServerError(err).ToResponse())
+		return
+	}
+
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=\"storage-%s-%s.tar.gz\"",
+		tenant_id, manifest.ExportedAt.Format("20060102150405"),
+	))
+	c.Status(http.StatusOK)
+
+	if err := p.Export(c.Writer, manifest); err != nil {
+		// headers are sent, the truncated archive is rejected by the importer
+		log.Error("failed to export storage of tenant %s: %s", tenant_id, err.Error())
+	}
+}
+
+func ImportPluginStorage(tenant_id string, archive io.Reader, overwrite bool) *entities.Response {
+	p := persistence.GetPersistence()
+	if p == nil {
+		return exception.InternalServerError(errors.New("persistence not found")).ToResponse()
+	}
+
+	result, err := p.Import(archive, tenant_id, overwrite)
+	if errors.Is(err, persistence.ErrInvalidArchive) {
+		return exception.BadRequestError(err).ToResponse()
+	} else if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(result)
+}
+
+func RotateStorageEncryption(tenant_id string) *entities.Response {
+	p := persistence.GetPersistence()
+	if p == nil {
+		return exception.InternalServerError(errors.New("persistence not found")).ToResponse()
+	}
+
+	if tenant_id == "" {
+		rewrapped, err := p.RewrapDataKeys()
+		if errors.Is(err, persistence.ErrEncryptionDisabled) {
+			return exception.BadRequestError(err).ToResponse()
+		} else if err != nil {
+			return exception.InternalServerError(err).ToResponse()
+		}
+
+		return entities.NewSuccessResponse(map[string]any{
+			"rewrapped": rewrapped,
+		})
+	}
+
+	encrypted, err := p.RotateDataKey(tenant_id)
+	if errors.Is(err, persistence.ErrEncryptionDisabled) {
+		return exception.BadRequestError(err).ToResponse()
+	} else if err != nil {
+		return exception.InternalServerError(err).ToResponse()
+	}
+
+	return entities.NewSuccessResponse(map[string]any{
+		"encrypted": encrypted,
+	})
+}
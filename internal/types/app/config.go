@@ -75,8 +75,36 @@ type Config struct {
 	PluginRemoteInstallingMaxSingleTenantConn int    `envconfig:"PLUGIN_REMOTE_INSTALLING_MAX_SINGLE_TENANT_CONN"`
 	PluginRemoteInstallServerEventLoopNums    int    `envconfig:"PLUGIN_REMOTE_INSTALL_SERVER_EVENT_LOOP_NUMS"`
 
+	// plugin remote installing tls
+	PluginRemoteInstallingTLSEnabled  bool   `envconfig:"PLUGIN_REMOTE_INSTALLING_TLS_ENABLED"`
+	PluginRemoteInstallingTLSCertFile string `envconfig:"PLUGIN_REMOTE_INSTALLING_TLS_CERT_FILE"`
+	PluginRemoteInstallingTLSKeyFile  string `envconfig:"PLUGIN_REMOTE_INSTALLING_TLS_KEY_FILE"`
+
+	// plugin remote installing signed tokens, replace the random key stored in redis
+	PluginRemoteInstallingTokenEnabled bool   `envconfig:"PLUGIN_REMOTE_INSTALLING_TOKEN_ENABLED"`
+	PluginRemoteInstallingTokenSecret  string `envconfig:"PLUGIN_REMOTE_INSTALLING_TOKEN_SECRET"`
+	PluginRemoteInstallingTokenTTL     int    `envconfig:"PLUGIN_REMOTE_INSTALLING_TOKEN_TTL"` // seconds
+
+	// record remote debugging sessions into downloadable trace files
+	PluginRemoteDebuggingTraceEnabled bool   `envconfig:"PLUGIN_REMOTE_DEBUGGING_TRACE_ENABLED"`
+	PluginRemoteDebuggingTracePath    string `envconfig:"PLUGIN_REMOTE_DEBUGGING_TRACE_PATH"`
+	PluginRemoteDebuggingTraceMaxSize int64  `envconfig:"PLUGIN_REMOTE_DEBUGGING_TRACE_MAX_SIZE"` // bytes
+
 	// plugin endpoint
 	PluginEndpointEnabled *bool `envconfig:"PLUGIN_ENDPOINT_ENABLED"`
+	// proxies whose forwarding headers are trusted when resolving client ips, ips or cidrs
+	EndpointTrustedProxies []string `envconfig:"ENDPOINT_TRUSTED_PROXIES"`
+
+	// limits of endpoint requests, rate limits and concurrency are shared by the cluster through redis
+	EndpointLimitEnabled         bool  `envconfig:"ENDPOINT_LIMIT_ENABLED"`
+	EndpointLimitFailOpen        *bool `envconfig:"ENDPOINT_LIMIT_FAIL_OPEN"`             // let requests through if redis fails
+	EndpointRateLimit            int   `envconfig:"ENDPOINT_RATE_LIMIT" validate:"min=0"` // requests per minute
+	EndpointBurst                int   `envconfig:"ENDPOINT_BURST" validate:"min=0"`
+	EndpointMaxConcurrency       int   `envconfig:"ENDPOINT_MAX_CONCURRENCY" validate:"min=0"`
+	EndpointTenantRateLimit      int   `envconfig:"ENDPOINT_TENANT_RATE_LIMIT" validate:"min=0"` // requests per minute
+	EndpointTenantBurst          int   `envconfig:"ENDPOINT_TENANT_BURST" validate:"min=0"`
+	EndpointTenantMaxConcurrency int   `envconfig:"ENDPOINT_TENANT_MAX_CONCURRENCY" validate:"min=0"`
+	EndpointMaxBodySize          int64 `envconfig:"ENDPOINT_MAX_BODY_SIZE" validate:"min=0"` // bytes
 
 	// storage
 	PluginWorkingPath      string `envconfig:"PLUGIN_WORKING_PATH"` // where the plugin finally running
@@ -133,6 +161,15 @@ type Config struct {
 	// persistence storage
 	PersistenceStoragePath    string `envconfig:"PERSISTENCE_STORAGE_PATH"`
 	PersistenceStorageMaxSize int64  `envconfig:"PERSISTENCE_STORAGE_MAX_SIZE"`
+	// seconds between sweeps of expired keys
+	PersistenceStorageSweepInterval int `envconfig:"PERSISTENCE_STORAGE_SWEEP_INTERVAL" validate:"min=0"`
+	// seconds between reconciliations of recorded storage sizes with the storage
+	PersistenceStorageReconcileInterval int `envconfig:"PERSISTENCE_STORAGE_RECONCILE_INTERVAL" validate:"min=0"`
+	// master keys wrapping data keys of tenants, comma separated `id:base64 key` pairs, the first one is the primary,
+	// values are encrypted at rest once any master key is configured
+	PersistenceStorageEncryptionKeys string `envconfig:"PERSISTENCE_STORAGE_ENCRYPTION_KEYS"`
+	// file of master keys, one `id:base64 key` pair per line, appended to the keys above
+	PersistenceStorageEncryptionKeysFile string `envconfig:"PERSISTENCE_STORAGE_ENCRYPTION_KEYS_FILE"`
 
 	// force verifying signature for all plugins, not allowing install plugin not signed
 	ForceVerifyingSignature *bool `envconfig:"FORCE_VERIFYING_SIGNATURE"`
@@ -153,6 +190,26 @@ type Config struct {
 	DifyPluginServerlessConnectorAPIKey        *string `envconfig:"DIFY_PLUGIN_SERVERLESS_CONNECTOR_API_KEY"`
 	DifyPluginServerlessConnectorLaunchTimeout int     `envconfig:"DIFY_PLUGIN_SERVERLESS_CONNECTOR_LAUNCH_TIMEOUT"`
 
+	// retries of serverless invocations on connection errors and 429/503 responses
+	PluginServerlessRetryMaxAttempts int `envconfig:"PLUGIN_SERVERLESS_RETRY_MAX_ATTEMPTS"`
+	PluginServerlessRetryBackoff     int `envconfig:"PLUGIN_SERVERLESS_RETRY_BACKOFF"`     // in milliseconds
+	PluginServerlessRetryMaxBackoff  int `envconfig:"PLUGIN_SERVERLESS_RETRY_MAX_BACKOFF"` // in milliseconds
+	// circuit breaker of serverless functions, opened after continuous failures
+	PluginServerlessCircuitBreakerFailureThreshold int `envconfig:"PLUGIN_SERVERLESS_CIRCUIT_BREAKER_FAILURE_THRESHOLD"`
+	PluginServerlessCircuitBreakerOpenTimeout      int `envconfig:"PLUGIN_SERVERLESS_CIRCUIT_BREAKER_OPEN_TIMEOUT"` // in seconds
+	// transport of serverless invocations, http or websocket, websocket enables full duplex
+	// backwards invocations and falls back to http if the function does not support it
+	PluginServerlessTransport string `envconfig:"PLUGIN_SERVERLESS_TRANSPORT"`
+	// keep alive of serverless functions, only the master node runs it
+	PluginServerlessKeepAliveEnabled       bool   `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_ENABLED"`
+	PluginServerlessKeepAliveInterval      int    `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_INTERVAL"` // in seconds
+	PluginServerlessKeepAlivePath          string `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_PATH"`
+	PluginServerlessKeepAliveBusinessHours string `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_BUSINESS_HOURS"`
+	PluginServerlessKeepAliveBusinessDays  string `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_BUSINESS_DAYS"`
+	PluginServerlessKeepAliveTimezone      string `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_TIMEZONE"`
+	PluginServerlessKeepAliveTrafficWindow int    `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_TRAFFIC_WINDOW"` // in seconds
+	PluginServerlessKeepAliveConcurrency   int    `envconfig:"PLUGIN_SERVERLESS_KEEPALIVE_CONCURRENCY"`
+
 	MaxPluginPackageSize            int64 `envconfig:"MAX_PLUGIN_PACKAGE_SIZE" validate:"required"`
 	MaxBundlePackageSize            int64 `envconfig:"MAX_BUNDLE_PACKAGE_SIZE" validate:"required"`
 	MaxServerlessTransactionTimeout int   `envconfig:"MAX_SERVERLESS_TRANSACTION_TIMEOUT"`
@@ -171,6 +228,13 @@ type Config struct {
 
 	DisplayClusterLog bool `envconfig:"DISPLAY_CLUSTER_LOG"`
 
+	// cluster mTLS, redirected requests between nodes go through a dedicated tls listener
+	ClusterMTLSEnabled  bool   `envconfig:"CLUSTER_MTLS_ENABLED"`
+	ClusterMTLSPort     uint16 `envconfig:"CLUSTER_MTLS_PORT"`
+	ClusterMTLSCAFile   string `envconfig:"CLUSTER_MTLS_CA_FILE"`
+	ClusterMTLSCertFile string `envconfig:"CLUSTER_MTLS_CERT_FILE"`
+	ClusterMTLSKeyFile  string `envconfig:"CLUSTER_MTLS_KEY_FILE"`
+
 	PPROFEnabled bool `envconfig:"PPROF_ENABLED"`
 
 	SentryEnabled          bool    `envconfig:"SENTRY_ENABLED"`
@@ -192,6 +256,31 @@ type Config struct {
 	DifyInvocationWriteTimeout int64 `envconfig:"DIFY_BACKWARDS_INVOCATION_WRITE_TIMEOUT" default:"5000"`
 	// dify invocation read timeout in milliseconds
 	DifyInvocationReadTimeout int64 `envconfig:"DIFY_BACKWARDS_INVOCATION_READ_TIMEOUT" default:"240000"`
+
+	// rate limits of backwards invocations, per invoke type, 0 means unlimited
+	// overrides of tenants and plugins are set through admin apis
+	PluginBackwardsInvocationRateLimitEnabled bool `envconfig:"PLUGIN_BACKWARDS_INVOCATION_RATE_LIMIT_ENABLED"`
+	PluginBackwardsInvocationTenantRateLimit  int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_TENANT_RATE_LIMIT" validate:"min=0"` // requests per minute
+	PluginBackwardsInvocationTenantBurst      int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_TENANT_BURST" validate:"min=0"`
+	PluginBackwardsInvocationTenantDailyQuota int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_TENANT_DAILY_QUOTA" validate:"min=0"`
+	PluginBackwardsInvocationPluginRateLimit  int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_PLUGIN_RATE_LIMIT" validate:"min=0"` // requests per minute
+	PluginBackwardsInvocationPluginBurst      int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_PLUGIN_BURST" validate:"min=0"`
+	PluginBackwardsInvocationPluginDailyQuota int  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_PLUGIN_DAILY_QUOTA" validate:"min=0"`
+
+	// audit log of backwards invocations, payloads are hashed or excluded
+	PluginBackwardsInvocationAuditEnabled bool   `envconfig:"PLUGIN_BACKWARDS_INVOCATION_AUDIT_ENABLED"`
+	PluginBackwardsInvocationAuditPayload string `envconfig:"PLUGIN_BACKWARDS_INVOCATION_AUDIT_PAYLOAD" validate:"omitempty,oneof=hash none"`
+
+	// response cache of text embedding and rerank invocations, scoped to tenants
+	PluginBackwardsInvocationCacheEnabled       bool  `envconfig:"PLUGIN_BACKWARDS_INVOCATION_CACHE_ENABLED"`
+	PluginBackwardsInvocationCacheTTL           int   `envconfig:"PLUGIN_BACKWARDS_INVOCATION_CACHE_TTL" validate:"min=0"`             // seconds
+	PluginBackwardsInvocationCacheMaxEntrySize  int   `envconfig:"PLUGIN_BACKWARDS_INVOCATION_CACHE_MAX_ENTRY_SIZE" validate:"min=0"`  // bytes
+	PluginBackwardsInvocationCacheMaxTenantSize int64 `envconfig:"PLUGIN_BACKWARDS_INVOCATION_CACHE_MAX_TENANT_SIZE" validate:"min=0"` // bytes written per ttl
+
+	// idempotency keys of dispatch requests, responses are replayed to duplicates within the window
+	DispatchIdempotencyEnabled         bool  `envconfig:"DISPATCH_IDEMPOTENCY_ENABLED" default:"true"`
+	DispatchIdempotencyWindow          int   `envconfig:"DISPATCH_IDEMPOTENCY_WINDOW" validate:"min=0"`            // seconds
+	DispatchIdempotencyMaxResponseSize int64 `envconfig:"DISPATCH_IDEMPOTENCY_MAX_RESPONSE_SIZE" validate:"min=0"` // bytes
 }
 
 func (c *Config) Validate() error {
@@ -214,6 +303,32 @@ func (c *Config) Validate() error {
 		if c.PluginRemoteInstallServerEventLoopNums == 0 {
 			return fmt.Errorf("plugin remote install server event loop nums is empty")
 		}
+		if c.PluginRemoteInstallingTLSEnabled {
+			if c.PluginRemoteInstallingTLSCertFile == "" {
+				return fmt.Errorf("plugin remote installing tls cert file is empty")
+			}
+			if c.PluginRemoteInstallingTLSKeyFile == "" {
+				return fmt.Errorf("plugin remote installing tls key file is empty")
+			}
+		}
+		if c.PluginRemoteInstallingTokenEnabled && len(c.PluginRemoteInstallingTokenSecret) < 32 {
+			return fmt.Errorf("length of plugin remote installing token secret must be at least 32")
+		}
+	}
+
+	if c.ClusterMTLSEnabled {
+		if c.ClusterMTLSCAFile == "" {
+			return fmt.Errorf("cluster mtls ca file is empty")
+		}
+		if c.ClusterMTLSCertFile == "" {
+			return fmt.Errorf("cluster mtls cert file is empty")
+		}
+		if c.ClusterMTLSKeyFile == "" {
+			return fmt.Errorf("cluster mtls key file is empty")
+		}
+		if c.ClusterMTLSPort == c.ServerPort {
+			return fmt.Errorf("cluster mtls port must be different from server port")
+		}
 	}
 
 	if c.Platform == PLATFORM_SERVERLESS {
@@ -225,6 +340,10 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("dify plugin serverless connector api key is empty")
 		}
 
+		if c.PluginServerlessTransport != "http" && c.PluginServerlessTransport != "websocket" {
+			return fmt.Errorf("invalid plugin serverless transport: %s", c.PluginServerlessTransport)
+		}
+
 		if c.MaxServerlessTransactionTimeout == 0 {
 			return fmt.Errorf("max serverless transaction timeout is empty")
 		}
@@ -21,7 +21,21 @@ func (config *Config) SetDefault() {
 	setDefaultString(&config.PluginStorageType, oss.OSS_TYPE_LOCAL)
 	setDefaultInt(&config.PluginMediaCacheSize, 1024)
 	setDefaultInt(&config.DifyPluginServerlessConnectorLaunchTimeout, 240)
+	setDefaultInt(&config.PluginServerlessRetryMaxAttempts, 3)
+	setDefaultInt(&config.PluginServerlessRetryBackoff, 200)
+	setDefaultInt(&config.PluginServerlessRetryMaxBackoff, 5000)
+	setDefaultInt(&config.PluginServerlessCircuitBreakerFailureThreshold, 5)
+	setDefaultInt(&config.PluginServerlessCircuitBreakerOpenTimeout, 30)
+	setDefaultString(&config.PluginServerlessTransport, "http")
+	setDefaultInt(&config.PluginServerlessKeepAliveInterval, 240)
+	setDefaultString(&config.PluginServerlessKeepAlivePath, "/health")
+	setDefaultString(&config.PluginServerlessKeepAliveTimezone, "UTC")
+	setDefaultInt(&config.PluginServerlessKeepAliveTrafficWindow, 3600)
+	setDefaultInt(&config.PluginServerlessKeepAliveConcurrency, 16)
 	setDefaultInt(&config.PluginRemoteInstallingMaxSingleTenantConn, 5)
+	setDefaultInt(&config.PluginRemoteInstallingTokenTTL, 3600)
+	setDefaultString(&config.PluginRemoteDebuggingTracePath, "debugging_traces")
+	setDefaultInt(&config.PluginRemoteDebuggingTraceMaxSize, 32*1024*1024)
 	setDefaultBoolPtr(&config.PluginRemoteInstallingEnabled, true)
 	setDefaultBoolPtr(&config.PluginEndpointEnabled, true)
 	setDefaultString(&config.DBSslMode, "disable")
@@ -31,6 +45,10 @@ func (config *Config) SetDefault() {
 	setDefaultString(&config.PersistenceStoragePath, "persistence")
 	setDefaultInt(&config.PluginLocalLaunchingConcurrent, 2)
 	setDefaultInt(&config.PersistenceStorageMaxSize, 100*1024*1024)
+	setDefaultInt(&config.EndpointMaxBodySize, 10*1024*1024)
+	setDefaultBoolPtr(&config.EndpointLimitFailOpen, true)
+	setDefaultInt(&config.PersistenceStorageSweepInterval, 60)
+	setDefaultInt(&config.PersistenceStorageReconcileInterval, 3600)
 	setDefaultString(&config.PluginPackageCachePath, "plugin_packages")
 	setDefaultString(&config.PythonInterpreterPath, "/usr/bin/python3")
 	setDefaultInt(&config.PythonEnvInitTimeout, 120)
@@ -39,6 +57,13 @@ func (config *Config) SetDefault() {
 	setDefaultBoolPtr(&config.PipVerbose, true)
 	setDefaultInt(&config.DifyInvocationWriteTimeout, 5000)
 	setDefaultInt(&config.DifyInvocationReadTimeout, 240000)
+	setDefaultString(&config.PluginBackwardsInvocationAuditPayload, "hash")
+	setDefaultInt(&config.PluginBackwardsInvocationCacheTTL, 3600)
+	setDefaultInt(&config.PluginBackwardsInvocationCacheMaxEntrySize, 1024*1024)
+	setDefaultInt(&config.PluginBackwardsInvocationCacheMaxTenantSize, 64*1024*1024)
+	setDefaultInt(&config.DispatchIdempotencyWindow, 3600)
+	setDefaultInt(&config.DispatchIdempotencyMaxResponseSize, 4*1024*1024)
+	setDefaultInt(&config.ClusterMTLSPort, 5005)
 	if config.DBType == "postgresql" {
 		setDefaultString(&config.DBDefaultDatabase, "postgres")
 	} else if config.DBType == "mysql" {
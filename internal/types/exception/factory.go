@@ -12,6 +12,8 @@ const (
 	PluginDaemonNotFoundError         = "PluginDaemonNotFoundError"
 	PluginDaemonUnauthorizedError     = "PluginDaemonUnauthorizedError"
 	PluginDaemonPermissionDeniedError = "PluginDaemonPermissionDeniedError"
+	PluginDaemonTooManyRequestsError  = "PluginDaemonTooManyRequestsError"
+	PluginDaemonPayloadTooLargeError  = "PluginDaemonPayloadTooLargeError"
 	PluginDaemonInvokeError           = "PluginDaemonInvokeError"
 	PluginUniqueIdentifierError       = "PluginUniqueIdentifierError"
 	PluginNotFoundError               = "PluginNotFoundError"
@@ -56,6 +58,14 @@ func PermissionDeniedError(msg string) PluginDaemonError {
 	return ErrorWithTypeAndCode(msg, PluginPermissionDeniedError, -403)
 }
 
+func TooManyRequestsError(err error) PluginDaemonError {
+	return ErrorWithTypeAndCode(err.Error(), PluginDaemonTooManyRequestsError, -429)
+}
+
+func PayloadTooLargeError(err error) PluginDaemonError {
+	return ErrorWithTypeAndCode(err.Error(), PluginDaemonPayloadTooLargeError, -413)
+}
+
 func InvokePluginError(err error) PluginDaemonError {
 	return ErrorWithTypeAndCode(err.Error(), PluginInvokeError, -500)
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// BackwardsInvocationAudit is an append-only record of a backwards invocation made by a plugin,
+// payloads are never stored, only their sha256 if enabled
+type BackwardsInvocationAudit struct {
+	Model
+	TenantID               string `json:"tenant_id" gorm:"index:idx_backwards_invocation_audit_tenant_started;size:64;column:tenant_id"`
+	UserID                 string `json:"user_id" gorm:"index;size:64;column:user_id"`
+	PluginID               string `json:"plugin_id" gorm:"index;size:255;column:plugin_id"`
+	PluginUniqueIdentifier string `json:"plugin_unique_identifier" gorm:"size:255;column:plugin_unique_identifier"`
+	SessionID              string `json:"session_id" gorm:"size:64;column:session_id"`
+	InvokeFrom             string `json:"invoke_from" gorm:"size:64;column:invoke_from"`
+	InvokeType             string `json:"invoke_type" gorm:"index;size:64;column:invoke_type"`
+	// targets of the invocation, empty if not applicable
+	AppID     string `json:"app_id" gorm:"index;size:64;column:app_id"`
+	Provider  string `json:"provider" gorm:"size:255;column:provider"`
+	ModelName string `json:"model" gorm:"size:255;column:model"`
+	Tool      string `json:"tool" gorm:"size:255;column:tool"`
+	// sha256 of the request payload, empty if payloads are excluded
+	PayloadHash string    `json:"payload_hash" gorm:"size:64;column:payload_hash"`
+	Status      string    `json:"status" gorm:"index;size:32;column:status"`
+	Error       string    `json:"error" gorm:"type:text;column:error"`
+	StartedAt   time.Time `json:"started_at" gorm:"index:idx_backwards_invocation_audit_tenant_started;column:started_at"`
+	DurationMs  int64     `json:"duration_ms" gorm:"column:duration_ms"`
+}
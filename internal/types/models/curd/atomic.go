@@ -369,6 +369,15 @@ func UpgradePlugin(
 
 		// update exists installation
 		installation.PluginUniqueIdentifier = newPluginUniqueIdentifier.String()
+		// app bindings are made by the tenant admin, keep them unless rebound
+		if bindings, ok := installation.Meta[models.PLUGIN_INSTALLATION_META_APP_BINDINGS]; ok {
+			if _, ok := meta[models.PLUGIN_INSTALLATION_META_APP_BINDINGS]; !ok {
+				if meta == nil {
+					meta = map[string]any{}
+				}
+				meta[models.PLUGIN_INSTALLATION_META_APP_BINDINGS] = bindings
+			}
+		}
 		installation.Meta = meta
 		err = db.Update(installation, tx)
 		if err != nil {
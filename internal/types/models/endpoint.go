@@ -17,5 +17,28 @@ type Endpoint struct {
 	ExpiredAt   time.Time                                    `json:"expired_at" gorm:"column:expired_at"`
 	Enabled     bool                                         `json:"enabled" gorm:"column:enabled"`
 	Settings    map[string]any                               `json:"settings" gorm:"column:settings;serializer:json"`
+	Auth        *EndpointAuth                                `json:"auth" gorm:"column:auth;serializer:json"`
+	Limits      *EndpointLimits                              `json:"limits" gorm:"column:limits;serializer:json"`
 	Declaration *plugin_entities.EndpointProviderDeclaration `json:"declaration" gorm:"-"` // not stored in db
 }
+
+// EndpointAuth guards requests to the endpoint on top of the hook id, every configured check has to pass
+type EndpointAuth struct {
+	// header carrying the hex encoded HMAC-SHA256 of "<timestamp>.<body>", optionally prefixed with "sha256=",
+	// the unix timestamp is sent in the X-Dify-Endpoint-Timestamp header
+	HMACHeader string `json:"hmac_header,omitempty"`
+	// encrypted by dify like settings of the endpoint
+	HMACSecret string `json:"hmac_secret,omitempty"`
+	// sha256 of the bearer token, the token itself is never stored
+	BearerToken string `json:"bearer_token,omitempty"`
+	// ips or cidrs the endpoint is allowed to be requested from
+	IPAllowlist []string `json:"ip_allowlist,omitempty"`
+}
+
+// EndpointLimits tightens limits of requests to the endpoint, 0 means the limit of the config applies
+type EndpointLimits struct {
+	RequestsPerMinute int   `json:"requests_per_minute"`
+	Burst             int   `json:"burst"`
+	MaxConcurrency    int   `json:"max_concurrency"`
+	MaxBodySize       int64 `json:"max_body_size"` // bytes
+}
@@ -13,3 +13,29 @@ type PluginInstallation struct {
 	Source                 string         `json:"source" gorm:"column:source;size:63"`
 	Meta                   map[string]any `json:"meta" gorm:"column:meta;serializer:json"`
 }
+
+const (
+	// PLUGIN_INSTALLATION_META_APP_BINDINGS is the key of app ids bound by the tenant admin in Meta,
+	// plugins with scoped app permission are only able to invoke these apps
+	PLUGIN_INSTALLATION_META_APP_BINDINGS = "app_bindings"
+)
+
+// AppBindings returns the app ids bound to the installation
+func (p *PluginInstallation) AppBindings() []string {
+	bindings := []string{}
+	if p.Meta == nil {
+		return bindings
+	}
+
+	switch values := p.Meta[PLUGIN_INSTALLATION_META_APP_BINDINGS].(type) {
+	case []string:
+		bindings = append(bindings, values...)
+	case []any:
+		for _, value := range values {
+			if appId, ok := value.(string); ok {
+				bindings = append(bindings, appId)
+			}
+		}
+	}
+	return bindings
+}
@@ -6,3 +6,14 @@ type TenantStorage struct {
 	PluginID string `gorm:"column:plugin_id;type:varchar(255);not null;index"`
 	Size     int64  `gorm:"column:size;type:bigint;not null"`
 }
+
+// TenantDataKey is a data key encrypting persistence storage of the tenant, wrapped by a master key,
+// retired keys are kept to decrypt values written before the rotation
+type TenantDataKey struct {
+	Model
+	TenantID    string `gorm:"column:tenant_id;type:varchar(255);not null;uniqueIndex:idx_tenant_data_key_version"`
+	Version     int    `gorm:"column:version;not null;uniqueIndex:idx_tenant_data_key_version"`
+	MasterKeyID string `gorm:"column:master_key_id;type:varchar(64);not null;index"`
+	WrappedKey  string `gorm:"column:wrapped_key;type:text;not null"`
+	Active      bool   `gorm:"column:active;not null;default:false"`
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"strconv"
 	"strings"
 	"time"
 
@@ -144,6 +145,43 @@ func get[T any](key string, context ...redis.Cmdable) (*T, error) {
 	return &result, err
 }
 
+// MGet gets the values of the keys in one round trip, nil for the keys not found
+func MGet[T any](keys []string, context ...redis.Cmdable) ([]*T, error) {
+	if client == nil {
+		return nil, ErrDBNotInit
+	}
+
+	if len(keys) == 0 {
+		return []*T{}, nil
+	}
+
+	serialKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		serialKeys = append(serialKeys, serialKey(key))
+	}
+
+	values, err := getCmdable(context...).MGet(ctx, serialKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*T, len(values))
+	for i, value := range values {
+		val, ok := value.(string)
+		if !ok || len(val) == 0 {
+			continue
+		}
+
+		item, err := parser.UnmarshalCBOR[T]([]byte(val))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = &item
+	}
+
+	return result, nil
+}
+
 // GetString get the string with key
 func GetString(key string, context ...redis.Cmdable) (string, error) {
 	if client == nil {
@@ -549,3 +587,120 @@ func Subscribe[T any](channel string) (<-chan T, func()) {
 		pubsub.Close()
 	}
 }
+
+// EvalScript runs the lua script atomically, keys are serialized like the others
+func EvalScript(script *redis.Script, keys []string, args []any, context ...redis.Cmdable) (any, error) {
+	if client == nil {
+		return nil, ErrDBNotInit
+	}
+
+	serialKeys := make([]string, len(keys))
+	for i, key := range keys {
+		serialKeys[i] = serialKey(key)
+	}
+
+	return script.Run(ctx, getCmdable(context...), serialKeys, args...).Result()
+}
+
+// ZAdd adds the member to the sorted set with score, the score is updated if the member exists
+func ZAdd(key string, score float64, member string, context ...redis.Cmdable) error {
+	if client == nil {
+		return ErrDBNotInit
+	}
+
+	return getCmdable(context...).ZAdd(ctx, serialKey(key), redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRem removes the member from the sorted set
+func ZRem(key string, member string, context ...redis.Cmdable) error {
+	if client == nil {
+		return ErrDBNotInit
+	}
+
+	return getCmdable(context...).ZRem(ctx, serialKey(key), member).Err()
+}
+
+// ZScore returns the score of the member, ErrNotFound if the member does not exist
+func ZScore(key string, member string, context ...redis.Cmdable) (float64, error) {
+	if client == nil {
+		return 0, ErrDBNotInit
+	}
+
+	score, err := getCmdable(context...).ZScore(ctx, serialKey(key), member).Result()
+	if err == redis.Nil {
+		return 0, ErrNotFound
+	}
+	return score, err
+}
+
+// ZScores returns scores of the members in one round trip, nil for the members not in the sorted set
+func ZScores(key string, members []string, context ...redis.Cmdable) ([]*float64, error) {
+	if client == nil {
+		return nil, ErrDBNotInit
+	}
+
+	if len(members) == 0 {
+		return []*float64{}, nil
+	}
+
+	cmds := make([]*redis.FloatCmd, len(members))
+	_, err := getCmdable(context...).Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, member := range members {
+			cmds[i] = p.ZScore(ctx, serialKey(key), member)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	scores := make([]*float64, len(members))
+	for i, cmd := range cmds {
+		score, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scores[i] = &score
+	}
+
+	return scores, nil
+}
+
+// ZRangeByScore returns at most count members whose score is less than or equal to max, in ascending order,
+// skipping the first offset members
+func ZRangeByScore(key string, max float64, offset int64, count int64, context ...redis.Cmdable) ([]string, error) {
+	if client == nil {
+		return nil, ErrDBNotInit
+	}
+
+	return getCmdable(context...).ZRangeByScore(ctx, serialKey(key), &redis.ZRangeBy{
+		Min:    "-inf",
+		Max:    strconv.FormatFloat(max, 'f', -1, 64),
+		Offset: offset,
+		Count:  count,
+	}).Result()
+}
+
+// RPush appends values to the list
+func RPush(key string, values []string, context ...redis.Cmdable) error {
+	if client == nil {
+		return ErrDBNotInit
+	}
+
+	args := make([]any, 0, len(values))
+	for _, value := range values {
+		args = append(args, value)
+	}
+	return getCmdable(context...).RPush(ctx, serialKey(key), args...).Err()
+}
+
+// LRange returns elements of the list from start to stop, both inclusive, negative indexes count from the end
+func LRange(key string, start int64, stop int64, context ...redis.Cmdable) ([]string, error) {
+	if client == nil {
+		return nil, ErrDBNotInit
+	}
+
+	return getCmdable(context...).LRange(ctx, serialKey(key), start, stop).Result()
+}
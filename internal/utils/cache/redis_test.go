@@ -317,6 +317,31 @@ func TestSetAndGet(t *testing.T) {
 	}
 }
 
+func TestMGet(t *testing.T) {
+	if err := InitRedisClient("127.0.0.1:6379", "", "difyai123456", false, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+
+	key := strings.Join([]string{TEST_PREFIX, "mget-test"}, ":")
+	missing := strings.Join([]string{TEST_PREFIX, "mget-test-missing"}, ":")
+	if err := Store(key, map[string]string{"key": "hello"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer Del(key)
+
+	values, err := MGet[map[string]string]([]string{key, missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] == nil || (*values[0])["key"] != "hello" {
+		t.Fatalf("MGet should return the stored value, got %v", values)
+	}
+	if values[1] != nil {
+		t.Fatalf("MGet should return nil for missing keys")
+	}
+}
+
 func TestLock(t *testing.T) {
 	if err := InitRedisClient("127.0.0.1:6379", "", "difyai123456", false, 0); err != nil {
 		t.Fatal(err)
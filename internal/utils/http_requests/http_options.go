@@ -1,6 +1,9 @@
 package http_requests
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type HttpOptions struct {
 	Type  string
@@ -20,6 +23,7 @@ const (
 	HttpOptionTypeDirectReferer                    = "directReferer"
 	HttpOptionTypeRetCode                          = "retCode"
 	HttpOptionTypeUsingLengthPrefixed              = "usingLengthPrefixed"
+	HttpOptionTypeContext                          = "context"
 )
 
 // milliseconds
@@ -109,3 +113,8 @@ func HttpWithRetCode(retCode *int) HttpOptions {
 func HttpUsingLengthPrefixed(using bool) HttpOptions {
 	return HttpOptions{HttpOptionTypeUsingLengthPrefixed, using}
 }
+
+// the request is aborted once ctx is done, including reading its response
+func HttpContext(ctx context.Context) HttpOptions {
+	return HttpOptions{HttpOptionTypeContext, ctx}
+}
@@ -6,6 +6,14 @@ type EndpointResponseChunk struct {
 	Result  *string           `json:"result" validate:"omitempty"`
 }
 
+// EndpointRequestBodyChunk is a piece of the request body streamed to plugins supporting it,
+// the last one has End set, along with Error if reading the body failed
+type EndpointRequestBodyChunk struct {
+	Chunk []byte `json:"chunk,omitempty"` // base64 encoded
+	End   bool   `json:"end,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
 const (
 	HeaderXOriginalHost = "X-Original-Host"
 )
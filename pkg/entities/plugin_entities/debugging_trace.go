@@ -0,0 +1,76 @@
+package plugin_entities
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+/*
+ * A debugging trace records the traffic between the daemon and a remote debugging plugin.
+ *
+ * It's a newline-delimited json file, the first line is DebuggingTraceMeta
+ * and every following line is a DebuggingTraceRecord.
+ * */
+
+type DebuggingTraceDirection string
+
+const (
+	// requests and backwards invocation responses written to the plugin
+	DEBUGGING_TRACE_DIRECTION_TO_PLUGIN DebuggingTraceDirection = "to_plugin"
+	// events sent by the plugin
+	DEBUGGING_TRACE_DIRECTION_FROM_PLUGIN DebuggingTraceDirection = "from_plugin"
+)
+
+type DebuggingTraceMeta struct {
+	TraceID                string            `json:"trace_id"`
+	TenantID               string            `json:"tenant_id"`
+	PluginUniqueIdentifier string            `json:"plugin_unique_identifier"`
+	Declaration            PluginDeclaration `json:"declaration"`
+	StartedAt              int64             `json:"started_at"`
+	EndedAt                int64             `json:"ended_at"`
+	// the trace reached the size limit, records after it are dropped
+	Truncated bool `json:"truncated"`
+}
+
+type DebuggingTraceRecord struct {
+	Direction DebuggingTraceDirection `json:"direction"`
+	// unix milliseconds
+	Timestamp int64           `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ParseDebuggingTrace parses a trace file into its meta and records
+func ParseDebuggingTrace(data []byte) (*DebuggingTraceMeta, []DebuggingTraceRecord, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 1024*1024), len(data)+1)
+
+	if !scanner.Scan() {
+		return nil, nil, errors.New("empty trace")
+	}
+
+	var meta DebuggingTraceMeta
+	if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+		return nil, nil, errors.Join(errors.New("invalid trace meta"), err)
+	}
+
+	records := make([]DebuggingTraceRecord, 0)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record DebuggingTraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, nil, errors.Join(errors.New("invalid trace record"), err)
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return &meta, records, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -79,8 +80,55 @@ func (p *PluginPermissionRequirement) AllowInvokeStorage() bool {
 	return p != nil && p.Storage != nil && p.Storage.Enabled
 }
 
+// AllowInvokeToolProvider checks the tool provider against the scope, any provider if not scoped
+func (p *PluginPermissionRequirement) AllowInvokeToolProvider(provider string) bool {
+	return p.AllowInvokeTool() && matchProviders(p.Tool.Providers, provider)
+}
+
+// AllowInvokeModelProvider checks the model provider against the scope, any provider if not scoped
+func (p *PluginPermissionRequirement) AllowInvokeModelProvider(provider string) bool {
+	return p.AllowInvokeModel() && matchProviders(p.Model.Providers, provider)
+}
+
+// AppScoped returns true if the plugin is only able to invoke apps bound by the tenant
+func (p *PluginPermissionRequirement) AppScoped() bool {
+	return p.AllowInvokeApp() && p.App.Scoped
+}
+
+// short names of providers refer to the ones published by langgenius
+const BUILTIN_PROVIDER_PREFIX = "langgenius/"
+
+// matchProviders matches a provider like `langgenius/openai/openai` or `openai` against the allowed ones,
+// which could be full names, short names or plugin ids like `langgenius/openai`
+func matchProviders(allowed []string, provider string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	shortName := func(name string) string {
+		return name[strings.LastIndex(name, "/")+1:]
+	}
+
+	for _, a := range allowed {
+		switch {
+		case a == provider:
+			return true
+		case strings.HasPrefix(provider, a+"/"):
+			return true
+		// short names of providers are resolved to the built-in ones, never to providers of other authors
+		case !strings.Contains(provider, "/") && strings.HasPrefix(a, BUILTIN_PROVIDER_PREFIX) && shortName(a) == provider:
+			return true
+		case !strings.Contains(a, "/") && strings.HasPrefix(provider, BUILTIN_PROVIDER_PREFIX) && shortName(provider) == a:
+			return true
+		}
+	}
+	return false
+}
+
 type PluginPermissionToolRequirement struct {
 	Enabled bool `json:"enabled" yaml:"enabled"`
+	// tool providers allowed to invoke, all if empty
+	Providers []string `json:"providers,omitempty" yaml:"providers,omitempty" validate:"omitempty,dive,required"`
 }
 
 type PluginPermissionModelRequirement struct {
@@ -91,6 +139,8 @@ type PluginPermissionModelRequirement struct {
 	TTS           bool `json:"tts" yaml:"tts"`
 	Speech2text   bool `json:"speech2text" yaml:"speech2text"`
 	Moderation    bool `json:"moderation" yaml:"moderation"`
+	// model providers allowed to invoke, all if empty
+	Providers []string `json:"providers,omitempty" yaml:"providers,omitempty" validate:"omitempty,dive,required"`
 }
 
 type PluginPermissionNodeRequirement struct {
@@ -103,6 +153,8 @@ type PluginPermissionEndpointRequirement struct {
 
 type PluginPermissionAppRequirement struct {
 	Enabled bool `json:"enabled" yaml:"enabled"`
+	// only apps bound by the tenant admin are allowed to invoke, the bindings are kept in the installation
+	Scoped bool `json:"scoped,omitempty" yaml:"scoped,omitempty"`
 }
 
 type PluginPermissionStorageRequirement struct {
@@ -125,11 +177,27 @@ type PluginRunner struct {
 	Entrypoint string             `json:"entrypoint" yaml:"entrypoint" validate:"required,max=256"`
 }
 
+// features of the protocol a plugin supports beyond the baseline, declared by its sdk
+const (
+	// request bodies of endpoints are streamed in chunks following the request instead of being hex encoded in it
+	PLUGIN_FEATURE_ENDPOINT_STREAMING_BODY = "endpoint_streaming_body"
+)
+
 type PluginMeta struct {
 	Version            string           `json:"version" yaml:"version" validate:"required,version"`
 	Arch               []constants.Arch `json:"arch" yaml:"arch" validate:"required,dive,is_available_arch"`
 	Runner             PluginRunner     `json:"runner" yaml:"runner" validate:"required"`
 	MinimumDifyVersion *string          `json:"minimum_dify_version" yaml:"minimum_dify_version"`
+	Features           []string         `json:"features,omitempty" yaml:"features,omitempty" validate:"omitempty,max=32,dive,max=64"`
+}
+
+func (m *PluginMeta) HasFeature(feature string) bool {
+	for _, f := range m.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
 }
 
 type PluginExtensions struct {
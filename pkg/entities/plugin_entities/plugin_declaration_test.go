@@ -191,3 +191,28 @@ func TestPluginDeclarationIncorrectName(t *testing.T) {
 		return
 	}
 }
+
+func TestMatchProviders(t *testing.T) {
+	cases := []struct {
+		allowed  []string
+		provider string
+		matched  bool
+	}{
+		{nil, "evil/openai/openai", true},
+		{[]string{"langgenius/openai/openai"}, "langgenius/openai/openai", true},
+		{[]string{"langgenius/openai"}, "langgenius/openai/openai", true},
+		{[]string{"langgenius/openai/openai"}, "openai", true},
+		{[]string{"openai"}, "openai", true},
+		{[]string{"openai"}, "langgenius/openai/openai", true},
+		{[]string{"openai"}, "evil/openai/openai", false},
+		{[]string{"evil/openai/openai"}, "openai", false},
+		{[]string{"evil/openai"}, "openai", false},
+		{[]string{"langgenius/openai"}, "langgenius/anthropic/anthropic", false},
+	}
+
+	for _, c := range cases {
+		if matchProviders(c.allowed, c.provider) != c.matched {
+			t.Errorf("expected matchProviders(%v, %s) to be %v", c.allowed, c.provider, c.matched)
+		}
+	}
+}
@@ -81,6 +81,12 @@ type (
 		Error(string)
 	}
 
+	// PluginSessionDuplexLifetime is implemented by runtimes which are full duplex only for some sessions,
+	// e.g. serverless runtimes are full duplex only if the session is held by a bidirectional stream
+	PluginSessionDuplexLifetime interface {
+		IsFullDuplex(session_id string) bool
+	}
+
 	PluginClusterLifetime interface {
 		// stop the plugin
 		Stop()
@@ -219,6 +225,24 @@ type PluginRuntimeState struct {
 	Verified    bool       `json:"verified"`
 	ScheduledAt *time.Time `json:"scheduled_at"`
 	Logs        []string   `json:"logs"`
+
+	// only available for serverless runtimes
+	CircuitBreaker *CircuitBreakerState `json:"circuit_breaker,omitempty"`
+}
+
+type CircuitBreakerStatus string
+
+const (
+	CIRCUIT_BREAKER_STATUS_CLOSED    CircuitBreakerStatus = "closed"
+	CIRCUIT_BREAKER_STATUS_OPEN      CircuitBreakerStatus = "open"
+	CIRCUIT_BREAKER_STATUS_HALF_OPEN CircuitBreakerStatus = "half_open"
+)
+
+type CircuitBreakerState struct {
+	Status              CircuitBreakerStatus `json:"status"`
+	ConsecutiveFailures int                  `json:"consecutive_failures"`
+	OpenedAt            *time.Time           `json:"opened_at"`
+	LastError           string               `json:"last_error"`
 }
 
 func (s *PluginRuntimeState) Hash() (uint64, error) {
@@ -0,0 +1,39 @@
+package requests
+
+import "time"
+
+type RequestListBackwardsInvocationRateLimits struct {
+	TenantID string `form:"tenant_id" validate:"required"`
+}
+
+type RequestSetBackwardsInvocationRateLimit struct {
+	TenantID string `json:"tenant_id" validate:"required"`
+	// empty means the limit of the tenant shared by all its plugins
+	PluginID string `json:"plugin_id"`
+	// empty means all the invoke types
+	InvokeType        string `json:"invoke_type"`
+	RequestsPerMinute int    `json:"requests_per_minute" validate:"min=0"`
+	Burst             int    `json:"burst" validate:"min=0"`
+	DailyQuota        int    `json:"daily_quota" validate:"min=0"`
+}
+
+type RequestDeleteBackwardsInvocationRateLimit struct {
+	TenantID   string `json:"tenant_id" validate:"required"`
+	PluginID   string `json:"plugin_id"`
+	InvokeType string `json:"invoke_type"`
+}
+
+// RequestListBackwardsInvocationAudits filters audit records, tenant id comes from the uri of tenant apis
+// and from the query of admin apis, empty filters match everything
+type RequestListBackwardsInvocationAudits struct {
+	TenantID   string    `uri:"tenant_id" form:"tenant_id"`
+	PluginID   string    `form:"plugin_id"`
+	UserID     string    `form:"user_id"`
+	InvokeType string    `form:"invoke_type"`
+	AppID      string    `form:"app_id"`
+	Status     string    `form:"status" validate:"omitempty,oneof=succeeded failed denied rate_limited cancelled"`
+	From       time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To         time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Page       int       `form:"page" validate:"required,min=1"`
+	PageSize   int       `form:"page_size" validate:"required,min=1,max=256"`
+}
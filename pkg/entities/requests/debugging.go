@@ -3,3 +3,21 @@ package requests
 type RequestGetRemoteDebuggingKey struct {
 	TenantID string `uri:"tenant_id" validate:"required"`
 }
+
+type RequestListDebuggingTraces struct {
+	TenantID string `uri:"tenant_id" validate:"required"`
+}
+
+type RequestDownloadDebuggingTrace struct {
+	TenantID string `uri:"tenant_id" validate:"required"`
+	TraceID  string `uri:"trace_id" validate:"required,uuid"`
+}
+
+type RequestListDebuggingConnections struct {
+	TenantID string `uri:"tenant_id" validate:"required"`
+}
+
+type RequestDisconnectDebuggingConnection struct {
+	TenantID     string `uri:"tenant_id" validate:"required"`
+	ConnectionID string `json:"connection_id" validate:"required"`
+}
@@ -3,4 +3,41 @@ package requests
 type RequestInvokeEndpoint struct {
 	RawHttpRequest string         `json:"raw_http_request" validate:"required"`
 	Settings       map[string]any `json:"settings" validate:"omitempty"`
+	// the raw request carries only the head, the body follows in request_body events of the session,
+	// the bytes are forwarded as is, bodies of unknown length carry no framing header and end with the end event
+	StreamingBody bool `json:"streaming_body,omitempty"`
+}
+
+// RequestEndpointAuth configures checks on requests to an endpoint, empty fields disable the check,
+// secrets equal to the hidden value returned by listing keep the current ones,
+// bodies of requests are read as a whole to verify hmac signatures, they are never streamed to plugins,
+// signatures cover "<timestamp>.<body>" with the unix timestamp sent in X-Dify-Endpoint-Timestamp
+type RequestEndpointAuth struct {
+	HMACHeader  string   `json:"hmac_header" validate:"required_with=HMACSecret,omitempty,max=127"`
+	HMACSecret  string   `json:"hmac_secret" validate:"required_with=HMACHeader,omitempty,max=255"`
+	BearerToken string   `json:"bearer_token" validate:"omitempty,max=255"`
+	IPAllowlist []string `json:"ip_allowlist" validate:"omitempty,max=100,dive,required"`
+}
+
+// RequestEndpointLimits tightens limits of requests to an endpoint, 0 means the limit of the daemon applies
+type RequestEndpointLimits struct {
+	RequestsPerMinute int   `json:"requests_per_minute" validate:"min=0"`
+	Burst             int   `json:"burst" validate:"min=0"`
+	MaxConcurrency    int   `json:"max_concurrency" validate:"min=0"`
+	MaxBodySize       int64 `json:"max_body_size" validate:"min=0"`
+}
+
+type RequestGetEndpointLimit struct {
+	TenantID string `form:"tenant_id" validate:"required"`
+}
+
+type RequestSetEndpointLimit struct {
+	TenantID          string `json:"tenant_id" validate:"required"`
+	RequestsPerMinute int    `json:"requests_per_minute" validate:"min=0"`
+	Burst             int    `json:"burst" validate:"min=0"`
+	MaxConcurrency    int    `json:"max_concurrency" validate:"min=0"`
+}
+
+type RequestDeleteEndpointLimit struct {
+	TenantID string `json:"tenant_id" validate:"required"`
 }
@@ -0,0 +1,15 @@
+package requests
+
+type RequestListSessions struct {
+	TenantID string `form:"tenant_id"`
+	PluginID string `form:"plugin_id"`
+	Action   string `form:"action"`
+	// seconds since the session is created
+	MinAge int `form:"min_age" validate:"omitempty,min=0"`
+	Offset int `form:"offset" validate:"omitempty,min=0"`
+	Limit  int `form:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+type RequestTerminateSession struct {
+	SessionID string `uri:"session_id" validate:"required"`
+}
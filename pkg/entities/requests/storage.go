@@ -0,0 +1,23 @@
+package requests
+
+type RequestPurgePluginStorage struct {
+	TenantID string `json:"tenant_id" validate:"required"`
+	PluginID string `json:"plugin_id" validate:"required"`
+}
+
+type RequestExportPluginStorage struct {
+	TenantID string `form:"tenant_id" validate:"required"`
+	// empty exports all plugins of the tenant
+	PluginID string `form:"plugin_id"`
+}
+
+type RequestImportPluginStorage struct {
+	// empty imports into the tenant of the archive
+	TenantID  string `form:"tenant_id"`
+	Overwrite bool   `form:"overwrite"`
+}
+
+type RequestRotateStorageEncryption struct {
+	// rotates the data key of the tenant, empty rewraps data keys of all tenants with the primary master key
+	TenantID string `json:"tenant_id"`
+}